@@ -42,6 +42,9 @@ func main() {
 		log.Fatalf("initalize logger err %s\n", err)
 	}
 
+	info := server.GetVersionInfo()
+	log.Infof("pd version %s, git hash %s, build ts %s, etcd version %s", info.Version, info.GitHash, info.BuildTS, info.EtcdVersion)
+
 	svr, err := server.CreateServer(cfg)
 	if err != nil {
 		log.Errorf("create pd server err %s\n", err)
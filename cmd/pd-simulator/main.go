@@ -0,0 +1,70 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ngaut/log"
+	"github.com/pingcap/pd/server"
+)
+
+var (
+	configFile = flag.String("config", "", "simulator config file")
+	eventsFile = flag.String("events", "", "recorded heartbeats file, stdin is used if it is empty")
+	seed       = flag.Int64("seed", 0, "random seed of the scheduler, overrides the seed in the config file")
+)
+
+// pd-simulator replays the recorded heartbeats against the scheduler and
+// prints the operators the scheduler emits, one JSON object per line.
+func main() {
+	flag.Parse()
+
+	cfg := &server.SimulatorConfig{}
+	if *configFile != "" {
+		if _, err := toml.DecodeFile(*configFile, cfg); err != nil {
+			log.Fatalf("load config %s err %v", *configFile, err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			cfg.Seed = *seed
+		}
+	})
+
+	events := os.Stdin
+	if *eventsFile != "" {
+		f, err := os.Open(*eventsFile)
+		if err != nil {
+			log.Fatalf("open events %s err %v", *eventsFile, err)
+		}
+		defer f.Close()
+		events = f
+	}
+
+	ops, err := server.Simulate(cfg, events)
+	if err != nil {
+		log.Fatalf("simulate err %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, op := range ops {
+		if err = enc.Encode(op); err != nil {
+			log.Fatalf("encode operator err %v", err)
+		}
+	}
+}
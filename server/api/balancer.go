@@ -40,7 +40,7 @@ func newBalancerHandler(svr *server.Server, rd *render.Render) *balancerHandler
 func (h *balancerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
@@ -76,7 +76,7 @@ func newHistoryOperatorHandler(svr *server.Server, rd *render.Render) *historyOp
 func (h *historyOperatorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
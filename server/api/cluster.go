@@ -15,11 +15,20 @@ package api
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/pingcap/pd/server"
 	"github.com/unrolled/render"
 )
 
+// clusterInfo is the response of the cluster API. The cluster ID is
+// a string because JSON numbers can't hold all the 64-bit integers.
+type clusterInfo struct {
+	Bootstrapped bool   `json:"bootstrapped"`
+	ID           string `json:"id,omitempty"`
+	MaxPeerCount uint32 `json:"max_peer_count,omitempty"`
+}
+
 type clusterHandler struct {
 	svr *server.Server
 	rd  *render.Render
@@ -35,13 +44,39 @@ func newClusterHandler(svr *server.Server, rd *render.Render) *clusterHandler {
 func (h *clusterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
-		h.rd.JSON(w, http.StatusOK, nil)
+		h.rd.JSON(w, http.StatusOK, &clusterInfo{})
 		return
 	}
 
-	h.rd.JSON(w, http.StatusOK, cluster.GetConfig())
+	meta := cluster.GetConfig()
+	h.rd.JSON(w, http.StatusOK, &clusterInfo{
+		Bootstrapped: true,
+		ID:           strconv.FormatUint(meta.GetId(), 10),
+		MaxPeerCount: meta.GetMaxPeerCount(),
+	})
+}
+
+type clusterStatusHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newClusterStatusHandler(svr *server.Server, rd *render.Render) *clusterStatusHandler {
+	return &clusterStatusHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *clusterStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, err := h.svr.GetClusterStatus()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, status)
 }
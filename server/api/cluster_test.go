@@ -0,0 +1,166 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testClusterSuite{})
+
+type testClusterSuite struct {
+	hc *http.Client
+}
+
+func (s *testClusterSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testClusterSuite) mustGetStatus(c *C, addr string) *server.ClusterStatus {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	got := &server.ClusterStatus{}
+	c.Assert(json.Unmarshal(buf, got), IsNil)
+	return got
+}
+
+func (s *testClusterSuite) mustGetCluster(c *C, addr string) *clusterInfo {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	got := &clusterInfo{}
+	c.Assert(json.Unmarshal(buf, got), IsNil)
+	return got
+}
+
+func (s *testClusterSuite) TestCluster(c *C) {
+	// The ID can't be represented by a float64 exactly.
+	cfg := server.NewTestSingleConfig()
+	cfg.ClusterID = 1<<63 + 1
+	defer os.RemoveAll(cfg.DataDir)
+	svr, err := server.CreateServer(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(svr.StartEtcd(NewHandler(svr)), IsNil)
+	go svr.Run()
+	defer svr.Close()
+	mustWaitLeader(c, []*server.Server{svr})
+
+	parts := []string{cfg.ClientUrls, apiPrefix, "/api/v1/cluster"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	c.Assert(s.mustGetCluster(c, addr), DeepEquals, &clusterInfo{})
+
+	conn := mustRPCConnect(c, svr)
+	defer conn.Close()
+	req := &pdpb.Request{
+		Header:  &pdpb.RequestHeader{ClusterId: proto.Uint64(cfg.ClusterID)},
+		CmdType: pdpb.CommandType_Bootstrap.Enum(),
+		Bootstrap: &pdpb.BootstrapRequest{
+			Store:  newTestStore(1),
+			Region: newTestRegion(1, []byte{}, []byte{}, newTestPeer(1, 1)),
+		},
+	}
+	mustRPCCall(c, conn, req)
+
+	expected := &clusterInfo{
+		Bootstrapped: true,
+		ID:           "9223372036854775809",
+		MaxPeerCount: 3,
+	}
+	for i := 0; i < 2; i++ {
+		c.Assert(s.mustGetCluster(c, addr), DeepEquals, expected)
+	}
+}
+
+func (s *testClusterSuite) TestClusterStatus(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/cluster/status"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	// The free space changes over time, so we only check it is reported.
+	got := s.mustGetStatus(c, addr)
+	c.Assert(got.DataDirFreeBytes, Greater, uint64(0))
+	got.DataDirFreeBytes = 0
+	c.Assert(got, DeepEquals, &server.ClusterStatus{ClusterID: cfgs[0].ClusterID})
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	// Store 1 is up, store 2 is down and store 3 is offline.
+	mustBootstrapCluster(c, conn)
+	mustHeartbeatStore(c, conn, 1)
+	mustPutStore(c, conn, newTestStore(2))
+	mustPutStore(c, conn, newTestStore(3))
+	mustHeartbeatStore(c, conn, 3)
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster.OfflineStore(3), IsNil)
+
+	// The region only has 1 replica, but the max peer count is 3.
+	got = s.mustGetStatus(c, addr)
+	got.DataDirFreeBytes = 0
+	c.Assert(got, DeepEquals, &server.ClusterStatus{
+		Bootstrapped:           true,
+		ClusterID:              cfgs[0].ClusterID,
+		UpStores:               1,
+		DownStores:             1,
+		OfflineStores:          1,
+		RegionCount:            1,
+		UnderReplicatedRegions: 1,
+		UnderReplicated:        true,
+	})
+
+	region := newTestRegion(1, []byte{}, []byte{}, newTestPeer(1, 1), newTestPeer(2, 2), newTestPeer(3, 3))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(2),
+		Version: proto.Uint64(1),
+	}
+	mustRegionHeartbeat(c, conn, region, newTestPeer(1, 1))
+
+	got = s.mustGetStatus(c, addr)
+	c.Assert(got.RegionCount, Equals, 1)
+	c.Assert(got.UnderReplicatedRegions, Equals, 0)
+	c.Assert(got.UnderReplicated, IsFalse)
+
+	// Allocating an ID reserves a block of IDs.
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_AllocId.Enum(),
+		AllocId: &pdpb.AllocIdRequest{},
+	}
+	id := mustRPCCall(c, conn, req).GetAllocId().GetId()
+	got = s.mustGetStatus(c, addr)
+	c.Assert(got.AllocIDMax, Equals, id-1+cfgs[0].IDAllocStep)
+}
@@ -14,8 +14,12 @@
 package api
 
 import (
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/juju/errors"
 	"github.com/pingcap/pd/server"
 	"github.com/unrolled/render"
 )
@@ -40,10 +44,436 @@ func (h *confHandler) Post(w http.ResponseWriter, r *http.Request) {
 	config := &server.BalanceConfig{}
 	err := fromBody(r, config)
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInvalidBody, err.Error())
 		return
 	}
 
-	h.svr.SetBalanceConfig(*config)
-	h.rd.JSON(w, http.StatusOK, nil)
+	err = h.svr.SetBalanceConfig(*config)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, nil)
+	case server.ErrInvalidConfig:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidConfig, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// scheduleConfig is the request body to change the scheduling limits,
+// the limits which are not specified are kept unchanged.
+type scheduleConfig struct {
+	MaxSnapshotCount     *int64   `json:"max-snapshot-count"`
+	LeaderScheduleLimit  *int64   `json:"leader-schedule-limit"`
+	RegionScheduleLimit  *int64   `json:"region-schedule-limit"`
+	MinRegionCount       *int64   `json:"min-region-count"`
+	MinLeaderRegionCount *int64   `json:"min-leader-region-count"`
+	MaxDiffScoreFraction *float64 `json:"max-diff-score-fraction"`
+	OperatorStepTimeout  *string  `json:"operator-step-timeout"`
+	OperatorStepRetry    *int64   `json:"operator-step-retry"`
+}
+
+func (h *confHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	h.rd.JSON(w, http.StatusOK, h.svr.GetScheduleConfig())
+}
+
+// apply applies the specified limits to cfg and returns the new config,
+// the errors of the invalid fields are returned if any. It is shared by
+// changing and validating the config, so they always agree.
+func (input *scheduleConfig) apply(cfg server.ScheduleConfig) (server.ScheduleConfig, map[string]string) {
+	errs := make(map[string]string)
+	for _, item := range []struct {
+		name   string
+		input  *int64
+		target *uint64
+	}{
+		{"max-snapshot-count", input.MaxSnapshotCount, &cfg.MaxSnapshotCount},
+		{"leader-schedule-limit", input.LeaderScheduleLimit, &cfg.LeaderScheduleLimit},
+		{"region-schedule-limit", input.RegionScheduleLimit, &cfg.RegionScheduleLimit},
+		{"min-region-count", input.MinRegionCount, &cfg.MinRegionCount},
+		{"min-leader-region-count", input.MinLeaderRegionCount, &cfg.MinLeaderRegionCount},
+		{"operator-step-retry", input.OperatorStepRetry, &cfg.OperatorStepRetry},
+	} {
+		if item.input == nil {
+			continue
+		}
+		if *item.input < 0 {
+			errs[item.name] = fmt.Sprintf("%d is negative", *item.input)
+			continue
+		}
+		*item.target = uint64(*item.input)
+	}
+	if input.MaxDiffScoreFraction != nil {
+		fraction := *input.MaxDiffScoreFraction
+		if fraction < 0 || fraction >= 1 {
+			errs["max-diff-score-fraction"] = fmt.Sprintf("%v is not in [0, 1)", fraction)
+		} else {
+			cfg.MaxDiffScoreFraction = fraction
+		}
+	}
+	if input.OperatorStepTimeout != nil {
+		// A zero timeout disables the step timeout.
+		timeout, err := time.ParseDuration(*input.OperatorStepTimeout)
+		if err != nil || timeout < 0 {
+			errs["operator-step-timeout"] = fmt.Sprintf("%q is not a non-negative duration", *input.OperatorStepTimeout)
+		} else {
+			cfg.OperatorStepTimeout.Duration = timeout
+		}
+	}
+	return cfg, errs
+}
+
+func (h *confHandler) PostSchedule(w http.ResponseWriter, r *http.Request) {
+	input := &scheduleConfig{}
+	if err := fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	cfg, errs := input.apply(h.svr.GetScheduleConfig())
+	if len(errs) > 0 {
+		writeFieldErrors(h.rd, w, errCodeInvalidConfig, errs)
+		return
+	}
+
+	if err := h.svr.SetScheduleConfig(cfg); err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, h.svr.GetScheduleConfig())
+}
+
+// ValidateSchedule validates the scheduling limits like PostSchedule, and
+// returns the config they would result in without saving it.
+func (h *confHandler) ValidateSchedule(w http.ResponseWriter, r *http.Request) {
+	input := &scheduleConfig{}
+	if err := fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	cfg, errs := input.apply(h.svr.GetScheduleConfig())
+	if len(errs) > 0 {
+		writeFieldErrors(h.rd, w, errCodeInvalidConfig, errs)
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, cfg)
+}
+
+func (h *confHandler) GetReplicate(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, cluster.GetReplicateConfig())
+}
+
+func (h *confHandler) PostReplicate(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	cfg := server.ReplicateConfig{}
+	if err = fromBody(r, &cfg); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	// An even number of replicas tolerates no more failures than one less,
+	// so only the odd numbers are allowed.
+	if cfg.MaxReplicas%2 == 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidConfig, fmt.Sprintf("invalid max-replicas: %d, it must be odd", cfg.MaxReplicas))
+		return
+	}
+
+	err = cluster.SetReplicateConfig(cfg)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, cluster.GetReplicateConfig())
+	case server.ErrInvalidConfig:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidConfig, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// keyRange is the key range in the API, the keys are hex encoded and an
+// empty end_key means no upper bound.
+type keyRange struct {
+	StartKey string `json:"start_key"`
+	EndKey   string `json:"end_key"`
+}
+
+func newKeyRange(r *server.KeyRange) keyRange {
+	return keyRange{
+		StartKey: hex.EncodeToString(r.StartKey),
+		EndKey:   hex.EncodeToString(r.EndKey),
+	}
+}
+
+// decode decodes the hex encoded keys of the range.
+func (r *keyRange) decode() (server.KeyRange, error) {
+	startKey, err := hex.DecodeString(r.StartKey)
+	if err != nil {
+		return server.KeyRange{}, errors.Errorf("invalid start key: %s", r.StartKey)
+	}
+	endKey, err := hex.DecodeString(r.EndKey)
+	if err != nil {
+		return server.KeyRange{}, errors.Errorf("invalid end key: %s", r.EndKey)
+	}
+	return server.KeyRange{StartKey: startKey, EndKey: endKey}, nil
+}
+
+// placementRule is the placement rule in the API.
+type placementRule struct {
+	keyRange
+	Labels map[string]string `json:"labels"`
+}
+
+func newPlacementRules(rules []*server.PlacementRule) []*placementRule {
+	result := make([]*placementRule, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, &placementRule{
+			keyRange: newKeyRange(&rule.KeyRange),
+			Labels:   rule.Labels,
+		})
+	}
+	return result
+}
+
+func (h *confHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, newPlacementRules(cluster.GetPlacementRules()))
+}
+
+// PostRules replaces all the placement rules with the rules in the body.
+func (h *confHandler) PostRules(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	var input []*placementRule
+	if err = fromBody(r, &input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	rules := make([]*server.PlacementRule, 0, len(input))
+	for _, item := range input {
+		kr, err := item.decode()
+		if err != nil {
+			writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, err.Error())
+			return
+		}
+		rules = append(rules, &server.PlacementRule{
+			KeyRange: kr,
+			Labels:   item.Labels,
+		})
+	}
+
+	err = cluster.SetPlacementRules(rules)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, newPlacementRules(cluster.GetPlacementRules()))
+	case server.ErrInvalidPlacementRule:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidRule, err.Error())
+	case server.ErrOverlappingPlacementRules:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeOverlappingRules, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// namespace is the namespace in the API.
+type namespace struct {
+	Name                string      `json:"name"`
+	Ranges              []*keyRange `json:"ranges"`
+	StoreIDs            []uint64    `json:"store_ids"`
+	LeaderScheduleLimit uint64      `json:"leader_schedule_limit"`
+	RegionScheduleLimit uint64      `json:"region_schedule_limit"`
+}
+
+func newNamespaces(namespaces []*server.Namespace) []*namespace {
+	result := make([]*namespace, 0, len(namespaces))
+	for _, ns := range namespaces {
+		ranges := make([]*keyRange, 0, len(ns.Ranges))
+		for _, r := range ns.Ranges {
+			kr := newKeyRange(r)
+			ranges = append(ranges, &kr)
+		}
+		result = append(result, &namespace{
+			Name:                ns.Name,
+			Ranges:              ranges,
+			StoreIDs:            ns.StoreIDs,
+			LeaderScheduleLimit: ns.LeaderScheduleLimit,
+			RegionScheduleLimit: ns.RegionScheduleLimit,
+		})
+	}
+	return result
+}
+
+func (h *confHandler) GetNamespaces(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, newNamespaces(cluster.GetNamespaces()))
+}
+
+// PostNamespaces replaces all the namespaces with the namespaces in the body.
+func (h *confHandler) PostNamespaces(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	var input []*namespace
+	if err = fromBody(r, &input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	namespaces := make([]*server.Namespace, 0, len(input))
+	for _, item := range input {
+		ranges := make([]*server.KeyRange, 0, len(item.Ranges))
+		for _, r := range item.Ranges {
+			kr, err := r.decode()
+			if err != nil {
+				writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, err.Error())
+				return
+			}
+			ranges = append(ranges, &kr)
+		}
+		namespaces = append(namespaces, &server.Namespace{
+			Name:                item.Name,
+			Ranges:              ranges,
+			StoreIDs:            item.StoreIDs,
+			LeaderScheduleLimit: item.LeaderScheduleLimit,
+			RegionScheduleLimit: item.RegionScheduleLimit,
+		})
+	}
+
+	err = cluster.SetNamespaces(namespaces)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, newNamespaces(cluster.GetNamespaces()))
+	case server.ErrInvalidNamespace:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidNamespace, err.Error())
+	case server.ErrOverlappingNamespaces:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeOverlappingNamespaces, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// leaderAffinity is the leader affinity in the API.
+type leaderAffinity struct {
+	Location string `json:"location"`
+	keyRange
+	Labels map[string]string `json:"labels"`
+}
+
+func newLeaderAffinities(affinities []*server.LeaderAffinity) []*leaderAffinity {
+	result := make([]*leaderAffinity, 0, len(affinities))
+	for _, a := range affinities {
+		result = append(result, &leaderAffinity{
+			Location: a.Location,
+			keyRange: newKeyRange(&a.KeyRange),
+			Labels:   a.Labels,
+		})
+	}
+	return result
+}
+
+func (h *confHandler) GetLeaderAffinities(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, newLeaderAffinities(cluster.GetLeaderAffinities()))
+}
+
+// PostLeaderAffinities replaces all the leader affinities with the
+// affinities in the body.
+func (h *confHandler) PostLeaderAffinities(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	var input []*leaderAffinity
+	if err = fromBody(r, &input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	affinities := make([]*server.LeaderAffinity, 0, len(input))
+	for _, item := range input {
+		kr, err := item.decode()
+		if err != nil {
+			writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, err.Error())
+			return
+		}
+		affinities = append(affinities, &server.LeaderAffinity{
+			Location: item.Location,
+			KeyRange: kr,
+			Labels:   item.Labels,
+		})
+	}
+
+	err = cluster.SetLeaderAffinities(affinities)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, newLeaderAffinities(cluster.GetLeaderAffinities()))
+	case server.ErrInvalidLeaderAffinity:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidAffinity, err.Error())
+	case server.ErrOverlappingLeaderAffinities:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeOverlappingAffinities, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
 }
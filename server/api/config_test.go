@@ -0,0 +1,499 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testConfigSuite{})
+
+type testConfigSuite struct {
+	hc *http.Client
+}
+
+func (s *testConfigSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testConfigSuite) mustGetConfig(c *C, addr string) *server.Config {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	got := &server.Config{}
+	c.Assert(json.Unmarshal(buf, got), IsNil)
+	return got
+}
+
+func (s *testConfigSuite) TestConfigGet(c *C) {
+	cfgs, _, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/config"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	got := s.mustGetConfig(c, addr)
+	c.Assert(got.ClientUrls, Equals, cfgs[0].ClientUrls)
+	c.Assert(got.PeerUrls, Equals, cfgs[0].PeerUrls)
+	c.Assert(got.DataDir, Equals, cfgs[0].DataDir)
+	c.Assert(got.Name, Equals, cfgs[0].Name)
+
+	// The computed values are returned.
+	c.Assert(got.AdvertiseClientUrls, Equals, cfgs[0].ClientUrls)
+	c.Assert(got.BalanceCfg.MaxStoreDownDuration.Duration > 0, IsTrue)
+	c.Assert(got.BalanceCfg, DeepEquals, cfgs[0].BalanceCfg)
+}
+
+func (s *testConfigSuite) TestConfigSchedule(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/config"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	mustPostSchedule := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr+"/schedule", "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	mustPostSchedule(`{"leader-schedule-limit": 2, "max-snapshot-count": 5}`, http.StatusOK)
+	got := s.mustGetConfig(c, addr)
+	c.Assert(got.BalanceCfg.LeaderScheduleLimit, Equals, uint64(2))
+	c.Assert(got.BalanceCfg.MaxSendingSnapCount, Equals, uint64(5))
+	c.Assert(got.BalanceCfg.MaxReceivingSnapCount, Equals, uint64(5))
+	// The limit which is not specified is unchanged.
+	c.Assert(got.BalanceCfg.RegionScheduleLimit, Equals, cfgs[0].BalanceCfg.RegionScheduleLimit)
+
+	// Zero limit is allowed to stop scheduling.
+	mustPostSchedule(`{"region-schedule-limit": 0}`, http.StatusOK)
+	c.Assert(svrs[0].GetScheduleConfig().RegionScheduleLimit, Equals, uint64(0))
+
+	buf := mustPostSchedule(`{"leader-schedule-limit": -1}`, http.StatusBadRequest)
+	checkErrorResponse(c, buf, errCodeInvalidConfig)
+	buf = mustPostSchedule(`{"leader-schedule-limit": "abc"}`, http.StatusBadRequest)
+	checkErrorResponse(c, buf, errCodeInvalidBody)
+	buf = mustPostSchedule(`{"region-schedule-limit": 1.5}`, http.StatusBadRequest)
+	checkErrorResponse(c, buf, errCodeInvalidBody)
+	c.Assert(svrs[0].GetScheduleConfig(), DeepEquals, server.ScheduleConfig{
+		MaxSnapshotCount:    5,
+		LeaderScheduleLimit: 2,
+		RegionScheduleLimit: 0,
+		// The tolerance which is not specified is unchanged.
+		MaxDiffScoreFraction: cfgs[0].BalanceCfg.MaxDiffScoreFraction,
+		OperatorStepTimeout:  cfgs[0].BalanceCfg.OperatorStepTimeout,
+		OperatorStepRetry:    cfgs[0].BalanceCfg.OperatorStepRetry,
+	})
+
+	mustPostSchedule(`{"min-region-count": 10, "min-leader-region-count": 5}`, http.StatusOK)
+	got = s.mustGetConfig(c, addr)
+	c.Assert(got.BalanceCfg.MinRegionCount, Equals, uint64(10))
+	c.Assert(got.BalanceCfg.MinLeaderRegionCount, Equals, uint64(5))
+	buf = mustPostSchedule(`{"min-region-count": -1}`, http.StatusBadRequest)
+	checkErrorResponse(c, buf, errCodeInvalidConfig)
+
+	mustPostSchedule(`{"max-diff-score-fraction": 0.3}`, http.StatusOK)
+	got = s.mustGetConfig(c, addr)
+	c.Assert(got.BalanceCfg.MaxDiffScoreFraction, Equals, 0.3)
+	c.Assert(svrs[0].GetScheduleConfig().MaxDiffScoreFraction, Equals, 0.3)
+	for _, body := range []string{`{"max-diff-score-fraction": -0.1}`, `{"max-diff-score-fraction": 1}`} {
+		checkErrorResponse(c, mustPostSchedule(body, http.StatusBadRequest), errCodeInvalidConfig)
+	}
+
+	mustPostSchedule(`{"operator-step-timeout": "30s", "operator-step-retry": 2}`, http.StatusOK)
+	got = s.mustGetConfig(c, addr)
+	c.Assert(got.BalanceCfg.OperatorStepTimeout.Duration, Equals, 30*time.Second)
+	c.Assert(got.BalanceCfg.OperatorStepRetry, Equals, uint64(2))
+	for _, body := range []string{`{"operator-step-timeout": "abc"}`, `{"operator-step-timeout": "-1s"}`, `{"operator-step-retry": -1}`} {
+		checkErrorResponse(c, mustPostSchedule(body, http.StatusBadRequest), errCodeInvalidConfig)
+	}
+}
+
+func (s *testConfigSuite) TestConfigScheduleValidate(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/config/schedule/validate"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	mustValidate := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	old := svrs[0].GetScheduleConfig()
+
+	// The valid config is merged with the current one and returned.
+	buf := mustValidate(`{"leader-schedule-limit": 2, "operator-step-timeout": "30s"}`, http.StatusOK)
+	got := server.ScheduleConfig{}
+	c.Assert(json.Unmarshal(buf, &got), IsNil)
+	expect := old
+	expect.LeaderScheduleLimit = 2
+	expect.OperatorStepTimeout.Duration = 30 * time.Second
+	c.Assert(got, DeepEquals, expect)
+
+	// The errors of all the invalid fields are returned.
+	buf = mustValidate(`{"leader-schedule-limit": -1, "max-diff-score-fraction": 1, "operator-step-timeout": "abc", "region-schedule-limit": 1}`, http.StatusBadRequest)
+	resp := checkErrorResponse(c, buf, errCodeInvalidConfig)
+	c.Assert(resp.Fields, HasLen, 3)
+	for _, name := range []string{"leader-schedule-limit", "max-diff-score-fraction", "operator-step-timeout"} {
+		c.Assert(resp.Fields[name], Not(Equals), "")
+	}
+	checkErrorResponse(c, mustValidate(`{"leader-schedule-limit": "abc"}`, http.StatusBadRequest), errCodeInvalidBody)
+
+	// Nothing is saved.
+	c.Assert(svrs[0].GetScheduleConfig(), DeepEquals, old)
+}
+
+func (s *testConfigSuite) TestConfigReplicate(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/config/replicate"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	mustPostReplicate := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustGetReplicate := func() *server.ReplicateConfig {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		got := &server.ReplicateConfig{}
+		c.Assert(json.Unmarshal(buf, got), IsNil)
+		return got
+	}
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	c.Assert(mustGetReplicate(), DeepEquals, &server.ReplicateConfig{MaxReplicas: cfgs[0].MaxPeerCount})
+
+	checkErrorResponse(c, mustPostReplicate(`{"max-replicas": 0}`, http.StatusBadRequest), errCodeInvalidConfig)
+	checkErrorResponse(c, mustPostReplicate(`{"max-replicas": 2}`, http.StatusBadRequest), errCodeInvalidConfig)
+	checkErrorResponse(c, mustPostReplicate(`{"max-replicas": -1}`, http.StatusBadRequest), errCodeInvalidBody)
+	// The odd number larger than the max uint32 is not wrapped.
+	checkErrorResponse(c, mustPostReplicate(`{"max-replicas": 4294967297}`, http.StatusBadRequest), errCodeInvalidConfig)
+
+	// The region has enough replicas now.
+	mustPostReplicate(`{"max-replicas": 1}`, http.StatusOK)
+	c.Assert(mustGetReplicate(), DeepEquals, &server.ReplicateConfig{MaxReplicas: 1})
+	leader := newTestPeer(1, 1)
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: newTestRegion(1, []byte{}, []byte{}, leader),
+			Leader: leader,
+		},
+	}
+	resp := mustRPCCall(c, conn, req)
+	c.Assert(resp.GetRegionHeartbeat().GetChangePeer(), IsNil)
+
+	// Raising the replicas adds a peer to the region.
+	mustPostReplicate(`{"max-replicas": 3}`, http.StatusOK)
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster.GetConfig().GetMaxPeerCount(), Equals, uint32(3))
+	resp = mustRPCCall(c, conn, req)
+	changePeer := resp.GetRegionHeartbeat().GetChangePeer()
+	c.Assert(changePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+	c.Assert(changePeer.GetPeer().GetStoreId(), Not(Equals), uint64(1))
+}
+
+func (s *testConfigSuite) TestConfigFollower(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	apiAddr := func(svr *server.Server) string {
+		parts := []string{svr.GetAddr(), apiPrefix, "/api/v1/config"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+
+	balanceCfg := leader.GetConfig().BalanceCfg
+	balanceCfg.MaxLeaderCount = 123
+	body, err := json.Marshal(balanceCfg)
+	c.Assert(err, IsNil)
+	resp, err := s.hc.Post(apiAddr(leader), "application/json", strings.NewReader(string(body)))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	// The invalid config is not saved.
+	invalidCfg := balanceCfg
+	invalidCfg.MaxLeaderCount = 456
+	invalidCfg.MaxStoreDownDuration.Duration = time.Second
+	body, err = json.Marshal(invalidCfg)
+	c.Assert(err, IsNil)
+	resp, err = s.hc.Post(apiAddr(leader), "application/json", strings.NewReader(string(body)))
+	c.Assert(err, IsNil)
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+	checkErrorResponse(c, buf, errCodeInvalidConfig)
+
+	// The followers watch the config, so they get the new value soon.
+	for _, svr := range svrs {
+		if svr == leader {
+			continue
+		}
+		var got *server.Config
+		for i := 0; i < 50; i++ {
+			got = s.mustGetConfig(c, apiAddr(svr))
+			if got.BalanceCfg.MaxLeaderCount == 123 {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		c.Assert(got.BalanceCfg.MaxLeaderCount, Equals, uint64(123))
+	}
+}
+
+func (s *testConfigSuite) TestConfigRules(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/config/rules"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	readRules := func(resp *http.Response, status int) []byte {
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustPostRules := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		return readRules(resp, status)
+	}
+	mustGetRules := func() []*placementRule {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		var rules []*placementRule
+		c.Assert(json.Unmarshal(readRules(resp, http.StatusOK), &rules), IsNil)
+		return rules
+	}
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster.SetStoreLabels(3, map[string]string{"ssd": "true"}), IsNil)
+	c.Assert(mustGetRules(), HasLen, 0)
+
+	checkErrorResponse(c, mustPostRules(`[{"start_key": "zz", "labels": {"ssd": "true"}}]`, http.StatusBadRequest), errCodeInvalidKey)
+	checkErrorResponse(c, mustPostRules(`[{"start_key": "61"}]`, http.StatusBadRequest), errCodeInvalidRule)
+	checkErrorResponse(c, mustPostRules(`[{"start_key": "62", "end_key": "61", "labels": {"ssd": "true"}}]`, http.StatusBadRequest), errCodeInvalidRule)
+	overlapping := `[{"start_key": "", "end_key": "62", "labels": {"ssd": "true"}}, {"start_key": "61", "labels": {"ssd": "true"}}]`
+	checkErrorResponse(c, mustPostRules(overlapping, http.StatusBadRequest), errCodeOverlappingRules)
+	c.Assert(mustGetRules(), HasLen, 0)
+
+	// All the regions before "m" must be placed on the SSD stores.
+	mustPostRules(`[{"start_key": "", "end_key": "6d", "labels": {"ssd": "true"}}]`, http.StatusOK)
+	c.Assert(mustGetRules(), DeepEquals, []*placementRule{{keyRange: keyRange{EndKey: "6d"}, Labels: map[string]string{"ssd": "true"}}})
+
+	// The peer IDs are not allocated by PD, so we use a large one to avoid
+	// conflicting with the new peer.
+	leader := newTestPeer(1000, 1)
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: newTestRegion(1, []byte{}, []byte{}, leader),
+			Leader: leader,
+		},
+	}
+	resp := mustRPCCall(c, conn, req)
+	changePeer := resp.GetRegionHeartbeat().GetChangePeer()
+	c.Assert(changePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+	c.Assert(changePeer.GetPeer().GetStoreId(), Equals, uint64(3))
+
+	// Posting no rule removes all the rules.
+	mustPostRules(`[]`, http.StatusOK)
+	c.Assert(mustGetRules(), HasLen, 0)
+}
+
+func (s *testConfigSuite) TestConfigNamespaces(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/config/namespaces"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	readNamespaces := func(resp *http.Response, status int) []byte {
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustPostNamespaces := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		return readNamespaces(resp, status)
+	}
+	mustGetNamespaces := func() []*namespace {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		var namespaces []*namespace
+		c.Assert(json.Unmarshal(readNamespaces(resp, http.StatusOK), &namespaces), IsNil)
+		return namespaces
+	}
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	c.Assert(mustGetNamespaces(), HasLen, 0)
+
+	checkErrorResponse(c, mustPostNamespaces(`[{"name": "a", "ranges": [{"start_key": "zz"}], "store_ids": [1]}]`, http.StatusBadRequest), errCodeInvalidKey)
+	checkErrorResponse(c, mustPostNamespaces(`[{"name": "a", "ranges": [{"start_key": "61"}]}]`, http.StatusBadRequest), errCodeInvalidNamespace)
+	checkErrorResponse(c, mustPostNamespaces(`[{"name": "a", "store_ids": [1]}]`, http.StatusBadRequest), errCodeInvalidNamespace)
+	overlapping := `[{"name": "a", "ranges": [{"end_key": "62"}], "store_ids": [1]}, {"name": "b", "ranges": [{"start_key": "61"}], "store_ids": [3]}]`
+	checkErrorResponse(c, mustPostNamespaces(overlapping, http.StatusBadRequest), errCodeOverlappingNamespaces)
+	c.Assert(mustGetNamespaces(), HasLen, 0)
+
+	// All the regions before "m" can only be placed on store 1 and store 3.
+	mustPostNamespaces(`[{"name": "a", "ranges": [{"end_key": "6d"}], "store_ids": [1, 3], "region_schedule_limit": 2}, {"name": "b", "ranges": [{"start_key": "6d"}], "store_ids": [2]}]`, http.StatusOK)
+	c.Assert(mustGetNamespaces(), DeepEquals, []*namespace{
+		{Name: "a", Ranges: []*keyRange{{EndKey: "6d"}}, StoreIDs: []uint64{1, 3}, RegionScheduleLimit: 2},
+		{Name: "b", Ranges: []*keyRange{{StartKey: "6d"}}, StoreIDs: []uint64{2}},
+	})
+
+	// The peer IDs are not allocated by PD, so we use a large one to avoid
+	// conflicting with the new peer.
+	leader := newTestPeer(1000, 1)
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: newTestRegion(1, []byte{}, []byte{}, leader),
+			Leader: leader,
+		},
+	}
+	resp := mustRPCCall(c, conn, req)
+	changePeer := resp.GetRegionHeartbeat().GetChangePeer()
+	c.Assert(changePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+	c.Assert(changePeer.GetPeer().GetStoreId(), Equals, uint64(3))
+
+	// Posting no namespace removes all the namespaces.
+	mustPostNamespaces(`[]`, http.StatusOK)
+	c.Assert(mustGetNamespaces(), HasLen, 0)
+}
+
+func (s *testConfigSuite) TestConfigLeaderAffinities(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/config/leader-affinities"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	readAffinities := func(resp *http.Response, status int) []byte {
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustPostAffinities := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		return readAffinities(resp, status)
+	}
+	mustGetAffinities := func() []*leaderAffinity {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		var affinities []*leaderAffinity
+		c.Assert(json.Unmarshal(readAffinities(resp, http.StatusOK), &affinities), IsNil)
+		return affinities
+	}
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+	c.Assert(mustGetAffinities(), HasLen, 0)
+
+	checkErrorResponse(c, mustPostAffinities(`[{"start_key": "zz", "labels": {"zone": "z1"}}]`, http.StatusBadRequest), errCodeInvalidKey)
+	checkErrorResponse(c, mustPostAffinities(`[{"start_key": "61"}]`, http.StatusBadRequest), errCodeInvalidAffinity)
+	checkErrorResponse(c, mustPostAffinities(`[{"start_key": "62", "end_key": "61", "labels": {"zone": "z1"}}]`, http.StatusBadRequest), errCodeInvalidAffinity)
+	overlapping := `[{"start_key": "", "end_key": "62", "labels": {"zone": "z1"}}, {"start_key": "61", "labels": {"zone": "z2"}}]`
+	checkErrorResponse(c, mustPostAffinities(overlapping, http.StatusBadRequest), errCodeOverlappingAffinities)
+	c.Assert(mustGetAffinities(), HasLen, 0)
+
+	// The clients in dc1 read the regions before "m" mostly.
+	mustPostAffinities(`[{"location": "dc1", "start_key": "", "end_key": "6d", "labels": {"zone": "z1"}}]`, http.StatusOK)
+	c.Assert(mustGetAffinities(), DeepEquals, []*leaderAffinity{{Location: "dc1", keyRange: keyRange{EndKey: "6d"}, Labels: map[string]string{"zone": "z1"}}})
+
+	// Posting no affinity removes all the affinities.
+	mustPostAffinities(`[]`, http.StatusOK)
+	c.Assert(mustGetAffinities(), HasLen, 0)
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+type diagnoseHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newDiagnoseHandler(svr *server.Server, rd *render.Render) *diagnoseHandler {
+	return &diagnoseHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// ServeHTTP explains why the region is not balanced, it returns the reason
+// of every store which the region can't be moved to.
+func (h *diagnoseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidRegionID, err.Error())
+		return
+	}
+
+	diagnosis, err := cluster.DiagnoseRegion(regionID)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, diagnosis)
+	case server.ErrRegionNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeRegionNotFound, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testDiagnoseSuite{})
+
+type testDiagnoseSuite struct {
+	hc *http.Client
+}
+
+func (s *testDiagnoseSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testDiagnoseSuite) TestDiagnoseRegion(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	diagnose := func(regionID uint64, status int) []byte {
+		addr, err := unixAddrToHTTPAddr(fmt.Sprintf("%s%s/api/v1/diagnose/%d", cfgs[0].ClientUrls, apiPrefix, regionID))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, status)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		return buf
+	}
+
+	// The bootstrapped region has only one peer on store 1 and hasn't
+	// reported its leader yet.
+	diagnosis := &server.RegionDiagnosis{}
+	c.Assert(json.Unmarshal(diagnose(1, http.StatusOK), diagnosis), IsNil)
+	c.Assert(diagnosis.RegionID, Equals, uint64(1))
+	c.Assert(diagnosis.Reasons, DeepEquals, []string{"no_leader", "replica_count_mismatch"})
+	c.Assert(diagnosis.SourceStoreID, Equals, uint64(1))
+	c.Assert(diagnosis.Stores, HasLen, 3)
+	c.Assert(diagnosis.Stores[0], DeepEquals, &server.StoreDiagnosis{StoreID: 1, Reason: "has_peer"})
+
+	checkErrorResponse(c, diagnose(100, http.StatusNotFound), errCodeRegionNotFound)
+}
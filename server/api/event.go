@@ -41,7 +41,7 @@ func newFeedHandler(svr *server.Server, rd *render.Render) *feedHandler {
 func (h *feedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
@@ -57,7 +57,7 @@ func (h *feedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	offset, err := strconv.ParseUint(offsetStr, 10, 64)
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInvalidOffset, err.Error())
 		return
 	}
 
@@ -80,7 +80,7 @@ func newEventsHandler(svr *server.Server, rd *render.Render) *eventsHandler {
 func (h *eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
@@ -88,8 +88,18 @@ func (h *eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	evts := cluster.FetchEvents(0, true)
-	h.rd.JSON(w, http.StatusOK, evts)
+	// Returns all the events if the limit is not specified.
+	limit, err := parseQueryInt(r, "limit", 0)
+	if err != nil || limit < 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLimit, "invalid limit")
+		return
+	}
+	if limit == 0 {
+		h.rd.JSON(w, http.StatusOK, cluster.FetchEvents(0, true))
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, cluster.GetLatestEvents(limit))
 }
 
 type wsHandler struct {
@@ -169,7 +179,7 @@ func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	defer func() {
 		h.Lock()
-		log.Info("client is closed, removing channel")
+		log.Infof("[%s] client is closed, removing channel", requestID(r))
 		close(h.chs[r])
 		delete(h.chs, r)
 		h.Unlock()
@@ -179,19 +189,19 @@ func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-ticker.C:
 			if err := c.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
-				log.Error(err)
+				log.Errorf("[%s] %v", requestID(r), err)
 				return
 			}
 		case event := <-ch:
 			logMsg, err := json.Marshal(event)
 			if err != nil {
-				log.Error(err)
+				log.Errorf("[%s] %v", requestID(r), err)
 				return
 			}
 
 			err = c.WriteMessage(websocket.TextMessage, logMsg)
 			if err != nil {
-				log.Error(err)
+				log.Errorf("[%s] %v", requestID(r), err)
 				return
 			}
 		}
@@ -0,0 +1,200 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testEventSuite{})
+
+type testEventSuite struct {
+	hc *http.Client
+}
+
+func (s *testEventSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testEventSuite) mustGetEvents(c *C, addr string) []server.LogEvent {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	var evts []server.LogEvent
+	c.Assert(json.Unmarshal(buf, &evts), IsNil)
+	return evts
+}
+
+func (s *testEventSuite) TestTransferLeaderEvent(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	leader := newTestPeer(1, 1)
+	region := newTestRegion(1, []byte{}, []byte{}, leader, newTestPeer(2, 2), newTestPeer(3, 3))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(3),
+		Version: proto.Uint64(1),
+	}
+	mustRegionHeartbeat(c, conn, region, leader)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	// The peer change in the heartbeat may be recorded already.
+	count := len(s.mustGetEvents(c, addr+"/events"))
+
+	resp, err := s.hc.Post(addr+"/operators", "application/json", strings.NewReader(`{"name": "transfer-leader", "region_id": 1, "store_id": 2}`))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	// The leader is told to transfer the leadership, the operator starts.
+	start := time.Now()
+	mustRegionHeartbeat(c, conn, region, leader)
+	c.Assert(s.mustGetEvents(c, addr+"/events"), HasLen, count+1)
+	evts := s.mustGetEvents(c, addr+"/events?limit=1")
+	c.Assert(evts, HasLen, 1)
+	evt := evts[0]
+	c.Assert(evt.TransferLeaderEvent.Region, Equals, uint64(1))
+	c.Assert(evt.TransferLeaderEvent.StoreFrom, Equals, uint64(1))
+	c.Assert(evt.TransferLeaderEvent.StoreTo, Equals, uint64(2))
+	c.Assert(evt.Time.After(start.Add(-time.Second)), IsTrue)
+
+	// The leader moves, then the operator ends.
+	mustRegionHeartbeat(c, conn, region, newTestPeer(2, 2))
+	c.Assert(s.mustGetEvents(c, addr+"/events"), HasLen, count+2)
+	evts = s.mustGetEvents(c, addr+"/events?limit=2")
+	c.Assert(evts, HasLen, 2)
+	c.Assert(evts[1].Status, Not(Equals), evts[0].Status)
+	c.Assert(evts[1].ID > evts[0].ID, IsTrue)
+	c.Assert(evts[1].TransferLeaderEvent, DeepEquals, evts[0].TransferLeaderEvent)
+
+	// The store state change is recorded too.
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster.OfflineStore(3), IsNil)
+	evts = s.mustGetEvents(c, addr+"/events?limit=1")
+	c.Assert(evts, HasLen, 1)
+	c.Assert(evts[0].StoreStateEvent.Store, Equals, uint64(3))
+	c.Assert(evts[0].StoreStateEvent.State, Equals, server.StoreStateOffline.String())
+
+	resp, err = s.hc.Get(addr + "/events?limit=-1")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *testEventSuite) TestStoreHistory(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3, 4} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	leader := newTestPeer(1, 1)
+	region := newTestRegion(1, []byte{}, []byte{}, leader, newTestPeer(2, 2), newTestPeer(3, 3))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(3),
+		Version: proto.Uint64(1),
+	}
+	mustRegionHeartbeat(c, conn, region, leader)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	// The peer changes in the heartbeat may be recorded already.
+	counts := make(map[string]int)
+	for _, storeID := range []string{"1", "2"} {
+		counts[storeID] = len(s.mustGetEvents(c, addr+"/stores/"+storeID+"/history"))
+	}
+
+	// The leader is transferred from store 1 to store 2.
+	resp, err := s.hc.Post(addr+"/operators", "application/json", strings.NewReader(`{"name": "transfer-leader", "region_id": 1, "store_id": 2}`))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	mustRegionHeartbeat(c, conn, region, leader)
+	mustRegionHeartbeat(c, conn, region, newTestPeer(2, 2))
+
+	for _, storeID := range []string{"1", "2"} {
+		evts := s.mustGetEvents(c, addr+"/stores/"+storeID+"/history")
+		c.Assert(evts, HasLen, counts[storeID]+2)
+		c.Assert(evts[0].ID > evts[1].ID, IsTrue)
+		c.Assert(evts[0].Status, Not(Equals), evts[1].Status)
+		c.Assert(evts[0].TransferLeaderEvent.Region, Equals, uint64(1))
+		c.Assert(evts[0].TransferLeaderEvent.StoreFrom, Equals, uint64(1))
+		c.Assert(evts[0].TransferLeaderEvent.StoreTo, Equals, uint64(2))
+	}
+
+	// The store state change of store 3 is newer than the transfer.
+	time.Sleep(time.Second)
+	since := time.Now().Format(time.RFC3339)
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster.OfflineStore(3), IsNil)
+	evts := s.mustGetEvents(c, addr+"/stores/3/history?since="+since)
+	c.Assert(evts, HasLen, 1)
+	c.Assert(evts[0].StoreStateEvent.State, Equals, server.StoreStateOffline.String())
+	c.Assert(s.mustGetEvents(c, addr+"/stores/2/history?since="+since), HasLen, 0)
+
+	// Store 4 has no history, the list is empty rather than null.
+	resp, err = s.hc.Get(addr + "/stores/4/history")
+	c.Assert(err, IsNil)
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(strings.TrimSpace(string(buf)), Equals, "[]")
+
+	resp, err = s.hc.Get(addr + "/stores/5/history")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+
+	resp, err = s.hc.Get(addr + "/stores/1/history?since=yesterday")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+}
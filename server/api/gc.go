@@ -0,0 +1,114 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+type safePointInfo struct {
+	SafePoint uint64 `json:"safe_point"`
+}
+
+// safePointRollback is the error response of lowering the safe point, it
+// has the current safe point.
+type safePointRollback struct {
+	errorResponse
+	SafePoint uint64 `json:"safe_point"`
+}
+
+type serviceSafePointInput struct {
+	ServiceID string `json:"service_id"`
+	// TTL is in seconds.
+	TTL       int64  `json:"ttl"`
+	SafePoint uint64 `json:"safe_point"`
+}
+
+type gcHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newGCHandler(svr *server.Server, rd *render.Render) *gcHandler {
+	return &gcHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *gcHandler) GetSafePoint(w http.ResponseWriter, r *http.Request) {
+	safePoint, err := h.svr.GetGCSafePoint()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, &safePointInfo{SafePoint: safePoint})
+}
+
+// PostSafePoint advances the safe point, a safe point less than the current
+// one is rejected with the current one.
+func (h *gcHandler) PostSafePoint(w http.ResponseWriter, r *http.Request) {
+	input := &safePointInfo{}
+	if err := fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	safePoint, err := h.svr.UpdateGCSafePoint(input.SafePoint)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &safePointInfo{SafePoint: safePoint})
+	case server.ErrSafePointRollback:
+		logError(w, http.StatusPreconditionFailed, errCodeSafePointRollback, err.Error())
+		h.rd.JSON(w, http.StatusPreconditionFailed, &safePointRollback{
+			errorResponse: errorResponse{
+				Code:    errCodeSafePointRollback,
+				Message: err.Error(),
+			},
+			SafePoint: safePoint,
+		})
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// PostServiceSafePoint registers the safe point of a service for the TTL, and
+// returns the minimal safe point of the services which are not expired.
+func (h *gcHandler) PostServiceSafePoint(w http.ResponseWriter, r *http.Request) {
+	input := &serviceSafePointInput{}
+	if err := fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	if input.TTL <= 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidTTL, "ttl must be positive")
+		return
+	}
+
+	ttl := time.Duration(input.TTL) * time.Second
+	safePoint, err := h.svr.UpdateServiceGCSafePoint(input.ServiceID, ttl, input.SafePoint)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &safePointInfo{SafePoint: safePoint})
+	case server.ErrInvalidServiceID:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidServiceID, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testGCSuite{})
+
+type testGCSuite struct {
+	hc *http.Client
+}
+
+func (s *testGCSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testGCSuite) TestSafePoint(c *C) {
+	cfgs, _, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/gc/safepoint"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	mustPost := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustGet := func() uint64 {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		info := &safePointInfo{}
+		c.Assert(json.Unmarshal(buf, info), IsNil)
+		return info.SafePoint
+	}
+	safePointOf := func(buf []byte) uint64 {
+		info := &safePointInfo{}
+		c.Assert(json.Unmarshal(buf, info), IsNil)
+		return info.SafePoint
+	}
+
+	c.Assert(mustGet(), Equals, uint64(0))
+
+	c.Assert(safePointOf(mustPost(`{"safe_point": 100}`, http.StatusOK)), Equals, uint64(100))
+	c.Assert(mustGet(), Equals, uint64(100))
+	// Saving the same safe point again is not a rollback.
+	c.Assert(safePointOf(mustPost(`{"safe_point": 100}`, http.StatusOK)), Equals, uint64(100))
+
+	buf := mustPost(`{"safe_point": 50}`, http.StatusPreconditionFailed)
+	checkErrorResponse(c, buf, errCodeSafePointRollback)
+	c.Assert(safePointOf(buf), Equals, uint64(100))
+	c.Assert(mustGet(), Equals, uint64(100))
+
+	c.Assert(safePointOf(mustPost(`{"safe_point": 200}`, http.StatusOK)), Equals, uint64(200))
+	c.Assert(mustGet(), Equals, uint64(200))
+
+	checkErrorResponse(c, mustPost(`{"safe_point": "x"}`, http.StatusBadRequest), errCodeInvalidBody)
+	c.Assert(mustGet(), Equals, uint64(200))
+}
+
+func (s *testGCSuite) TestServiceSafePoint(c *C) {
+	cfgs, _, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/gc/service-safepoint"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	mustPost := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustRegister := func(serviceID string, ttl int64, safePoint uint64) uint64 {
+		input, err := json.Marshal(&serviceSafePointInput{ServiceID: serviceID, TTL: ttl, SafePoint: safePoint})
+		c.Assert(err, IsNil)
+		info := &safePointInfo{}
+		c.Assert(json.Unmarshal(mustPost(string(input), http.StatusOK), info), IsNil)
+		return info.SafePoint
+	}
+
+	c.Assert(mustRegister("backup", 60, 200), Equals, uint64(200))
+	c.Assert(mustRegister("cdc", 1, 100), Equals, uint64(100))
+	// The global safe point is the smaller one no matter who registers last.
+	c.Assert(mustRegister("backup", 60, 300), Equals, uint64(100))
+
+	// The registration of cdc expires and is ignored.
+	time.Sleep(1500 * time.Millisecond)
+	c.Assert(mustRegister("backup", 60, 300), Equals, uint64(300))
+	c.Assert(mustRegister("cdc", 60, 400), Equals, uint64(300))
+
+	checkErrorResponse(c, mustPost(`{"ttl": 60, "safe_point": 100}`, http.StatusBadRequest), errCodeInvalidServiceID)
+	checkErrorResponse(c, mustPost(`{"service_id": "../safe_point", "ttl": 60, "safe_point": 100}`, http.StatusBadRequest), errCodeInvalidServiceID)
+	checkErrorResponse(c, mustPost(`{"service_id": "a/b", "ttl": 60, "safe_point": 100}`, http.StatusBadRequest), errCodeInvalidServiceID)
+	checkErrorResponse(c, mustPost(`{"service_id": "cdc", "safe_point": 100}`, http.StatusBadRequest), errCodeInvalidTTL)
+	checkErrorResponse(c, mustPost(`service`, http.StatusBadRequest), errCodeInvalidBody)
+
+	// The global safe point is capped by the service safe points.
+	parts = []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/gc/safepoint"}
+	gcAddr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	mustPostGC := func(body string) uint64 {
+		resp, err := s.hc.Post(gcAddr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		info := &safePointInfo{}
+		c.Assert(json.NewDecoder(resp.Body).Decode(info), IsNil)
+		return info.SafePoint
+	}
+	c.Assert(mustPostGC(`{"safe_point": 500}`), Equals, uint64(300))
+	c.Assert(mustPostGC(`{"safe_point": 400}`), Equals, uint64(300))
+	c.Assert(mustRegister("backup", 60, 600), Equals, uint64(400))
+	c.Assert(mustPostGC(`{"safe_point": 500}`), Equals, uint64(400))
+}
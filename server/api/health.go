@@ -0,0 +1,60 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+// healthInfo is the response body of the health check.
+type healthInfo struct {
+	Name     string `json:"name"`
+	Leader   string `json:"leader"`
+	IsLeader bool   `json:"is_leader"`
+}
+
+type healthHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newHealthHandler(svr *server.Server, rd *render.Render) *healthHandler {
+	return &healthHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// ServeHTTP only reads the leader key, the read goes through the etcd
+// quorum, so it fails if the member loses the connection to etcd.
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	leader, err := h.svr.GetLeader()
+	if err != nil {
+		writeError(h.rd, w, http.StatusServiceUnavailable, errCodeEtcdUnavailable, err.Error())
+		return
+	}
+	if leader == nil {
+		writeError(h.rd, w, http.StatusServiceUnavailable, errCodeNoLeader, "no leader now")
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, &healthInfo{
+		Name:     h.svr.Name(),
+		Leader:   leader.GetAddr(),
+		IsLeader: h.svr.IsLeader(),
+	})
+}
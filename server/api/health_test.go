@@ -0,0 +1,62 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testHealthSuite{})
+
+type testHealthSuite struct {
+	hc *http.Client
+}
+
+func (s *testHealthSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testHealthSuite) TestHealth(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	leaders := 0
+	for _, svr := range svrs {
+		parts := []string{svr.GetAddr(), apiPrefix, "/api/v1/health"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+		var got healthInfo
+		c.Assert(json.Unmarshal(buf, &got), IsNil)
+		c.Assert(got.Name, Equals, svr.Name())
+		c.Assert(got.Leader, Equals, leader.GetAddr())
+		c.Assert(got.IsLeader, Equals, svr == leader)
+		if got.IsLeader {
+			leaders++
+		}
+	}
+	c.Assert(leaders, Equals, 1)
+}
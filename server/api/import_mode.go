@@ -0,0 +1,93 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+type importModeHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newImportModeHandler(svr *server.Server, rd *render.Render) *importModeHandler {
+	return &importModeHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// importModeInput is the request body to change the import mode,
+// e.g, {"enabled": true}.
+type importModeInput struct {
+	Enabled *bool `json:"enabled"`
+}
+
+func (h *importModeHandler) Get(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	info, err := cluster.GetImportMode()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, info)
+}
+
+func (h *importModeHandler) Post(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	input := &importModeInput{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	if input.Enabled == nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, "enabled is not specified")
+		return
+	}
+
+	if err = cluster.SetImportMode(*input.Enabled); err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	info, err := cluster.GetImportMode()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testImportModeSuite{})
+
+type testImportModeSuite struct {
+	hc *http.Client
+}
+
+func (s *testImportModeSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testImportModeSuite) TestImportMode(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+
+	httpAddr := func(path string) string {
+		addr, err := unixAddrToHTTPAddr(strings.Join([]string{cfgs[0].ClientUrls, apiPrefix, path}, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+	addr, statusAddr := httpAddr("/api/v1/admin/import-mode"), httpAddr("/api/v1/cluster/status")
+
+	readBody := func(resp *http.Response, status int) []byte {
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustPost := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		return readBody(resp, status)
+	}
+	mustGet := func() *server.ImportModeInfo {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		info := &server.ImportModeInfo{}
+		c.Assert(json.Unmarshal(readBody(resp, http.StatusOK), info), IsNil)
+		return info
+	}
+	mustGetStatus := func() *server.ClusterStatus {
+		resp, err := s.hc.Get(statusAddr)
+		c.Assert(err, IsNil)
+		status := &server.ClusterStatus{}
+		c.Assert(json.Unmarshal(readBody(resp, http.StatusOK), status), IsNil)
+		return status
+	}
+
+	c.Assert(mustGet().Enabled, IsFalse)
+	c.Assert(mustGetStatus().ImportMode, IsFalse)
+
+	mustPost(`{"enabled": true}`, http.StatusOK)
+	info := mustGet()
+	c.Assert(info.Enabled, IsTrue)
+	c.Assert(info.ExpiresAt.Sub(info.EnabledAt), Equals, svrs[0].GetConfig().BalanceCfg.ImportModeTTL.Duration)
+	c.Assert(mustGetStatus().ImportMode, IsTrue)
+
+	checkErrorResponse(c, mustPost(`{}`, http.StatusBadRequest), errCodeInvalidBody)
+	checkErrorResponse(c, mustPost(`{"enabled": "yes"}`, http.StatusBadRequest), errCodeInvalidBody)
+
+	mustPost(`{"enabled": false}`, http.StatusOK)
+	c.Assert(mustGet().Enabled, IsFalse)
+	c.Assert(mustGetStatus().ImportMode, IsFalse)
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+type logLevelHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newLogLevelHandler(svr *server.Server, rd *render.Render) *logLevelHandler {
+	return &logLevelHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// logLevel is the request and response body of the log level.
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func (h *logLevelHandler) Get(w http.ResponseWriter, r *http.Request) {
+	h.rd.JSON(w, http.StatusOK, &logLevel{Level: h.svr.GetLogLevel()})
+}
+
+func (h *logLevelHandler) Post(w http.ResponseWriter, r *http.Request) {
+	input := &logLevel{}
+	if err := fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	err := h.svr.SetLogLevel(input.Level)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &logLevel{Level: h.svr.GetLogLevel()})
+	case server.ErrInvalidLogLevel:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLogLevel, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
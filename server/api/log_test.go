@@ -0,0 +1,82 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/ngaut/log"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testLogSuite{})
+
+type testLogSuite struct {
+	hc *http.Client
+}
+
+func (s *testLogSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testLogSuite) TestLogLevel(c *C) {
+	cfgs, _, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/admin/log-level"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	mustPost := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustGet := func() string {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		level := &logLevel{}
+		c.Assert(json.Unmarshal(buf, level), IsNil)
+		return level.Level
+	}
+
+	// The log level is process-wide, restore it after the test.
+	oldLevel := log.GetLogLevel()
+	defer log.SetLevel(oldLevel)
+
+	c.Assert(mustGet(), Equals, "info")
+	mustPost(`{"level": "debug"}`, http.StatusOK)
+	c.Assert(mustGet(), Equals, "debug")
+	c.Assert(log.GetLogLevel(), Equals, log.LOG_LEVEL_DEBUG)
+
+	mustPost(`{"level": "warn"}`, http.StatusOK)
+	c.Assert(mustGet(), Equals, "warn")
+	c.Assert(log.GetLogLevel(), Equals, log.LOG_LEVEL_WARN)
+
+	checkErrorResponse(c, mustPost(`{"level": "verbose"}`, http.StatusBadRequest), errCodeInvalidLogLevel)
+	checkErrorResponse(c, mustPost(`{}`, http.StatusBadRequest), errCodeInvalidLogLevel)
+	checkErrorResponse(c, mustPost(`level`, http.StatusBadRequest), errCodeInvalidBody)
+	c.Assert(mustGet(), Equals, "warn")
+}
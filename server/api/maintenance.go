@@ -0,0 +1,98 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+type maintenanceHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newMaintenanceHandler(svr *server.Server, rd *render.Render) *maintenanceHandler {
+	return &maintenanceHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// maintenanceInput is the request body to change the maintenance mode,
+// by is who enables it, the client address is used if it is empty.
+type maintenanceInput struct {
+	Enabled *bool  `json:"enabled"`
+	By      string `json:"by"`
+}
+
+func (h *maintenanceHandler) Get(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	info, err := cluster.GetMaintenance()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, info)
+}
+
+func (h *maintenanceHandler) Post(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	input := &maintenanceInput{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	if input.Enabled == nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, "enabled is not specified")
+		return
+	}
+	by := input.By
+	if len(by) == 0 {
+		by = r.RemoteAddr
+	}
+
+	if err = cluster.SetMaintenance(*input.Enabled, by); err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	info, err := cluster.GetMaintenance()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testMaintenanceSuite{})
+
+type testMaintenanceSuite struct {
+	hc *http.Client
+}
+
+func (s *testMaintenanceSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testMaintenanceSuite) TestMaintenance(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/admin/maintenance"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	readInfo := func(resp *http.Response, status int) []byte {
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustPost := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		return readInfo(resp, status)
+	}
+	mustGet := func() *server.MaintenanceInfo {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		info := &server.MaintenanceInfo{}
+		c.Assert(json.Unmarshal(readInfo(resp, http.StatusOK), info), IsNil)
+		return info
+	}
+
+	c.Assert(mustGet().Enabled, IsFalse)
+	mustPost(`{"enabled": true, "by": "tester"}`, http.StatusOK)
+	info := mustGet()
+	c.Assert(info.Enabled, IsTrue)
+	c.Assert(info.EnabledBy, Equals, "tester")
+	c.Assert(info.EnabledAt.IsZero(), IsFalse)
+
+	checkErrorResponse(c, mustPost(`{"by": "tester"}`, http.StatusBadRequest), errCodeInvalidBody)
+	checkErrorResponse(c, mustPost(`{"enabled": "yes"}`, http.StatusBadRequest), errCodeInvalidBody)
+
+	// The region lacks replicas, but no peer is added in maintenance mode.
+	// The peer ID is large enough not to collide with the allocated ones.
+	leader := newTestPeer(1000, 1)
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: newTestRegion(1, []byte{}, []byte{}, leader),
+			Leader: leader,
+		},
+	}
+	for i := 0; i < 3; i++ {
+		resp := mustRPCCall(c, conn, req)
+		c.Assert(resp.GetRegionHeartbeat().GetChangePeer(), IsNil)
+	}
+
+	mustPost(`{"enabled": false}`, http.StatusOK)
+	c.Assert(mustGet().Enabled, IsFalse)
+	resp := mustRPCCall(c, conn, req)
+	c.Assert(resp.GetRegionHeartbeat().GetChangePeer(), NotNil)
+}
@@ -15,10 +15,16 @@ package api
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
 	"github.com/pingcap/pd/server"
 	"github.com/unrolled/render"
 	"golang.org/x/net/context"
@@ -51,7 +57,7 @@ func (h *memberListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	listResp, err := client.MemberList(ctx)
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 
@@ -70,6 +76,43 @@ func (h *memberListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.rd.JSON(w, http.StatusOK, ret)
 }
 
+type memberGetHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newMemberGetHandler(svr *server.Server, rd *render.Render) *memberGetHandler {
+	return &memberGetHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *memberGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+	client := h.svr.GetClient()
+
+	name := (mux.Vars(r))["name"]
+	listResp, err := client.MemberList(ctx)
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	for _, m := range listResp.Members {
+		if name == m.Name {
+			info := memberInfo{
+				Name:       m.Name,
+				ClientUrls: m.ClientURLs,
+				PeerUrls:   m.PeerURLs,
+			}
+			h.rd.JSON(w, http.StatusOK, info)
+			return
+		}
+	}
+	writeError(h.rd, w, http.StatusNotFound, errCodeMemberNotFound, fmt.Sprintf("not found, pd: %s", name))
+}
+
 type memberDeleteHandler struct {
 	svr *server.Server
 	rd  *render.Render
@@ -92,7 +135,7 @@ func (h *memberDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	defer cancel()
 	listResp, err := client.MemberList(ctx)
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	for _, m := range listResp.Members {
@@ -102,7 +145,7 @@ func (h *memberDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	if id == 0 {
-		h.rd.JSON(w, http.StatusNotFound, fmt.Sprintf("not found, pd: %s", name))
+		writeError(h.rd, w, http.StatusNotFound, errCodeMemberNotFound, fmt.Sprintf("not found, pd: %s", name))
 		return
 	}
 
@@ -111,12 +154,192 @@ func (h *memberDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	defer cancel()
 	_, err = client.MemberRemove(ctx, id)
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
+	if err = h.svr.DeleteMemberLeaderPriority(name); err != nil {
+		log.Warnf("delete leader priority of removed member %s err %v", name, err)
+	}
 	h.rd.JSON(w, http.StatusOK, fmt.Sprintf("removed, pd: %s", name))
 }
 
+// memberUpdate is the request body to update a member.
+type memberUpdate struct {
+	PeerUrls []string `json:"peer-urls"`
+}
+
+type memberUpdateHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newMemberUpdateHandler(svr *server.Server, rd *render.Render) *memberUpdateHandler {
+	return &memberUpdateHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *memberUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := h.svr.GetClient()
+
+	name := (mux.Vars(r))["name"]
+	var input memberUpdate
+	if err := fromBody(r, &input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	if len(input.PeerUrls) == 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidURL, "peer urls are empty")
+		return
+	}
+	for _, u := range input.PeerUrls {
+		if err := checkURLReachable(u); err != nil {
+			writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidURL, fmt.Sprintf("invalid url %s: %v", u, err))
+			return
+		}
+	}
+	force, err := parseQueryBool(r, "force", false)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidForce, err.Error())
+		return
+	}
+
+	// step 1. get etcd member
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+	listResp, err := client.MemberList(ctx)
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	var member *etcdserverpb.Member
+	for _, m := range listResp.Members {
+		if name == m.Name {
+			member = m
+			break
+		}
+	}
+	if member == nil {
+		writeError(h.rd, w, http.StatusNotFound, errCodeMemberNotFound, fmt.Sprintf("not found, pd: %s", name))
+		return
+	}
+
+	// step 2. the leader may be unreachable after its peer urls change,
+	// so it can be updated only with the force flag.
+	if !force {
+		leader, err := h.svr.GetLeader()
+		if err != nil {
+			writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		if leader != nil && isMemberAddr(member, leader.GetAddr()) {
+			writeError(h.rd, w, http.StatusConflict, errCodeMemberIsLeader, fmt.Sprintf("leader, pd: %s, use force to update it", name))
+			return
+		}
+	}
+
+	// step 3. update member peer urls by id
+	ctx, cancel = context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+	if _, err = client.MemberUpdate(ctx, member.ID, input.PeerUrls); err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, fmt.Sprintf("updated, pd: %s", name))
+}
+
+// memberPriority is the leader priority of a member.
+type memberPriority struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+}
+
+type memberPriorityHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newMemberPriorityHandler(svr *server.Server, rd *render.Render) *memberPriorityHandler {
+	return &memberPriorityHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *memberPriorityHandler) Get(w http.ResponseWriter, r *http.Request) {
+	name := (mux.Vars(r))["name"]
+	priority, err := h.svr.GetMemberLeaderPriority(name)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &memberPriority{Name: name, Priority: priority})
+	case server.ErrMemberNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeMemberNotFound, fmt.Sprintf("not found, pd: %s", name))
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// Post changes the leader priority of the member, the leader is transferred
+// to the healthy member with the highest priority soon.
+func (h *memberPriorityHandler) Post(w http.ResponseWriter, r *http.Request) {
+	name := (mux.Vars(r))["name"]
+	input := &memberPriority{}
+	if err := fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	err := h.svr.SetMemberLeaderPriority(name, input.Priority)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &memberPriority{Name: name, Priority: input.Priority})
+	case server.ErrMemberNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeMemberNotFound, fmt.Sprintf("not found, pd: %s", name))
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// checkURLReachable checks the url is valid and can be connected.
+func checkURLReachable(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if u.Host == "" {
+		return errors.New("no host")
+	}
+
+	network := "tcp"
+	switch u.Scheme {
+	case "http", "https":
+	case "unix", "unixs":
+		network = "unix"
+	default:
+		return errors.Errorf("unsupported scheme %s", u.Scheme)
+	}
+
+	conn, err := net.DialTimeout(network, u.Host, defaultDialTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(conn.Close())
+}
+
+// isMemberAddr checks whether the addr, which may have several urls
+// separated by comma, is the client urls of the member.
+func isMemberAddr(m *etcdserverpb.Member, addr string) bool {
+	for _, u := range strings.Split(addr, ",") {
+		for _, clientURL := range m.ClientURLs {
+			if strings.TrimSpace(u) == clientURL {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type leaderInfo struct {
 	Addr string `json:"addr"`
 	Pid  int64  `json:"pid"`
@@ -137,7 +360,7 @@ func newLeaderHandler(svr *server.Server, rd *render.Render) *leaderHandler {
 func (h *leaderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	leader, err := h.svr.GetLeader()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 
@@ -147,3 +370,89 @@ func (h *leaderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	h.rd.JSON(w, http.StatusOK, ret)
 }
+
+type leaderResignHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newLeaderResignHandler(svr *server.Server, rd *render.Render) *leaderResignHandler {
+	return &leaderResignHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *leaderResignHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := h.svr.ResignLeader()
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, fmt.Sprintf("resigned, pd: %s", h.svr.Name()))
+	case server.ErrNotLeader:
+		writeNotLeader(h.svr, h.rd, w)
+	case server.ErrNoHealthyMember:
+		writeError(h.rd, w, http.StatusServiceUnavailable, errCodeNoHealthyMember, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// writeNotLeader tells the client who is the leader now.
+func writeNotLeader(svr *server.Server, rd *render.Render, w http.ResponseWriter) {
+	leader, err := svr.GetLeader()
+	if err != nil {
+		writeError(rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if leader == nil {
+		writeError(rd, w, http.StatusForbidden, errCodeNotLeader, "not leader, no leader now")
+		return
+	}
+	writeError(rd, w, http.StatusForbidden, errCodeNotLeader, fmt.Sprintf("not leader, leader: %s", leader.GetAddr()))
+}
+
+// redirectToLeader redirects the request to the same path on the leader.
+func redirectToLeader(svr *server.Server, rd *render.Render, w http.ResponseWriter, r *http.Request) {
+	leader, err := svr.GetLeader()
+	if err != nil {
+		writeError(rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if leader == nil {
+		writeError(rd, w, http.StatusForbidden, errCodeNotLeader, "not leader, no leader now")
+		return
+	}
+	addr := strings.TrimSuffix(strings.Split(leader.GetAddr(), ",")[0], "/")
+	http.Redirect(w, r, addr+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
+
+type memberLeaderHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newMemberLeaderHandler(svr *server.Server, rd *render.Render) *memberLeaderHandler {
+	return &memberLeaderHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *memberLeaderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := (mux.Vars(r))["name"]
+	err := h.svr.TransferLeader(name)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, fmt.Sprintf("transferred, pd: %s", name))
+	case server.ErrNotLeader:
+		writeNotLeader(h.svr, h.rd, w)
+	case server.ErrMemberNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeMemberNotFound, fmt.Sprintf("not found, pd: %s", name))
+	case server.ErrMemberIsLeader:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeMemberIsLeader, fmt.Sprintf("already leader, pd: %s", name))
+	case server.ErrMemberUnhealthy:
+		writeError(h.rd, w, http.StatusServiceUnavailable, errCodeMemberUnhealthy, fmt.Sprintf("unhealthy, pd: %s", name))
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
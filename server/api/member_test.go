@@ -14,14 +14,24 @@
 package api
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
+	"math/big"
+	mrand "math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
@@ -76,9 +86,18 @@ func unixAddrToHTTPAddr(addr string) (string, error) {
 type cleanUpFunc func()
 
 func mustNewCluster(c *C, num int) ([]*server.Config, []*server.Server, cleanUpFunc) {
+	return mustNewClusterWithConfigs(c, server.NewTestMultiConfig(num))
+}
+
+// mustNewClusterWithConfigs starts the cluster with the configs
+// which are created by server.NewTestMultiConfig.
+// apiPrefix is the default api-prefix of the test servers.
+const apiPrefix = "/pd"
+
+func mustNewClusterWithConfigs(c *C, cfgs []*server.Config) ([]*server.Config, []*server.Server, cleanUpFunc) {
+	num := len(cfgs)
 	dirs := make([]string, 0, num)
 	svrs := make([]*server.Server, 0, num)
-	cfgs := server.NewTestMultiConfig(num)
 
 	ch := make(chan *server.Server, num)
 	for _, cfg := range cfgs {
@@ -144,6 +163,15 @@ func checkListResponse(c *C, body []byte, cfgs []*server.Config) {
 	}
 }
 
+// checkErrorResponse checks the error response body has the expected code.
+func checkErrorResponse(c *C, body []byte, code string) *errorResponse {
+	got := &errorResponse{}
+	c.Assert(json.Unmarshal(body, got), IsNil)
+	c.Assert(got.Code, Equals, code)
+	c.Assert(got.Message, Not(Equals), "")
+	return got
+}
+
 func (s *testMemberAPISuite) TestMemberList(c *C) {
 	numbers := []int{1, 3}
 
@@ -151,7 +179,7 @@ func (s *testMemberAPISuite) TestMemberList(c *C) {
 		cfgs, _, clean := mustNewCluster(c, num)
 		defer clean()
 
-		parts := []string{cfgs[rand.Intn(len(cfgs))].ClientUrls, apiPrefix, "/api/v1/members"}
+		parts := []string{cfgs[mrand.Intn(len(cfgs))].ClientUrls, apiPrefix, "/api/v1/members"}
 		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
 		c.Assert(err, IsNil)
 		resp, err := s.hc.Get(addr)
@@ -162,11 +190,95 @@ func (s *testMemberAPISuite) TestMemberList(c *C) {
 	}
 }
 
+func (s *testMemberAPISuite) TestAPIPrefix(c *C) {
+	cfgs := server.NewTestMultiConfig(1)
+	cfgs[0].APIPrefix = "/custom/"
+	_, _, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	mustGet := func(path string, status int) []byte {
+		addr, err := unixAddrToHTTPAddr(cfgs[0].ClientUrls + path)
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	checkListResponse(c, mustGet("/custom/api/v1/members", http.StatusOK), cfgs)
+	mustGet("/custom/metrics", http.StatusOK)
+	mustGet(apiPrefix+"/api/v1/members", http.StatusNotFound)
+
+	got := &server.Config{}
+	c.Assert(json.Unmarshal(mustGet("/custom/api/v1/config", http.StatusOK), got), IsNil)
+	c.Assert(got.APIPrefix, Equals, "/custom")
+}
+
+func (s *testMemberAPISuite) TestAdvertiseUrls(c *C) {
+	cfgs := server.NewTestMultiConfig(1)
+	cfg := cfgs[0]
+	defer os.RemoveAll(cfg.DataDir)
+
+	cfg.AdvertiseClientUrls = "unix//localhost:1"
+	_, err := server.CreateServer(cfg)
+	c.Assert(err, NotNil)
+
+	// The advertise urls are not listened, the api is served on the
+	// client urls.
+	cfg.AdvertiseClientUrls = fmt.Sprintf("unix://localhost:%d", 50000+os.Getpid()%5000)
+	cfg.AdvertisePeerUrls = fmt.Sprintf("unix://localhost:%d", 55000+os.Getpid()%5000)
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, cfg.AdvertisePeerUrls)
+	_, _, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	addr, err := unixAddrToHTTPAddr(cfg.ClientUrls + apiPrefix + "/api/v1/members/" + cfg.Name)
+	c.Assert(err, IsNil)
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	var got memberInfo
+	c.Assert(json.NewDecoder(resp.Body).Decode(&got), IsNil)
+	c.Assert(got.ClientUrls, DeepEquals, []string{cfg.AdvertiseClientUrls})
+	c.Assert(got.PeerUrls, DeepEquals, []string{cfg.AdvertisePeerUrls})
+}
+
+func (s *testMemberAPISuite) TestMemberGet(c *C) {
+	cfgs, _, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	mustGet := func(name string, status int) []byte {
+		parts := []string{cfgs[mrand.Intn(len(cfgs))].ClientUrls, apiPrefix, "/api/v1/members/", name}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	target := cfgs[mrand.Intn(len(cfgs))]
+	var got memberInfo
+	c.Assert(json.Unmarshal(mustGet(target.Name, http.StatusOK), &got), IsNil)
+	c.Assert(got.Name, Equals, target.Name)
+	relaxEqualStings(c, got.ClientUrls, strings.Split(target.ClientUrls, ","))
+	relaxEqualStings(c, got.PeerUrls, strings.Split(target.PeerUrls, ","))
+
+	checkErrorResponse(c, mustGet(fmt.Sprintf("pd%d", mrand.Int63()), http.StatusNotFound), errCodeMemberNotFound)
+}
+
 func (s *testMemberAPISuite) TestMemberDelete(c *C) {
 	cfgs, _, clean := mustNewCluster(c, 3)
 	defer clean()
 
-	target := rand.Intn(len(cfgs))
+	target := mrand.Intn(len(cfgs))
 	newCfgs := append(cfgs[:target], cfgs[target+1:]...)
 
 	var table = []struct {
@@ -177,22 +289,22 @@ func (s *testMemberAPISuite) TestMemberDelete(c *C) {
 	}{
 		{
 			// delete a nonexistent pd
-			name:    fmt.Sprintf("pd%d", rand.Int63()),
-			addr:    cfgs[rand.Intn(len(cfgs))].ClientUrls,
+			name:    fmt.Sprintf("pd%d", mrand.Int63()),
+			addr:    cfgs[mrand.Intn(len(cfgs))].ClientUrls,
 			checker: Equals,
 			status:  http.StatusNotFound,
 		},
 		{
 			// delete a pd randomly
 			name:    cfgs[target].Name,
-			addr:    cfgs[rand.Intn(len(cfgs))].ClientUrls,
+			addr:    cfgs[mrand.Intn(len(cfgs))].ClientUrls,
 			checker: Equals,
 			status:  http.StatusOK,
 		},
 		{
 			// delete it again
 			name:    cfgs[target].Name,
-			addr:    newCfgs[rand.Intn(len(newCfgs))].ClientUrls,
+			addr:    newCfgs[mrand.Intn(len(newCfgs))].ClientUrls,
 			checker: Not(Equals),
 			status:  http.StatusOK,
 		},
@@ -208,9 +320,14 @@ func (s *testMemberAPISuite) TestMemberDelete(c *C) {
 		c.Assert(err, IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, t.checker, t.status)
+		if resp.StatusCode == http.StatusNotFound {
+			buf, err := ioutil.ReadAll(resp.Body)
+			c.Assert(err, IsNil)
+			checkErrorResponse(c, buf, errCodeMemberNotFound)
+		}
 	}
 
-	parts := []string{cfgs[rand.Intn(len(newCfgs))].ClientUrls, apiPrefix, "/api/v1/members"}
+	parts := []string{cfgs[mrand.Intn(len(newCfgs))].ClientUrls, apiPrefix, "/api/v1/members"}
 	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
 	c.Assert(err, IsNil)
 	resp, err := s.hc.Get(addr)
@@ -221,6 +338,68 @@ func (s *testMemberAPISuite) TestMemberDelete(c *C) {
 	checkListResponse(c, buf, newCfgs)
 }
 
+func (s *testMemberAPISuite) TestMemberUpdate(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	var follower *server.Server
+	for _, svr := range svrs {
+		if svr != leader {
+			follower = svr
+			break
+		}
+	}
+
+	// The new peer url must be reachable.
+	peerURL := fmt.Sprintf("unix://localhost:%d", 60000+os.Getpid()%5000)
+	l, err := net.Listen("unix", strings.TrimPrefix(peerURL, "unix://"))
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	mustUpdate := func(name string, query string, peerURLs []string, status int, code string) {
+		parts := []string{leader.GetAddr(), apiPrefix, "/api/v1/members/", name, query}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		body, err := json.Marshal(&memberUpdate{PeerUrls: peerURLs})
+		c.Assert(err, IsNil)
+		req, err := http.NewRequest("PUT", addr, bytes.NewReader(body))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Do(req)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		if status != http.StatusOK {
+			checkErrorResponse(c, buf, code)
+		}
+	}
+
+	mustUpdate("unknown", "", []string{peerURL}, http.StatusNotFound, errCodeMemberNotFound)
+	mustUpdate(follower.Name(), "", []string{"://bad"}, http.StatusBadRequest, errCodeInvalidURL)
+	mustUpdate(follower.Name(), "", nil, http.StatusBadRequest, errCodeInvalidURL)
+	mustUpdate(follower.Name(), "", []string{"unix://localhost:1"}, http.StatusBadRequest, errCodeInvalidURL)
+	mustUpdate(follower.Name(), "?force=bad", []string{peerURL}, http.StatusBadRequest, errCodeInvalidForce)
+	mustUpdate(leader.Name(), "", []string{peerURL}, http.StatusConflict, errCodeMemberIsLeader)
+	mustUpdate(follower.Name(), "", []string{peerURL}, http.StatusOK, "")
+
+	for _, cfg := range cfgs {
+		if cfg.Name == follower.Name() {
+			cfg.PeerUrls = peerURL
+		}
+	}
+	parts := []string{leader.GetAddr(), apiPrefix, "/api/v1/members"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	checkListResponse(c, buf, cfgs)
+}
+
 func (s *testMemberAPISuite) TestLeader(c *C) {
 	cfgs, svrs, clean := mustNewCluster(c, 3)
 	defer clean()
@@ -228,7 +407,7 @@ func (s *testMemberAPISuite) TestLeader(c *C) {
 	leader, err := svrs[0].GetLeader()
 	c.Assert(err, IsNil)
 
-	parts := []string{cfgs[rand.Intn(len(cfgs))].ClientUrls, apiPrefix, "/api/v1/leader"}
+	parts := []string{cfgs[mrand.Intn(len(cfgs))].ClientUrls, apiPrefix, "/api/v1/leader"}
 	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
 	c.Assert(err, IsNil)
 	resp, err := s.hc.Get(addr)
@@ -242,3 +421,279 @@ func (s *testMemberAPISuite) TestLeader(c *C) {
 	c.Assert(got.Addr, Equals, leader.GetAddr())
 	c.Assert(got.Pid, Equals, leader.GetPid())
 }
+
+// mustWaitLeader waits for a leader which is not in the excluded servers.
+func mustWaitLeader(c *C, svrs []*server.Server, excluded ...*server.Server) *server.Server {
+	isExcluded := func(svr *server.Server) bool {
+		for _, s := range excluded {
+			if s == svr {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The etcd cluster may be electing, so we ignore the errors and wait
+	// longer than the next leader TTL.
+	for i := 0; i < 200; i++ {
+		for _, s := range svrs {
+			leader, err := s.GetLeader()
+			if err != nil || leader == nil || isExcluded(s) {
+				continue
+			}
+			if leader.GetAddr() == s.GetAddr() {
+				return s
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Fatal("no leader")
+	return nil
+}
+
+func (s *testMemberAPISuite) TestLeaderResign(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+
+	mustResign := func(svr *server.Server, status int) []byte {
+		parts := []string{svr.GetAddr(), apiPrefix, "/api/v1/leader/resign"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Post(addr, "application/json", nil)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// Resign on a follower, it tells us the leader address.
+	for _, svr := range svrs {
+		if svr == leader {
+			continue
+		}
+		got := checkErrorResponse(c, mustResign(svr, http.StatusForbidden), errCodeNotLeader)
+		c.Assert(strings.Contains(got.Message, leader.GetAddr()), IsTrue)
+	}
+
+	mustResign(leader, http.StatusOK)
+	// Resign again is safe, the server may have already stepped down.
+	parts := []string{leader.GetAddr(), apiPrefix, "/api/v1/leader/resign"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	resp, err := s.hc.Post(addr, "application/json", nil)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusForbidden, IsTrue)
+
+	// Another member becomes the leader, resign on the old leader now, it is a follower.
+	newLeader := mustWaitLeader(c, svrs, leader)
+	got := checkErrorResponse(c, mustResign(leader, http.StatusForbidden), errCodeNotLeader)
+	c.Assert(strings.Contains(got.Message, newLeader.GetAddr()), IsTrue)
+}
+
+func (s *testMemberAPISuite) TestMemberLeader(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	var target *server.Server
+	for _, svr := range svrs {
+		if svr != leader {
+			target = svr
+			break
+		}
+	}
+
+	mustTransfer := func(svr *server.Server, name string, status int, code string) {
+		parts := []string{svr.GetAddr(), apiPrefix, "/api/v1/members/", name, "/leader"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Post(addr, "application/json", nil)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		if status != http.StatusOK {
+			checkErrorResponse(c, buf, code)
+		}
+	}
+
+	mustTransfer(target, target.Name(), http.StatusForbidden, errCodeNotLeader)
+	mustTransfer(leader, leader.Name(), http.StatusBadRequest, errCodeMemberIsLeader)
+	mustTransfer(leader, "unknown", http.StatusNotFound, errCodeMemberNotFound)
+	mustTransfer(leader, target.Name(), http.StatusOK, "")
+
+	newLeader := mustWaitLeader(c, svrs, leader)
+	c.Assert(newLeader.Name(), Equals, target.Name())
+}
+
+func (s *testMemberAPISuite) TestMemberPriority(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	var target *server.Server
+	for _, svr := range svrs {
+		if svr != leader {
+			target = svr
+			break
+		}
+	}
+
+	priorityAddr := func(name string) string {
+		parts := []string{leader.GetAddr(), apiPrefix, "/api/v1/members/", name, "/priority"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+	mustGetPriority := func(name string) int {
+		resp, err := s.hc.Get(priorityAddr(name))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		got := &memberPriority{}
+		c.Assert(json.Unmarshal(buf, got), IsNil)
+		c.Assert(got.Name, Equals, name)
+		return got.Priority
+	}
+	mustSetPriority := func(name string, body string, status int) []byte {
+		resp, err := s.hc.Post(priorityAddr(name), "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// The members have the same priority, the leader is kept.
+	c.Assert(mustGetPriority(target.Name()), Equals, 0)
+	checkErrorResponse(c, mustSetPriority("unknown", `{"priority": 10}`, http.StatusNotFound), errCodeMemberNotFound)
+	checkErrorResponse(c, mustSetPriority(target.Name(), `{"priority": "x"}`, http.StatusBadRequest), errCodeInvalidBody)
+
+	// The member with a higher priority becomes the leader.
+	mustSetPriority(target.Name(), `{"priority": 10}`, http.StatusOK)
+	c.Assert(mustGetPriority(target.Name()), Equals, 10)
+	for i := 0; i < 200 && !target.IsLeader(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(target.IsLeader(), IsTrue)
+	c.Assert(mustWaitLeader(c, svrs), Equals, target)
+
+	// The members have the same priority, the one with the smallest name
+	// becomes the leader.
+	first := svrs[0]
+	for _, svr := range svrs {
+		mustSetPriority(svr.Name(), `{"priority": 10}`, http.StatusOK)
+		if svr.Name() < first.Name() {
+			first = svr
+		}
+	}
+	for i := 0; i < 200 && !first.IsLeader(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(first.IsLeader(), IsTrue)
+	c.Assert(mustWaitLeader(c, svrs), Equals, first)
+}
+
+// mustGenerateCert generates a self-signed certificate for 127.0.0.1,
+// it is also used as the trusted CA.
+func mustGenerateCert(c *C, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"pd"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, IsNil)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	c.Assert(ioutil.WriteFile(certFile, certPEM, 0600), IsNil)
+	c.Assert(ioutil.WriteFile(keyFile, keyPEM, 0600), IsNil)
+	return certFile, keyFile
+}
+
+// freeHTTPSURL returns a https url with a free local port.
+func freeHTTPSURL(c *C) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	return fmt.Sprintf("https://%s", l.Addr().String())
+}
+
+func (s *testMemberAPISuite) TestMemberListTLS(c *C) {
+	certDir, err := ioutil.TempDir("/tmp", "test_pd_tls")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(certDir)
+	certFile, keyFile := mustGenerateCert(c, certDir)
+
+	cfg := server.NewTestSingleConfig()
+	defer os.RemoveAll(cfg.DataDir)
+	cfg.ClientUrls = freeHTTPSURL(c)
+	cfg.PeerUrls = freeHTTPSURL(c)
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, cfg.PeerUrls)
+	cfg.CertFile = certFile
+	cfg.KeyFile = keyFile
+	cfg.TrustedCAFile = certFile
+
+	svr, err := server.CreateServer(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(svr.StartEtcd(NewHandler(svr)), IsNil)
+	go svr.Run()
+	defer svr.Close()
+
+	caPEM, err := ioutil.ReadFile(certFile)
+	c.Assert(err, IsNil)
+	pool := x509.NewCertPool()
+	c.Assert(pool.AppendCertsFromPEM(caPEM), IsTrue)
+	hc := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+
+	resp, err := hc.Get(cfg.ClientUrls + apiPrefix + "/api/v1/members")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	checkListResponse(c, buf, []*server.Config{cfg})
+
+	// The plain http client can not talk to the TLS server.
+	resp, err = http.Get(strings.Replace(cfg.ClientUrls, "https", "http", 1) + apiPrefix + "/api/v1/members")
+	if err == nil {
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Not(Equals), http.StatusOK)
+	}
+}
+
+func (s *testMemberAPISuite) TestPartialTLSConfig(c *C) {
+	cfg := server.NewTestSingleConfig()
+	defer os.RemoveAll(cfg.DataDir)
+	cfg.CertFile = "cert.pem"
+	cfg.KeyFile = "key.pem"
+
+	_, err := server.CreateServer(cfg)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	. "github.com/pingcap/check"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var _ = Suite(&testMetricsSuite{})
+
+type testMetricsSuite struct {
+	hc *http.Client
+}
+
+func (s *testMetricsSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testMetricsSuite) mustScrape(c *C, addr string) map[string]*dto.MetricFamily {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	c.Assert(err, IsNil)
+	return families
+}
+
+func (s *testMetricsSuite) TestMetrics(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	mustRegionHeartbeat(c, conn, newTestRegion(1, []byte{}, []byte{}, newTestPeer(1, 1)), newTestPeer(1, 1))
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/metrics"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	families := s.mustScrape(c, addr)
+	c.Assert(families["pd_cluster_stores_total"].GetMetric()[0].GetGauge().GetValue(), Equals, float64(3))
+	c.Assert(families["pd_cluster_regions_total"].GetMetric()[0].GetGauge().GetValue(), Equals, float64(1))
+	c.Assert(families["pd_leader_changes_total"].GetMetric()[0].GetCounter().GetValue() > 0, IsTrue)
+
+	leaders := make(map[string]float64)
+	for _, m := range families["pd_cluster_store_leaders"].GetMetric() {
+		leaders[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+	}
+	c.Assert(leaders, DeepEquals, map[string]float64{"1": 1, "2": 0, "3": 0})
+}
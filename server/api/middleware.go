@@ -0,0 +1,265 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+	"github.com/urfave/negroni"
+	"golang.org/x/net/context"
+)
+
+// requestIDHeader is the header to pass the request ID, the ID from the
+// client is used if it exists, so the request can be traced across services.
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// requestLog is the log line written after a request is handled.
+type requestLog struct {
+	RequestID string `json:"request_id"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Latency   string `json:"latency"`
+}
+
+// requestLogger gives every request an ID, the ID is returned in the
+// X-Request-Id header and logged with the request result.
+type requestLogger struct{}
+
+func newRequestLogger() *requestLogger {
+	return &requestLogger{}
+}
+
+func (l *requestLogger) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+
+	id := r.Header.Get(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+
+	next(w, r.WithContext(ctx))
+
+	entry := &requestLog{
+		RequestID: id,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Latency:   time.Since(start).String(),
+	}
+	if res, ok := w.(negroni.ResponseWriter); ok {
+		entry.Status = res.Status()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("[%s] marshal request log err %v", id, err)
+		return
+	}
+	log.Info(string(line))
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Errorf("generate request id err %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID of the request, the log lines about the request
+// should be prefixed with it.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// possiblyStaleHeader marks the reads served while the leader is warming
+// up, the region leaders restored from the snapshot may be outdated.
+const possiblyStaleHeader = "X-Possibly-Stale"
+
+// staleReadMarker sets the possibly stale header for the reads if some
+// region leaders haven't reported to the new leader since it's elected.
+type staleReadMarker struct {
+	svr *server.Server
+}
+
+func newStaleReadMarker(svr *server.Server) *staleReadMarker {
+	return &staleReadMarker{svr: svr}
+}
+
+func (m *staleReadMarker) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		cluster, err := m.svr.GetRaftCluster()
+		if err == nil && cluster != nil && cluster.IsWarmingUp() {
+			w.Header().Set(possiblyStaleHeader, "true")
+		}
+	}
+	next(w, r)
+}
+
+// strongReadHandler serves the reads with ?consistency=strong only after
+// the leader confirms its leadership in etcd, the followers redirect them
+// to the leader. The reads without it may be served from a stale cache,
+// e.g, right after the leader changes.
+type strongReadHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStrongReadHandler(svr *server.Server) *strongReadHandler {
+	return &strongReadHandler{
+		svr: svr,
+		rd:  render.New(render.Options{IndentJSON: true}),
+	}
+}
+
+func (h *strongReadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		next(w, r)
+		return
+	}
+
+	switch consistency := r.URL.Query().Get("consistency"); consistency {
+	case "":
+		next(w, r)
+		return
+	case "strong":
+	default:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidConsistency, fmt.Sprintf("invalid consistency: %s", consistency))
+		return
+	}
+
+	if !h.svr.IsLeader() {
+		redirectToLeader(h.svr, h.rd, w, r)
+		return
+	}
+	if err := h.svr.ConfirmLeader(); err != nil {
+		writeError(h.rd, w, http.StatusServiceUnavailable, errCodeReadNotConfirmed, fmt.Sprintf("can't confirm the leadership: %v", err))
+		return
+	}
+	// The region leaders restored from the snapshot may be outdated.
+	if cluster, err := h.svr.GetRaftCluster(); err == nil && cluster != nil && cluster.IsWarmingUp() {
+		writeError(h.rd, w, http.StatusServiceUnavailable, errCodeReadNotConfirmed, "the leader is warming up")
+		return
+	}
+	next(w, r)
+}
+
+const (
+	corsAllowMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders = "Content-Type, " + requestIDHeader
+	corsMaxAge       = "600"
+)
+
+// corsHandler adds the CORS headers for the allowed origins, so the browser
+// based dashboards can call the API directly. "*" allows all origins.
+type corsHandler struct {
+	origins map[string]struct{}
+}
+
+func newCORSHandler(origins []string) *corsHandler {
+	h := &corsHandler{origins: make(map[string]struct{}, len(origins))}
+	for _, origin := range origins {
+		h.origins[origin] = struct{}{}
+	}
+	return h
+}
+
+func (h *corsHandler) isAllowed(origin string) bool {
+	if _, ok := h.origins["*"]; ok {
+		return true
+	}
+	_, ok := h.origins[origin]
+	return ok
+}
+
+func (h *corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !h.isAllowed(origin) {
+		next(w, r)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Add("Vary", "Origin")
+	header.Set("Access-Control-Expose-Headers", requestIDHeader+", "+possiblyStaleHeader)
+
+	// The preflight request is answered here, the routes don't accept OPTIONS.
+	if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+		allowHeaders := corsAllowHeaders
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			allowHeaders = strings.Join([]string{allowHeaders, requested}, ", ")
+		}
+		header.Set("Access-Control-Allow-Headers", allowHeaders)
+		header.Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	next(w, r)
+}
+
+// basicAuthHandler requires the HTTP basic auth for the requests which
+// may change the state, the reads are left open for compatibility.
+type basicAuthHandler struct {
+	username string
+	password string
+	rd       *render.Render
+}
+
+func newBasicAuthHandler(username string, password string) *basicAuthHandler {
+	return &basicAuthHandler{
+		username: username,
+		password: password,
+		rd:       render.New(render.Options{IndentJSON: true}),
+	}
+}
+
+func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		next(w, r)
+		return
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok || !h.check(username, password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="pd"`)
+		writeError(h.rd, w, http.StatusUnauthorized, errCodeUnauthorized, "invalid or missing credentials")
+		return
+	}
+	next(w, r)
+}
+
+func (h *basicAuthHandler) check(username string, password string) bool {
+	// Compare both in constant time, so the time doesn't tell which is wrong.
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(h.username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) == 1
+	return userOK && passOK
+}
@@ -0,0 +1,259 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/pd/server"
+	"github.com/urfave/negroni"
+)
+
+var _ = Suite(&testRequestLoggerSuite{})
+
+type testRequestLoggerSuite struct{}
+
+func (s *testRequestLoggerSuite) TestRequestID(c *C) {
+	// The handler can get the request ID from the context.
+	var handledID string
+	engine := negroni.New(newRequestLogger())
+	engine.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handledID = requestID(r)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	ids := make(map[string]struct{})
+	for i := 0; i < 10; i++ {
+		req, err := http.NewRequest("GET", "/pd/api/v1/version", nil)
+		c.Assert(err, IsNil)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		c.Assert(w.Code, Equals, http.StatusAccepted)
+
+		id := w.Header().Get(requestIDHeader)
+		c.Assert(id, Not(Equals), "")
+		c.Assert(id, Equals, handledID)
+		_, ok := ids[id]
+		c.Assert(ok, IsFalse)
+		ids[id] = struct{}{}
+	}
+
+	// The request ID from the client is kept.
+	req, err := http.NewRequest("GET", "/pd/api/v1/version", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set(requestIDHeader, "client-id")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	c.Assert(w.Header().Get(requestIDHeader), Equals, "client-id")
+	c.Assert(handledID, Equals, "client-id")
+}
+
+func (s *testRequestLoggerSuite) TestAPIRequestID(c *C) {
+	cfgs, _, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	hc := newUnixSocketClient()
+	addr, err := unixAddrToHTTPAddr(cfgs[0].ClientUrls + apiPrefix + "/api/v1/version")
+	c.Assert(err, IsNil)
+
+	var last string
+	for i := 0; i < 2; i++ {
+		resp, err := hc.Get(addr)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		id := resp.Header.Get(requestIDHeader)
+		c.Assert(id, Not(Equals), "")
+		c.Assert(id, Not(Equals), last)
+		last = id
+	}
+}
+
+func (s *testRequestLoggerSuite) TestCORS(c *C) {
+	engine := negroni.New(newCORSHandler([]string{"http://dashboard"}))
+	engine.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	serve := func(method string, origin string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/pd/api/v1/stores/1", nil)
+		c.Assert(err, IsNil)
+		req.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "DELETE")
+			req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("OPTIONS", "http://dashboard")
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), Equals, "http://dashboard")
+	c.Assert(w.Header().Get("Access-Control-Allow-Methods"), Equals, corsAllowMethods)
+	c.Assert(w.Header().Get("Access-Control-Allow-Headers"), Equals, corsAllowHeaders+", X-Custom")
+
+	w = serve("DELETE", "http://dashboard")
+	c.Assert(w.Code, Equals, http.StatusAccepted)
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), Equals, "http://dashboard")
+	c.Assert(w.Header().Get("Access-Control-Allow-Methods"), Equals, "")
+
+	// The other origins get no CORS header.
+	w = serve("OPTIONS", "http://other")
+	c.Assert(w.Code, Equals, http.StatusAccepted)
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), Equals, "")
+
+	engine = negroni.New(newCORSHandler([]string{"*"}))
+	c.Assert(serve("OPTIONS", "http://other").Header().Get("Access-Control-Allow-Origin"), Equals, "http://other")
+}
+
+func (s *testRequestLoggerSuite) TestAPICORS(c *C) {
+	cfgs := server.NewTestMultiConfig(2)
+	cfgs[0].CORSAllowedOrigins = []string{"http://dashboard"}
+	for _, cfg := range cfgs {
+		// Start the servers as two separate clusters.
+		cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, cfg.PeerUrls)
+	}
+	_, _, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	hc := newUnixSocketClient()
+	preflight := func(cfg *server.Config) *http.Response {
+		addr, err := unixAddrToHTTPAddr(cfg.ClientUrls + apiPrefix + "/api/v1/members")
+		c.Assert(err, IsNil)
+		req, err := http.NewRequest("OPTIONS", addr, nil)
+		c.Assert(err, IsNil)
+		req.Header.Set("Origin", "http://dashboard")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		resp, err := hc.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := preflight(cfgs[0])
+	c.Assert(resp.StatusCode, Equals, http.StatusNoContent)
+	c.Assert(resp.Header.Get("Access-Control-Allow-Origin"), Equals, "http://dashboard")
+	c.Assert(resp.Header.Get("Access-Control-Allow-Methods"), Equals, corsAllowMethods)
+
+	resp = preflight(cfgs[1])
+	c.Assert(resp.StatusCode, Not(Equals), http.StatusNoContent)
+	c.Assert(resp.Header.Get("Access-Control-Allow-Origin"), Equals, "")
+	c.Assert(resp.Header.Get("Access-Control-Allow-Methods"), Equals, "")
+}
+
+func (s *testRequestLoggerSuite) TestAPIBasicAuth(c *C) {
+	cfgs := server.NewTestMultiConfig(1)
+	cfgs[0].APIUsername = "admin"
+	cfgs[0].APIPassword = "secret"
+	_, svrs, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+	mustPutStore(c, conn, newTestStore(2))
+
+	hc := newUnixSocketClient()
+	addr, err := unixAddrToHTTPAddr(cfgs[0].ClientUrls + apiPrefix + "/api/v1/stores/2")
+	c.Assert(err, IsNil)
+	mustDelete := func(username string, password string, status int) *http.Response {
+		req, err := http.NewRequest("DELETE", addr, nil)
+		c.Assert(err, IsNil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := hc.Do(req)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		if status == http.StatusUnauthorized {
+			checkErrorResponse(c, buf, errCodeUnauthorized)
+		}
+		return resp
+	}
+
+	resp := mustDelete("", "", http.StatusUnauthorized)
+	c.Assert(resp.Header.Get("WWW-Authenticate"), Equals, `Basic realm="pd"`)
+	mustDelete("admin", "wrong", http.StatusUnauthorized)
+	mustDelete("other", "secret", http.StatusUnauthorized)
+	mustDelete("admin", "secret", http.StatusOK)
+
+	// The reads are not authenticated, and the password is not exposed.
+	resp, err = hc.Get(addr)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	cfgAddr, err := unixAddrToHTTPAddr(cfgs[0].ClientUrls + apiPrefix + "/api/v1/config")
+	c.Assert(err, IsNil)
+	resp, err = hc.Get(cfgAddr)
+	c.Assert(err, IsNil)
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(buf), "secret"), IsFalse)
+	c.Assert(strings.Contains(svrs[0].GetConfig().String(), "secret"), IsFalse)
+}
+
+func (s *testRequestLoggerSuite) TestAPIStrongRead(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	conn := mustRPCConnect(c, leader)
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+
+	const path = "/api/v1/stores?consistency=strong"
+	hc := newUnixSocketClient()
+	hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	mustGet := func(svr *server.Server, path string, status int) *http.Response {
+		addr, err := unixAddrToHTTPAddr(svr.GetAddr() + apiPrefix + path)
+		c.Assert(err, IsNil)
+		resp, err := hc.Get(addr)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		if status == http.StatusBadRequest {
+			checkErrorResponse(c, buf, errCodeInvalidConsistency)
+		}
+		return resp
+	}
+
+	// The leader confirms its leadership and serves the read.
+	mustGet(leader, path, http.StatusOK)
+	mustGet(leader, "/api/v1/stores?consistency=abc", http.StatusBadRequest)
+
+	// The followers redirect the strong reads to the leader, and serve the
+	// other reads themselves.
+	for _, svr := range svrs {
+		if svr == leader {
+			continue
+		}
+		resp := mustGet(svr, path, http.StatusTemporaryRedirect)
+		c.Assert(resp.Header.Get("Location"), Equals, leader.GetAddr()+apiPrefix+path)
+		mustGet(svr, "/api/v1/stores", http.StatusOK)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+type operatorsInfo struct {
+	Count     int                      `json:"count"`
+	Operators []*server.OperatorStatus `json:"operators"`
+}
+
+type dryRunOperatorsInfo struct {
+	Count     int                            `json:"count"`
+	Operators []*server.DryRunOperatorStatus `json:"operators"`
+}
+
+type operatorAdded struct {
+	ID uint64 `json:"id"`
+}
+
+type operatorHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newOperatorHandler(svr *server.Server, rd *render.Render) *operatorHandler {
+	return &operatorHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *operatorHandler) List(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	dryRun, err := parseQueryBool(r, "dry-run", false)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidDryRun, err.Error())
+		return
+	}
+	if dryRun {
+		operators := cluster.GetDryRunOperators()
+		h.rd.JSON(w, http.StatusOK, &dryRunOperatorsInfo{
+			Count:     len(operators),
+			Operators: operators,
+		})
+		return
+	}
+
+	operators := cluster.GetOperators()
+	h.rd.JSON(w, http.StatusOK, &operatorsInfo{
+		Count:     len(operators),
+		Operators: operators,
+	})
+}
+
+func (h *operatorHandler) Post(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	input := &server.OperatorRequest{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	id, err := cluster.AddOperator(input)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &operatorAdded{ID: id})
+	case server.ErrRegionNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeRegionNotFound, err.Error())
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, err.Error())
+	case server.ErrInvalidOperator:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidOperator, err.Error())
+	case server.ErrRegionHasOperator:
+		writeError(h.rd, w, http.StatusConflict, errCodeRegionHasOperator, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+func (h *operatorHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionIDStr := mux.Vars(r)["region_id"]
+	regionID, err := strconv.ParseUint(regionIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidRegionID, err.Error())
+		return
+	}
+
+	err = cluster.CancelOperator(regionID)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, fmt.Sprintf("canceled, region: %d", regionID))
+	case server.ErrOperatorNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeOperatorNotFound, fmt.Sprintf("not found, region: %d", regionID))
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
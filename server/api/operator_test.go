@@ -0,0 +1,205 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+)
+
+var _ = Suite(&testOperatorSuite{})
+
+type testOperatorSuite struct {
+	hc *http.Client
+}
+
+func (s *testOperatorSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+// operatorProgress is the progress part of server.OperatorStatus.
+type operatorProgress struct {
+	ID       uint64 `json:"id"`
+	RegionID uint64 `json:"region_id"`
+	Step     int    `json:"step"`
+	Total    int    `json:"total"`
+}
+
+func (s *testOperatorSuite) mustGetOperators(c *C, addr string) []*operatorProgress {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	got := &struct {
+		Count     int                 `json:"count"`
+		Operators []*operatorProgress `json:"operators"`
+	}{}
+	c.Assert(json.Unmarshal(buf, got), IsNil)
+	c.Assert(got.Operators, HasLen, got.Count)
+	return got.Operators
+}
+
+func (s *testOperatorSuite) TestTransferLeader(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3, 4} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	oldLeader, newLeader := newTestPeer(1, 1), newTestPeer(2, 2)
+	region := newTestRegion(1, []byte{}, []byte{}, oldLeader, newLeader, newTestPeer(3, 3))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(3),
+		Version: proto.Uint64(1),
+	}
+	mustRegionHeartbeat(c, conn, region, oldLeader)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/operators"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	mustPost := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// Illegal operators are rejected.
+	checkErrorResponse(c, mustPost(`{"name": "transfer-leader", "region_id": 2, "store_id": 2}`, http.StatusNotFound), errCodeRegionNotFound)
+	checkErrorResponse(c, mustPost(`{"name": "transfer-leader", "region_id": 1, "store_id": 5}`, http.StatusNotFound), errCodeStoreNotFound)
+	checkErrorResponse(c, mustPost(`{"name": "transfer-leader", "region_id": 1, "store_id": 4}`, http.StatusBadRequest), errCodeInvalidOperator)
+	checkErrorResponse(c, mustPost(`{"name": "transfer-leader", "region_id": 1, "store_id": 1}`, http.StatusBadRequest), errCodeInvalidOperator)
+	checkErrorResponse(c, mustPost(`{"name": "add-peer", "region_id": 1, "store_id": 2}`, http.StatusBadRequest), errCodeInvalidOperator)
+	checkErrorResponse(c, mustPost(`{"name": "remove-peer", "region_id": 1, "store_id": 1}`, http.StatusBadRequest), errCodeInvalidOperator)
+	checkErrorResponse(c, mustPost(`{"name": "split", "region_id": 1, "store_id": 2}`, http.StatusBadRequest), errCodeInvalidOperator)
+
+	added := &operatorAdded{}
+	c.Assert(json.Unmarshal(mustPost(`{"name": "transfer-leader", "region_id": 1, "store_id": 2}`, http.StatusOK), added), IsNil)
+	c.Assert(added.ID, Not(Equals), uint64(0))
+	// Another operator on the same region conflicts with it.
+	checkErrorResponse(c, mustPost(`{"name": "add-peer", "region_id": 1, "store_id": 4}`, http.StatusConflict), errCodeRegionHasOperator)
+
+	c.Assert(s.mustGetOperators(c, addr), DeepEquals, []*operatorProgress{{ID: added.ID, RegionID: 1, Step: 0, Total: 1}})
+
+	// The leader is told to transfer the leadership.
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: region,
+			Leader: oldLeader,
+		},
+	}
+	resp := mustRPCCall(c, conn, req)
+	c.Assert(resp.GetRegionHeartbeat().GetTransferLeader().GetPeer(), DeepEquals, newLeader)
+
+	// The leader moves, then the operator is finished.
+	req.RegionHeartbeat.Leader = newLeader
+	resp = mustRPCCall(c, conn, req)
+	c.Assert(resp.GetRegionHeartbeat().GetTransferLeader(), IsNil)
+	c.Assert(s.mustGetOperators(c, addr), HasLen, 0)
+
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	_, leader := cluster.GetRegionByID(1)
+	c.Assert(leader, DeepEquals, newLeader)
+}
+
+func (s *testOperatorSuite) TestCancel(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3, 4} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	// The peer IDs are not allocated by PD, so we use large ones to avoid
+	// conflicting with the new peer.
+	leader := newTestPeer(101, 1)
+	region := newTestRegion(1, []byte{}, []byte{}, leader, newTestPeer(102, 2), newTestPeer(103, 3))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(3),
+		Version: proto.Uint64(1),
+	}
+	mustRegionHeartbeat(c, conn, region, leader)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/operators"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	mustAdd := func() {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(`{"name": "add-peer", "region_id": 1, "store_id": 4}`))
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	}
+	mustCancel := func(regionID string, status int) []byte {
+		req, err := http.NewRequest("DELETE", addr+"/"+regionID, nil)
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Do(req)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// The operator is running, but store 4 can't receive the peer.
+	mustAdd()
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: region,
+			Leader: leader,
+		},
+	}
+	resp := mustRPCCall(c, conn, req)
+	c.Assert(resp.GetRegionHeartbeat().GetChangePeer().GetPeer().GetStoreId(), Equals, uint64(4))
+
+	mustCancel("1", http.StatusOK)
+	c.Assert(s.mustGetOperators(c, addr), HasLen, 0)
+	checkErrorResponse(c, mustCancel("1", http.StatusNotFound), errCodeOperatorNotFound)
+	checkErrorResponse(c, mustCancel("x", http.StatusBadRequest), errCodeInvalidRegionID)
+
+	// The region is not changed any more, and its slot is released.
+	resp = mustRPCCall(c, conn, req)
+	c.Assert(resp.GetRegionHeartbeat().GetChangePeer(), IsNil)
+	mustAdd()
+	c.Assert(s.mustGetOperators(c, addr), HasLen, 1)
+}
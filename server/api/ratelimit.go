@@ -0,0 +1,122 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+// rateLimitSweepInterval is how often the buckets of the idle clients are
+// removed, so the limiter doesn't keep every client ever seen.
+const rateLimitSweepInterval = time.Minute
+
+// rateLimiter limits the requests of each client IP with a token bucket,
+// which holds up to burst tokens and gains rate tokens per second. A
+// request takes one token, the requests over the limit get 429 with the
+// Retry-After header.
+type rateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	prefix  string
+	exempt  map[string]struct{}
+	buckets map[string]*server.TokenBucket
+	swept   time.Time
+	rd      *render.Render
+}
+
+func newRateLimiter(rate float64, burst int, prefix string, exempt []string) *rateLimiter {
+	l := &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		prefix:  prefix,
+		exempt:  make(map[string]struct{}, len(exempt)),
+		buckets: make(map[string]*server.TokenBucket),
+		swept:   time.Now(),
+		rd:      render.New(render.Options{IndentJSON: true}),
+	}
+	for _, path := range exempt {
+		l.exempt[path] = struct{}{}
+	}
+	return l
+}
+
+func (l *rateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if _, ok := l.exempt[strings.TrimPrefix(r.URL.Path, l.prefix)]; ok {
+		next(w, r)
+		return
+	}
+
+	wait := l.take(clientIP(r), time.Now())
+	if wait > 0 {
+		seconds := int64(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		writeError(l.rd, w, http.StatusTooManyRequests, errCodeTooManyRequests, "too many requests, retry later")
+		return
+	}
+	next(w, r)
+}
+
+// take takes a token for the client, it returns how long the client should
+// wait for the next token if there is no token now.
+func (l *rateLimiter) take(client string, now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.swept) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = server.NewTokenBucket(l.burst, now)
+		l.buckets[client] = b
+	}
+
+	if tokens := b.Refill(l.rate, l.burst, now); tokens < 1 {
+		return time.Duration((1 - tokens) / l.rate * float64(time.Second))
+	}
+	b.Take(1)
+	return 0
+}
+
+// sweep removes the buckets which are full again, they are the same as
+// the new buckets.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.Refill(l.rate, l.burst, now) >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.swept = now
+}
+
+// clientIP returns the IP of the client, the whole remote address is used
+// if it has no port, e.g. the unix socket.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/pd/server"
+	"github.com/urfave/negroni"
+)
+
+var _ = Suite(&testRateLimitSuite{})
+
+type testRateLimitSuite struct{}
+
+func (s *testRateLimitSuite) TestRateLimiter(c *C) {
+	l := newRateLimiter(10, 3, "/pd", []string{"/api/v1/health"})
+	engine := negroni.New(l)
+	engine.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(path string, remoteAddr string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		c.Assert(err, IsNil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	// The burst is allowed, then the requests are limited.
+	limited := 0
+	for i := 0; i < 10; i++ {
+		w := serve("/pd/api/v1/members", "10.0.0.1:1234")
+		if w.Code == http.StatusTooManyRequests {
+			c.Assert(w.Header().Get("Retry-After"), Equals, "1")
+			limited++
+			continue
+		}
+		c.Assert(w.Code, Equals, http.StatusOK)
+	}
+	c.Assert(limited, Greater, 0)
+	c.Assert(limited, LessEqual, 7)
+
+	// The other clients and the exempted paths are not affected.
+	c.Assert(serve("/pd/api/v1/members", "10.0.0.2:1234").Code, Equals, http.StatusOK)
+	for i := 0; i < 10; i++ {
+		c.Assert(serve("/pd/api/v1/health", "10.0.0.1:1234").Code, Equals, http.StatusOK)
+	}
+}
+
+func (s *testRateLimitSuite) TestTokenBucket(c *C) {
+	l := newRateLimiter(10, 3, "/pd", nil)
+	now := time.Now()
+
+	// The requests within the limit always succeed.
+	for i := 0; i < 100; i++ {
+		c.Assert(l.take("a", now.Add(time.Duration(i)*100*time.Millisecond)), Equals, time.Duration(0))
+	}
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		c.Assert(l.take("b", now), Equals, time.Duration(0))
+	}
+	c.Assert(l.take("b", now), Equals, 100*time.Millisecond)
+	c.Assert(l.take("b", now.Add(100*time.Millisecond)), Equals, time.Duration(0))
+
+	// The idle client is removed after the sweep.
+	l.take("c", now.Add(2*time.Minute))
+	c.Assert(l.buckets, HasLen, 1)
+}
+
+func (s *testRateLimitSuite) TestAPIRateLimit(c *C) {
+	cfg := server.NewTestSingleConfig()
+	cfg.APIRateLimit = 1
+	cfg.APIRateBurst = 2
+	cfgs, _, clean := mustNewClusterWithConfigs(c, []*server.Config{cfg})
+	defer clean()
+
+	hc := newUnixSocketClient()
+	get := func(path string) int {
+		addr, err := unixAddrToHTTPAddr(cfgs[0].ClientUrls + apiPrefix + path)
+		c.Assert(err, IsNil)
+		resp, err := hc.Get(addr)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	c.Assert(get("/api/v1/version"), Equals, http.StatusOK)
+	c.Assert(get("/api/v1/version"), Equals, http.StatusOK)
+	c.Assert(get("/api/v1/version"), Equals, http.StatusTooManyRequests)
+	// The health check is exempted by default.
+	for i := 0; i < 5; i++ {
+		c.Assert(get("/api/v1/health"), Equals, http.StatusOK)
+	}
+}
@@ -14,23 +14,84 @@
 package api
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/juju/errors"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/pd/server"
 	"github.com/unrolled/render"
 )
 
 type regionInfo struct {
-	Region *metapb.Region `json:"region"`
-	Leader *metapb.Peer   `json:"leader"`
+	Region           *metapb.Region `json:"region"`
+	Leader           *metapb.Peer   `json:"leader"`
+	LastHeartbeatAge string         `json:"last_heartbeat_age,omitempty"`
+}
+
+const (
+	defaultRegionLimit = 1000
+	maxRegionLimit     = 1000
+
+	// regionDumpBatchSize is the region count written between the flushes
+	// of the region dump.
+	regionDumpBatchSize = 1000
+)
+
+// regionMeta is the region info with hex encoded keys, so binary keys can survive JSON.
+type regionMeta struct {
+	ID               uint64              `json:"id"`
+	StartKey         string              `json:"start_key"`
+	EndKey           string              `json:"end_key"`
+	RegionEpoch      *metapb.RegionEpoch `json:"region_epoch"`
+	Peers            []*metapb.Peer      `json:"peers"`
+	Leader           *metapb.Peer        `json:"leader"`
+	LastHeartbeatAge string              `json:"last_heartbeat_age,omitempty"`
+}
+
+func newRegionMeta(region *metapb.Region, leader *metapb.Peer) *regionMeta {
+	return &regionMeta{
+		ID:          region.GetId(),
+		StartKey:    hex.EncodeToString(region.GetStartKey()),
+		EndKey:      hex.EncodeToString(region.GetEndKey()),
+		RegionEpoch: region.GetRegionEpoch(),
+		Peers:       region.GetPeers(),
+		Leader:      leader,
+	}
+}
+
+// getHeartbeatAge returns the age of the last heartbeat of the region in
+// seconds precision, or empty if the region hasn't reported.
+func getHeartbeatAge(cluster *server.RaftCluster, regionID uint64) string {
+	age, ok := cluster.GetRegionHeartbeatAge(regionID)
+	if !ok {
+		return ""
+	}
+	return (age / time.Second * time.Second).String()
 }
 
 type regionsInfo struct {
-	Count   int              `json:"count"`
-	Regions []*metapb.Region `json:"regions"`
+	Count   int           `json:"count"`
+	Total   int           `json:"total"`
+	Regions []*regionMeta `json:"regions"`
+}
+
+// storeRegionMeta is the region info with whether the store holds the leader.
+type storeRegionMeta struct {
+	regionMeta
+	IsLeader bool `json:"is_leader"`
+}
+
+type storeRegionsInfo struct {
+	Count   int                `json:"count"`
+	Total   int                `json:"total"`
+	Regions []*storeRegionMeta `json:"regions"`
 }
 
 type regionHandler struct {
@@ -48,7 +109,7 @@ func newRegionHandler(svr *server.Server, rd *render.Render) *regionHandler {
 func (h *regionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
@@ -60,18 +121,62 @@ func (h *regionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	regionIDStr := vars["id"]
 	regionID, err := strconv.ParseUint(regionIDStr, 10, 64)
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInvalidRegionID, err.Error())
 		return
 	}
 
 	region, leader := cluster.GetRegionByID(regionID)
 	regionInfo := &regionInfo{
-		Region: region,
-		Leader: leader,
+		Region:           region,
+		Leader:           leader,
+		LastHeartbeatAge: getHeartbeatAge(cluster, regionID),
 	}
 	h.rd.JSON(w, http.StatusOK, regionInfo)
 }
 
+type regionKeyHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newRegionKeyHandler(svr *server.Server, rd *render.Render) *regionKeyHandler {
+	return &regionKeyHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *regionKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyStr := vars["key"]
+	key, err := hex.DecodeString(keyStr)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, fmt.Sprintf("invalid hex key: %s", keyStr))
+		return
+	}
+
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	// No region covers any key before the cluster is bootstrapped.
+	if cluster == nil {
+		writeError(h.rd, w, http.StatusNotFound, errCodeRegionNotFound, fmt.Sprintf("not found, key: %s", keyStr))
+		return
+	}
+
+	region, leader := cluster.GetRegion(key)
+	if region == nil {
+		writeError(h.rd, w, http.StatusNotFound, errCodeRegionNotFound, fmt.Sprintf("not found, key: %s", keyStr))
+		return
+	}
+
+	meta := newRegionMeta(region, leader)
+	meta.LastHeartbeatAge = getHeartbeatAge(cluster, region.GetId())
+	h.rd.JSON(w, http.StatusOK, meta)
+}
+
 type regionsHandler struct {
 	svr *server.Server
 	rd  *render.Render
@@ -87,7 +192,7 @@ func newRegionsHandler(svr *server.Server, rd *render.Render) *regionsHandler {
 func (h *regionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
@@ -95,10 +200,660 @@ func (h *regionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	regions := cluster.GetRegions()
+	limit, err := parseQueryInt(r, "limit", defaultRegionLimit)
+	if err != nil || limit < 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLimit, "invalid limit")
+		return
+	}
+	if limit > maxRegionLimit {
+		limit = maxRegionLimit
+	}
+	offset, err := parseQueryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidOffset, "invalid offset")
+		return
+	}
+
+	regions := cluster.ScanRegions(offset, limit)
 	regionsInfo := &regionsInfo{
 		Count:   len(regions),
-		Regions: regions,
+		Total:   cluster.GetRegionCount(),
+		Regions: make([]*regionMeta, 0, len(regions)),
+	}
+	for _, region := range regions {
+		_, leader := cluster.GetRegionByID(region.GetId())
+		meta := newRegionMeta(region, leader)
+		meta.LastHeartbeatAge = getHeartbeatAge(cluster, region.GetId())
+		regionsInfo.Regions = append(regionsInfo.Regions, meta)
 	}
 	h.rd.JSON(w, http.StatusOK, regionsInfo)
 }
+
+type regionsRangeHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newRegionsRangeHandler(svr *server.Server, rd *render.Render) *regionsRangeHandler {
+	return &regionsRangeHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// ServeHTTP lists the regions overlapping [start_key, end_key) in the key
+// order, the keys are hex encoded and an empty end_key means no upper bound.
+func (h *regionsRangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	startKeyStr, endKeyStr := r.URL.Query().Get("start_key"), r.URL.Query().Get("end_key")
+	startKey, err := hex.DecodeString(startKeyStr)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, fmt.Sprintf("invalid start key: %s", startKeyStr))
+		return
+	}
+	endKey, err := hex.DecodeString(endKeyStr)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, fmt.Sprintf("invalid end key: %s", endKeyStr))
+		return
+	}
+	if len(endKey) > 0 && bytes.Compare(startKey, endKey) > 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, fmt.Sprintf("start key %s is greater than end key %s", startKeyStr, endKeyStr))
+		return
+	}
+	regions, ok := scanRange(h.rd, w, h.svr, cluster, startKey, endKey)
+	if !ok {
+		return
+	}
+
+	regionsInfo := &regionsInfo{
+		Total:   cluster.GetRegionCount(),
+		Regions: make([]*regionMeta, 0),
+	}
+	// The range is empty if the start key equals the end key.
+	if len(endKey) == 0 || bytes.Compare(startKey, endKey) < 0 {
+		for _, region := range regions {
+			_, leader := cluster.GetRegionByID(region.GetId())
+			meta := newRegionMeta(region, leader)
+			meta.LastHeartbeatAge = getHeartbeatAge(cluster, region.GetId())
+			regionsInfo.Regions = append(regionsInfo.Regions, meta)
+		}
+	}
+	regionsInfo.Count = len(regionsInfo.Regions)
+	h.rd.JSON(w, http.StatusOK, regionsInfo)
+}
+
+type regionsDumpHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newRegionsDumpHandler(svr *server.Server, rd *render.Render) *regionsDumpHandler {
+	return &regionsDumpHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// ServeHTTP streams all the regions ordered by the start key on the leader,
+// one JSON object per line. The regions are read and flushed in batches, so
+// the memory doesn't grow with the region count.
+func (h *regionsDumpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.svr.IsLeader() {
+		redirectToLeader(h.svr, h.rd, w, r)
+		return
+	}
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=regions.json")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for key := []byte{}; ; {
+		regions := cluster.ScanRegionsByKey(key, regionDumpBatchSize)
+		for _, region := range regions {
+			_, leader := cluster.GetRegionByID(region.GetId())
+			if err = encoder.Encode(newRegionMeta(region, leader)); err != nil {
+				// The client has gone, nothing can be reported.
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(regions) < regionDumpBatchSize {
+			return
+		}
+		key = regions[len(regions)-1].GetEndKey()
+		if len(key) == 0 {
+			return
+		}
+	}
+}
+
+type storeRegionsHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStoreRegionsHandler(svr *server.Server, rd *render.Render) *storeRegionsHandler {
+	return &storeRegionsHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *storeRegionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+	if _, _, err = cluster.GetStore(storeID); err != nil {
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+		return
+	}
+
+	limit, err := parseQueryInt(r, "limit", defaultRegionLimit)
+	if err != nil || limit < 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLimit, "invalid limit")
+		return
+	}
+	if limit > maxRegionLimit {
+		limit = maxRegionLimit
+	}
+
+	regions, total := cluster.GetStoreRegions(storeID, limit)
+	regionsInfo := &storeRegionsInfo{
+		Count:   len(regions),
+		Total:   total,
+		Regions: make([]*storeRegionMeta, 0, len(regions)),
+	}
+	for _, region := range regions {
+		_, leader := cluster.GetRegionByID(region.GetId())
+		meta := &storeRegionMeta{
+			regionMeta: *newRegionMeta(region, leader),
+			IsLeader:   leader.GetStoreId() == storeID,
+		}
+		meta.LastHeartbeatAge = getHeartbeatAge(cluster, region.GetId())
+		regionsInfo.Regions = append(regionsInfo.Regions, meta)
+	}
+	h.rd.JSON(w, http.StatusOK, regionsInfo)
+}
+
+// scatterRange is the request body to scatter the regions in [start_key, end_key),
+// the keys are hex encoded and an empty end_key means no upper bound.
+type scatterRange struct {
+	StartKey string `json:"start_key"`
+	EndKey   string `json:"end_key"`
+}
+
+type scatterResult struct {
+	Count   int      `json:"count"`
+	Regions []uint64 `json:"regions"`
+}
+
+type regionScatterHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newRegionScatterHandler(svr *server.Server, rd *render.Render) *regionScatterHandler {
+	return &regionScatterHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *regionScatterHandler) Scatter(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionIDStr := mux.Vars(r)["id"]
+	regionID, err := strconv.ParseUint(regionIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidRegionID, err.Error())
+		return
+	}
+
+	err = cluster.ScatterRegion(regionID)
+	if err != nil {
+		h.writeScatterError(w, err)
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, fmt.Sprintf("scattered, region: %d", regionID))
+}
+
+func (h *regionScatterHandler) ScatterRange(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	input := &scatterRange{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	startKey, err := hex.DecodeString(input.StartKey)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, fmt.Sprintf("invalid start key: %s", input.StartKey))
+		return
+	}
+	endKey, err := hex.DecodeString(input.EndKey)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidKey, fmt.Sprintf("invalid end key: %s", input.EndKey))
+		return
+	}
+
+	regions, ok := scanRange(h.rd, w, h.svr, cluster, startKey, endKey)
+	if !ok {
+		return
+	}
+
+	scattered, err := cluster.ScatterRegions(regions)
+	if err != nil {
+		h.writeScatterError(w, err)
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, &scatterResult{Count: len(scattered), Regions: scattered})
+}
+
+func (h *regionScatterHandler) writeScatterError(w http.ResponseWriter, err error) {
+	switch errors.Cause(err) {
+	case server.ErrRegionNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeRegionNotFound, err.Error())
+	case server.ErrRegionHasOperator:
+		writeError(h.rd, w, http.StatusConflict, errCodeRegionHasOperator, err.Error())
+	case server.ErrNotEnoughStores:
+		writeError(h.rd, w, http.StatusServiceUnavailable, errCodeNotEnoughStores, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+type underReplicatedRegions struct {
+	Count   int                             `json:"count"`
+	Total   int                             `json:"total"`
+	Regions []*server.UnderReplicatedRegion `json:"regions"`
+}
+
+type underReplicatedHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newUnderReplicatedHandler(svr *server.Server, rd *render.Render) *underReplicatedHandler {
+	return &underReplicatedHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *underReplicatedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	limit, err := parseQueryInt(r, "limit", defaultRegionLimit)
+	if err != nil || limit < 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLimit, "invalid limit")
+		return
+	}
+	if limit > maxRegionLimit {
+		limit = maxRegionLimit
+	}
+
+	regions, total := cluster.GetUnderReplicatedRegions(limit)
+	h.rd.JSON(w, http.StatusOK, &underReplicatedRegions{
+		Count:   len(regions),
+		Total:   total,
+		Regions: regions,
+	})
+}
+
+type orphanPeerHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newOrphanPeerHandler(svr *server.Server, rd *render.Render) *orphanPeerHandler {
+	return &orphanPeerHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *orphanPeerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, cluster.GetOrphanPeers())
+}
+
+type regionPeerHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newRegionPeerHandler(svr *server.Server, rd *render.Render) *regionPeerHandler {
+	return &regionPeerHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// peerInput is the request body to add or remove the peer of the region on
+// the store.
+type peerInput struct {
+	StoreID uint64 `json:"store_id"`
+}
+
+// parseInput parses the region ID and the store ID, it writes the error and
+// returns false if they are invalid.
+func (h *regionPeerHandler) parseInput(w http.ResponseWriter, r *http.Request) (uint64, uint64, bool) {
+	regionIDStr := mux.Vars(r)["id"]
+	regionID, err := strconv.ParseUint(regionIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidRegionID, err.Error())
+		return 0, 0, false
+	}
+	input := &peerInput{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return 0, 0, false
+	}
+	if input.StoreID == 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, "store_id is not specified")
+		return 0, 0, false
+	}
+	return regionID, input.StoreID, true
+}
+
+func (h *regionPeerHandler) Add(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionID, storeID, ok := h.parseInput(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := cluster.AddRegionPeer(regionID, storeID)
+	h.writeResult(w, id, err)
+}
+
+func (h *regionPeerHandler) Remove(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionID, storeID, ok := h.parseInput(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := cluster.RemoveRegionPeer(regionID, storeID)
+	h.writeResult(w, id, err)
+}
+
+func (h *regionPeerHandler) writeResult(w http.ResponseWriter, id uint64, err error) {
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &operatorAdded{ID: id})
+	case server.ErrRegionNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeRegionNotFound, err.Error())
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, err.Error())
+	case server.ErrPeerNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodePeerNotFound, err.Error())
+	case server.ErrPeerExists:
+		writeError(h.rd, w, http.StatusConflict, errCodePeerExists, err.Error())
+	case server.ErrQuorumLost:
+		writeError(h.rd, w, http.StatusPreconditionFailed, errCodeQuorumLost, err.Error())
+	case server.ErrInvalidOperator:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidOperator, err.Error())
+	case server.ErrRegionHasOperator:
+		writeError(h.rd, w, http.StatusConflict, errCodeRegionHasOperator, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+type regionLabelHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newRegionLabelHandler(svr *server.Server, rd *render.Render) *regionLabelHandler {
+	return &regionLabelHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *regionLabelHandler) Get(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionID, ok := h.parseRegionID(w, r)
+	if !ok {
+		return
+	}
+
+	labels, err := cluster.GetRegionLabels(regionID)
+	if err != nil {
+		h.writeLabelError(w, regionID, err)
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, labels)
+}
+
+func (h *regionLabelHandler) Post(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionID, ok := h.parseRegionID(w, r)
+	if !ok {
+		return
+	}
+
+	// The labels in the body replace all the labels of the region.
+	labels := make(map[string]string)
+	if err = fromBody(r, &labels); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	if err = cluster.SetRegionLabels(regionID, labels); err != nil {
+		h.writeLabelError(w, regionID, err)
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, labels)
+}
+
+func (h *regionLabelHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionID, ok := h.parseRegionID(w, r)
+	if !ok {
+		return
+	}
+
+	if err = cluster.DeleteRegionLabels(regionID); err != nil {
+		h.writeLabelError(w, regionID, err)
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, fmt.Sprintf("labels deleted, region: %d", regionID))
+}
+
+func (h *regionLabelHandler) parseRegionID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	regionIDStr := mux.Vars(r)["id"]
+	regionID, err := strconv.ParseUint(regionIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidRegionID, fmt.Sprintf("invalid region id: %s", regionIDStr))
+		return 0, false
+	}
+	return regionID, true
+}
+
+func (h *regionLabelHandler) writeLabelError(w http.ResponseWriter, regionID uint64, err error) {
+	switch errors.Cause(err) {
+	case server.ErrRegionNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeRegionNotFound, fmt.Sprintf("not found, region: %d", regionID))
+	case server.ErrInvalidRegionLabel:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLabel, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+// regionSiblings is the regions right before and after a region in the key
+// order, which is null at the boundary.
+type regionSiblings struct {
+	Prev *regionMeta `json:"prev"`
+	Next *regionMeta `json:"next"`
+}
+
+type regionSiblingsHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newRegionSiblingsHandler(svr *server.Server, rd *render.Render) *regionSiblingsHandler {
+	return &regionSiblingsHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *regionSiblingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	regionIDStr := mux.Vars(r)["id"]
+	regionID, err := strconv.ParseUint(regionIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidRegionID, fmt.Sprintf("invalid region id: %s", regionIDStr))
+		return
+	}
+
+	prev, next, ok := cluster.GetAdjacentRegions(regionID)
+	if !ok {
+		writeError(h.rd, w, http.StatusNotFound, errCodeRegionNotFound, fmt.Sprintf("not found, region: %d", regionID))
+		return
+	}
+
+	siblings := &regionSiblings{}
+	for _, item := range []struct {
+		region *metapb.Region
+		target **regionMeta
+	}{
+		{prev, &siblings.Prev},
+		{next, &siblings.Next},
+	} {
+		if item.region == nil {
+			continue
+		}
+		_, leader := cluster.GetRegionByID(item.region.GetId())
+		*item.target = newRegionMeta(item.region, leader)
+	}
+	h.rd.JSON(w, http.StatusOK, siblings)
+}
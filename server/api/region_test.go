@@ -0,0 +1,949 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testRegionSuite{})
+
+type testRegionSuite struct {
+	hc *http.Client
+}
+
+func (s *testRegionSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+// newTestSplitKey returns a binary key which is not valid UTF-8.
+func newTestSplitKey(i int) []byte {
+	return []byte{byte(i), 0xff}
+}
+
+// mustSplitRegions splits the bootstrapped region 1 into n regions, region i
+// covers [key(i-1), key(i)), the first and the last keys are empty.
+func mustSplitRegions(c *C, conn net.Conn, n int) []*metapb.Region {
+	regions := make([]*metapb.Region, 0, n)
+	for i := 1; i <= n; i++ {
+		startKey, endKey := []byte{}, []byte{}
+		if i > 1 {
+			startKey = newTestSplitKey(i - 1)
+		}
+		if i < n {
+			endKey = newTestSplitKey(i)
+		}
+
+		peerID := uint64(1)
+		if i > 1 {
+			peerID = uint64(100 + i)
+		}
+		peer := newTestPeer(peerID, 1)
+		region := newTestRegion(uint64(i), startKey, endKey, peer)
+		region.RegionEpoch.Version = proto.Uint64(2)
+		mustRegionHeartbeat(c, conn, region, peer)
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+func (s *testRegionSuite) mustGetRegions(c *C, addr string) *regionsInfo {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	got := &regionsInfo{}
+	c.Assert(json.Unmarshal(buf, got), IsNil)
+	return got
+}
+
+func (s *testRegionSuite) TestRegionsList(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	regions := mustSplitRegions(c, conn, 10)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/regions"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	got := s.mustGetRegions(c, addr)
+	c.Assert(got.Count, Equals, len(regions))
+	c.Assert(got.Total, Equals, len(regions))
+	for i, region := range got.Regions {
+		c.Assert(region.ID, Equals, regions[i].GetId())
+		c.Assert(region.StartKey, Equals, hex.EncodeToString(regions[i].GetStartKey()))
+		c.Assert(region.EndKey, Equals, hex.EncodeToString(regions[i].GetEndKey()))
+		c.Assert(region.Peers, HasLen, 1)
+		c.Assert(region.Leader.GetId(), Equals, regions[i].GetPeers()[0].GetId())
+		c.Assert(region.LastHeartbeatAge, Not(Equals), "")
+	}
+
+	// Walk the pages, no region should be skipped or duplicated.
+	var ids []uint64
+	for offset := 0; ; offset += 3 {
+		got = s.mustGetRegions(c, fmt.Sprintf("%s?limit=3&offset=%d", addr, offset))
+		c.Assert(got.Total, Equals, len(regions))
+		if got.Count == 0 {
+			break
+		}
+		c.Assert(got.Count <= 3, IsTrue)
+		for _, region := range got.Regions {
+			ids = append(ids, region.ID)
+		}
+	}
+	c.Assert(ids, HasLen, len(regions))
+	for i, id := range ids {
+		c.Assert(id, Equals, regions[i].GetId())
+	}
+
+	for _, t := range []struct {
+		query string
+		code  string
+	}{
+		{"?limit=abc", errCodeInvalidLimit},
+		{"?limit=-1", errCodeInvalidLimit},
+		{"?offset=abc", errCodeInvalidOffset},
+		{"?offset=-1", errCodeInvalidOffset},
+	} {
+		resp, err := s.hc.Get(addr + t.query)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+		checkErrorResponse(c, buf, t.code)
+	}
+}
+
+func (s *testRegionSuite) TestRegionByKey(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	addr := func(key string) string {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/regions/key/", key}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+
+	mustGetError := func(key string, status int, code string) {
+		resp, err := s.hc.Get(addr(key))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		checkErrorResponse(c, buf, code)
+	}
+
+	// No region covers any key before bootstrap.
+	mustGetError("61", http.StatusNotFound, errCodeRegionNotFound)
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	regions := mustSplitRegions(c, conn, 3)
+
+	table := []struct {
+		key      []byte
+		regionID uint64
+	}{
+		{key: []byte{0}, regionID: 1},
+		{key: newTestSplitKey(1), regionID: 2},
+		{key: []byte{1, 0xfe}, regionID: 1},
+		{key: []byte{1, 0xff, 0}, regionID: 2},
+		{key: newTestSplitKey(2), regionID: 3},
+		// The end key of the last region is empty, which means +infinity.
+		{key: []byte{0xff, 0xff, 0xff}, regionID: 3},
+	}
+
+	for _, t := range table {
+		resp, err := s.hc.Get(addr(hex.EncodeToString(t.key)))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+		got := &regionMeta{}
+		c.Assert(json.Unmarshal(buf, got), IsNil)
+		region := regions[t.regionID-1]
+		c.Assert(got.ID, Equals, t.regionID)
+		c.Assert(got.StartKey, Equals, hex.EncodeToString(region.GetStartKey()))
+		c.Assert(got.EndKey, Equals, hex.EncodeToString(region.GetEndKey()))
+		c.Assert(got.Leader.GetId(), Equals, region.GetPeers()[0].GetId())
+	}
+
+	mustGetError("zz", http.StatusBadRequest, errCodeInvalidKey)
+	mustGetError("123", http.StatusBadRequest, errCodeInvalidKey)
+}
+
+func (s *testRegionSuite) TestScatter(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	mustSplitRegions(c, conn, 3)
+	for _, id := range []uint64{1, 2, 3, 4} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	// Region 1 has two peers on store 1 after bulk split.
+	leader := newTestPeer(1, 1)
+	region := newTestRegion(1, []byte{}, newTestSplitKey(1), leader, newTestPeer(2, 1), newTestPeer(3, 2))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(3),
+		Version: proto.Uint64(2),
+	}
+	mustRegionHeartbeat(c, conn, region, leader)
+
+	mustPost := func(path string, body string, status int) []byte {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, path}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	mustPost("/api/v1/regions/1/scatter", "", http.StatusOK)
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster.GetBalanceOperators()[1], NotNil)
+
+	// The region is being scattered.
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/scatter", "", http.StatusConflict), errCodeRegionHasOperator)
+	checkErrorResponse(c, mustPost("/api/v1/regions/100/scatter", "", http.StatusNotFound), errCodeRegionNotFound)
+	checkErrorResponse(c, mustPost("/api/v1/regions/scatter", `{"start_key": "xyz"}`, http.StatusBadRequest), errCodeInvalidKey)
+
+	got := &scatterResult{}
+	body := fmt.Sprintf(`{"start_key": "%s", "end_key": "%s"}`, hex.EncodeToString(newTestSplitKey(1)), hex.EncodeToString(newTestSplitKey(2)))
+	c.Assert(json.Unmarshal(mustPost("/api/v1/regions/scatter", body, http.StatusOK), got), IsNil)
+	c.Assert(got.Regions, DeepEquals, []uint64{2})
+
+	// Region 1 is skipped in the batch form, region 2 may be skipped too
+	// if it has been moved by the last scatter.
+	got = &scatterResult{}
+	buf := mustPost("/api/v1/regions/scatter", `{"start_key": "", "end_key": ""}`, http.StatusOK)
+	c.Assert(json.Unmarshal(buf, got), IsNil)
+	c.Assert(got.Regions[len(got.Regions)-1], Equals, uint64(3))
+	c.Assert(got.Regions[0], Not(Equals), uint64(1))
+}
+
+func (s *testRegionSuite) TestAddPeer(c *C) {
+	cfgs := server.NewTestMultiConfig(1)
+	cfgs[0].BalanceCfg.LocationLabels = []string{"zone"}
+	_, svrs, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	// Store 3 is in the same zone with store 1.
+	zones := map[uint64]string{1: "z1", 2: "z2", 3: "z1", 4: "z3", 5: "z4"}
+	for id := uint64(1); id <= 5; id++ {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+		c.Assert(cluster.SetStoreLabels(id, map[string]string{"zone": zones[id]}), IsNil)
+	}
+
+	mustPost := func(path string, body string, status int) []byte {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, path}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// The peer IDs are not allocated by PD, so we use large ones to avoid
+	// conflicting with the new peer.
+	leader := newTestPeer(101, 1)
+	region := newTestRegion(1, []byte{}, []byte{}, leader, newTestPeer(102, 2))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(2),
+		Version: proto.Uint64(1),
+	}
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: region,
+			Leader: leader,
+		},
+	}
+	mustRPCCall(c, conn, req)
+	// The replica balancer may have added a peer, cancel it.
+	cluster.CancelOperator(1)
+
+	checkErrorResponse(c, mustPost("/api/v1/regions/100/peer/add", `{"store_id": 4}`, http.StatusNotFound), errCodeRegionNotFound)
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/add", `{"store_id": 9}`, http.StatusNotFound), errCodeStoreNotFound)
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/add", `{"store_id": 2}`, http.StatusConflict), errCodePeerExists)
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/add", `{"store_id": 3}`, http.StatusBadRequest), errCodeInvalidOperator)
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/add", `{}`, http.StatusBadRequest), errCodeInvalidBody)
+
+	// The peer is added to store 4.
+	mustPost("/api/v1/regions/1/peer/add", `{"store_id": 4}`, http.StatusOK)
+	changePeer := mustRPCCall(c, conn, req).GetRegionHeartbeat().GetChangePeer()
+	c.Assert(changePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+	c.Assert(changePeer.GetPeer().GetStoreId(), Equals, uint64(4))
+	region.Peers = append(region.Peers, changePeer.GetPeer())
+	region.RegionEpoch.ConfVer = proto.Uint64(3)
+	c.Assert(mustRPCCall(c, conn, req).GetRegionHeartbeat().GetChangePeer(), IsNil)
+	got, _ := cluster.GetRegionByID(1)
+	c.Assert(got.GetPeers(), HasLen, 3)
+	c.Assert(cluster.GetOperators(), HasLen, 0)
+
+	// The region has enough replicas, a follower is removed after the peer
+	// on store 5 catches up.
+	mustPost("/api/v1/regions/1/peer/add", `{"store_id": 5}`, http.StatusOK)
+	operators := cluster.GetOperators()
+	c.Assert(operators, HasLen, 1)
+	c.Assert(operators[0].Total, Equals, 3)
+	cluster.CancelOperator(1)
+
+	// The peer on store 6 replaces the follower on store 4 in the same zone.
+	mustPutStore(c, conn, newTestStore(6))
+	mustHeartbeatStore(c, conn, 6)
+	c.Assert(cluster.SetStoreLabels(6, map[string]string{"zone": "z3"}), IsNil)
+	mustPost("/api/v1/regions/1/peer/add", `{"store_id": 6}`, http.StatusOK)
+	changePeer = mustRPCCall(c, conn, req).GetRegionHeartbeat().GetChangePeer()
+	c.Assert(changePeer.GetPeer().GetStoreId(), Equals, uint64(6))
+	operators = cluster.GetOperators()
+	c.Assert(operators, HasLen, 1)
+	c.Assert(operators[0].Total, Equals, 3)
+	removed, err := json.Marshal(operators[0].Operators[2])
+	c.Assert(err, IsNil)
+	c.Assert(string(removed), Matches, `.*"store_id":4.*`)
+}
+
+func (s *testRegionSuite) TestRemovePeer(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3, 4} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	mustPost := func(path string, body string, status int) []byte {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, path}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// The only peer of the bootstrapped region can't be removed.
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/remove", `{"store_id": 1}`, http.StatusPreconditionFailed), errCodeQuorumLost)
+
+	// The peer IDs are not allocated by PD, so we use large ones to avoid
+	// conflicting with the new peer.
+	leader := newTestPeer(101, 1)
+	region := newTestRegion(1, []byte{}, []byte{}, leader, newTestPeer(102, 2), newTestPeer(103, 3))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(3),
+		Version: proto.Uint64(1),
+	}
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: region,
+			Leader: leader,
+		},
+	}
+	c.Assert(mustRPCCall(c, conn, req).GetRegionHeartbeat().GetChangePeer(), IsNil)
+
+	// Add an extra replica on store 4.
+	mustPost("/api/v1/operators", `{"name": "add-peer", "region_id": 1, "store_id": 4}`, http.StatusOK)
+	changePeer := mustRPCCall(c, conn, req).GetRegionHeartbeat().GetChangePeer()
+	c.Assert(changePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+	extraPeer := changePeer.GetPeer()
+	c.Assert(extraPeer.GetStoreId(), Equals, uint64(4))
+	region.Peers = append(region.Peers, extraPeer)
+	region.RegionEpoch.ConfVer = proto.Uint64(4)
+	c.Assert(mustRPCCall(c, conn, req).GetRegionHeartbeat().GetChangePeer(), IsNil)
+
+	checkErrorResponse(c, mustPost("/api/v1/regions/100/peer/remove", `{"store_id": 4}`, http.StatusNotFound), errCodeRegionNotFound)
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/remove", `{"store_id": 5}`, http.StatusNotFound), errCodePeerNotFound)
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/remove", `{}`, http.StatusBadRequest), errCodeInvalidBody)
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/remove", `{"store_id": 1}`, http.StatusBadRequest), errCodeInvalidOperator)
+
+	// The extra replica on store 4 is removed.
+	added := &operatorAdded{}
+	c.Assert(json.Unmarshal(mustPost("/api/v1/regions/1/peer/remove", `{"store_id": 4}`, http.StatusOK), added), IsNil)
+	c.Assert(added.ID, Not(Equals), uint64(0))
+	checkErrorResponse(c, mustPost("/api/v1/regions/1/peer/remove", `{"store_id": 3}`, http.StatusConflict), errCodeRegionHasOperator)
+	changePeer = mustRPCCall(c, conn, req).GetRegionHeartbeat().GetChangePeer()
+	c.Assert(changePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
+	c.Assert(changePeer.GetPeer(), DeepEquals, extraPeer)
+}
+
+func (s *testRegionSuite) TestUnderReplicated(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	regions := mustSplitRegions(c, conn, 3)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/regions/check/under-replicated"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	mustGet := func(query string) *underReplicatedRegions {
+		resp, err := s.hc.Get(addr + query)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		got := &underReplicatedRegions{}
+		c.Assert(json.Unmarshal(buf, got), IsNil)
+		return got
+	}
+	checkRegions := func(got *underReplicatedRegions, expected map[uint64]int) {
+		c.Assert(got.Count, Equals, len(expected))
+		c.Assert(got.Total, Equals, len(expected))
+		for _, region := range got.Regions {
+			c.Assert(region.Replicas, Equals, expected[region.ID])
+			c.Assert(region.Expected, Equals, 3)
+		}
+	}
+
+	// All the regions have only 1 replica.
+	checkRegions(mustGet(""), map[uint64]int{1: 1, 2: 1, 3: 1})
+	got := mustGet("?limit=1")
+	c.Assert(got.Count, Equals, 1)
+	c.Assert(got.Total, Equals, 3)
+	c.Assert(got.Regions[0].ID, Equals, regions[0].GetId())
+
+	// Region 2 has enough replicas.
+	region := regions[1]
+	leader := region.GetPeers()[0]
+	region.Peers = append(region.Peers, newTestPeer(201, 2), newTestPeer(202, 3))
+	region.RegionEpoch.ConfVer = proto.Uint64(3)
+	mustRegionHeartbeat(c, conn, region, leader)
+	checkRegions(mustGet(""), map[uint64]int{1: 1, 3: 1})
+
+	// A replica of region 2 is removed.
+	region.Peers = region.Peers[:2]
+	region.RegionEpoch.ConfVer = proto.Uint64(4)
+	mustRegionHeartbeat(c, conn, region, leader)
+	checkRegions(mustGet(""), map[uint64]int{1: 1, 2: 2, 3: 1})
+
+	resp, err := s.hc.Get(addr + "?limit=-1")
+	c.Assert(err, IsNil)
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+	checkErrorResponse(c, buf, errCodeInvalidLimit)
+}
+
+func (s *testRegionSuite) TestOrphanPeers(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	mustPutStore(c, conn, newTestStore(2))
+	for _, id := range []uint64{1, 2} {
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/regions/check/orphan-peers"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	mustGet := func() []*server.OrphanPeer {
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		var got []*server.OrphanPeer
+		c.Assert(json.Unmarshal(buf, &got), IsNil)
+		return got
+	}
+	c.Assert(mustGet(), HasLen, 0)
+
+	// The store of peer 103 doesn't exist.
+	region := newTestRegion(100, []byte{}, []byte{}, newTestPeer(101, 1), newTestPeer(102, 2), newTestPeer(103, 9))
+	mustRegionHeartbeat(c, conn, region, region.GetPeers()[0])
+	c.Assert(mustGet(), DeepEquals, []*server.OrphanPeer{
+		{RegionID: 100, PeerID: 103, StoreID: 9, Reason: "store_not_found"},
+	})
+}
+
+func (s *testRegionSuite) TestRegionSnapshotFailover(c *C) {
+	cfgs := server.NewTestMultiConfig(3)
+	for _, cfg := range cfgs {
+		cfg.RegionSnapshotInterval.Duration = 100 * time.Millisecond
+	}
+	_, svrs, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	conn := mustRPCConnect(c, leader)
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+	regions := mustSplitRegions(c, conn, 3)
+
+	mustGetRegions := func(svr *server.Server) (*regionsInfo, bool) {
+		parts := []string{svr.GetAddr(), apiPrefix, "/api/v1/regions"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		got := &regionsInfo{}
+		c.Assert(json.Unmarshal(buf, got), IsNil)
+		return got, resp.Header.Get(possiblyStaleHeader) == "true"
+	}
+	got, stale := mustGetRegions(leader)
+	c.Assert(got.Count, Equals, 3)
+	c.Assert(stale, IsFalse)
+
+	// Wait for the leader to save the region snapshot.
+	time.Sleep(500 * time.Millisecond)
+	c.Assert(leader.ResignLeader(), IsNil)
+	newLeader := mustWaitLeader(c, svrs, leader)
+	elected := time.Now()
+	for i := 0; i < 100; i++ {
+		cluster, err := newLeader.GetRaftCluster()
+		c.Assert(err, IsNil)
+		if cluster != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The region leaders are served before the regions report.
+	got, stale = mustGetRegions(newLeader)
+	c.Assert(time.Since(elected), Less, time.Second)
+	c.Assert(stale, IsTrue)
+	c.Assert(got.Count, Equals, 3)
+	for _, region := range got.Regions {
+		c.Assert(region.Leader, NotNil)
+		c.Assert(region.Leader.GetStoreId(), Equals, uint64(1))
+	}
+
+	// The reads are not stale after all the regions report.
+	newConn := mustRPCConnect(c, newLeader)
+	defer newConn.Close()
+	for _, region := range regions {
+		mustRegionHeartbeat(c, newConn, region, region.GetPeers()[0])
+	}
+	got, stale = mustGetRegions(newLeader)
+	c.Assert(stale, IsFalse)
+	c.Assert(got.Count, Equals, 3)
+}
+
+func (s *testRegionSuite) TestRegionsDump(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	conn := mustRPCConnect(c, leader)
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+	regions := mustSplitRegions(c, conn, 5)
+
+	dumpAddr := func(svr *server.Server) string {
+		parts := []string{svr.GetAddr(), apiPrefix, "/api/v1/regions/dump"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+
+	resp, err := s.hc.Get(dumpAddr(leader))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	// Every line is a region, they are ordered by the start key.
+	var got []*regionMeta
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		meta := &regionMeta{}
+		c.Assert(json.Unmarshal(scanner.Bytes(), meta), IsNil)
+		got = append(got, meta)
+	}
+	c.Assert(scanner.Err(), IsNil)
+	c.Assert(got, HasLen, len(regions))
+	for i, region := range regions {
+		c.Assert(got[i].ID, Equals, region.GetId())
+		c.Assert(got[i].StartKey, Equals, hex.EncodeToString(region.GetStartKey()))
+		c.Assert(got[i].EndKey, Equals, hex.EncodeToString(region.GetEndKey()))
+		c.Assert(got[i].Leader.GetStoreId(), Equals, uint64(1))
+	}
+
+	// The followers redirect the dump to the leader.
+	noRedirect := newUnixSocketClient()
+	noRedirect.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	for _, svr := range svrs {
+		if svr == leader {
+			continue
+		}
+		resp, err := noRedirect.Get(dumpAddr(svr))
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusTemporaryRedirect)
+		c.Assert(resp.Header.Get("Location"), Equals, leader.GetAddr()+apiPrefix+"/api/v1/regions/dump")
+	}
+}
+
+func (s *testRegionSuite) TestStoreRegions(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	regions := mustSplitRegions(c, conn, 3)
+
+	// Region 2 has a follower on store 2, region 3 has the leader on store 2.
+	region := regions[1]
+	region.Peers = append(region.Peers, newTestPeer(201, 2))
+	region.RegionEpoch.ConfVer = proto.Uint64(2)
+	mustRegionHeartbeat(c, conn, region, region.GetPeers()[0])
+	region = regions[2]
+	leader := newTestPeer(301, 2)
+	region.Peers = append(region.Peers, leader)
+	region.RegionEpoch.ConfVer = proto.Uint64(2)
+	mustRegionHeartbeat(c, conn, region, leader)
+
+	mustGet := func(storeID uint64, query string, status int) []byte {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, fmt.Sprintf("/api/v1/regions/store/%d%s", storeID, query)}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	checkRegions := func(storeID uint64, query string, total int, expected map[uint64]bool) {
+		got := &storeRegionsInfo{}
+		c.Assert(json.Unmarshal(mustGet(storeID, query, http.StatusOK), got), IsNil)
+		c.Assert(got.Count, Equals, len(expected))
+		c.Assert(got.Total, Equals, total)
+		for _, region := range got.Regions {
+			isLeader, ok := expected[region.ID]
+			c.Assert(ok, IsTrue)
+			c.Assert(region.IsLeader, Equals, isLeader)
+		}
+	}
+
+	checkRegions(1, "", 3, map[uint64]bool{1: true, 2: true, 3: false})
+	checkRegions(2, "", 2, map[uint64]bool{2: false, 3: true})
+	checkRegions(2, "?limit=1", 2, map[uint64]bool{2: false})
+	checkRegions(3, "", 0, map[uint64]bool{})
+
+	checkErrorResponse(c, mustGet(100, "", http.StatusNotFound), errCodeStoreNotFound)
+	checkErrorResponse(c, mustGet(1, "?limit=-1", http.StatusBadRequest), errCodeInvalidLimit)
+}
+
+func (s *testRegionSuite) TestRegionLabels(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	do := func(method string, regionID uint64, body string, status int) []byte {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, fmt.Sprintf("/api/v1/regions/%d/labels", regionID)}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		req, err := http.NewRequest(method, addr, strings.NewReader(body))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Do(req)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	checkLabels := func(regionID uint64, expected map[string]string) {
+		labels := make(map[string]string)
+		c.Assert(json.Unmarshal(do("GET", regionID, "", http.StatusOK), &labels), IsNil)
+		c.Assert(labels, DeepEquals, expected)
+	}
+
+	checkLabels(1, map[string]string{})
+	do("POST", 1, `{"do-not-merge": "table boundary"}`, http.StatusOK)
+	checkLabels(1, map[string]string{"do-not-merge": "table boundary"})
+
+	checkErrorResponse(c, do("POST", 1, `{"": "x"}`, http.StatusBadRequest), errCodeInvalidLabel)
+	checkErrorResponse(c, do("POST", 100, `{"a": "b"}`, http.StatusNotFound), errCodeRegionNotFound)
+	checkErrorResponse(c, do("GET", 100, "", http.StatusNotFound), errCodeRegionNotFound)
+
+	do("DELETE", 1, "", http.StatusOK)
+	checkLabels(1, map[string]string{})
+	do("POST", 1, `{"do-not-merge": "table boundary", "owner": "dba"}`, http.StatusOK)
+
+	// Region 2 takes over the range of region 1, the labels of region 1 are
+	// removed with it, so they don't come back with a new region 1.
+	region := newTestRegion(2, []byte{}, []byte("m"), newTestPeer(2, 1))
+	region.RegionEpoch.Version = proto.Uint64(2)
+	mustRegionHeartbeat(c, conn, region, region.GetPeers()[0])
+	checkErrorResponse(c, do("GET", 1, "", http.StatusNotFound), errCodeRegionNotFound)
+	checkLabels(2, map[string]string{})
+
+	region = newTestRegion(1, []byte("m"), []byte{}, newTestPeer(1, 1))
+	region.RegionEpoch.Version = proto.Uint64(2)
+	mustRegionHeartbeat(c, conn, region, region.GetPeers()[0])
+	checkLabels(1, map[string]string{})
+}
+
+func (s *testRegionSuite) TestRegionSiblings(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	addr := func(id string) string {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/regions/", id, "/siblings"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+	mustGet := func(id string, status int) []byte {
+		resp, err := s.hc.Get(addr(id))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustGetSiblings := func(id uint64) *regionSiblings {
+		got := &regionSiblings{}
+		c.Assert(json.Unmarshal(mustGet(fmt.Sprint(id), http.StatusOK), got), IsNil)
+		return got
+	}
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	regions := mustSplitRegions(c, conn, 3)
+
+	checkRegion := func(got *regionMeta, region *metapb.Region) {
+		c.Assert(got, NotNil)
+		c.Assert(got.ID, Equals, region.GetId())
+		c.Assert(got.StartKey, Equals, hex.EncodeToString(region.GetStartKey()))
+		c.Assert(got.EndKey, Equals, hex.EncodeToString(region.GetEndKey()))
+		c.Assert(got.Peers, HasLen, 1)
+		c.Assert(got.Peers[0].GetStoreId(), Equals, uint64(1))
+		c.Assert(got.Leader.GetId(), Equals, region.GetPeers()[0].GetId())
+	}
+
+	got := mustGetSiblings(2)
+	checkRegion(got.Prev, regions[0])
+	checkRegion(got.Next, regions[2])
+
+	// The first and the last regions have no sibling at the boundary.
+	got = mustGetSiblings(1)
+	c.Assert(got.Prev, IsNil)
+	checkRegion(got.Next, regions[1])
+	got = mustGetSiblings(3)
+	checkRegion(got.Prev, regions[1])
+	c.Assert(got.Next, IsNil)
+
+	checkErrorResponse(c, mustGet("100", http.StatusNotFound), errCodeRegionNotFound)
+	checkErrorResponse(c, mustGet("abc", http.StatusBadRequest), errCodeInvalidRegionID)
+}
+
+func (s *testRegionSuite) TestScatterRangeLimit(c *C) {
+	cfgs := server.NewTestMultiConfig(1)
+	cfgs[0].MaxScanRegions = 2
+	_, svrs, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	mustSplitRegions(c, conn, 5)
+	for _, id := range []uint64{1, 2, 3, 4} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/regions/scatter"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	mustScatter := func(startKey []byte, endKey []byte, status int) []byte {
+		body := fmt.Sprintf(`{"start_key": "%s", "end_key": "%s"}`, hex.EncodeToString(startKey), hex.EncodeToString(endKey))
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// The whole key space and [key1, key3] touch more than 2 regions.
+	checkErrorResponse(c, mustScatter(nil, nil, http.StatusRequestEntityTooLarge), errCodeRangeTooLarge)
+	checkErrorResponse(c, mustScatter(newTestSplitKey(1), append(newTestSplitKey(3), 0), http.StatusRequestEntityTooLarge), errCodeRangeTooLarge)
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster.GetBalanceOperators(), HasLen, 0)
+
+	// [key1, key3) only touches region 2 and 3.
+	got := &scatterResult{}
+	c.Assert(json.Unmarshal(mustScatter(newTestSplitKey(1), newTestSplitKey(3), http.StatusOK), got), IsNil)
+	c.Assert(got.Count, LessEqual, 2)
+}
+
+func (s *testRegionSuite) TestRegionsRange(c *C) {
+	cfgs := server.NewTestMultiConfig(1)
+	cfgs[0].MaxScanRegions = 3
+	_, svrs, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	regions := mustSplitRegions(c, conn, 5)
+
+	mustGet := func(startKey string, endKey string, status int) []byte {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/regions/range?start_key=", startKey, "&end_key=", endKey}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	table := []struct {
+		startKey []byte
+		endKey   []byte
+		expect   []uint64
+	}{
+		{startKey: []byte{1, 0}, endKey: []byte{3, 0}, expect: []uint64{1, 2, 3}},
+		// The region which ends at the start key doesn't overlap the range.
+		{startKey: newTestSplitKey(2), endKey: newTestSplitKey(3), expect: []uint64{3}},
+		// The empty end key means no upper bound.
+		{startKey: []byte{3, 0}, expect: []uint64{3, 4, 5}},
+		{startKey: newTestSplitKey(4), expect: []uint64{5}},
+		{startKey: newTestSplitKey(2), endKey: newTestSplitKey(2), expect: []uint64{}},
+	}
+	for _, t := range table {
+		got := &regionsInfo{}
+		buf := mustGet(hex.EncodeToString(t.startKey), hex.EncodeToString(t.endKey), http.StatusOK)
+		c.Assert(json.Unmarshal(buf, got), IsNil)
+		c.Assert(got.Count, Equals, len(t.expect))
+		c.Assert(got.Total, Equals, 5)
+		ids := make([]uint64, 0, len(got.Regions))
+		for _, region := range got.Regions {
+			ids = append(ids, region.ID)
+			c.Assert(region.StartKey, Equals, hex.EncodeToString(regions[region.ID-1].GetStartKey()))
+		}
+		c.Assert(ids, DeepEquals, t.expect)
+	}
+
+	checkErrorResponse(c, mustGet("", "", http.StatusRequestEntityTooLarge), errCodeRangeTooLarge)
+	checkErrorResponse(c, mustGet("0100", "", http.StatusRequestEntityTooLarge), errCodeRangeTooLarge)
+	checkErrorResponse(c, mustGet("03", "02", http.StatusBadRequest), errCodeInvalidKey)
+	checkErrorResponse(c, mustGet("xyz", "", http.StatusBadRequest), errCodeInvalidKey)
+	checkErrorResponse(c, mustGet("", "123", http.StatusBadRequest), errCodeInvalidKey)
+}
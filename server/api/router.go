@@ -16,6 +16,7 @@ package api
 import (
 	"github.com/gorilla/mux"
 	"github.com/pingcap/pd/server"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/unrolled/render"
 )
 
@@ -30,26 +31,109 @@ func createRouter(prefix string, svr *server.Server) *mux.Router {
 	router := mux.NewRouter().PathPrefix(prefix).Subrouter()
 	router.Handle("/api/v1/balancers", newBalancerHandler(svr, rd)).Methods("GET")
 	router.Handle("/api/v1/cluster", newClusterHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/cluster/status", newClusterStatusHandler(svr, rd)).Methods("GET")
 
 	confHandler := newConfHandler(svr, rd)
 	router.HandleFunc("/api/v1/config", confHandler.Get).Methods("GET")
 	router.HandleFunc("/api/v1/config", confHandler.Post).Methods("POST")
+	router.HandleFunc("/api/v1/config/schedule", confHandler.GetSchedule).Methods("GET")
+	router.HandleFunc("/api/v1/config/schedule", confHandler.PostSchedule).Methods("POST")
+	router.HandleFunc("/api/v1/config/schedule/validate", confHandler.ValidateSchedule).Methods("POST")
+	router.HandleFunc("/api/v1/config/replicate", confHandler.GetReplicate).Methods("GET")
+	router.HandleFunc("/api/v1/config/replicate", confHandler.PostReplicate).Methods("POST")
+	router.HandleFunc("/api/v1/config/rules", confHandler.GetRules).Methods("GET")
+	router.HandleFunc("/api/v1/config/rules", confHandler.PostRules).Methods("POST")
+	router.HandleFunc("/api/v1/config/namespaces", confHandler.GetNamespaces).Methods("GET")
+	router.HandleFunc("/api/v1/config/namespaces", confHandler.PostNamespaces).Methods("POST")
+	router.HandleFunc("/api/v1/config/leader-affinities", confHandler.GetLeaderAffinities).Methods("GET")
+	router.HandleFunc("/api/v1/config/leader-affinities", confHandler.PostLeaderAffinities).Methods("POST")
 
+	router.Handle("/api/v1/diagnose/{id}", newDiagnoseHandler(svr, rd)).Methods("GET")
 	router.Handle("/api/v1/events", newEventsHandler(svr, rd)).Methods("GET")
 	router.Handle("/api/v1/feed", newFeedHandler(svr, rd)).Methods("GET")
 	router.Handle("/api/v1/history/operators", newHistoryOperatorHandler(svr, rd)).Methods("GET")
+	operatorHandler := newOperatorHandler(svr, rd)
+	router.HandleFunc("/api/v1/operators", operatorHandler.List).Methods("GET")
+	router.HandleFunc("/api/v1/operators", operatorHandler.Post).Methods("POST")
+	router.HandleFunc("/api/v1/operators/{region_id}", operatorHandler.Delete).Methods("DELETE")
 	router.Handle("/api/v1/store/{id}", newStoreHandler(svr, rd)).Methods("GET")
 	router.Handle("/api/v1/stores", newStoresHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/stores", newStoresRegisterHandler(svr, rd)).Methods("POST")
+	router.Handle("/api/v1/stores/{id}", newStoreHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/stores/{id}", newStoreDeleteHandler(svr, rd)).Methods("DELETE")
+	storeLabelHandler := newStoreLabelHandler(svr, rd)
+	router.HandleFunc("/api/v1/stores/{id}/label", storeLabelHandler.Get).Methods("GET")
+	router.HandleFunc("/api/v1/stores/{id}/label", storeLabelHandler.Post).Methods("POST")
+	storeWeightHandler := newStoreWeightHandler(svr, rd)
+	router.HandleFunc("/api/v1/stores/{id}/weight", storeWeightHandler.Get).Methods("GET")
+	router.HandleFunc("/api/v1/stores/{id}/weight", storeWeightHandler.Post).Methods("POST")
+	storeLimitHandler := newStoreLimitHandler(svr, rd)
+	router.HandleFunc("/api/v1/stores/{id}/limit", storeLimitHandler.Get).Methods("GET")
+	router.HandleFunc("/api/v1/stores/{id}/limit", storeLimitHandler.Post).Methods("POST")
+	router.Handle("/api/v1/stores/{id}/history", newStoreHistoryHandler(svr, rd)).Methods("GET")
+	storeEvictLeaderHandler := newStoreEvictLeaderHandler(svr, rd)
+	router.HandleFunc("/api/v1/stores/{id}/evict-leader", storeEvictLeaderHandler.Post).Methods("POST")
+	router.HandleFunc("/api/v1/stores/{id}/evict-leader", storeEvictLeaderHandler.Delete).Methods("DELETE")
 	router.Handle("/api/v1/region/{id}", newRegionHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/regions/{id}/siblings", newRegionSiblingsHandler(svr, rd)).Methods("GET")
 	router.Handle("/api/v1/regions", newRegionsHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/regions/dump", newRegionsDumpHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/regions/range", newRegionsRangeHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/regions/key/{key}", newRegionKeyHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/regions/store/{id}", newStoreRegionsHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/stats/regions", newRegionStatsHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/regions/check/under-replicated", newUnderReplicatedHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/regions/check/orphan-peers", newOrphanPeerHandler(svr, rd)).Methods("GET")
+	regionScatterHandler := newRegionScatterHandler(svr, rd)
+	router.HandleFunc("/api/v1/regions/scatter", regionScatterHandler.ScatterRange).Methods("POST")
+	router.HandleFunc("/api/v1/regions/{id}/scatter", regionScatterHandler.Scatter).Methods("POST")
+	regionPeerHandler := newRegionPeerHandler(svr, rd)
+	router.HandleFunc("/api/v1/regions/{id}/peer/add", regionPeerHandler.Add).Methods("POST")
+	router.HandleFunc("/api/v1/regions/{id}/peer/remove", regionPeerHandler.Remove).Methods("POST")
+	regionLabelHandler := newRegionLabelHandler(svr, rd)
+	router.HandleFunc("/api/v1/regions/{id}/labels", regionLabelHandler.Get).Methods("GET")
+	router.HandleFunc("/api/v1/regions/{id}/labels", regionLabelHandler.Post).Methods("POST")
+	router.HandleFunc("/api/v1/regions/{id}/labels", regionLabelHandler.Delete).Methods("DELETE")
+	schedulerHandler := newSchedulerHandler(svr, rd)
+	router.HandleFunc("/api/v1/schedulers", schedulerHandler.List).Methods("GET")
+	router.HandleFunc("/api/v1/schedulers", schedulerHandler.Add).Methods("POST")
+	router.HandleFunc("/api/v1/schedulers/{name}", schedulerHandler.Delete).Methods("DELETE")
+	router.HandleFunc("/api/v1/schedulers/{name}/pause", schedulerHandler.Pause).Methods("POST")
+	router.HandleFunc("/api/v1/schedulers/{name}/resume", schedulerHandler.Resume).Methods("POST")
+	router.HandleFunc("/api/v1/schedulers/{name}", schedulerHandler.DryRun).Methods("POST")
+	router.HandleFunc("/api/v1/admin/schedule/run", schedulerHandler.Run).Methods("POST")
+	maintenanceHandler := newMaintenanceHandler(svr, rd)
+	router.HandleFunc("/api/v1/admin/maintenance", maintenanceHandler.Get).Methods("GET")
+	router.HandleFunc("/api/v1/admin/maintenance", maintenanceHandler.Post).Methods("POST")
+	importModeHandler := newImportModeHandler(svr, rd)
+	router.HandleFunc("/api/v1/admin/import-mode", importModeHandler.Get).Methods("GET")
+	router.HandleFunc("/api/v1/admin/import-mode", importModeHandler.Post).Methods("POST")
+	logLevelHandler := newLogLevelHandler(svr, rd)
+	router.HandleFunc("/api/v1/admin/log-level", logLevelHandler.Get).Methods("GET")
+	router.HandleFunc("/api/v1/admin/log-level", logLevelHandler.Post).Methods("POST")
+
+	router.Handle("/api/v1/tso/status", newTSOStatusHandler(svr, rd)).Methods("GET")
+	gcHandler := newGCHandler(svr, rd)
+	router.HandleFunc("/api/v1/gc/safepoint", gcHandler.GetSafePoint).Methods("GET")
+	router.HandleFunc("/api/v1/gc/safepoint", gcHandler.PostSafePoint).Methods("POST")
+	router.HandleFunc("/api/v1/gc/service-safepoint", gcHandler.PostServiceSafePoint).Methods("POST")
 	router.Handle("/api/v1/version", newVersionHandler(rd)).Methods("GET")
+	router.Handle("/api/v1/health", newHealthHandler(svr, rd)).Methods("GET")
 
 	router.Handle("/api/v1/members", newMemberListHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/members/{name}", newMemberGetHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/members/{name}", newMemberUpdateHandler(svr, rd)).Methods("PUT")
 	router.Handle("/api/v1/members/{name}", newMemberDeleteHandler(svr, rd)).Methods("DELETE")
+	router.Handle("/api/v1/members/{name}/leader", newMemberLeaderHandler(svr, rd)).Methods("POST")
+	memberPriorityHandler := newMemberPriorityHandler(svr, rd)
+	router.HandleFunc("/api/v1/members/{name}/priority", memberPriorityHandler.Get).Methods("GET")
+	router.HandleFunc("/api/v1/members/{name}/priority", memberPriorityHandler.Post).Methods("POST")
 	router.Handle("/api/v1/leader", newLeaderHandler(svr, rd)).Methods("GET")
+	router.Handle("/api/v1/leader/resign", newLeaderResignHandler(svr, rd)).Methods("POST")
 
 	router.Handle("/", newHomeHandler(rd)).Methods("GET")
 	router.Handle("/ws", newWSHandler(svr))
+	router.Handle("/metrics", prometheus.Handler()).Methods("GET")
 
 	return router
 }
@@ -0,0 +1,210 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+// schedulerPause is the request body to pause a scheduler, e.g, {"duration": "30m"}.
+type schedulerPause struct {
+	Duration string `json:"duration"`
+}
+
+// schedulerInput is the request body to add a scheduler, e.g,
+// {"name": "evict-leader", "args": {"store_id": "1"}}.
+type schedulerInput struct {
+	Name string            `json:"name"`
+	Args map[string]string `json:"args"`
+}
+
+type schedulerHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newSchedulerHandler(svr *server.Server, rd *render.Render) *schedulerHandler {
+	return &schedulerHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *schedulerHandler) List(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, cluster.GetSchedulers())
+}
+
+// Add adds the scheduler, or adds back a removed built-in scheduler.
+func (h *schedulerHandler) Add(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	input := &schedulerInput{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	if input.Name == "" {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidSchedulerArgs, "name is not specified")
+		return
+	}
+
+	h.writeResult(w, input.Name, cluster.AddScheduler(input.Name, input.Args), fmt.Sprintf("added, scheduler: %s", input.Name))
+}
+
+// Delete removes the scheduler.
+func (h *schedulerHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	h.writeResult(w, name, cluster.RemoveScheduler(name), fmt.Sprintf("removed, scheduler: %s", name))
+}
+
+func (h *schedulerHandler) Pause(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	input := &schedulerPause{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	d, err := time.ParseDuration(input.Duration)
+	if err != nil || d <= 0 {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidDuration, fmt.Sprintf("invalid duration: %s", input.Duration))
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	h.writeResult(w, name, cluster.PauseScheduler(name, d), fmt.Sprintf("paused, scheduler: %s", name))
+}
+
+func (h *schedulerHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	h.writeResult(w, name, cluster.ResumeScheduler(name), fmt.Sprintf("resumed, scheduler: %s", name))
+}
+
+// DryRun sets the dry-run mode of the scheduler by ?dry-run=true or false.
+func (h *schedulerHandler) DryRun(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	if r.URL.Query().Get("dry-run") == "" {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidDryRun, "dry-run is not specified")
+		return
+	}
+	dryRun, err := parseQueryBool(r, "dry-run", false)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidDryRun, err.Error())
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	h.writeResult(w, name, cluster.SetSchedulerDryRun(name, dryRun), fmt.Sprintf("dry-run %v, scheduler: %s", dryRun, name))
+}
+
+// Run runs a scheduling pass on the leader now, and returns the operators
+// added by the pass.
+func (h *schedulerHandler) Run(w http.ResponseWriter, r *http.Request) {
+	if !h.svr.IsLeader() {
+		writeNotLeader(h.svr, h.rd, w)
+		return
+	}
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	operators := cluster.RunSchedulePass()
+	h.rd.JSON(w, http.StatusOK, &operatorsInfo{
+		Count:     len(operators),
+		Operators: operators,
+	})
+}
+
+func (h *schedulerHandler) writeResult(w http.ResponseWriter, name string, err error, msg string) {
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, msg)
+	case server.ErrSchedulerNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeSchedulerNotFound, fmt.Sprintf("not found, scheduler: %s", name))
+	case server.ErrInvalidSchedulerArgs:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidSchedulerArgs, err.Error())
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
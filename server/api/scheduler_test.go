@@ -0,0 +1,337 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testSchedulerSuite{})
+
+type testSchedulerSuite struct {
+	hc *http.Client
+}
+
+func (s *testSchedulerSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testSchedulerSuite) mustGetSchedulers(c *C, addr string) map[string]*server.SchedulerInfo {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	var schedulers []*server.SchedulerInfo
+	c.Assert(json.Unmarshal(buf, &schedulers), IsNil)
+
+	got := make(map[string]*server.SchedulerInfo)
+	for _, scheduler := range schedulers {
+		got[scheduler.Name] = scheduler
+	}
+	return got
+}
+
+func (s *testSchedulerSuite) TestSchedulerPause(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/schedulers"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	got := s.mustGetSchedulers(c, addr)
+	c.Assert(got, HasLen, 3)
+	c.Assert(got["leader"].Paused, IsFalse)
+	c.Assert(got["region"].Paused, IsFalse)
+
+	mustPost := func(url string, body string, status int, code string) {
+		resp, err := s.hc.Post(url, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		if status != http.StatusOK {
+			checkErrorResponse(c, buf, code)
+		}
+	}
+
+	mustPost(addr+"/region/pause", `{"duration": "10m"}`, http.StatusOK, "")
+	got = s.mustGetSchedulers(c, addr)
+	c.Assert(got["leader"].Paused, IsFalse)
+	c.Assert(got["region"].Paused, IsTrue)
+	c.Assert(got["region"].PausedUntil.After(time.Now().Add(5*time.Minute)), IsTrue)
+
+	mustPost(addr+"/unknown/pause", `{"duration": "10m"}`, http.StatusNotFound, errCodeSchedulerNotFound)
+	mustPost(addr+"/region/pause", `{"duration": "abc"}`, http.StatusBadRequest, errCodeInvalidDuration)
+	mustPost(addr+"/region/pause", `{"duration": "-1m"}`, http.StatusBadRequest, errCodeInvalidDuration)
+	mustPost(addr+"/region/pause", `abc`, http.StatusBadRequest, errCodeInvalidBody)
+
+	mustPost(addr+"/region/resume", "", http.StatusOK, "")
+	got = s.mustGetSchedulers(c, addr)
+	c.Assert(got["region"].Paused, IsFalse)
+}
+
+func (s *testSchedulerSuite) TestSchedulerStateAfterLeaderChange(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	conn := mustRPCConnect(c, leader)
+	defer conn.Close()
+	mustBootstrapCluster(c, conn)
+
+	apiAddr := func(svr *server.Server, path string) string {
+		parts := []string{svr.GetAddr(), apiPrefix, path}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+	mustPost := func(addr string, body string) {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	}
+
+	mustPost(apiAddr(leader, "/api/v1/schedulers/region/pause"), `{"duration": "10m"}`)
+	mustPost(apiAddr(leader, "/api/v1/schedulers"), `{"name": "evict-leader", "args": {"store_id": "1"}}`)
+	req, err := http.NewRequest("DELETE", apiAddr(leader, "/api/v1/schedulers/leader"), nil)
+	c.Assert(err, IsNil)
+	resp, err := s.hc.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	balanceCfg := leader.GetConfig().BalanceCfg
+	balanceCfg.MaxLeaderCount = 123
+	body, err := json.Marshal(balanceCfg)
+	c.Assert(err, IsNil)
+	mustPost(apiAddr(leader, "/api/v1/config"), string(body))
+
+	mustPost(apiAddr(leader, "/api/v1/leader/resign"), "")
+	newLeader := mustWaitLeader(c, svrs, leader)
+
+	// The new leader starts the cluster with the saved state.
+	for i := 0; i < 100; i++ {
+		cluster, err := newLeader.GetRaftCluster()
+		c.Assert(err, IsNil)
+		if cluster != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	got := s.mustGetSchedulers(c, apiAddr(newLeader, "/api/v1/schedulers"))
+	c.Assert(got["region"].Paused, IsTrue)
+	c.Assert(got["leader"], IsNil)
+	c.Assert(got["evict-leader-1"], NotNil)
+	c.Assert(newLeader.GetConfig().BalanceCfg.MaxLeaderCount, Equals, uint64(123))
+}
+
+func (s *testSchedulerSuite) TestSchedulerDryRun(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	mustPost := func(url string, status int, code string) {
+		resp, err := s.hc.Post(url, "application/json", nil)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		if status != http.StatusOK {
+			checkErrorResponse(c, buf, code)
+		}
+	}
+
+	mustPost(addr+"/schedulers/leader?dry-run=true", http.StatusOK, "")
+	got := s.mustGetSchedulers(c, addr+"/schedulers")
+	c.Assert(got["leader"].DryRun, IsTrue)
+	c.Assert(got["region"].DryRun, IsFalse)
+
+	mustPost(addr+"/schedulers/unknown?dry-run=true", http.StatusNotFound, errCodeSchedulerNotFound)
+	mustPost(addr+"/schedulers/leader?dry-run=abc", http.StatusBadRequest, errCodeInvalidDryRun)
+	mustPost(addr+"/schedulers/leader", http.StatusBadRequest, errCodeInvalidDryRun)
+
+	// No operator is generated by the dry-run scheduler yet.
+	resp, err := s.hc.Get(addr + "/operators?dry-run=true")
+	c.Assert(err, IsNil)
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	operators := &dryRunOperatorsInfo{}
+	c.Assert(json.Unmarshal(buf, operators), IsNil)
+	c.Assert(operators.Count, Equals, 0)
+
+	mustPost(addr+"/schedulers/leader?dry-run=false", http.StatusOK, "")
+	got = s.mustGetSchedulers(c, addr+"/schedulers")
+	c.Assert(got["leader"].DryRun, IsFalse)
+}
+
+func (s *testSchedulerSuite) TestSchedulerAddRemove(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/schedulers"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	checkResponse := func(resp *http.Response, status int, code string) {
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		if status != http.StatusOK {
+			checkErrorResponse(c, buf, code)
+		}
+	}
+	mustAdd := func(body string, status int, code string) {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		checkResponse(resp, status, code)
+	}
+	mustRemove := func(name string, status int, code string) {
+		req, err := http.NewRequest("DELETE", addr+"/"+name, nil)
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Do(req)
+		c.Assert(err, IsNil)
+		checkResponse(resp, status, code)
+	}
+
+	mustAdd(`{"name": "evict-leader", "args": {"store_id": "1"}}`, http.StatusOK, "")
+	got := s.mustGetSchedulers(c, addr)
+	c.Assert(got, HasLen, 4)
+	c.Assert(got["evict-leader-1"], NotNil)
+	c.Assert(got["evict-leader-1"].Args, DeepEquals, map[string]string{"store_id": "1"})
+	c.Assert(got["leader"].Args, IsNil)
+
+	mustAdd(`{"name": "evict-leader"}`, http.StatusBadRequest, errCodeInvalidSchedulerArgs)
+	mustAdd(`{"name": "evict-leader", "args": {"store_id": "abc"}}`, http.StatusBadRequest, errCodeInvalidSchedulerArgs)
+	mustAdd(`{"name": "evict-leader", "args": {"store_id": "100"}}`, http.StatusNotFound, errCodeStoreNotFound)
+	mustAdd(`{"args": {"store_id": "1"}}`, http.StatusBadRequest, errCodeInvalidSchedulerArgs)
+	mustAdd(`{"name": "unknown"}`, http.StatusNotFound, errCodeSchedulerNotFound)
+	mustAdd(`abc`, http.StatusBadRequest, errCodeInvalidBody)
+
+	mustRemove("evict-leader-1", http.StatusOK, "")
+	mustRemove("evict-leader-1", http.StatusNotFound, errCodeSchedulerNotFound)
+	got = s.mustGetSchedulers(c, addr)
+	c.Assert(got, HasLen, 3)
+	c.Assert(got["evict-leader-1"], IsNil)
+
+	// The built-in schedulers can be removed and added back.
+	mustRemove("region", http.StatusOK, "")
+	got = s.mustGetSchedulers(c, addr)
+	c.Assert(got, HasLen, 2)
+	c.Assert(got["region"], IsNil)
+	mustAdd(`{"name": "region"}`, http.StatusOK, "")
+	got = s.mustGetSchedulers(c, addr)
+	c.Assert(got, HasLen, 3)
+	c.Assert(got["region"], NotNil)
+}
+
+func (s *testSchedulerSuite) TestScheduleRun(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	conn := mustRPCConnect(c, leader)
+	defer conn.Close()
+
+	// Store 1 has the leaders of all the regions.
+	mustBootstrapCluster(c, conn)
+	mustPutStore(c, conn, newTestStore(2))
+	mustPutStore(c, conn, newTestStore(3))
+	regions := mustSplitRegions(c, conn, 12)
+	for _, region := range regions {
+		leaderPeer := region.GetPeers()[0]
+		region.Peers = append(region.Peers, newTestPeer(region.GetId()*10+2, 2), newTestPeer(region.GetId()*10+3, 3))
+		region.RegionEpoch.ConfVer = proto.Uint64(3)
+		mustRegionHeartbeat(c, conn, region, leaderPeer)
+	}
+	for _, id := range []uint64{1, 2, 3} {
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	mustPost := func(svr *server.Server, status int) []byte {
+		parts := []string{svr.GetAddr(), apiPrefix, "/api/v1/admin/schedule/run"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Post(addr, "application/json", nil)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// The leaders are moved out of store 1.
+	got := &struct {
+		Count     int                 `json:"count"`
+		Operators []*operatorProgress `json:"operators"`
+	}{}
+	c.Assert(json.Unmarshal(mustPost(leader, http.StatusOK), got), IsNil)
+	c.Assert(got.Count, Greater, 0)
+	c.Assert(got.Operators, HasLen, got.Count)
+	cluster, err := leader.GetRaftCluster()
+	c.Assert(err, IsNil)
+	running := make(map[uint64]bool)
+	for _, op := range cluster.GetOperators() {
+		running[op.ID] = true
+	}
+	for _, op := range got.Operators {
+		c.Assert(running[op.ID], IsTrue)
+		c.Assert(op.Total, Equals, 1)
+	}
+
+	// The followers tell the leader address.
+	for _, svr := range svrs {
+		if svr == leader {
+			continue
+		}
+		buf := mustPost(svr, http.StatusForbidden)
+		checkErrorResponse(c, buf, errCodeNotLeader)
+		c.Assert(string(buf), Matches, "(?s).*"+leader.GetAddr()+".*")
+	}
+}
@@ -20,20 +20,34 @@ import (
 	"github.com/urfave/negroni"
 )
 
-const apiPrefix = "/pd"
-
-// NewHandler creates a HTTP handler for API.
+// NewHandler creates a HTTP handler for API, the routes are mounted under
+// the api-prefix in the config.
 func NewHandler(svr *server.Server) http.Handler {
+	cfg := svr.GetConfig()
 	engine := negroni.New()
 
 	recovery := negroni.NewRecovery()
 	engine.Use(recovery)
 
+	engine.Use(newRequestLogger())
+	engine.Use(newStaleReadMarker(svr))
+
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		engine.Use(newCORSHandler(cfg.CORSAllowedOrigins))
+	}
+	if cfg.APIRateLimit > 0 {
+		engine.Use(newRateLimiter(cfg.APIRateLimit, cfg.APIRateBurst, cfg.APIPrefix, cfg.APIRateLimitExempt))
+	}
+	if cfg.APIUsername != "" {
+		engine.Use(newBasicAuthHandler(cfg.APIUsername, cfg.APIPassword))
+	}
+	engine.Use(newStrongReadHandler(svr))
+
 	static := negroni.NewStatic(http.Dir("templates/static/"))
-	static.Prefix = apiPrefix
+	static.Prefix = cfg.APIPrefix
 	engine.Use(static)
 
-	router := createRouter(apiPrefix, svr)
+	router := createRouter(cfg.APIPrefix, svr)
 	engine.UseHandler(router)
 
 	return engine
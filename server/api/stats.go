@@ -0,0 +1,59 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+type regionStatsHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newRegionStatsHandler(svr *server.Server, rd *render.Render) *regionStatsHandler {
+	return &regionStatsHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// ServeHTTP returns the region count of each table if group-by is table,
+// or of each raw key prefix if group-by is prefix or no key decoder is set.
+func (h *regionStatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group-by")
+	if len(groupBy) == 0 {
+		groupBy = server.RegionStatsByTable
+	}
+	if groupBy != server.RegionStatsByTable && groupBy != server.RegionStatsByPrefix {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidGroupBy, fmt.Sprintf("invalid group-by: %s", groupBy))
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, cluster.GetRegionStats(groupBy))
+}
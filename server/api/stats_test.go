@@ -0,0 +1,140 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testStatsSuite{})
+
+type testStatsSuite struct {
+	hc *http.Client
+}
+
+func (s *testStatsSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+// newTestTableKey returns the memcomparable encoded key of the table like
+// TiDB.
+func newTestTableKey(tableID int64, suffix string) []byte {
+	key := make([]byte, 9, 9+len(suffix))
+	key[0] = 't'
+	binary.BigEndian.PutUint64(key[1:], uint64(tableID)^0x8000000000000000)
+	key = append(key, suffix...)
+
+	var encoded []byte
+	for i := 0; i <= len(key); i += 8 {
+		group := make([]byte, 8)
+		n := copy(group, key[i:])
+		encoded = append(encoded, group...)
+		encoded = append(encoded, byte(0xFF-(8-n)))
+	}
+	return encoded
+}
+
+func (s *testStatsSuite) TestRegionStats(c *C) {
+	cfgs := server.NewTestMultiConfig(1)
+	cfgs[0].KeyDecoder = server.DecodeTableKey
+	cfgs[0].RegionStatsPrefixLength = 1
+	_, svrs, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	mustHeartbeatStore(c, conn, 1)
+
+	// The first and the last regions are not in any table, and "t\x80" is
+	// a malformed table key.
+	splitKeys := [][]byte{
+		[]byte("t\x80"),
+		newTestTableKey(1, ""),
+		newTestTableKey(1, "_r5"),
+		newTestTableKey(2, ""),
+		[]byte("u"),
+	}
+	for i := 0; i <= len(splitKeys); i++ {
+		startKey, endKey := []byte{}, []byte{}
+		if i > 0 {
+			startKey = splitKeys[i-1]
+		}
+		if i < len(splitKeys) {
+			endKey = splitKeys[i]
+		}
+		peerID := uint64(1)
+		if i > 0 {
+			peerID = uint64(100 + i)
+		}
+		peer := newTestPeer(peerID, 1)
+		region := newTestRegion(uint64(i+1), startKey, endKey, peer)
+		region.RegionEpoch.Version = proto.Uint64(2)
+		mustRegionHeartbeat(c, conn, region, peer)
+	}
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stats/regions"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	mustGet := func(query string, status int) []byte {
+		resp, err := s.hc.Get(addr + query)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+	mustGetStats := func(query string) *server.RegionStats {
+		stats := &server.RegionStats{}
+		c.Assert(json.Unmarshal(mustGet(query, http.StatusOK), stats), IsNil)
+		return stats
+	}
+
+	byTable := &server.RegionStats{
+		GroupBy: server.RegionStatsByTable,
+		Buckets: []*server.RegionStatsBucket{
+			{Key: "table_1", Count: 2},
+			{Key: "table_2", Count: 1},
+			{Key: "unknown", Count: 3},
+		},
+	}
+	c.Assert(mustGetStats("?group-by=table"), DeepEquals, byTable)
+	c.Assert(mustGetStats(""), DeepEquals, byTable)
+
+	byPrefix := &server.RegionStats{
+		GroupBy: server.RegionStatsByPrefix,
+		Buckets: []*server.RegionStatsBucket{
+			{Key: "", Count: 1},
+			{Key: "74", Count: 4},
+			{Key: "75", Count: 1},
+		},
+	}
+	c.Assert(mustGetStats("?group-by=prefix"), DeepEquals, byPrefix)
+
+	// The regions are grouped by the key prefix without the key decoder.
+	cfgs[0].KeyDecoder = nil
+	c.Assert(mustGetStats("?group-by=table"), DeepEquals, byPrefix)
+
+	checkErrorResponse(c, mustGet("?group-by=store", http.StatusBadRequest), errCodeInvalidGroupBy)
+}
@@ -14,10 +14,15 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/juju/errors"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/pd/server"
 	"github.com/unrolled/render"
@@ -26,6 +31,29 @@ import (
 type storeInfo struct {
 	Store  *metapb.Store       `json:"store"`
 	Status *server.StoreStatus `json:"status"`
+	// RemainingRegionCount is the count of regions not moved out of the
+	// offline store yet, it is only reported for the offline store.
+	RemainingRegionCount *int `json:"remaining_region_count,omitempty"`
+}
+
+func newStoreInfo(cluster *server.RaftCluster, store *metapb.Store, status *server.StoreStatus) *storeInfo {
+	info := &storeInfo{
+		Store:  store,
+		Status: status,
+	}
+	info.Status.Scores = cluster.GetScores(store, status)
+	if status.State == server.StoreStateOffline {
+		count := cluster.GetStoreRegionCount(store.GetId())
+		info.RemainingRegionCount = &count
+	}
+	return info
+}
+
+// storeDetail is the store info with the region and leader count hosted on it.
+type storeDetail struct {
+	*storeInfo
+	RegionCount int `json:"region_count"`
+	LeaderCount int `json:"leader_count"`
 }
 
 type storesInfo struct {
@@ -48,7 +76,7 @@ func newStoreHandler(svr *server.Server, rd *render.Render) *storeHandler {
 func (h *storeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
@@ -60,23 +88,82 @@ func (h *storeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	storeIDStr := vars["id"]
 	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
 		return
 	}
 
 	store, status, err := cluster.GetStore(storeID)
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
 		return
 	}
 
-	storeInfo := &storeInfo{
-		Store:  store,
-		Status: status,
+	detail := &storeDetail{
+		storeInfo:   newStoreInfo(cluster, store, status),
+		RegionCount: cluster.GetStoreRegionCount(storeID),
+		LeaderCount: cluster.GetStoreLeaderCount(storeID),
+	}
+
+	h.rd.JSON(w, http.StatusOK, detail)
+}
+
+type storeDeleteHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStoreDeleteHandler(svr *server.Server, rd *render.Render) *storeDeleteHandler {
+	return &storeDeleteHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *storeDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
 	}
-	storeInfo.Status.Scores = cluster.GetScores(storeInfo.Store, storeInfo.Status)
 
-	h.rd.JSON(w, http.StatusOK, storeInfo)
+	vars := mux.Vars(r)
+	storeIDStr := vars["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	// The tombstone store is purged with force, otherwise the store is
+	// made offline.
+	force, err := parseQueryBool(r, "force", false)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidForce, err.Error())
+		return
+	}
+	msg := fmt.Sprintf("offline, store: %d", storeID)
+	if force {
+		err = cluster.PurgeStore(storeID)
+		msg = fmt.Sprintf("purged, store: %d", storeID)
+	} else {
+		err = cluster.OfflineStore(storeID)
+	}
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, msg)
+	case server.ErrStoreNotTombstone:
+		writeError(h.rd, w, http.StatusPreconditionFailed, errCodeStoreNotTombstone, fmt.Sprintf("not tombstone, store: %d", storeID))
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+	case server.ErrStoreIsLastReplica:
+		writeError(h.rd, w, http.StatusPreconditionFailed, errCodeStoreLastReplica, fmt.Sprintf("last replica, store: %d", storeID))
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
 }
 
 type storesHandler struct {
@@ -94,7 +181,7 @@ func newStoresHandler(svr *server.Server, rd *render.Render) *storesHandler {
 func (h *storesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cluster, err := h.svr.GetRaftCluster()
 	if err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err)
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	if cluster == nil {
@@ -102,26 +189,529 @@ func (h *storesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Filter the stores by state if the client specifies it, e.g, ?state=up.
+	state := r.URL.Query().Get("state")
+	sortKey := r.URL.Query().Get("sort")
+	if _, ok := storeSortKeys[sortKey]; !ok {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidSort, fmt.Sprintf("invalid sort: %s", sortKey))
+		return
+	}
+	order := r.URL.Query().Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidOrder, fmt.Sprintf("invalid order: %s", order))
+		return
+	}
+
 	stores := cluster.GetStores()
 	storesInfo := &storesInfo{
-		Count:  len(stores),
 		Stores: make([]*storeInfo, 0, len(stores)),
 	}
 
 	for _, s := range stores {
 		store, status, err := cluster.GetStore(s.GetId())
 		if err != nil {
-			h.rd.JSON(w, http.StatusInternalServerError, err)
+			writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
 			return
 		}
-
-		storeInfo := &storeInfo{
-			Store:  store,
-			Status: status,
+		if len(state) > 0 && !strings.EqualFold(status.State.String(), state) {
+			continue
 		}
-		storeInfo.Status.Scores = cluster.GetScores(storeInfo.Store, storeInfo.Status)
-		storesInfo.Stores = append(storesInfo.Stores, storeInfo)
+
+		storesInfo.Stores = append(storesInfo.Stores, newStoreInfo(cluster, store, status))
 	}
+	storesInfo.Count = len(storesInfo.Stores)
+	sort.Sort(newStoreSorter(cluster, storesInfo.Stores, sortKey, order == "desc"))
 
 	h.rd.JSON(w, http.StatusOK, storesInfo)
 }
+
+// storeSortKeys returns the values to sort the stores by, the stores are
+// sorted by ID if no key is specified.
+var storeSortKeys = map[string]func(cluster *server.RaftCluster, info *storeInfo) float64{
+	"": nil,
+	"region_count": func(cluster *server.RaftCluster, info *storeInfo) float64 {
+		return float64(cluster.GetStoreRegionCount(info.Store.GetId()))
+	},
+	"leader_count": func(cluster *server.RaftCluster, info *storeInfo) float64 {
+		return float64(cluster.GetStoreLeaderCount(info.Store.GetId()))
+	},
+	"used_ratio": func(cluster *server.RaftCluster, info *storeInfo) float64 {
+		return info.Status.UsedRatio
+	},
+}
+
+// storeSorter sorts the stores by the values in the order, the stores with
+// the same value are always sorted by ID ascending, so the output is stable.
+type storeSorter struct {
+	stores []*storeInfo
+	values []float64
+	byID   bool
+	desc   bool
+}
+
+func newStoreSorter(cluster *server.RaftCluster, stores []*storeInfo, key string, desc bool) *storeSorter {
+	s := &storeSorter{
+		stores: stores,
+		values: make([]float64, len(stores)),
+		byID:   storeSortKeys[key] == nil,
+		desc:   desc,
+	}
+	if s.byID {
+		return s
+	}
+	for i, store := range stores {
+		s.values[i] = storeSortKeys[key](cluster, store)
+	}
+	return s
+}
+
+func (s *storeSorter) Len() int {
+	return len(s.stores)
+}
+
+func (s *storeSorter) Swap(i, j int) {
+	s.stores[i], s.stores[j] = s.stores[j], s.stores[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
+
+func (s *storeSorter) Less(i, j int) bool {
+	if s.values[i] != s.values[j] {
+		return (s.values[i] < s.values[j]) != s.desc
+	}
+	if s.byID && s.desc {
+		return s.stores[i].Store.GetId() > s.stores[j].Store.GetId()
+	}
+	return s.stores[i].Store.GetId() < s.stores[j].Store.GetId()
+}
+
+type storesRegisterHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStoresRegisterHandler(svr *server.Server, rd *render.Render) *storesRegisterHandler {
+	return &storesRegisterHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// registeredStores is the response of registering stores, the IDs are
+// in the same order as the requested stores.
+type registeredStores struct {
+	IDs []uint64 `json:"ids"`
+}
+
+func (h *storesRegisterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.svr.GetConfig().EnableAdminAPI {
+		writeError(h.rd, w, http.StatusForbidden, errCodeAdminAPIDisabled, "admin API is disabled")
+		return
+	}
+
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	var regs []server.StoreRegistration
+	if err = fromBody(r, &regs); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	for _, reg := range regs {
+		if len(reg.Address) == 0 {
+			writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, "store address is empty")
+			return
+		}
+	}
+
+	ids, err := cluster.RegisterStores(regs)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &registeredStores{IDs: ids})
+	case server.ErrDuplicateStoreAddress:
+		writeError(h.rd, w, http.StatusConflict, errCodeDuplicateAddress, err.Error())
+	case server.ErrInvalidStoreLabel:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLabel, err.Error())
+	case server.ErrTooManyStores:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+type storeLabelHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStoreLabelHandler(svr *server.Server, rd *render.Render) *storeLabelHandler {
+	return &storeLabelHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *storeLabelHandler) Get(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	labels, err := cluster.GetStoreLabels(storeID)
+	if err != nil {
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+		return
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	h.rd.JSON(w, http.StatusOK, labels)
+}
+
+func (h *storeLabelHandler) Post(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	// The labels in the body replace all the labels of the store.
+	labels := make(map[string]string)
+	if err = fromBody(r, &labels); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	err = cluster.SetStoreLabels(storeID, labels)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, labels)
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+	case server.ErrInvalidStoreLabel:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLabel, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+type storeWeightHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStoreWeightHandler(svr *server.Server, rd *render.Render) *storeWeightHandler {
+	return &storeWeightHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *storeWeightHandler) Get(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	weight, err := cluster.GetStoreWeight(storeID)
+	if err != nil {
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, weight)
+}
+
+// storeWeight is the request body to change the store weight,
+// the weight which is not specified is kept unchanged.
+type storeWeight struct {
+	Leader *float64 `json:"leader"`
+	Region *float64 `json:"region"`
+}
+
+func (h *storeWeightHandler) Post(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	input := &storeWeight{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+
+	weight, err := cluster.GetStoreWeight(storeID)
+	if err != nil {
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+		return
+	}
+	if input.Leader != nil {
+		weight.Leader = *input.Leader
+	}
+	if input.Region != nil {
+		weight.Region = *input.Region
+	}
+
+	err = cluster.SetStoreWeight(storeID, *weight)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, weight)
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+	case server.ErrInvalidStoreWeight:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidWeight, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+type storeLimitHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStoreLimitHandler(svr *server.Server, rd *render.Render) *storeLimitHandler {
+	return &storeLimitHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *storeLimitHandler) Get(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	limit, err := cluster.GetStoreLimit(storeID)
+	if err != nil {
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, limit)
+}
+
+// storeLimit is the request body to change the store limit.
+type storeLimit struct {
+	Rate *float64 `json:"rate"`
+}
+
+func (h *storeLimitHandler) Post(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	input := &storeLimit{}
+	if err = fromBody(r, input); err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, err.Error())
+		return
+	}
+	if input.Rate == nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidBody, "rate is not specified")
+		return
+	}
+
+	limit := server.StoreLimit{Rate: *input.Rate}
+	err = cluster.SetStoreLimit(storeID, limit)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, &limit)
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+	case server.ErrInvalidStoreLimit:
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidLimit, err.Error())
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+type storeEvictLeaderHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStoreEvictLeaderHandler(svr *server.Server, rd *render.Render) *storeEvictLeaderHandler {
+	return &storeEvictLeaderHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// Post installs the evict-leader scheduler of the store.
+func (h *storeEvictLeaderHandler) Post(w http.ResponseWriter, r *http.Request) {
+	h.setEvictLeader(w, r, true)
+}
+
+// Delete removes the evict-leader scheduler of the store.
+func (h *storeEvictLeaderHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	h.setEvictLeader(w, r, false)
+}
+
+func (h *storeEvictLeaderHandler) setEvictLeader(w http.ResponseWriter, r *http.Request, evict bool) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	msg := fmt.Sprintf("evicting leaders, store: %d", storeID)
+	if evict {
+		err = cluster.EvictStoreLeader(storeID)
+	} else {
+		err = cluster.CancelEvictStoreLeader(storeID)
+		msg = fmt.Sprintf("canceled evicting leaders, store: %d", storeID)
+	}
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, msg)
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
+
+type storeHistoryHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newStoreHistoryHandler(svr *server.Server, rd *render.Render) *storeHistoryHandler {
+	return &storeHistoryHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+// ServeHTTP returns the scheduling events which touch the store, the newest
+// event is the first. The since parameter is in RFC3339 format, all the
+// events in the buffer are returned if it is not specified.
+func (h *storeHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cluster, err := h.svr.GetRaftCluster()
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if cluster == nil {
+		h.rd.JSON(w, http.StatusOK, nil)
+		return
+	}
+
+	storeIDStr := mux.Vars(r)["id"]
+	storeID, err := strconv.ParseUint(storeIDStr, 10, 64)
+	if err != nil {
+		writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidStoreID, fmt.Sprintf("invalid store id: %s", storeIDStr))
+		return
+	}
+
+	var since time.Time
+	if sinceStr := r.URL.Query().Get("since"); len(sinceStr) > 0 {
+		since, err = time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			writeError(h.rd, w, http.StatusBadRequest, errCodeInvalidTime, fmt.Sprintf("invalid since: %s", sinceStr))
+			return
+		}
+	}
+
+	evts, err := cluster.GetStoreHistory(storeID, since)
+	switch errors.Cause(err) {
+	case nil:
+		h.rd.JSON(w, http.StatusOK, evts)
+	case server.ErrStoreNotFound:
+		writeError(h.rd, w, http.StatusNotFound, errCodeStoreNotFound, fmt.Sprintf("not found, store: %d", storeID))
+	default:
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
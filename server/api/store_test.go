@@ -0,0 +1,871 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/msgpb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pingcap/kvproto/pkg/util"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testStoreSuite{})
+
+type testStoreSuite struct {
+	hc *http.Client
+}
+
+func (s *testStoreSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+// mustRPCConnect connects to the leader and switches the connection to RPC.
+func mustRPCConnect(c *C, svr *server.Server) net.Conn {
+	leader, err := svr.GetLeader()
+	c.Assert(err, IsNil)
+	c.Assert(leader, NotNil)
+
+	u, err := url.Parse(leader.GetAddr())
+	c.Assert(err, IsNil)
+	conn, err := net.Dial("unix", u.Host)
+	c.Assert(err, IsNil)
+
+	req, err := http.NewRequest("GET", "/pd/rpc", nil)
+	c.Assert(err, IsNil)
+	c.Assert(req.Write(conn), IsNil)
+
+	return conn
+}
+
+// mustRPCCall sends the request to cluster 0 if the request has no header.
+func mustRPCCall(c *C, conn net.Conn, req *pdpb.Request) *pdpb.Response {
+	if req.Header == nil {
+		req.Header = &pdpb.RequestHeader{
+			ClusterId: proto.Uint64(0),
+		}
+	}
+	msg := &msgpb.Message{
+		MsgType: msgpb.MessageType_PdReq.Enum(),
+		PdReq:   req,
+	}
+	c.Assert(util.WriteMessage(conn, 0, msg), IsNil)
+
+	msg = &msgpb.Message{}
+	_, err := util.ReadMessage(conn, msg)
+	c.Assert(err, IsNil)
+	c.Assert(msg.GetMsgType(), Equals, msgpb.MessageType_PdResp)
+
+	resp := msg.GetPdResp()
+	c.Assert(resp.GetHeader().GetError(), IsNil)
+	return resp
+}
+
+func newTestStore(storeID uint64) *metapb.Store {
+	return &metapb.Store{
+		Id:      proto.Uint64(storeID),
+		Address: proto.String(fmt.Sprintf("127.0.0.1:%d", 20160+storeID)),
+	}
+}
+
+func newTestRegion(regionID uint64, startKey []byte, endKey []byte, peers ...*metapb.Peer) *metapb.Region {
+	return &metapb.Region{
+		Id:       proto.Uint64(regionID),
+		StartKey: startKey,
+		EndKey:   endKey,
+		RegionEpoch: &metapb.RegionEpoch{
+			ConfVer: proto.Uint64(1),
+			Version: proto.Uint64(1),
+		},
+		Peers: peers,
+	}
+}
+
+func newTestPeer(peerID uint64, storeID uint64) *metapb.Peer {
+	return &metapb.Peer{
+		Id:      proto.Uint64(peerID),
+		StoreId: proto.Uint64(storeID),
+	}
+}
+
+// mustBootstrapCluster bootstraps the cluster with store 1 and region 1 covering all the keys.
+func mustBootstrapCluster(c *C, conn net.Conn) {
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_Bootstrap.Enum(),
+		Bootstrap: &pdpb.BootstrapRequest{
+			Store:  newTestStore(1),
+			Region: newTestRegion(1, []byte{}, []byte{}, newTestPeer(1, 1)),
+		},
+	}
+	resp := mustRPCCall(c, conn, req)
+	c.Assert(resp.Bootstrap, NotNil)
+}
+
+func mustPutStore(c *C, conn net.Conn, store *metapb.Store) {
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_PutStore.Enum(),
+		PutStore: &pdpb.PutStoreRequest{
+			Store: store,
+		},
+	}
+	resp := mustRPCCall(c, conn, req)
+	c.Assert(resp.PutStore, NotNil)
+}
+
+func mustHeartbeatStore(c *C, conn net.Conn, storeID uint64) {
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_StoreHeartbeat.Enum(),
+		StoreHeartbeat: &pdpb.StoreHeartbeatRequest{
+			Stats: &pdpb.StoreStats{
+				StoreId:   proto.Uint64(storeID),
+				Capacity:  proto.Uint64(100),
+				Available: proto.Uint64(50),
+			},
+		},
+	}
+	resp := mustRPCCall(c, conn, req)
+	c.Assert(resp.StoreHeartbeat, NotNil)
+}
+
+func mustRegionHeartbeat(c *C, conn net.Conn, region *metapb.Region, leader *metapb.Peer) {
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_RegionHeartbeat.Enum(),
+		RegionHeartbeat: &pdpb.RegionHeartbeatRequest{
+			Region: region,
+			Leader: leader,
+		},
+	}
+	mustRPCCall(c, conn, req)
+}
+
+func (s *testStoreSuite) mustGetStores(c *C, addr string) *storesInfo {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	got := &storesInfo{}
+	c.Assert(json.Unmarshal(buf, got), IsNil)
+	return got
+}
+
+func (s *testStoreSuite) TestStoresList(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	// Store 1 keeps sending heartbeats but store 2 never does.
+	mustBootstrapCluster(c, conn)
+	mustPutStore(c, conn, newTestStore(2))
+	mustHeartbeatStore(c, conn, 1)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	got := s.mustGetStores(c, addr)
+	c.Assert(got.Count, Equals, 2)
+	c.Assert(got.Stores, HasLen, 2)
+	for _, info := range got.Stores {
+		c.Assert(info.Store.GetAddress(), Equals, newTestStore(info.Store.GetId()).GetAddress())
+		if info.Store.GetId() == 1 {
+			c.Assert(info.Status.State, Equals, server.StoreStateUp)
+			c.Assert(info.Status.LastHeartbeatTS.IsZero(), IsFalse)
+		} else {
+			c.Assert(info.Status.State, Equals, server.StoreStateDown)
+		}
+	}
+
+	got = s.mustGetStores(c, addr+"?state=up")
+	c.Assert(got.Count, Equals, 1)
+	c.Assert(got.Stores[0].Store.GetId(), Equals, uint64(1))
+
+	got = s.mustGetStores(c, addr+"?state=down")
+	c.Assert(got.Count, Equals, 1)
+	c.Assert(got.Stores[0].Store.GetId(), Equals, uint64(2))
+}
+
+func (s *testStoreSuite) TestStoreAddressChange(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	mustPutStore(c, conn, newTestStore(2))
+	mustHeartbeatStore(c, conn, 2)
+
+	// Store 2 is rescheduled and registers again with a new address.
+	store := newTestStore(2)
+	store.Address = proto.String("127.0.0.2:20162")
+	mustPutStore(c, conn, store)
+	mustHeartbeatStore(c, conn, 2)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	got := s.mustGetStores(c, addr)
+	c.Assert(got.Count, Equals, 2)
+	for _, info := range got.Stores {
+		if info.Store.GetId() == 2 {
+			c.Assert(info.Store.GetAddress(), Equals, "127.0.0.2:20162")
+			c.Assert(info.Status.State, Equals, server.StoreStateUp)
+		}
+	}
+}
+
+type testStoreDetail struct {
+	Store       *metapb.Store       `json:"store"`
+	Status      *server.StoreStatus `json:"status"`
+	RegionCount int                 `json:"region_count"`
+	LeaderCount int                 `json:"leader_count"`
+
+	RemainingRegionCount *int `json:"remaining_region_count"`
+}
+
+func (s *testStoreSuite) TestStoresSort(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	// Store 1,2,3 are up and store 4 is down.
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3, 4} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		if id != 4 {
+			mustHeartbeatStore(c, conn, id)
+		}
+	}
+
+	// Store 1 has 3 regions and all the leaders, store 2 has 2 regions and
+	// store 3 has 1 region.
+	regions := mustSplitRegions(c, conn, 3)
+	for i, region := range regions[1:] {
+		leader := region.GetPeers()[0]
+		for j := 0; j <= i; j++ {
+			storeID := uint64(j + 2)
+			region.Peers = append(region.Peers, newTestPeer(region.GetId()*10+storeID, storeID))
+		}
+		region.RegionEpoch.ConfVer = proto.Uint64(uint64(i + 2))
+		mustRegionHeartbeat(c, conn, region, leader)
+	}
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+	testCases := []struct {
+		query string
+		ids   []uint64
+	}{
+		{"", []uint64{1, 2, 3, 4}},
+		{"?order=asc", []uint64{1, 2, 3, 4}},
+		{"?order=desc", []uint64{4, 3, 2, 1}},
+		{"?sort=region_count", []uint64{4, 3, 2, 1}},
+		{"?sort=region_count&order=desc", []uint64{1, 2, 3, 4}},
+		{"?sort=region_count&state=up", []uint64{3, 2, 1}},
+		{"?sort=leader_count", []uint64{2, 3, 4, 1}},
+		{"?sort=leader_count&order=desc", []uint64{1, 2, 3, 4}},
+	}
+	for _, t := range testCases {
+		got := s.mustGetStores(c, addr+t.query)
+		ids := make([]uint64, 0, len(got.Stores))
+		for _, info := range got.Stores {
+			ids = append(ids, info.Store.GetId())
+		}
+		c.Assert(ids, DeepEquals, t.ids, Commentf("query %s", t.query))
+	}
+
+	// The used ratio of the down store is 0.
+	got := s.mustGetStores(c, addr+"?sort=used_ratio")
+	c.Assert(got.Stores[0].Store.GetId(), Equals, uint64(4))
+
+	for query, code := range map[string]string{
+		"?sort=size":  errCodeInvalidSort,
+		"?order=up":   errCodeInvalidOrder,
+		"?sort=state": errCodeInvalidSort,
+	} {
+		resp, err := s.hc.Get(addr + query)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+		checkErrorResponse(c, buf, code)
+	}
+}
+
+func (s *testStoreSuite) TestStoreGet(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	mustPutStore(c, conn, newTestStore(2))
+	mustRegionHeartbeat(c, conn, newTestRegion(1, []byte{}, []byte{}, newTestPeer(1, 1)), newTestPeer(1, 1))
+	mustHeartbeatStore(c, conn, 1)
+
+	addr := func(id string) string {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores/", id}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+
+	table := []struct {
+		id          string
+		status      int
+		code        string
+		regionCount int
+		leaderCount int
+	}{
+		{id: "1", status: http.StatusOK, regionCount: 1, leaderCount: 1},
+		{id: "2", status: http.StatusOK, regionCount: 0, leaderCount: 0},
+		{id: "3", status: http.StatusNotFound, code: errCodeStoreNotFound},
+		{id: "abc", status: http.StatusBadRequest, code: errCodeInvalidStoreID},
+	}
+
+	for _, t := range table {
+		resp, err := s.hc.Get(addr(t.id))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, t.status)
+		if t.status != http.StatusOK {
+			checkErrorResponse(c, buf, t.code)
+			continue
+		}
+
+		got := &testStoreDetail{}
+		c.Assert(json.Unmarshal(buf, got), IsNil)
+		c.Assert(fmt.Sprint(got.Store.GetId()), Equals, t.id)
+		c.Assert(got.Store.GetAddress(), Equals, newTestStore(got.Store.GetId()).GetAddress())
+		c.Assert(got.RegionCount, Equals, t.regionCount)
+		c.Assert(got.LeaderCount, Equals, t.leaderCount)
+	}
+}
+
+func (s *testStoreSuite) mustGetStore(c *C, addr string) *testStoreDetail {
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	got := &testStoreDetail{}
+	c.Assert(json.Unmarshal(buf, got), IsNil)
+	return got
+}
+
+func (s *testStoreSuite) TestStoreDelete(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	// Region 1 only has a replica in store 1.
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+
+	addr := func(id string) string {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores/", id}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+
+	table := []struct {
+		id     string
+		status int
+		code   string
+	}{
+		{id: "2", status: http.StatusOK},
+		// Offline an offline store again is OK.
+		{id: "2", status: http.StatusOK},
+		{id: "1", status: http.StatusPreconditionFailed, code: errCodeStoreLastReplica},
+		{id: "4", status: http.StatusNotFound, code: errCodeStoreNotFound},
+		{id: "abc", status: http.StatusBadRequest, code: errCodeInvalidStoreID},
+	}
+
+	for _, t := range table {
+		req, err := http.NewRequest("DELETE", addr(t.id), nil)
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Do(req)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, t.status)
+		if t.status != http.StatusOK {
+			checkErrorResponse(c, buf, t.code)
+		}
+	}
+
+	c.Assert(s.mustGetStore(c, addr("1")).Status.State, Equals, server.StoreStateUp)
+	c.Assert(s.mustGetStore(c, addr("2")).Status.State, Equals, server.StoreStateOffline)
+	c.Assert(s.mustGetStore(c, addr("3")).Status.State, Equals, server.StoreStateUp)
+
+	// The offline store keeps its state after it is put again.
+	mustPutStore(c, conn, newTestStore(2))
+	c.Assert(s.mustGetStore(c, addr("2")).Status.State, Equals, server.StoreStateOffline)
+}
+
+func (s *testStoreSuite) TestStoreTombstone(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+	for _, id := range []uint64{1, 2, 3} {
+		if id != 1 {
+			mustPutStore(c, conn, newTestStore(id))
+		}
+		mustHeartbeatStore(c, conn, id)
+	}
+	// Region 1 has replicas in store 1 and store 3.
+	leader := newTestPeer(1, 1)
+	region := newTestRegion(1, []byte{}, []byte{}, leader, newTestPeer(3, 3))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(2),
+		Version: proto.Uint64(1),
+	}
+	mustRegionHeartbeat(c, conn, region, leader)
+
+	addr := func(id string) string {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores/", id}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+	mustDelete := func(path string, status int) []byte {
+		req, err := http.NewRequest("DELETE", addr(path), nil)
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Do(req)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	// Only the tombstone store can be purged.
+	checkErrorResponse(c, mustDelete("2?force=true", http.StatusPreconditionFailed), errCodeStoreNotTombstone)
+	checkErrorResponse(c, mustDelete("4?force=true", http.StatusNotFound), errCodeStoreNotFound)
+	checkErrorResponse(c, mustDelete("2?force=bad", http.StatusBadRequest), errCodeInvalidForce)
+
+	// Store 2 has no region, it becomes tombstone after it is offline, but
+	// store 3 still has a peer of region 1.
+	mustDelete("2", http.StatusOK)
+	mustDelete("3", http.StatusOK)
+	mustHeartbeatStore(c, conn, 1)
+	c.Assert(s.mustGetStore(c, addr("2")).Status.State, Equals, server.StoreStateTombstone)
+	c.Assert(s.mustGetStore(c, addr("3")).Status.State, Equals, server.StoreStateOffline)
+	checkErrorResponse(c, mustDelete("3?force=true", http.StatusPreconditionFailed), errCodeStoreNotTombstone)
+
+	// The drain progress is only reported for the offline store.
+	c.Assert(s.mustGetStore(c, addr("1")).RemainingRegionCount, IsNil)
+	c.Assert(s.mustGetStore(c, addr("2")).RemainingRegionCount, IsNil)
+	c.Assert(*s.mustGetStore(c, addr("3")).RemainingRegionCount, Equals, 1)
+	offline := s.mustGetStores(c, strings.TrimSuffix(addr(""), "/")+"?state=offline")
+	c.Assert(offline.Stores, HasLen, 1)
+	c.Assert(*offline.Stores[0].RemainingRegionCount, Equals, 1)
+
+	// The tombstone store is listed until it is purged.
+	mustGetStoreIDs := func() map[uint64]bool {
+		resp, err := s.hc.Get(strings.TrimSuffix(addr(""), "/"))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		stores := &storesInfo{}
+		c.Assert(json.Unmarshal(buf, stores), IsNil)
+		ids := make(map[uint64]bool, len(stores.Stores))
+		for _, store := range stores.Stores {
+			ids[store.Store.GetId()] = true
+		}
+		return ids
+	}
+	c.Assert(mustGetStoreIDs(), DeepEquals, map[uint64]bool{1: true, 2: true, 3: true})
+	mustDelete("2?force=true", http.StatusOK)
+	c.Assert(mustGetStoreIDs(), DeepEquals, map[uint64]bool{1: true, 3: true})
+	checkErrorResponse(c, mustDelete("2?force=true", http.StatusNotFound), errCodeStoreNotFound)
+}
+
+func (s *testStoreSuite) TestStoreLabel(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	addr := func(id string) string {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores/", id, "/label"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+
+	mustGetLabels := func(id string) map[string]string {
+		resp, err := s.hc.Get(addr(id))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		labels := make(map[string]string)
+		c.Assert(json.Unmarshal(buf, &labels), IsNil)
+		return labels
+	}
+
+	c.Assert(mustGetLabels("1"), HasLen, 0)
+
+	table := []struct {
+		id     string
+		body   string
+		status int
+		code   string
+	}{
+		{id: "1", body: `{"zone": "z1", "host": "h1"}`, status: http.StatusOK},
+		{id: "1", body: `{"": "z1"}`, status: http.StatusBadRequest, code: errCodeInvalidLabel},
+		{id: "1", body: `{"zone": ""}`, status: http.StatusBadRequest, code: errCodeInvalidLabel},
+		{id: "1", body: `{"zone": "区域"}`, status: http.StatusBadRequest, code: errCodeInvalidLabel},
+		{id: "1", body: `["zone"]`, status: http.StatusBadRequest, code: errCodeInvalidBody},
+		{id: "2", body: `{"zone": "z1"}`, status: http.StatusNotFound, code: errCodeStoreNotFound},
+		{id: "abc", body: `{"zone": "z1"}`, status: http.StatusBadRequest, code: errCodeInvalidStoreID},
+	}
+
+	for _, t := range table {
+		resp, err := s.hc.Post(addr(t.id), "application/json", strings.NewReader(t.body))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, t.status)
+		if t.status != http.StatusOK {
+			checkErrorResponse(c, buf, t.code)
+		}
+	}
+
+	// The invalid labels are not saved.
+	c.Assert(mustGetLabels("1"), DeepEquals, map[string]string{"zone": "z1", "host": "h1"})
+
+	// The labels are kept after the store is put again.
+	mustPutStore(c, conn, newTestStore(1))
+	c.Assert(mustGetLabels("1"), DeepEquals, map[string]string{"zone": "z1", "host": "h1"})
+}
+
+func (s *testStoreSuite) TestStoreWeight(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	addr := func(id string) string {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores/", id, "/weight"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+
+	mustGetWeight := func(id string) *server.StoreWeight {
+		resp, err := s.hc.Get(addr(id))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		weight := &server.StoreWeight{}
+		c.Assert(json.Unmarshal(buf, weight), IsNil)
+		return weight
+	}
+
+	// The default weight is 1.
+	c.Assert(mustGetWeight("1"), DeepEquals, &server.StoreWeight{Leader: 1, Region: 1})
+
+	table := []struct {
+		id     string
+		body   string
+		status int
+		code   string
+	}{
+		{id: "1", body: `{"leader": 1.0, "region": 2.0}`, status: http.StatusOK},
+		{id: "1", body: `{"leader": 3}`, status: http.StatusOK},
+		{id: "1", body: `{"region": 0}`, status: http.StatusBadRequest, code: errCodeInvalidWeight},
+		{id: "1", body: `{"leader": -1}`, status: http.StatusBadRequest, code: errCodeInvalidWeight},
+		{id: "1", body: `{"leader": "abc"}`, status: http.StatusBadRequest, code: errCodeInvalidBody},
+		{id: "2", body: `{"leader": 1}`, status: http.StatusNotFound, code: errCodeStoreNotFound},
+		{id: "abc", body: `{"leader": 1}`, status: http.StatusBadRequest, code: errCodeInvalidStoreID},
+	}
+
+	for _, t := range table {
+		resp, err := s.hc.Post(addr(t.id), "application/json", strings.NewReader(t.body))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, t.status)
+		if t.status != http.StatusOK {
+			checkErrorResponse(c, buf, t.code)
+		}
+	}
+
+	// The invalid weights are not saved, and the weights are kept after
+	// the store is put again.
+	c.Assert(mustGetWeight("1"), DeepEquals, &server.StoreWeight{Leader: 3, Region: 2})
+	mustPutStore(c, conn, newTestStore(1))
+	c.Assert(mustGetWeight("1"), DeepEquals, &server.StoreWeight{Leader: 3, Region: 2})
+}
+
+func (s *testStoreSuite) TestStoreLimit(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	addr := func(id string) string {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores/", id, "/limit"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		return addr
+	}
+
+	mustGetLimit := func(id string) *server.StoreLimit {
+		resp, err := s.hc.Get(addr(id))
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		limit := &server.StoreLimit{}
+		c.Assert(json.Unmarshal(buf, limit), IsNil)
+		return limit
+	}
+
+	// The default limit is the store balance rate of the cluster.
+	c.Assert(mustGetLimit("1"), DeepEquals, &server.StoreLimit{Rate: cfgs[0].BalanceCfg.StoreBalanceRate})
+
+	table := []struct {
+		id     string
+		body   string
+		status int
+		code   string
+	}{
+		{id: "1", body: `{"rate": 5}`, status: http.StatusOK},
+		{id: "1", body: `{"rate": 0}`, status: http.StatusOK},
+		{id: "1", body: `{"rate": -1}`, status: http.StatusBadRequest, code: errCodeInvalidLimit},
+		{id: "1", body: `{}`, status: http.StatusBadRequest, code: errCodeInvalidBody},
+		{id: "2", body: `{"rate": 1}`, status: http.StatusNotFound, code: errCodeStoreNotFound},
+		{id: "abc", body: `{"rate": 1}`, status: http.StatusBadRequest, code: errCodeInvalidStoreID},
+	}
+
+	for _, t := range table {
+		resp, err := s.hc.Post(addr(t.id), "application/json", strings.NewReader(t.body))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, t.status)
+		if t.status != http.StatusOK {
+			checkErrorResponse(c, buf, t.code)
+		}
+	}
+
+	// Zero is kept, it freezes the scheduling on the store.
+	c.Assert(mustGetLimit("1"), DeepEquals, &server.StoreLimit{Rate: 0})
+}
+
+func (s *testStoreSuite) TestStoresRegister(c *C) {
+	cfgs := server.NewTestMultiConfig(1)
+	cfgs[0].EnableAdminAPI = true
+	_, svrs, clean := mustNewClusterWithConfigs(c, cfgs)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	mustRegister := func(body string, status int) []byte {
+		resp, err := s.hc.Post(addr, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	buf := mustRegister(`[
+		{"address": "127.0.0.1:30001", "labels": {"zone": "z1"}},
+		{"address": "127.0.0.1:30002", "labels": {"zone": "z2"}},
+		{"address": "127.0.0.1:30003"}
+	]`, http.StatusOK)
+	registered := &registeredStores{}
+	c.Assert(json.Unmarshal(buf, registered), IsNil)
+	c.Assert(registered.IDs, HasLen, 3)
+
+	got := s.mustGetStores(c, addr)
+	c.Assert(got.Count, Equals, 4)
+	ids := make(map[uint64]string)
+	for _, info := range got.Stores {
+		ids[info.Store.GetId()] = info.Store.GetAddress()
+	}
+	c.Assert(ids, HasLen, 4)
+	for i, id := range registered.IDs {
+		c.Assert(ids[id], Equals, fmt.Sprintf("127.0.0.1:%d", 30001+i))
+	}
+	cluster, err := svrs[0].GetRaftCluster()
+	c.Assert(err, IsNil)
+	labels, err := cluster.GetStoreLabels(registered.IDs[1])
+	c.Assert(err, IsNil)
+	c.Assert(labels, DeepEquals, map[string]string{"zone": "z2"})
+
+	// Nothing is registered if any of the stores is invalid.
+	checkErrorResponse(c, mustRegister(`[{"address": "127.0.0.1:30004"}, {"address": "127.0.0.1:30001"}]`,
+		http.StatusConflict), errCodeDuplicateAddress)
+	checkErrorResponse(c, mustRegister(`[{"address": "127.0.0.1:30004"}, {"address": "127.0.0.1:30004"}]`,
+		http.StatusConflict), errCodeDuplicateAddress)
+	checkErrorResponse(c, mustRegister(`[{"address": "127.0.0.1:30004", "labels": {"zone": ""}}]`,
+		http.StatusBadRequest), errCodeInvalidLabel)
+	checkErrorResponse(c, mustRegister(`[{"labels": {"zone": "z1"}}]`, http.StatusBadRequest), errCodeInvalidBody)
+	checkErrorResponse(c, mustRegister(`{"address": "127.0.0.1:30004"}`, http.StatusBadRequest), errCodeInvalidBody)
+	// The stores are put in one transaction, which can't put more than 64.
+	regs := make([]string, 0, 65)
+	for i := 0; i < 65; i++ {
+		regs = append(regs, fmt.Sprintf(`{"address": "127.0.0.1:%d"}`, 31000+i))
+	}
+	body := "[" + strings.Join(regs, ",") + "]"
+	checkErrorResponse(c, mustRegister(body, http.StatusBadRequest), errCodeInvalidBody)
+	c.Assert(s.mustGetStores(c, addr).Count, Equals, 4)
+	buf = mustRegister("["+strings.Join(regs[:64], ",")+"]", http.StatusOK)
+	registered = &registeredStores{}
+	c.Assert(json.Unmarshal(buf, registered), IsNil)
+	c.Assert(registered.IDs, HasLen, 64)
+	c.Assert(s.mustGetStores(c, addr).Count, Equals, 68)
+}
+
+func (s *testStoreSuite) TestStoresRegisterDisabled(c *C) {
+	cfgs, _, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores"}
+	addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+	c.Assert(err, IsNil)
+
+	resp, err := s.hc.Post(addr, "application/json", strings.NewReader(`[{"address": "127.0.0.1:30001"}]`))
+	c.Assert(err, IsNil)
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusForbidden)
+	checkErrorResponse(c, buf, errCodeAdminAPIDisabled)
+}
+
+func (s *testStoreSuite) TestStoreEvictLeader(c *C) {
+	cfgs, svrs, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	conn := mustRPCConnect(c, svrs[0])
+	defer conn.Close()
+
+	mustBootstrapCluster(c, conn)
+
+	mustRequest := func(method string, id string, status int) []byte {
+		parts := []string{cfgs[0].ClientUrls, apiPrefix, "/api/v1/stores/", id, "/evict-leader"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		req, err := http.NewRequest(method, addr, nil)
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Do(req)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, status)
+		return buf
+	}
+
+	hasScheduler := func(name string) bool {
+		addr, err := unixAddrToHTTPAddr(cfgs[0].ClientUrls + apiPrefix + "/api/v1/schedulers")
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		var schedulers []*server.SchedulerInfo
+		c.Assert(json.Unmarshal(buf, &schedulers), IsNil)
+		for _, scheduler := range schedulers {
+			if scheduler.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	mustRequest("POST", "1", http.StatusOK)
+	c.Assert(hasScheduler("evict-leader-1"), IsTrue)
+	// It is OK to evict again.
+	mustRequest("POST", "1", http.StatusOK)
+	checkErrorResponse(c, mustRequest("POST", "2", http.StatusNotFound), errCodeStoreNotFound)
+	checkErrorResponse(c, mustRequest("POST", "abc", http.StatusBadRequest), errCodeInvalidStoreID)
+
+	mustRequest("DELETE", "1", http.StatusOK)
+	c.Assert(hasScheduler("evict-leader-1"), IsFalse)
+	checkErrorResponse(c, mustRequest("DELETE", "2", http.StatusNotFound), errCodeStoreNotFound)
+}
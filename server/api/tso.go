@@ -0,0 +1,65 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
+)
+
+// tsoStatus is the status of the timestamp oracle, the times are in
+// milliseconds and in RFC3339 format.
+type tsoStatus struct {
+	Physical      int64  `json:"physical"`
+	PhysicalTime  string `json:"physical_time"`
+	Logical       int64  `json:"logical"`
+	LastSaved     int64  `json:"last_saved"`
+	LastSavedTime string `json:"last_saved_time"`
+}
+
+type tsoStatusHandler struct {
+	svr *server.Server
+	rd  *render.Render
+}
+
+func newTSOStatusHandler(svr *server.Server, rd *render.Render) *tsoStatusHandler {
+	return &tsoStatusHandler{
+		svr: svr,
+		rd:  rd,
+	}
+}
+
+func (h *tsoStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, err := h.svr.GetTSOStatus()
+	if errors.Cause(err) == server.ErrNotLeader {
+		writeNotLeader(h.svr, h.rd, w)
+		return
+	}
+	if err != nil {
+		writeError(h.rd, w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	h.rd.JSON(w, http.StatusOK, &tsoStatus{
+		Physical:      status.Physical.UnixNano() / int64(time.Millisecond),
+		PhysicalTime:  status.Physical.Format(time.RFC3339),
+		Logical:       status.Logical,
+		LastSaved:     status.LastSaved.UnixNano() / int64(time.Millisecond),
+		LastSavedTime: status.LastSaved.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testTSOSuite{})
+
+type testTSOSuite struct {
+	hc *http.Client
+}
+
+func (s *testTSOSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testTSOSuite) TestTSOStatus(c *C) {
+	_, svrs, clean := mustNewCluster(c, 3)
+	defer clean()
+
+	leader := mustWaitLeader(c, svrs)
+	for _, svr := range svrs {
+		parts := []string{svr.GetAddr(), apiPrefix, "/api/v1/tso/status"}
+		addr, err := unixAddrToHTTPAddr(strings.Join(parts, ""))
+		c.Assert(err, IsNil)
+		resp, err := s.hc.Get(addr)
+		c.Assert(err, IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+
+		// Only the leader allocates timestamps.
+		if svr != leader {
+			c.Assert(resp.StatusCode, Equals, http.StatusForbidden)
+			got := checkErrorResponse(c, buf, errCodeNotLeader)
+			c.Assert(strings.Contains(got.Message, leader.GetAddr()), IsTrue)
+			continue
+		}
+
+		c.Assert(resp.StatusCode, Equals, http.StatusOK)
+		var got tsoStatus
+		c.Assert(json.Unmarshal(buf, &got), IsNil)
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		c.Assert(got.Physical, LessEqual, now)
+		c.Assert(got.Physical, Greater, now-int64(time.Second/time.Millisecond))
+		c.Assert(got.LastSaved, LessEqual, got.Physical)
+		physical, err := time.Parse(time.RFC3339, got.PhysicalTime)
+		c.Assert(err, IsNil)
+		c.Assert(physical.Unix(), Equals, got.Physical/1000)
+	}
+}
@@ -15,12 +15,129 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/pd/server"
+	"github.com/unrolled/render"
 )
 
+// The codes in the error response body, clients can use them to
+// know why the request fails.
+const (
+	errCodeInternal              = "internal_error"
+	errCodeInvalidBody           = "invalid_body"
+	errCodeInvalidConfig         = "invalid_config"
+	errCodeInvalidStoreID        = "invalid_store_id"
+	errCodeInvalidRegionID       = "invalid_region_id"
+	errCodeInvalidKey            = "invalid_key"
+	errCodeInvalidLimit          = "invalid_limit"
+	errCodeInvalidOffset         = "invalid_offset"
+	errCodeInvalidDuration       = "invalid_duration"
+	errCodeInvalidTime           = "invalid_time"
+	errCodeInvalidLogLevel       = "invalid_log_level"
+	errCodeInvalidGroupBy        = "invalid_group_by"
+	errCodeInvalidSort           = "invalid_sort"
+	errCodeInvalidOrder          = "invalid_order"
+	errCodeInvalidLabel          = "invalid_label"
+	errCodeInvalidWeight         = "invalid_weight"
+	errCodeInvalidURL            = "invalid_url"
+	errCodeInvalidForce          = "invalid_force"
+	errCodeInvalidDryRun         = "invalid_dry_run"
+	errCodeInvalidSchedulerArgs  = "invalid_scheduler_args"
+	errCodeInvalidConsistency    = "invalid_consistency"
+	errCodeStoreNotFound         = "store_not_found"
+	errCodeDuplicateAddress      = "duplicate_address"
+	errCodeStoreLastReplica      = "store_is_last_replica"
+	errCodeStoreNotTombstone     = "store_not_tombstone"
+	errCodeRegionNotFound        = "region_not_found"
+	errCodeMemberNotFound        = "member_not_found"
+	errCodeMemberIsLeader        = "member_is_leader"
+	errCodeMemberUnhealthy       = "member_unhealthy"
+	errCodeNoHealthyMember       = "no_healthy_member"
+	errCodeNotLeader             = "not_leader"
+	errCodeNoLeader              = "no_leader"
+	errCodeEtcdUnavailable       = "etcd_unavailable"
+	errCodeReadNotConfirmed      = "read_not_confirmed"
+	errCodeAdminAPIDisabled      = "admin_api_disabled"
+	errCodeUnauthorized          = "unauthorized"
+	errCodeTooManyRequests       = "too_many_requests"
+	errCodeSchedulerNotFound     = "scheduler_not_found"
+	errCodeRegionHasOperator     = "region_has_operator"
+	errCodeNotEnoughStores       = "not_enough_stores"
+	errCodeInvalidOperator       = "invalid_operator"
+	errCodeInvalidRule           = "invalid_rule"
+	errCodeOverlappingRules      = "overlapping_rules"
+	errCodeOperatorNotFound      = "operator_not_found"
+	errCodeInvalidNamespace      = "invalid_namespace"
+	errCodeOverlappingNamespaces = "overlapping_namespaces"
+	errCodeInvalidAffinity       = "invalid_affinity"
+	errCodeOverlappingAffinities = "overlapping_affinities"
+	errCodePeerNotFound          = "peer_not_found"
+	errCodePeerExists            = "peer_exists"
+	errCodeQuorumLost            = "quorum_lost"
+	errCodeSafePointRollback     = "safe_point_rollback"
+	errCodeInvalidServiceID      = "invalid_service_id"
+	errCodeInvalidTTL            = "invalid_ttl"
+	errCodeRangeTooLarge         = "range_too_large"
+)
+
+// errorResponse is the response body of the failed requests, Fields are
+// the errors of the invalid fields of the request body if any.
+type errorResponse struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// logError logs the error response with the request ID, which is set in
+// the response header by requestLogger.
+func logError(w http.ResponseWriter, status int, code string, msg string) {
+	id := w.Header().Get(requestIDHeader)
+	if status >= http.StatusInternalServerError {
+		log.Errorf("[%s] request failed, status: %d, code: %s, message: %s", id, status, code, msg)
+		return
+	}
+	log.Infof("[%s] request rejected, status: %d, code: %s, message: %s", id, status, code, msg)
+}
+
+func writeError(rd *render.Render, w http.ResponseWriter, status int, code string, msg string) {
+	logError(w, status, code, msg)
+	rd.JSON(w, status, &errorResponse{
+		Code:    code,
+		Message: msg,
+	})
+}
+
+// writeFieldErrors writes the errors of the invalid fields, the message
+// lists the fields in order.
+func writeFieldErrors(rd *render.Render, w http.ResponseWriter, code string, fields map[string]string) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("invalid %s: %s", name, fields[name]))
+	}
+
+	msg := strings.Join(msgs, "; ")
+	logError(w, http.StatusBadRequest, code, msg)
+	rd.JSON(w, http.StatusBadRequest, &errorResponse{
+		Code:    code,
+		Message: msg,
+		Fields:  fields,
+	})
+}
+
 func fromBody(r *http.Request, data interface{}) error {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -35,3 +152,49 @@ func fromBody(r *http.Request, data interface{}) error {
 
 	return nil
 }
+
+// parseQueryInt parses the integer query parameter, returns the default
+// value if the parameter is not specified.
+func parseQueryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	return n, nil
+}
+
+// parseQueryBool parses the boolean query parameter, returns the default
+// value if the parameter is not specified.
+func parseQueryBool(r *http.Request, name string, defaultValue bool) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	return b, nil
+}
+
+// scanRange returns the regions in the key range, it writes 413 and returns
+// false if the range touches more regions than max-scan-regions, so the
+// client should narrow the range.
+func scanRange(rd *render.Render, w http.ResponseWriter, svr *server.Server, cluster *server.RaftCluster, startKey []byte, endKey []byte) ([]*metapb.Region, bool) {
+	limit := svr.GetConfig().MaxScanRegions
+	regions, more := cluster.ScanRangeRegions(startKey, endKey, limit)
+	if more {
+		writeError(rd, w, http.StatusRequestEntityTooLarge, errCodeRangeTooLarge,
+			fmt.Sprintf("the range touches more than %d regions, please narrow the range", limit))
+		return nil, false
+	}
+	return regions, true
+}
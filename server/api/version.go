@@ -3,13 +3,10 @@ package api
 import (
 	"net/http"
 
+	"github.com/pingcap/pd/server"
 	"github.com/unrolled/render"
 )
 
-type version struct {
-	Version string `json:"version"`
-}
-
 type versionHandler struct {
 	rd *render.Render
 }
@@ -21,8 +18,5 @@ func newVersionHandler(rd *render.Render) *versionHandler {
 }
 
 func (h *versionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	version := &version{
-		Version: "1.0.0",
-	}
-	h.rd.JSON(w, http.StatusOK, version)
+	h.rd.JSON(w, http.StatusOK, server.GetVersionInfo())
 }
@@ -0,0 +1,54 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/pd/server"
+)
+
+var _ = Suite(&testVersionSuite{})
+
+type testVersionSuite struct {
+	hc *http.Client
+}
+
+func (s *testVersionSuite) SetUpSuite(c *C) {
+	s.hc = newUnixSocketClient()
+}
+
+func (s *testVersionSuite) TestGetVersion(c *C) {
+	cfgs, _, clean := mustNewCluster(c, 1)
+	defer clean()
+
+	addr, err := unixAddrToHTTPAddr(cfgs[0].ClientUrls + apiPrefix + "/api/v1/version")
+	c.Assert(err, IsNil)
+	resp, err := s.hc.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+
+	info := &server.VersionInfo{}
+	c.Assert(json.Unmarshal(buf, info), IsNil)
+	c.Assert(info.Version, Not(Equals), "")
+	c.Assert(info, DeepEquals, server.GetVersionInfo())
+	c.Assert(info.Version, Equals, server.PDReleaseVersion)
+	c.Assert(info.EtcdVersion, Not(Equals), "")
+}
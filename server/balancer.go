@@ -14,6 +14,9 @@
 package server
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/golang/protobuf/proto"
 	"github.com/juju/errors"
 	"github.com/ngaut/log"
@@ -25,6 +28,7 @@ var (
 	_ Balancer = &capacityBalancer{}
 	_ Balancer = &replicaBalancer{}
 	_ Balancer = &leaderBalancer{}
+	_ Balancer = &evictLeaderBalancer{}
 )
 
 // Balancer is an interface to select store regions for auto-balance.
@@ -33,6 +37,8 @@ type Balancer interface {
 	Balance(cluster *clusterInfo) (*score, *balanceOperator, error)
 	// ScoreType returns score type.
 	ScoreType() scoreType
+	// GetName returns the balancer name.
+	GetName() string
 }
 
 func selectFromStore(stores []*storeInfo, excluded map[uint64]struct{}, filters []Filter, st scoreType) *storeInfo {
@@ -128,6 +134,10 @@ func (cb *capacityBalancer) ScoreType() scoreType {
 	return cb.st
 }
 
+func (cb *capacityBalancer) GetName() string {
+	return "region"
+}
+
 func (cb *capacityBalancer) selectBalanceRegion(cluster *clusterInfo, stores []*storeInfo) (*metapb.Region, *metapb.Peer, *metapb.Peer) {
 	store := selectFromStore(stores, nil, cb.filters, cb.st)
 	if store == nil {
@@ -151,6 +161,35 @@ func (cb *capacityBalancer) selectBalanceRegion(cluster *clusterInfo, stores []*
 	return cluster.regions.randRegion(storeID)
 }
 
+// excludeSameLocationStores adds the stores which are in the same location with
+// the region peers to excluded, the peers to be removed are not considered.
+func excludeSameLocationStores(cluster *clusterInfo, stores []*storeInfo, region *metapb.Region,
+	excluded map[uint64]struct{}, labels []string, removedPeers ...*metapb.Peer) {
+	if len(labels) == 0 {
+		return
+	}
+
+	removed := make(map[uint64]struct{}, len(removedPeers))
+	for _, peer := range removedPeers {
+		removed[peer.GetId()] = struct{}{}
+	}
+
+	for _, peer := range region.GetPeers() {
+		if _, ok := removed[peer.GetId()]; ok {
+			continue
+		}
+		peerStore := cluster.getStore(peer.GetStoreId())
+		if peerStore == nil {
+			continue
+		}
+		for _, store := range stores {
+			if store.isSameLocation(peerStore, labels) {
+				excluded[store.store.GetId()] = struct{}{}
+			}
+		}
+	}
+}
+
 func (cb *capacityBalancer) selectAddPeer(cluster *clusterInfo, stores []*storeInfo, excluded map[uint64]struct{}) (*metapb.Peer, error) {
 	store := selectToStore(stores, excluded, cb.filters, cb.st)
 	if store == nil {
@@ -204,8 +243,12 @@ func (cb *capacityBalancer) Balance(cluster *clusterInfo) (*score, *balanceOpera
 		return nil, nil, nil
 	}
 
-	// Select one store to add new peer.
+	// Select one store to add new peer, which is not in the same location
+	// with the other peers and satisfies the placement rule.
 	excluded := getExcludedStores(region)
+	excludeSameLocationStores(cluster, stores, region, excluded, cb.cfg.LocationLabels, peer)
+	excludeRuleStores(cluster, stores, region, excluded)
+	excludeNamespaceStores(cluster, stores, region, excluded)
 	newPeer, err := cb.selectAddPeer(cluster, stores, excluded)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
@@ -221,9 +264,11 @@ func (cb *capacityBalancer) Balance(cluster *clusterInfo) (*score, *balanceOpera
 		return nil, nil, nil
 	}
 
+	// The peer is removed only after the new peer catches up.
 	addPeerOperator := newAddPeerOperator(region.GetId(), newPeer)
+	catchUpOperator := newCatchUpOperator(region.GetId(), newPeer, cb.cfg)
 	removePeerOperator := newRemovePeerOperator(region.GetId(), peer)
-	return score, newBalanceOperator(region, addPeerOperator, removePeerOperator), nil
+	return score, newBalanceOperator(region, addPeerOperator, catchUpOperator, removePeerOperator), nil
 }
 
 type leaderBalancer struct {
@@ -244,8 +289,14 @@ func (lb *leaderBalancer) ScoreType() scoreType {
 	return lb.st
 }
 
-// selectBalanceRegion tries to select a store leader region to do balance.
-func (lb *leaderBalancer) selectBalanceRegion(cluster *clusterInfo, stores []*storeInfo) (*metapb.Region, *metapb.Peer, *metapb.Peer) {
+func (lb *leaderBalancer) GetName() string {
+	return "leader"
+}
+
+// selectBalanceRegion tries to select a store leader region to do balance,
+// the new leader is not moved to another location if it unbalances the
+// leaders of the locations.
+func (lb *leaderBalancer) selectBalanceRegion(cluster *clusterInfo, stores []*storeInfo, locations *leaderLocations) (*metapb.Region, *metapb.Peer, *metapb.Peer) {
 	store := selectFromStore(stores, nil, lb.filters, lb.st)
 	if store == nil {
 		log.Debug("from store cannot be found to select balance region")
@@ -265,6 +316,14 @@ func (lb *leaderBalancer) selectBalanceRegion(cluster *clusterInfo, stores []*st
 	}
 
 	followers := getFollowerPeers(region, leader)
+	removeAffinityFollowers(cluster, region, store, followers)
+	if locations != nil {
+		for id := range followers {
+			if !locations.allowTransfer(store, cluster.getStore(id)) {
+				delete(followers, id)
+			}
+		}
+	}
 	newLeader := lb.selectNewLeaderPeer(cluster, followers)
 	if newLeader == nil {
 		log.Warn("new leader peer cannot be found to do leader transfer")
@@ -274,13 +333,59 @@ func (lb *leaderBalancer) selectBalanceRegion(cluster *clusterInfo, stores []*st
 	return region, leader, newLeader
 }
 
+// selectLocationRegion tries to select a leader region to move from the
+// location with the most leaders to the location with the least leaders,
+// it returns nil if the leaders of the locations are balanced.
+func (lb *leaderBalancer) selectLocationRegion(cluster *clusterInfo, stores []*storeInfo, locations *leaderLocations) (*metapb.Region, *metapb.Peer, *metapb.Peer) {
+	from, to := locations.maxMin()
+	if locations.isBalanced(locations.counts[from], locations.counts[to]) {
+		return nil, nil, nil
+	}
+
+	fromStores := make([]*storeInfo, 0, len(stores))
+	for _, store := range stores {
+		if locations.location(store) == from {
+			fromStores = append(fromStores, store)
+		}
+	}
+	store := selectFromStore(fromStores, nil, lb.filters, lb.st)
+	if store == nil {
+		return nil, nil, nil
+	}
+
+	storeID := store.store.GetId()
+	region := cluster.regions.randLeaderRegion(storeID)
+	if region == nil {
+		return nil, nil, nil
+	}
+	leader := leaderPeer(region, storeID)
+	if leader == nil {
+		return nil, nil, nil
+	}
+
+	followers := getFollowerPeers(region, leader)
+	removeAffinityFollowers(cluster, region, store, followers)
+	for id := range followers {
+		if followerStore := cluster.getStore(id); followerStore == nil || locations.location(followerStore) != to {
+			delete(followers, id)
+		}
+	}
+	newLeader := lb.selectNewLeaderPeer(cluster, followers)
+	if newLeader == nil {
+		return nil, nil, nil
+	}
+
+	return region, leader, newLeader
+}
+
 func (lb *leaderBalancer) selectNewLeaderPeer(cluster *clusterInfo, peers map[uint64]*metapb.Peer) *metapb.Peer {
 	stores := make([]*storeInfo, 0, len(peers))
 	for storeID := range peers {
 		stores = append(stores, cluster.getStore(storeID))
 	}
 
-	store := selectToStore(stores, nil, nil, lb.st)
+	// The leaders are being moved out of the evicting stores.
+	store := selectToStore(stores, getEvictingLeaderStores(stores), nil, lb.st)
 	if store == nil {
 		log.Debug("find no store to get new leader peer for region")
 		return nil
@@ -300,7 +405,29 @@ func (lb *leaderBalancer) Balance(cluster *clusterInfo) (*score, *balanceOperato
 	}
 
 	stores := cluster.getStores()
-	region, leader, newLeader := lb.selectBalanceRegion(cluster, stores)
+	var (
+		locations         *leaderLocations
+		region            *metapb.Region
+		leader, newLeader *metapb.Peer
+		locationScore     *score
+	)
+	if lb.cfg.LocationLeaderBalance && len(lb.cfg.LocationLabels) > 0 {
+		locations = newLeaderLocations(cluster, stores, lb.cfg)
+		region, leader, newLeader = lb.selectLocationRegion(cluster, stores, locations)
+		if region != nil {
+			// The leader is moved between the locations only if the scores
+			// of the stores allow it too, so no store is overloaded to
+			// balance the locations.
+			if _, ok := checkAndGetDiffScore(cluster, leader, newLeader, lb.st, lb.cfg); ok {
+				locationScore = locations.score(leader, newLeader, cluster)
+			} else {
+				region = nil
+			}
+		}
+	}
+	if region == nil {
+		region, leader, newLeader = lb.selectBalanceRegion(cluster, stores, locations)
+	}
 	if region == nil || leader == nil || newLeader == nil {
 		log.Debug("region cannot be found to do leader transfer")
 		return nil, nil, nil
@@ -313,9 +440,12 @@ func (lb *leaderBalancer) Balance(cluster *clusterInfo) (*score, *balanceOperato
 		return nil, nil, nil
 	}
 
-	score, ok := checkAndGetDiffScore(cluster, leader, newLeader, lb.st, lb.cfg)
-	if !ok {
-		return nil, nil, nil
+	score := locationScore
+	if score == nil {
+		var ok bool
+		if score, ok = checkAndGetDiffScore(cluster, leader, newLeader, lb.st, lb.cfg); !ok {
+			return nil, nil, nil
+		}
 	}
 
 	regionID := region.GetId()
@@ -323,6 +453,178 @@ func (lb *leaderBalancer) Balance(cluster *clusterInfo) (*score, *balanceOperato
 	return score, newBalanceOperator(region, transferLeaderOperator), nil
 }
 
+// leaderLocations is the leader count of each value of the first location
+// label, e.g, each zone, the stores without the label are not counted.
+type leaderLocations struct {
+	label  string
+	counts map[string]int
+	total  int
+
+	cfg *BalanceConfig
+}
+
+func newLeaderLocations(cluster *clusterInfo, stores []*storeInfo, cfg *BalanceConfig) *leaderLocations {
+	locations := &leaderLocations{
+		label:  cfg.LocationLabels[0],
+		counts: make(map[string]int),
+		cfg:    cfg,
+	}
+	for _, store := range stores {
+		location := locations.location(store)
+		if location == "" {
+			continue
+		}
+		count := cluster.regions.leaderRegionCount(store.store.GetId())
+		locations.counts[location] += count
+		locations.total += count
+	}
+	return locations
+}
+
+func (l *leaderLocations) location(store *storeInfo) string {
+	return store.meta.Labels[l.label]
+}
+
+// maxMin returns the locations with the most and the least leaders.
+func (l *leaderLocations) maxMin() (string, string) {
+	locations := make([]string, 0, len(l.counts))
+	for location := range l.counts {
+		locations = append(locations, location)
+	}
+	// Sort the locations so the ties are broken in the same way every time.
+	sort.Strings(locations)
+
+	var max, min string
+	for _, location := range locations {
+		count := l.counts[location]
+		if max == "" || count > l.counts[max] {
+			max = location
+		}
+		if min == "" || count < l.counts[min] {
+			min = location
+		}
+	}
+	return max, min
+}
+
+// isBalanced returns whether the two leader counts are in the tolerance,
+// moving one leader can't make the counts closer if they differ by 1.
+func (l *leaderLocations) isBalanced(more int, less int) bool {
+	diff := more - less
+	return diff <= 1 || diff <= int(float64(more)*l.cfg.MaxDiffScoreFraction)
+}
+
+// allowTransfer returns whether a leader can be moved from the store to the
+// other store without unbalancing the leaders of the locations.
+func (l *leaderLocations) allowTransfer(from *storeInfo, to *storeInfo) bool {
+	if to == nil {
+		return false
+	}
+	fromLocation, toLocation := l.location(from), l.location(to)
+	if fromLocation == toLocation || fromLocation == "" || toLocation == "" {
+		return true
+	}
+
+	l.counts[fromLocation]--
+	l.counts[toLocation]++
+	max, min := l.maxMin()
+	ok := l.isBalanced(l.counts[max], l.counts[min])
+	l.counts[fromLocation]++
+	l.counts[toLocation]--
+	return ok
+}
+
+// score returns the score of the leader transfer between the locations,
+// which is the percentage of the leaders in the locations.
+func (l *leaderLocations) score(oldLeader *metapb.Peer, newLeader *metapb.Peer, cluster *clusterInfo) *score {
+	from := l.counts[l.location(cluster.getStore(oldLeader.GetStoreId()))] * 100 / l.total
+	to := l.counts[l.location(cluster.getStore(newLeader.GetStoreId()))] * 100 / l.total
+	return &score{
+		from:      from,
+		to:        to,
+		diff:      from - to,
+		threshold: noThreshold,
+		st:        leaderScore,
+	}
+}
+
+// evictLeaderBalancer moves the leaders out of the store, e.g, before the
+// store is rebooted. The peers are kept in the store.
+type evictLeaderBalancer struct {
+	storeID uint64
+	filters []Filter
+
+	cfg *BalanceConfig
+}
+
+func newEvictLeaderBalancer(storeID uint64, cfg *BalanceConfig) *evictLeaderBalancer {
+	eb := &evictLeaderBalancer{storeID: storeID, cfg: cfg}
+	eb.filters = append(eb.filters, newStateFilter(cfg))
+	return eb
+}
+
+func (eb *evictLeaderBalancer) ScoreType() scoreType {
+	return leaderScore
+}
+
+func (eb *evictLeaderBalancer) GetName() string {
+	return evictLeaderBalancerName(eb.storeID)
+}
+
+func evictLeaderBalancerName(storeID uint64) string {
+	return fmt.Sprintf("evict-leader-%d", storeID)
+}
+
+// getEvictingLeaderStores returns the stores which the leaders are being
+// moved out of.
+func getEvictingLeaderStores(stores []*storeInfo) map[uint64]struct{} {
+	evicting := make(map[uint64]struct{})
+	for _, store := range stores {
+		if store != nil && store.isEvictingLeader() {
+			evicting[store.store.GetId()] = struct{}{}
+		}
+	}
+	return evicting
+}
+
+// Balance transfers the leader of a random region in the store to the
+// follower with the lowest leader score.
+func (eb *evictLeaderBalancer) Balance(cluster *clusterInfo) (*score, *balanceOperator, error) {
+	region := cluster.regions.randLeaderRegion(eb.storeID)
+	if region == nil {
+		log.Debugf("no leader region in store %d to evict", eb.storeID)
+		return nil, nil, nil
+	}
+
+	leader := leaderPeer(region, eb.storeID)
+	if leader == nil {
+		return nil, nil, nil
+	}
+
+	followers := getFollowerPeers(region, leader)
+	stores := make([]*storeInfo, 0, len(followers))
+	for storeID := range followers {
+		stores = append(stores, cluster.getStore(storeID))
+	}
+	store := selectToStore(stores, getEvictingLeaderStores(stores), eb.filters, leaderScore)
+	if store == nil {
+		log.Warnf("find no store to evict the leader of region %d from store %d", region.GetId(), eb.storeID)
+		return nil, nil, nil
+	}
+	newLeader := followers[store.store.GetId()]
+
+	// The eviction is prior to the balance, the diff score is the max.
+	score := &score{
+		from:      100,
+		to:        0,
+		diff:      100,
+		threshold: noThreshold,
+		st:        leaderScore,
+	}
+	transferLeaderOperator := newTransferLeaderOperator(region.GetId(), leader, newLeader, eb.cfg)
+	return score, newBalanceOperator(region, transferLeaderOperator), nil
+}
+
 // replicaBalancer is used to balance active replica count.
 type replicaBalancer struct {
 	*capacityBalancer
@@ -342,13 +644,33 @@ func newReplicaBalancer(region *metapb.Region, leader *metapb.Peer, downPeers []
 	}
 }
 
-func (rb *replicaBalancer) addPeer(cluster *clusterInfo) (*balanceOperator, error) {
+func (rb *replicaBalancer) GetName() string {
+	return "replica"
+}
+
+func (rb *replicaBalancer) addPeer(cluster *clusterInfo, downPeers []*metapb.Peer) (*balanceOperator, error) {
 	stores := cluster.getStores()
+
+	// Try to add the peer in a new location first, but the replica is more
+	// important than the location, so we don't care the location if we can't.
+	// The placement rule and the namespace must be always satisfied.
 	excluded := getExcludedStores(rb.region)
+	excludeSameLocationStores(cluster, stores, rb.region, excluded, rb.cfg.LocationLabels, downPeers...)
+	excludeRuleStores(cluster, stores, rb.region, excluded)
+	excludeNamespaceStores(cluster, stores, rb.region, excluded)
 	peer, err := rb.selectAddPeer(cluster, stores, excluded)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if peer == nil && len(rb.cfg.LocationLabels) > 0 {
+		excluded = getExcludedStores(rb.region)
+		excludeRuleStores(cluster, stores, rb.region, excluded)
+		excludeNamespaceStores(cluster, stores, rb.region, excluded)
+		peer, err = rb.selectAddPeer(cluster, stores, excluded)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
 	if peer == nil {
 		log.Warnf("find no store to add peer for region %v", rb.region)
 		return nil, nil
@@ -400,7 +722,64 @@ func (rb *replicaBalancer) collectDownPeers(cluster *clusterInfo) []*metapb.Peer
 			downPeers = append(downPeers, peer)
 		}
 	}
-	return downPeers
+
+	// The peers in offline stores and the stores which don't satisfy the
+	// placement rule or are not in the namespace should be moved out too.
+	collected := make(map[uint64]struct{}, len(downPeers))
+	for _, peer := range downPeers {
+		collected[peer.GetId()] = struct{}{}
+	}
+	rule := cluster.getPlacementRule(rb.region)
+	ns := cluster.getNamespace(rb.region)
+	drainingPeers := &drainingPeers{count: make(map[uint64]int)}
+	for _, peer := range rb.region.GetPeers() {
+		if _, ok := collected[peer.GetId()]; ok {
+			continue
+		}
+		store := cluster.getStore(peer.GetStoreId())
+		if store == nil {
+			continue
+		}
+		if store.isOffline() {
+			drainingPeers.peers = append(drainingPeers.peers, peer)
+		} else if (rule != nil && !rule.matchStore(store)) || (ns != nil && !ns.hasStore(store.store.GetId())) {
+			downPeers = append(downPeers, peer)
+		}
+	}
+
+	// When several stores are offline, the peer in the store nearest to
+	// empty is removed first, like drainOfflineStores does.
+	if len(drainingPeers.peers) > 1 {
+		for _, peer := range drainingPeers.peers {
+			drainingPeers.count[peer.GetStoreId()] = cluster.regions.storeRegionCount(peer.GetStoreId())
+		}
+		sort.Sort(drainingPeers)
+	}
+	return append(downPeers, drainingPeers.peers...)
+}
+
+// drainingPeers sorts the peers in the offline stores by the region count
+// of the store.
+type drainingPeers struct {
+	peers []*metapb.Peer
+	// store id -> region count
+	count map[uint64]int
+}
+
+func (s *drainingPeers) Len() int {
+	return len(s.peers)
+}
+
+func (s *drainingPeers) Swap(i, j int) {
+	s.peers[i], s.peers[j] = s.peers[j], s.peers[i]
+}
+
+func (s *drainingPeers) Less(i, j int) bool {
+	si, sj := s.peers[i].GetStoreId(), s.peers[j].GetStoreId()
+	if s.count[si] != s.count[sj] {
+		return s.count[si] < s.count[sj]
+	}
+	return si < sj
 }
 
 func (rb *replicaBalancer) Balance(cluster *clusterInfo) (*score, *balanceOperator, error) {
@@ -414,7 +793,7 @@ func (rb *replicaBalancer) Balance(cluster *clusterInfo) (*score, *balanceOperat
 	)
 
 	if peerCount-len(downPeers) < maxPeerCount {
-		bop, err = rb.addPeer(cluster)
+		bop, err = rb.addPeer(cluster, downPeers)
 	} else if peerCount > maxPeerCount {
 		bop, err = rb.removePeer(cluster, downPeers)
 	}
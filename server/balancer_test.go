@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/juju/errors"
 	. "github.com/pingcap/check"
 	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
@@ -32,6 +33,14 @@ type testBalancerSuite struct {
 	cfg *BalanceConfig
 }
 
+// balanceConfigGetter returns the getter of the config for the balancer
+// worker, the changes of the config take effect immediately.
+func balanceConfigGetter(cfg *BalanceConfig) func() *BalanceConfig {
+	return func() *BalanceConfig {
+		return cfg
+	}
+}
+
 func (s *testBalancerSuite) getRootPath() string {
 	return "test_balancer"
 }
@@ -42,7 +51,7 @@ func (s *testBalancerSuite) SetUpSuite(c *C) {
 }
 
 func (s *testBalancerSuite) newClusterInfo(c *C) *clusterInfo {
-	clusterInfo := newClusterInfo(s.getRootPath())
+	clusterInfo := newClusterInfo(s.getRootPath(), 1)
 	clusterInfo.idAlloc = newMockIDAllocator()
 
 	// Set cluster info.
@@ -187,7 +196,8 @@ func (s *testBalancerSuite) TestCapacityBalancer(c *C) {
 
 	// Now the region is (1,3,4), the balance operators should be
 	// 1) add peer: 2
-	// 2) remove peer: 4
+	// 2) wait for the new peer to catch up
+	// 3) remove peer: 4
 	s.updateStore(c, clusterInfo, 1, 100, 90, 0, 0)
 	s.updateStore(c, clusterInfo, 2, 100, 70, 0, 0)
 	s.updateStore(c, clusterInfo, 3, 100, 80, 0, 0)
@@ -196,13 +206,14 @@ func (s *testBalancerSuite) TestCapacityBalancer(c *C) {
 	cb = newCapacityBalancer(testCfg)
 	_, bop, err = cb.Balance(clusterInfo)
 	c.Assert(err, IsNil)
-	c.Assert(bop.Ops, HasLen, 2)
+	c.Assert(bop.Ops, HasLen, 3)
 
 	op1 := bop.Ops[0].(*changePeerOperator)
 	c.Assert(op1.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
 	c.Assert(op1.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
+	c.Assert(bop.Ops[1].(*catchUpOperator).Peer, DeepEquals, op1.ChangePeer.GetPeer())
 
-	op2 := bop.Ops[1].(*changePeerOperator)
+	op2 := bop.Ops[2].(*changePeerOperator)
 	c.Assert(op2.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
 	c.Assert(op2.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(4))
 
@@ -222,7 +233,7 @@ func (s *testBalancerSuite) TestCapacityBalancer(c *C) {
 	c.Assert(op1.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
 	c.Assert(op1.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
 
-	op2 = bop.Ops[1].(*changePeerOperator)
+	op2 = bop.Ops[2].(*changePeerOperator)
 	c.Assert(op2.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
 	c.Assert(op2.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(3))
 
@@ -273,7 +284,7 @@ func (s *testBalancerSuite) TestCapacityBalancer(c *C) {
 	c.Assert(op1.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
 	c.Assert(op1.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(4))
 
-	op2 = bop.Ops[1].(*changePeerOperator)
+	op2 = bop.Ops[2].(*changePeerOperator)
 	c.Assert(op2.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
 	c.Assert(op2.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(1))
 
@@ -296,6 +307,80 @@ func (s *testBalancerSuite) TestCapacityBalancer(c *C) {
 }
 
 // TODO: Refactor these tests, they are quite ugly now.
+func (s *testBalancerSuite) TestSafeRemovePeer(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	// The store id will be 1,2,3,4.
+	s.updateStore(c, clusterInfo, 1, 100, 60, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 70, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 80, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 90, 0, 0)
+
+	// Add two peers, the region is (1,3,4) and leader is 1.
+	s.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.addRegionPeer(c, clusterInfo, 3, region, leader)
+
+	// The peer on store 4 is moved to store 2.
+	s.updateStore(c, clusterInfo, 1, 100, 90, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 60, 0, 0)
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	newBalance := func() (*balanceOperator, *metapb.Peer, *metapb.Peer) {
+		_, bop, err := newCapacityBalancer(cfg).Balance(clusterInfo)
+		c.Assert(err, IsNil)
+		c.Assert(bop.Ops, HasLen, 3)
+		return bop, bop.Ops[0].(*changePeerOperator).ChangePeer.GetPeer(), bop.Ops[2].(*changePeerOperator).ChangePeer.GetPeer()
+	}
+
+	ctx := newOpContext(nil, nil)
+	mustDo := func(bop *balanceOperator, region *metapb.Region) *pdpb.ChangePeer {
+		finished, res, err := bop.Do(ctx, region, leader)
+		c.Assert(err, IsNil)
+		c.Assert(finished, IsFalse)
+		return res.GetChangePeer()
+	}
+	checkChangePeer := func(changePeer *pdpb.ChangePeer, changeType raftpb.ConfChangeType, peer *metapb.Peer) {
+		c.Assert(changePeer.GetChangeType(), Equals, changeType)
+		c.Assert(changePeer.GetPeer(), DeepEquals, peer)
+	}
+
+	// The old peer is removed only after the new peer is reported healthy in
+	// enough heartbeats after it is added.
+	bop, newPeer, oldPeer := newBalance()
+	c.Assert(newPeer.GetStoreId(), Equals, uint64(2))
+	c.Assert(oldPeer.GetStoreId(), Equals, uint64(4))
+	checkChangePeer(mustDo(bop, region), raftpb.ConfChangeType_AddNode, newPeer)
+	checkChangePeer(mustDo(bop, region), raftpb.ConfChangeType_AddNode, newPeer)
+	added := cloneRegion(region)
+	addRegionPeer(c, added, newPeer)
+	c.Assert(mustDo(bop, added), IsNil)
+	for i := uint64(0); i < cfg.PeerCatchUpCount; i++ {
+		c.Assert(mustDo(bop, added), IsNil)
+	}
+	checkChangePeer(mustDo(bop, added), raftpb.ConfChangeType_RemoveNode, oldPeer)
+
+	// The new peer is down before it catches up, it is removed instead of
+	// the old peer and the operator fails.
+	bop, newPeer, oldPeer = newBalance()
+	checkChangePeer(mustDo(bop, region), raftpb.ConfChangeType_AddNode, newPeer)
+	added = cloneRegion(region)
+	addRegionPeer(c, added, newPeer)
+	c.Assert(mustDo(bop, added), IsNil)
+	c.Assert(mustDo(bop, added), IsNil)
+	ctx.downPeers = []*pdpb.PeerStats{{Peer: newPeer, DownSeconds: proto.Uint64(60)}}
+	checkChangePeer(mustDo(bop, added), raftpb.ConfChangeType_RemoveNode, newPeer)
+	ctx.downPeers = nil
+	checkChangePeer(mustDo(bop, added), raftpb.ConfChangeType_RemoveNode, newPeer)
+	_, res, err := bop.Do(ctx, region, leader)
+	c.Assert(err, NotNil)
+	c.Assert(res, IsNil)
+	c.Assert(containPeer(region, oldPeer), IsTrue)
+}
+
 func (s *testBalancerSuite) TestDownStore(c *C) {
 	clusterInfo := s.newClusterInfo(c)
 	c.Assert(clusterInfo, NotNil)
@@ -335,13 +420,13 @@ func (s *testBalancerSuite) TestDownStore(c *C) {
 
 		_, bop, err := cb.Balance(clusterInfo)
 		c.Assert(err, IsNil)
-		c.Assert(bop.Ops, HasLen, 2)
+		c.Assert(bop.Ops, HasLen, 3)
 
 		op0 := bop.Ops[0].(*changePeerOperator)
 		c.Assert(op0.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
 		c.Assert(op0.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
 
-		op1 := bop.Ops[1].(*changePeerOperator)
+		op1 := bop.Ops[2].(*changePeerOperator)
 		c.Assert(op1.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
 		c.Assert(op1.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(4))
 
@@ -406,6 +491,34 @@ func (s *testBalancerSuite) TestReplicaBalancer(c *C) {
 	c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
 }
 
+func (s *testBalancerSuite) TestSnapCountLimit(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, _ := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(region.GetPeers(), HasLen, 1)
+	leader := region.GetPeers()[0]
+
+	// Store 4 is receiving and store 3 is sending too many snapshots, so
+	// the new peer is added to store 2 though it has less space.
+	s.updateStore(c, clusterInfo, 1, 100, 10, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 30, uint32(s.cfg.MaxSendingSnapCount)+1, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 40, 0, uint32(s.cfg.MaxReceivingSnapCount)+1)
+	s.addRegionPeer(c, clusterInfo, 2, region, leader)
+
+	// No store can receive a snapshot now.
+	s.updateStore(c, clusterInfo, 2, 100, 20, 0, uint32(s.cfg.MaxReceivingSnapCount)+1)
+	db := newReplicaBalancer(region, leader, nil, s.cfg)
+	_, bop, err := db.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop, IsNil)
+
+	// The snapshots of store 4 are finished.
+	s.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+	s.addRegionPeer(c, clusterInfo, 4, region, leader)
+}
+
 func (s *testBalancerSuite) TestReplicaBalancerWithDownPeers(c *C) {
 	clusterInfo := s.newClusterInfo(c)
 	c.Assert(clusterInfo, NotNil)
@@ -487,3 +600,916 @@ func (s *testBalancerSuite) TestReplicaBalancerWithDownPeers(c *C) {
 	c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
 	c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(4))
 }
+
+func (s *testBalancerSuite) TestReplicaBalancerWithOfflineStore(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, _ := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(region.GetPeers(), HasLen, 1)
+
+	// The store id will be 1,2,3,4.
+	s.updateStore(c, clusterInfo, 1, 100, 10, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	// Get leader peer.
+	leader := region.GetPeers()[0]
+
+	// Test add peer.
+	s.addRegionPeer(c, clusterInfo, 4, region, leader)
+
+	// Test add another peer.
+	s.addRegionPeer(c, clusterInfo, 3, region, leader)
+
+	// Make store 4 offline, we should add a replica to store 2
+	// instead of the offline store.
+	c.Assert(clusterInfo.setStoreMeta(4, storeMeta{State: StoreStateOffline}), IsTrue)
+	s.addRegionPeer(c, clusterInfo, 2, region, leader)
+
+	// Now we have enough active replicas, we can remove the peer in store 4.
+	rb := newReplicaBalancer(region, leader, nil, s.cfg)
+	_, bop, err := rb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop.Ops, HasLen, 1)
+
+	op, ok := bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+	c.Assert(ok, IsTrue)
+	c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
+	c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(4))
+}
+
+func (s *testBalancerSuite) TestLocationLabels(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, _ := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(region.GetPeers(), HasLen, 1)
+
+	cfg := *s.cfg
+	cfg.LocationLabels = []string{"zone"}
+
+	// Store 1 and store 2 are in the same zone.
+	for storeID, zone := range map[uint64]string{1: "z1", 2: "z1", 3: "z2", 4: "z3"} {
+		c.Assert(clusterInfo.setStoreMeta(storeID, storeMeta{Labels: map[string]string{"zone": zone}}), IsTrue)
+	}
+
+	// The store id will be 1,2,3,4, store 2 has the most available space.
+	s.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 90, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	// Get leader peer.
+	leader := region.GetPeers()[0]
+
+	// The replicas are not added to store 2, which is in the same zone with store 1.
+	mustAddPeer := func(storeID uint64) {
+		rb := newReplicaBalancer(region, leader, nil, &cfg)
+		_, bop, err := rb.Balance(clusterInfo)
+		c.Assert(err, IsNil)
+		op, ok := bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+		c.Assert(ok, IsTrue)
+		c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+		c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, storeID)
+		addRegionPeer(c, region, op.ChangePeer.GetPeer())
+		clusterInfo.regions.updateRegion(region)
+	}
+	mustAddPeer(4)
+	mustAddPeer(3)
+
+	// Now the region is (1,3,4), store 3 is nearly full, but its peer can't
+	// be moved to store 2, which is in the same zone with store 1.
+	s.updateStore(c, clusterInfo, 3, 100, 5, 0, 0)
+	cfg.MinCapacityUsedRatio = 0.3
+	cfg.MaxCapacityUsedRatio = 0.9
+	cb := newCapacityBalancer(&cfg)
+	_, bop, err := cb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop, IsNil)
+
+	// Store 2 is moved to the zone of store 3, so the peer can be moved.
+	c.Assert(clusterInfo.setStoreMeta(2, storeMeta{Labels: map[string]string{"zone": "z2"}}), IsTrue)
+	_, bop, err = cb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop.Ops, HasLen, 3)
+	c.Assert(bop.Ops[0].(*changePeerOperator).ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
+	c.Assert(bop.Ops[2].(*changePeerOperator).ChangePeer.GetPeer().GetStoreId(), Equals, uint64(3))
+
+	// All the stores are in the same zone now, but the replica is more
+	// important than the location, so we still add the peer.
+	for _, storeID := range []uint64{2, 3, 4} {
+		c.Assert(clusterInfo.setStoreMeta(storeID, storeMeta{Labels: map[string]string{"zone": "z1"}}), IsTrue)
+	}
+	region.Peers = region.Peers[:2]
+	clusterInfo.regions.updateRegion(region)
+	mustAddPeer(2)
+}
+
+func (s *testBalancerSuite) TestSameLocation(c *C) {
+	store := func(labels map[string]string) *storeInfo {
+		return &storeInfo{store: &metapb.Store{}, meta: storeMeta{Labels: labels}}
+	}
+	labels := []string{"zone", "rack"}
+	z1r1 := store(map[string]string{"zone": "z1", "rack": "r1"})
+
+	c.Assert(z1r1.isSameLocation(store(map[string]string{"zone": "z1", "rack": "r1"}), labels), IsTrue)
+	// Different racks in the same zone.
+	c.Assert(z1r1.isSameLocation(store(map[string]string{"zone": "z1", "rack": "r2"}), labels), IsFalse)
+	// The same rack name in different zones.
+	c.Assert(z1r1.isSameLocation(store(map[string]string{"zone": "z2", "rack": "r1"}), labels), IsFalse)
+	c.Assert(z1r1.isSameLocation(store(map[string]string{"zone": "z1"}), labels), IsFalse)
+	// The stores in the same zone without racks.
+	c.Assert(store(map[string]string{"zone": "z1"}).isSameLocation(store(map[string]string{"zone": "z1"}), labels), IsTrue)
+	// The locations are unknown.
+	c.Assert(store(nil).isSameLocation(store(nil), labels), IsFalse)
+	c.Assert(z1r1.isSameLocation(z1r1, nil), IsFalse)
+}
+
+func (s *testBalancerSuite) TestStoreWeight(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+
+	// The store id will be 1,2,3,4, store 3 has the lowest used ratio.
+	s.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 60, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 70, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	addPeerStore := func() uint64 {
+		rb := newReplicaBalancer(region, leader, nil, s.cfg)
+		_, bop, err := rb.Balance(clusterInfo)
+		c.Assert(err, IsNil)
+		op, ok := bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+		c.Assert(ok, IsTrue)
+		c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+		return op.ChangePeer.GetPeer().GetStoreId()
+	}
+	c.Assert(addPeerStore(), Equals, uint64(3))
+
+	// Store 4 is 3 times bigger than the others, so it should be filled
+	// until it uses 3 times the ratio of the others.
+	c.Assert(clusterInfo.setStoreMeta(4, storeMeta{RegionWeight: 3}), IsTrue)
+	c.Assert(addPeerStore(), Equals, uint64(4))
+	c.Assert(newCapacityScorer().Score(clusterInfo.getStore(4)), Equals, 20)
+
+	// The leader weight works the same way.
+	store := clusterInfo.getStore(1)
+	c.Assert(newLeaderScorer().Score(store), Equals, 100)
+	store.meta.LeaderWeight = 4
+	c.Assert(newLeaderScorer().Score(store), Equals, 25)
+}
+
+func (s *testBalancerSuite) TestHighUsedRatioStore(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+
+	// Store 4 has the lowest score because of its weight, but it is used
+	// more than the max capacity used ratio.
+	s.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 40, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 5, 0, 0)
+	c.Assert(clusterInfo.setStoreMeta(4, storeMeta{RegionWeight: 10}), IsTrue)
+	c.Assert(clusterInfo.getStore(4).stats.UsedRatio, Equals, 0.95)
+	c.Assert(newCapacityScorer().Score(clusterInfo.getStore(4)), Equals, 9)
+
+	addPeerStore := func() uint64 {
+		rb := newReplicaBalancer(region, leader, nil, s.cfg)
+		_, bop, err := rb.Balance(clusterInfo)
+		c.Assert(err, IsNil)
+		op := bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+		return op.ChangePeer.GetPeer().GetStoreId()
+	}
+	c.Assert(addPeerStore(), Equals, uint64(2))
+
+	// Store 4 is the target after it is below the max used ratio.
+	s.updateStore(c, clusterInfo, 4, 100, 20, 0, 0)
+	c.Assert(addPeerStore(), Equals, uint64(4))
+}
+
+func (s *testBalancerSuite) TestEvictLeader(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, _ := clusterInfo.regions.getRegion([]byte("a"))
+	clusterInfo.regions.removeRegion(region)
+
+	// Add 3 regions with peers in store 1,2,3, store 1 has all the leaders.
+	keys := [][]byte{{}, []byte("b"), []byte("c"), {}}
+	for i := uint64(0); i < 3; i++ {
+		peers := []*metapb.Peer{
+			s.newPeer(c, 1, 100+i*10),
+			s.newPeer(c, 2, 101+i*10),
+			s.newPeer(c, 3, 102+i*10),
+		}
+		region = s.newRegion(c, 200+i, keys[i], keys[i+1], peers, nil)
+		clusterInfo.regions.addRegion(region)
+		clusterInfo.regions.leaders.update(region.GetId(), 1)
+	}
+	for i := uint64(1); i < 5; i++ {
+		s.updateStore(c, clusterInfo, i, 100, 60, 0, 0)
+	}
+	c.Assert(clusterInfo.regions.leaderRegionCount(1), Equals, 3)
+
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(s.cfg))
+	c.Assert(bw.getBalancer(evictLeaderBalancerName(1)), IsNil)
+	meta := clusterInfo.getStore(1).meta
+	meta.EvictLeader = true
+	c.Assert(clusterInfo.setStoreMeta(1, meta), IsTrue)
+	c.Assert(clusterInfo.getEvictLeaderStores(), DeepEquals, []uint64{1})
+	c.Assert(bw.getBalancer(evictLeaderBalancerName(1)), NotNil)
+
+	// Transfer the leaders out until store 1 has no leader.
+	eb := newEvictLeaderBalancer(1, s.cfg)
+	for i := 0; i < 3; i++ {
+		_, bop, err := eb.Balance(clusterInfo)
+		c.Assert(err, IsNil)
+		c.Assert(bop, NotNil)
+		op := bop.Ops[0].(*transferLeaderOperator)
+		c.Assert(op.OldLeader.GetStoreId(), Equals, uint64(1))
+		c.Assert(op.NewLeader.GetStoreId(), Not(Equals), uint64(1))
+		clusterInfo.regions.leaders.update(op.RegionID, op.NewLeader.GetStoreId())
+	}
+	c.Assert(clusterInfo.regions.leaderRegionCount(1), Equals, 0)
+	_, bop, err := eb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop, IsNil)
+
+	// The leader balancer doesn't place leaders on store 1 though it has
+	// the lowest leader score.
+	for i := uint64(1); i < 5; i++ {
+		s.updateStore(c, clusterInfo, i, 100, 60, 0, 0)
+	}
+	c.Assert(newLeaderScorer().Score(clusterInfo.getStore(1)), Equals, 0)
+	lb := newLeaderBalancer(s.cfg)
+	for _, region := range clusterInfo.regions.getRegions() {
+		leaderStoreID := clusterInfo.regions.leaders.regionStores[region.GetId()]
+		followers := getFollowerPeers(region, leaderPeer(region, leaderStoreID))
+		newLeader := lb.selectNewLeaderPeer(clusterInfo, followers)
+		c.Assert(newLeader, NotNil)
+		c.Assert(newLeader.GetStoreId(), Not(Equals), uint64(1))
+	}
+
+	// The peers are kept in store 1.
+	c.Assert(clusterInfo.regions.storeRegionCount(1), Equals, 3)
+}
+
+func (s *testBalancerSuite) TestPlacementRules(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+
+	// Store 1 and store 3 are SSD stores, store 4 has the most available space.
+	for storeID, ssd := range map[uint64]string{1: "true", 2: "false", 3: "true", 4: "false"} {
+		c.Assert(clusterInfo.setStoreMeta(storeID, storeMeta{Labels: map[string]string{"ssd": ssd}}), IsTrue)
+	}
+	s.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 60, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 90, 0, 0)
+
+	addPeerStore := func() uint64 {
+		rb := newReplicaBalancer(region, leader, nil, s.cfg)
+		_, bop, err := rb.Balance(clusterInfo)
+		c.Assert(err, IsNil)
+		if bop == nil {
+			return 0
+		}
+		op, ok := bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+		c.Assert(ok, IsTrue)
+		c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+		return op.ChangePeer.GetPeer().GetStoreId()
+	}
+
+	// The region starts with "", it doesn't match the rule.
+	ssd := map[string]string{"ssd": "true"}
+	clusterInfo.setPlacementRules([]*PlacementRule{{KeyRange: KeyRange{StartKey: []byte("m")}, Labels: ssd}})
+	c.Assert(addPeerStore(), Equals, uint64(4))
+
+	// The region matches the rule now, the peer can only be added to store 3.
+	clusterInfo.setPlacementRules([]*PlacementRule{{KeyRange: KeyRange{EndKey: []byte("m")}, Labels: ssd}})
+	c.Assert(addPeerStore(), Equals, uint64(3))
+
+	// There is no other SSD store after the peer is added.
+	s.addRegionPeer(c, clusterInfo, 3, region, leader)
+	c.Assert(addPeerStore(), Equals, uint64(0))
+
+	// The peer on store 4 doesn't satisfy the rule, so the region lacks a
+	// replica, but it can only be removed after a SSD store is available.
+	region.Peers = append(region.Peers, s.newPeer(c, 4, 100))
+	clusterInfo.regions.updateRegion(region)
+	c.Assert(addPeerStore(), Equals, uint64(0))
+	c.Assert(clusterInfo.setStoreMeta(2, storeMeta{Labels: ssd}), IsTrue)
+	c.Assert(addPeerStore(), Equals, uint64(2))
+
+	// The region has 4 peers now, the peer on store 4 is removed.
+	region.Peers = append(region.Peers, s.newPeer(c, 2, 101))
+	clusterInfo.regions.updateRegion(region)
+	rb := newReplicaBalancer(region, leader, nil, s.cfg)
+	_, bop, err := rb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	op := bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+	c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
+	c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(4))
+}
+
+func (s *testBalancerSuite) TestValidatePlacementRules(c *C) {
+	ssd := map[string]string{"ssd": "true"}
+	rule := func(startKey, endKey string) *PlacementRule {
+		return &PlacementRule{KeyRange: KeyRange{StartKey: []byte(startKey), EndKey: []byte(endKey)}, Labels: ssd}
+	}
+
+	c.Assert(validatePlacementRules(nil), IsNil)
+	c.Assert(validatePlacementRules([]*PlacementRule{rule("m", ""), rule("a", "b"), rule("b", "m")}), IsNil)
+
+	for _, rules := range [][]*PlacementRule{
+		{rule("b", "a")},
+		{rule("a", "a")},
+		{{StartKey: []byte("a")}},
+	} {
+		c.Assert(errors.Cause(validatePlacementRules(rules)), Equals, ErrInvalidPlacementRule)
+	}
+	for _, rules := range [][]*PlacementRule{
+		{rule("a", "c"), rule("b", "d")},
+		{rule("c", ""), rule("a", "d")},
+		{rule("", ""), rule("x", "")},
+	} {
+		c.Assert(errors.Cause(validatePlacementRules(rules)), Equals, ErrOverlappingPlacementRules)
+	}
+}
+
+func (s *testBalancerSuite) TestNamespaces(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	for storeID := uint64(5); storeID <= 7; storeID++ {
+		clusterInfo.addStore(s.newStore(c, storeID, fmt.Sprintf("127.0.0.1:%d", storeID)))
+	}
+	for storeID, available := range map[uint64]uint64{1: 50, 2: 10, 3: 60, 4: 40, 5: 90, 6: 95, 7: 99} {
+		s.updateStore(c, clusterInfo, storeID, 100, available, 0, 0)
+	}
+
+	// Split the region into [, m) with the leader on store 1 and [m, ) with
+	// the leader on store 5.
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	clusterInfo.regions.removeRegion(region)
+	region.EndKey = []byte("m")
+	clusterInfo.regions.addRegion(region)
+	clusterInfo.regions.leaders.update(region.GetId(), 1)
+	leaderB := s.newPeer(c, 5, 200)
+	regionB := s.newRegion(c, 201, []byte("m"), []byte{}, []*metapb.Peer{leaderB}, nil)
+	clusterInfo.regions.addRegion(regionB)
+	clusterInfo.regions.leaders.update(regionB.GetId(), 5)
+
+	clusterInfo.setNamespaces([]*Namespace{
+		{Name: "a", Ranges: []*KeyRange{{EndKey: []byte("m")}}, StoreIDs: []uint64{1, 2, 3, 4}, RegionScheduleLimit: 1},
+		{Name: "b", Ranges: []*KeyRange{{StartKey: []byte("m")}}, StoreIDs: []uint64{5, 6, 7}},
+	})
+	c.Assert(clusterInfo.getNamespace(region).Name, Equals, "a")
+	c.Assert(clusterInfo.getNamespace(regionB).Name, Equals, "b")
+
+	// The replicas are only added to the stores of the namespace, though
+	// the stores of the other namespace have more available space.
+	s.addRegionPeer(c, clusterInfo, 3, region, leader)
+	s.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.addRegionPeer(c, clusterInfo, 7, regionB, leaderB)
+	s.addRegionPeer(c, clusterInfo, 6, regionB, leaderB)
+
+	// Store 4 is the most used store, but the region can only be moved to
+	// store 2, which is the only store left in the namespace.
+	s.updateStore(c, clusterInfo, 2, 100, 80, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 20, 0, 0)
+	cb := newCapacityBalancer(s.cfg)
+	_, bop, err := cb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop, NotNil)
+	c.Assert(bop.getRegionID(), Equals, region.GetId())
+	op := bop.Ops[0].(*changePeerOperator)
+	c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+	c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
+
+	// The peer on store 1 is outside the namespace of regionB, it is removed
+	// since the region has enough replicas in the namespace.
+	regionB.Peers = append(regionB.Peers, s.newPeer(c, 1, 202))
+	clusterInfo.regions.updateRegion(regionB)
+	rb := newReplicaBalancer(regionB, leaderB, nil, s.cfg)
+	_, bop, err = rb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	op = bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+	c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
+	c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(1))
+
+	// Namespace a allows only one region operator at a time.
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(s.cfg))
+	c.Assert(bw.allowNamespace(newBalanceOperator(region, op)), IsTrue)
+	c.Assert(bw.addBalanceOperator(region.GetId(), newBalanceOperator(region, op)), IsTrue)
+	c.Assert(bw.allowNamespace(newBalanceOperator(region, op)), IsFalse)
+	c.Assert(bw.allowNamespace(newBalanceOperator(regionB, op)), IsTrue)
+}
+
+func (s *testBalancerSuite) TestValidateNamespaces(c *C) {
+	namespace := func(name string, keys ...string) *Namespace {
+		ns := &Namespace{Name: name, StoreIDs: []uint64{1}}
+		for i := 0; i+1 < len(keys); i += 2 {
+			ns.Ranges = append(ns.Ranges, &KeyRange{StartKey: []byte(keys[i]), EndKey: []byte(keys[i+1])})
+		}
+		return ns
+	}
+
+	c.Assert(validateNamespaces(nil), IsNil)
+	c.Assert(validateNamespaces([]*Namespace{namespace("a", "a", "b", "m", ""), namespace("b", "b", "m")}), IsNil)
+
+	for _, namespaces := range [][]*Namespace{
+		{namespace("", "a", "b")},
+		{namespace("a", "a", "b"), namespace("a", "c", "d")},
+		{namespace("a")},
+		{namespace("a", "b", "a")},
+		{{Name: "a", Ranges: []*KeyRange{{StartKey: []byte("a")}}}},
+	} {
+		c.Assert(errors.Cause(validateNamespaces(namespaces)), Equals, ErrInvalidNamespace)
+	}
+	for _, namespaces := range [][]*Namespace{
+		{namespace("a", "a", "c"), namespace("b", "b", "d")},
+		{namespace("a", "a", "c", "b", "d")},
+		{namespace("a", "", ""), namespace("b", "x", "")},
+	} {
+		c.Assert(errors.Cause(validateNamespaces(namespaces)), Equals, ErrOverlappingNamespaces)
+	}
+}
+
+func (s *testBalancerSuite) TestOrphanPeers(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, _ := clusterInfo.regions.getRegion([]byte("a"))
+	clusterInfo.regions.removeRegion(region)
+	for i := uint64(1); i < 5; i++ {
+		s.updateStore(c, clusterInfo, i, 100, 60, 0, 0)
+	}
+
+	// Region 200 has peers in store 1,2,3, region 201 has peers in store
+	// 1,2,4 and region 202 has peers in store 1,3,4.
+	keys := [][]byte{{}, []byte("b"), []byte("c"), {}}
+	storeIDs := [][]uint64{{1, 2, 3}, {1, 2, 4}, {1, 3, 4}}
+	for i := uint64(0); i < 3; i++ {
+		peers := make([]*metapb.Peer, 0, 3)
+		for j, storeID := range storeIDs[i] {
+			peers = append(peers, s.newPeer(c, storeID, 100+i*10+uint64(j)))
+		}
+		region = s.newRegion(c, 200+i, keys[i], keys[i+1], peers, nil)
+		clusterInfo.regions.addRegion(region)
+		clusterInfo.regions.leaders.update(region.GetId(), 1)
+	}
+	c.Assert(findOrphanPeers(clusterInfo), HasLen, 0)
+
+	// Store 3 is removed and store 4 is tombstone.
+	clusterInfo.removeStore(3)
+	c.Assert(clusterInfo.setStoreMeta(4, storeMeta{State: StoreStateTombstone}), IsTrue)
+	c.Assert(findOrphanPeers(clusterInfo), DeepEquals, []*OrphanPeer{
+		{RegionID: 200, PeerID: 102, StoreID: 3, Reason: orphanStoreNotFound},
+		{RegionID: 201, PeerID: 112, StoreID: 4, Reason: orphanStoreTombstone},
+		{RegionID: 202, PeerID: 121, StoreID: 3, Reason: orphanStoreNotFound},
+		{RegionID: 202, PeerID: 122, StoreID: 4, Reason: orphanStoreTombstone},
+	})
+
+	// The orphan peers are only logged by default.
+	cfg := *s.cfg
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(&cfg))
+	bw.checkOrphanPeers()
+	c.Assert(bw.getBalanceOperators(), HasLen, 0)
+	// The orphan peers found are remembered to be logged only once.
+	c.Assert(bw.orphanPeers, HasLen, 4)
+
+	// Region 202 has only 1 healthy peer, removing any peer of it loses
+	// the quorum.
+	cfg.RemoveOrphanPeers = true
+	bw.checkOrphanPeers()
+	ops := bw.getBalanceOperators()
+	c.Assert(ops, HasLen, 2)
+	for regionID, peerID := range map[uint64]uint64{200: 102, 201: 112} {
+		op := ops[regionID].(*balanceOperator).Ops[0].(*onceOperator).Op.(*changePeerOperator)
+		c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
+		c.Assert(op.ChangePeer.GetPeer().GetId(), Equals, peerID)
+	}
+}
+
+func (s *testBalancerSuite) TestAdjustBalanceTolerance(c *C) {
+	for fraction, expected := range map[float64]float64{
+		0:    defaultMaxDiffScoreFraction,
+		0.3:  0.3,
+		-0.1: defaultMaxDiffScoreFraction,
+		1:    defaultMaxDiffScoreFraction,
+		1.5:  defaultMaxDiffScoreFraction,
+	} {
+		cfg := newBalanceConfig()
+		cfg.MaxDiffScoreFraction = fraction
+		cfg.adjust()
+		c.Assert(cfg.MaxDiffScoreFraction, Equals, expected)
+	}
+}
+
+func (s *testBalancerSuite) TestBalanceTolerance(c *C) {
+	testCfg := newBalanceConfig()
+	testCfg.adjust()
+	testCfg.MaxLeaderCount = 1
+	testCfg.MaxDiffScoreFraction = 0.3
+
+	// The region is (1,3,4), whose leader is in store 1.
+	clusterInfo := s.newClusterInfo(c)
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	s.updateStore(c, clusterInfo, 1, 100, 60, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 70, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 80, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 90, 0, 0)
+	s.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.addRegionPeer(c, clusterInfo, 3, region, leader)
+
+	// Store 4 uses 10% more than store 2, which is in the tolerance.
+	s.updateStore(c, clusterInfo, 1, 100, 90, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 70, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 80, 0, 0)
+	s.updateStore(c, clusterInfo, 4, 100, 60, 0, 0)
+	cb := newCapacityBalancer(testCfg)
+	_, bop, err := cb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop, IsNil)
+
+	// Store 4 uses 30% more than store 2.
+	s.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+	_, bop, err = cb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop, NotNil)
+	op := bop.Ops[0].(*changePeerOperator)
+	c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
+
+	// Add 10 regions with peers in store 1,2,3.
+	clusterInfo = s.newClusterInfo(c)
+	region, _ = clusterInfo.regions.getRegion([]byte("a"))
+	clusterInfo.regions.removeRegion(region)
+	for i := uint64(0); i < 10; i++ {
+		peers := []*metapb.Peer{
+			s.newPeer(c, 1, 100+i*10),
+			s.newPeer(c, 2, 101+i*10),
+			s.newPeer(c, 3, 102+i*10),
+		}
+		var startKey, endKey []byte
+		if i > 0 {
+			startKey = []byte{byte(i)}
+		}
+		if i < 9 {
+			endKey = []byte{byte(i + 1)}
+		}
+		region = s.newRegion(c, 200+i, startKey, endKey, peers, nil)
+		clusterInfo.regions.addRegion(region)
+	}
+	setLeaders := func(counts ...int) {
+		regionID := uint64(200)
+		for i, count := range counts {
+			for j := 0; j < count; j++ {
+				clusterInfo.regions.leaders.update(regionID, uint64(i+1))
+				regionID++
+			}
+		}
+		for i := uint64(1); i < 5; i++ {
+			s.updateStore(c, clusterInfo, i, 100, 60, 0, 0)
+		}
+	}
+
+	// The leader scores are 40, 30, 30, which are in the tolerance.
+	setLeaders(4, 3, 3)
+	lb := newLeaderBalancer(testCfg)
+	_, bop, err = lb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop, IsNil)
+
+	// The leader scores are 60, 20, 20.
+	setLeaders(6, 2, 2)
+	_, bop, err = lb.Balance(clusterInfo)
+	c.Assert(err, IsNil)
+	c.Assert(bop, NotNil)
+	transfer := bop.Ops[0].(*transferLeaderOperator)
+	c.Assert(transfer.OldLeader.GetStoreId(), Equals, uint64(1))
+}
+
+func (s *testBalancerSuite) TestLocationLeaderBalance(c *C) {
+	testCfg := newBalanceConfig()
+	testCfg.adjust()
+	testCfg.MaxLeaderCount = 1
+	testCfg.LocationLabels = []string{"zone"}
+
+	// Store 1,2 are in zone z1 and store 3,4 are in zone z2, the 12 regions
+	// have peers in store 1,2,3 and all the leaders are in store 1. Store 3
+	// has a larger leader weight, so it can get half of the leaders without
+	// failing the score check.
+	balanceLeaders := func() (int, int) {
+		clusterInfo := s.newClusterInfo(c)
+		region, _ := clusterInfo.regions.getRegion([]byte("a"))
+		clusterInfo.regions.removeRegion(region)
+		for i := uint64(0); i < 12; i++ {
+			peers := []*metapb.Peer{
+				s.newPeer(c, 1, 100+i*10),
+				s.newPeer(c, 2, 101+i*10),
+				s.newPeer(c, 3, 102+i*10),
+			}
+			var startKey, endKey []byte
+			if i > 0 {
+				startKey = []byte{byte(i)}
+			}
+			if i < 11 {
+				endKey = []byte{byte(i + 1)}
+			}
+			region = s.newRegion(c, 200+i, startKey, endKey, peers, nil)
+			clusterInfo.regions.addRegion(region)
+			clusterInfo.regions.leaders.update(region.GetId(), 1)
+		}
+		for i, zone := range []string{"z1", "z1", "z2", "z2"} {
+			meta := storeMeta{Labels: map[string]string{"zone": zone}}
+			if i == 2 {
+				meta.LeaderWeight = 1.5
+			}
+			c.Assert(clusterInfo.setStoreMeta(uint64(i+1), meta), IsTrue)
+		}
+
+		lb := newLeaderBalancer(testCfg)
+		for i := 0; i < 100; i++ {
+			for id := uint64(1); id < 5; id++ {
+				s.updateStore(c, clusterInfo, id, 100, 60, 0, 0)
+			}
+			_, bop, err := lb.Balance(clusterInfo)
+			c.Assert(err, IsNil)
+			if bop == nil {
+				break
+			}
+			op := bop.Ops[0].(*transferLeaderOperator)
+			clusterInfo.regions.leaders.update(op.RegionID, op.NewLeader.GetStoreId())
+		}
+		leaders := clusterInfo.regions.leaderRegionCount
+		return leaders(1) + leaders(2), leaders(3) + leaders(4)
+	}
+
+	// The leaders are balanced between the stores, so zone z1 has more of them.
+	z1, z2 := balanceLeaders()
+	c.Assert(z1+z2, Equals, 12)
+	c.Assert(z1 > z2+1, IsTrue)
+
+	// The leaders are balanced between the zones.
+	testCfg.LocationLeaderBalance = true
+	z1, z2 = balanceLeaders()
+	c.Assert(z1+z2, Equals, 12)
+	c.Assert(z1-z2 <= 1 && z2-z1 <= 1, IsTrue, Commentf("z1 %d, z2 %d", z1, z2))
+}
+
+func (s *testBalancerSuite) TestLeaderLocationsMaxMin(c *C) {
+	locations := &leaderLocations{
+		counts: map[string]int{"z3": 4, "z1": 4, "z2": 4},
+	}
+
+	// The ties are broken by the location name.
+	for i := 0; i < 10; i++ {
+		max, min := locations.maxMin()
+		c.Assert(max, Equals, "z1")
+		c.Assert(min, Equals, "z1")
+	}
+
+	locations.counts["z2"] = 5
+	locations.counts["z3"] = 3
+	max, min := locations.maxMin()
+	c.Assert(max, Equals, "z2")
+	c.Assert(min, Equals, "z3")
+}
+
+func (s *testBalancerSuite) TestLeaderAffinity(c *C) {
+	testCfg := newBalanceConfig()
+	testCfg.adjust()
+
+	// Store 1,2 are in zone z1 and store 3,4 are in zone z2, the 12 regions
+	// have peers in store 1,2,3, region i is [i, i+1).
+	newCluster := func(leaderStore func(i uint64) uint64) *clusterInfo {
+		clusterInfo := s.newClusterInfo(c)
+		region, _ := clusterInfo.regions.getRegion([]byte("a"))
+		clusterInfo.regions.removeRegion(region)
+		for i := uint64(0); i < 12; i++ {
+			peers := []*metapb.Peer{
+				s.newPeer(c, 1, 100+i*10),
+				s.newPeer(c, 2, 101+i*10),
+				s.newPeer(c, 3, 102+i*10),
+			}
+			var startKey, endKey []byte
+			if i > 0 {
+				startKey = []byte{byte(i)}
+			}
+			if i < 11 {
+				endKey = []byte{byte(i + 1)}
+			}
+			region = s.newRegion(c, 200+i, startKey, endKey, peers, nil)
+			clusterInfo.regions.addRegion(region)
+			clusterInfo.regions.leaders.update(region.GetId(), leaderStore(i))
+		}
+		for i, zone := range []string{"z1", "z1", "z2", "z2"} {
+			c.Assert(clusterInfo.setStoreMeta(uint64(i+1), storeMeta{Labels: map[string]string{"zone": zone}}), IsTrue)
+		}
+		// The leaders of [4, 8) prefer zone z2.
+		clusterInfo.setLeaderAffinities([]*LeaderAffinity{{
+			Location: "dc2",
+			KeyRange: KeyRange{StartKey: []byte{4}, EndKey: []byte{8}},
+			Labels:   map[string]string{"zone": "z2"},
+		}})
+		return clusterInfo
+	}
+	balance := func(clusterInfo *clusterInfo) int {
+		ab := newLeaderAffinityBalancer(testCfg)
+		for i := 0; ; i++ {
+			for id := uint64(1); id < 5; id++ {
+				s.updateStore(c, clusterInfo, id, 100, 60, 0, 0)
+			}
+			_, bop, err := ab.Balance(clusterInfo)
+			c.Assert(err, IsNil)
+			if bop == nil {
+				return i
+			}
+			op := bop.Ops[0].(*transferLeaderOperator)
+			c.Assert(op.OldLeader.GetStoreId(), Equals, uint64(1))
+			c.Assert(op.NewLeader.GetStoreId(), Equals, uint64(3))
+			clusterInfo.regions.leaders.update(op.RegionID, op.NewLeader.GetStoreId())
+		}
+	}
+
+	// All the leaders are in store 1, the leaders of the range drift to zone z2.
+	clusterInfo := newCluster(func(uint64) uint64 { return 1 })
+	c.Assert(balance(clusterInfo), Equals, 4)
+	for i := uint64(0); i < 12; i++ {
+		_, leader := clusterInfo.regions.getRegionByID(200 + i)
+		if i >= 4 && i < 8 {
+			c.Assert(leader.GetStoreId(), Equals, uint64(3))
+		} else {
+			c.Assert(leader.GetStoreId(), Equals, uint64(1))
+		}
+	}
+
+	// The start key is inside region 204, it isn't in the range, but the
+	// regions after it are.
+	clusterInfo = newCluster(func(uint64) uint64 { return 1 })
+	clusterInfo.setLeaderAffinities([]*LeaderAffinity{{
+		Location: "dc2",
+		KeyRange: KeyRange{StartKey: []byte{4, 0x80}, EndKey: []byte{8}},
+		Labels:   map[string]string{"zone": "z2"},
+	}})
+	c.Assert(balance(clusterInfo), Equals, 3)
+	for i := uint64(0); i < 12; i++ {
+		_, leader := clusterInfo.regions.getRegionByID(200 + i)
+		if i >= 5 && i < 8 {
+			c.Assert(leader.GetStoreId(), Equals, uint64(3))
+		} else {
+			c.Assert(leader.GetStoreId(), Equals, uint64(1))
+		}
+	}
+
+	// Store 3 has most of the leaders, the affinity doesn't unbalance it more.
+	clusterInfo = newCluster(func(i uint64) uint64 {
+		if i >= 4 && i < 8 {
+			return 1
+		}
+		return 3
+	})
+	c.Assert(balance(clusterInfo), Equals, 0)
+
+	// The leader balancer runs with the affinity, they don't move the
+	// leaders of the range back and forth, and they settle down.
+	clusterInfo = newCluster(func(uint64) uint64 { return 1 })
+	balancers := []Balancer{newLeaderBalancer(testCfg), newLeaderAffinityBalancer(testCfg)}
+	lastMove := -1
+	for i := 0; i < 100; i++ {
+		for _, b := range balancers {
+			for id := uint64(1); id < 5; id++ {
+				s.updateStore(c, clusterInfo, id, 100, 60, 0, 0)
+			}
+			_, bop, err := b.Balance(clusterInfo)
+			c.Assert(err, IsNil)
+			if bop == nil {
+				continue
+			}
+			op := bop.Ops[0].(*transferLeaderOperator)
+			if op.RegionID >= 204 && op.RegionID < 208 {
+				c.Assert(op.OldLeader.GetStoreId(), Not(Equals), uint64(3))
+			}
+			clusterInfo.regions.leaders.update(op.RegionID, op.NewLeader.GetStoreId())
+			lastMove = i
+		}
+	}
+	c.Assert(lastMove < 50, IsTrue, Commentf("last move in pass %d", lastMove))
+	affinityLeaders := 0
+	for id := uint64(204); id < 208; id++ {
+		if _, leader := clusterInfo.regions.getRegionByID(id); leader.GetStoreId() == 3 {
+			affinityLeaders++
+		}
+	}
+	c.Assert(affinityLeaders > 0, IsTrue)
+}
+
+func (s *testBalancerSuite) TestDrainStores(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, _ := clusterInfo.regions.getRegion([]byte("a"))
+	clusterInfo.regions.removeRegion(region)
+
+	// Add store 10, 11, the store id will be 1,2,3,4,10,11.
+	for _, id := range []uint64{10, 11} {
+		clusterInfo.addStore(s.newStore(c, id, fmt.Sprintf("127.0.0.1:%d", id)))
+	}
+	// Region 200 is (1,2,3), region 201 is (1,3,4), so store 2 is the
+	// nearest to empty after store 1 and 2 are offline.
+	region = s.newRegion(c, 200, []byte{}, []byte("m"), []*metapb.Peer{
+		s.newPeer(c, 1, 100),
+		s.newPeer(c, 2, 101),
+		s.newPeer(c, 3, 102),
+	}, nil)
+	clusterInfo.regions.addRegion(region)
+	clusterInfo.regions.leaders.update(region.GetId(), 3)
+	other := s.newRegion(c, 201, []byte("m"), []byte{}, []*metapb.Peer{
+		s.newPeer(c, 1, 103),
+		s.newPeer(c, 3, 104),
+		s.newPeer(c, 4, 105),
+	}, nil)
+	clusterInfo.regions.addRegion(other)
+
+	// The offline stores have the lowest used ratio, but they are never
+	// the target.
+	for i, id := range []uint64{1, 2, 3, 4, 10, 11} {
+		s.updateStore(c, clusterInfo, id, 100, uint64(90-i*10), 0, 0)
+	}
+	c.Assert(clusterInfo.setStoreMeta(1, storeMeta{State: StoreStateOffline}), IsTrue)
+	c.Assert(clusterInfo.setStoreMeta(2, storeMeta{State: StoreStateOffline}), IsTrue)
+
+	leader := leaderPeer(region, 3)
+	var addedStores, removedStores []uint64
+	for {
+		rb := newReplicaBalancer(region, leader, nil, s.cfg)
+		_, bop, err := rb.Balance(clusterInfo)
+		c.Assert(err, IsNil)
+		if bop == nil {
+			break
+		}
+		op := bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+		peer := op.ChangePeer.GetPeer()
+		if op.ChangePeer.GetChangeType() == raftpb.ConfChangeType_AddNode {
+			addedStores = append(addedStores, peer.GetStoreId())
+			addRegionPeer(c, region, peer)
+		} else {
+			removedStores = append(removedStores, peer.GetStoreId())
+			removeRegionPeer(c, region, peer)
+		}
+		clusterInfo.regions.updateRegion(region)
+	}
+
+	c.Assert(addedStores, DeepEquals, []uint64{4, 10})
+	c.Assert(removedStores, DeepEquals, []uint64{2, 1})
+	for _, peer := range region.GetPeers() {
+		c.Assert(clusterInfo.getStore(peer.GetStoreId()).isOffline(), IsFalse)
+	}
+}
+
+func (s *testBalancerSuite) TestDrainOfflineStores(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, _ := clusterInfo.regions.getRegion([]byte("a"))
+	clusterInfo.regions.removeRegion(region)
+	clusterInfo.addStore(s.newStore(c, 10, "127.0.0.1:10"))
+
+	// Store 1 has 2 regions and store 2 has 1 region, store 10 is the only
+	// store to move them to.
+	keys := [][]byte{{}, []byte("b"), []byte("c"), {}}
+	storeIDs := [][]uint64{{1, 3, 4}, {1, 3, 4}, {2, 3, 4}}
+	for i := uint64(0); i < 3; i++ {
+		peers := make([]*metapb.Peer, 0, 3)
+		for j, storeID := range storeIDs[i] {
+			peers = append(peers, s.newPeer(c, storeID, 100+i*10+uint64(j)))
+		}
+		region = s.newRegion(c, 200+i, keys[i], keys[i+1], peers, nil)
+		clusterInfo.regions.addRegion(region)
+		clusterInfo.regions.leaders.update(region.GetId(), 3)
+	}
+	for _, id := range []uint64{1, 2, 3, 4, 10} {
+		s.updateStore(c, clusterInfo, id, 100, 50, 0, 0)
+	}
+	c.Assert(clusterInfo.setStoreMeta(1, storeMeta{State: StoreStateOffline}), IsTrue)
+	c.Assert(clusterInfo.setStoreMeta(2, storeMeta{State: StoreStateOffline}), IsTrue)
+
+	cfg := *s.cfg
+	cfg.MaxBalanceCountPerLoop = 1
+	cfg.StoreBalanceRate = 1000
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(&cfg))
+
+	// The region of store 2 is moved first, it's nearer to empty.
+	checkDrained := func(bops []*balanceOperator, regionIDs ...uint64) {
+		c.Assert(bops, HasLen, len(regionIDs))
+		drained := make(map[uint64]bool)
+		for _, bop := range bops {
+			op := bop.Ops[0].(*onceOperator).Op.(*changePeerOperator)
+			c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+			c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(10))
+			drained[bop.getRegionID()] = true
+		}
+		for _, regionID := range regionIDs {
+			c.Assert(drained[regionID], IsTrue)
+		}
+	}
+	checkDrained(bw.drainOfflineStores(), 202)
+
+	// Both stores are drained at the same time.
+	cfg.MaxBalanceCountPerLoop = 10
+	checkDrained(bw.drainOfflineStores(), 200, 201)
+	c.Assert(bw.drainOfflineStores(), HasLen, 0)
+}
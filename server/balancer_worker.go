@@ -14,6 +14,7 @@
 package server
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -31,33 +32,70 @@ type balancerWorker struct {
 	// Balancer can use it?
 	balanceOperators map[uint64]*balanceOperator
 
-	balancers []Balancer
-	cfg       *BalanceConfig
+	// cfg returns the current balance config, which must not be changed.
+	cfg func() *BalanceConfig
+
+	// balancer name -> the time the balancer is paused until.
+	pausedUntil map[string]time.Time
+	// the balancers in dry-run mode, their operators are not executed.
+	dryRun          map[string]bool
+	dryRunOperators *lruCache
+	// the built-in balancers removed by the user.
+	removed map[string]bool
+	// no operator is generated or executed in maintenance mode.
+	maintenance bool
+	// the time the import mode expires, before which the region balancers
+	// are suppressed, see setImportMode.
+	importUntil time.Time
+	// limits the add-peer and remove-peer operations of the stores.
+	storeLimiter *storeLimiter
 
 	regionCache      *expireRegionCache
 	historyOperators *lruCache
 	events           *fifoCache
 
+	// the IDs of the orphan peers found in the last check, guarded by
+	// passLock.
+	orphanPeers map[uint64]struct{}
+
+	// serializes the scheduling passes.
+	passLock sync.Mutex
+
 	quit chan struct{}
 }
 
-func newBalancerWorker(cluster *clusterInfo, cfg *BalanceConfig) *balancerWorker {
+func newBalancerWorker(cluster *clusterInfo, getCfg func() *BalanceConfig) *balancerWorker {
+	cfg := getCfg()
 	bw := &balancerWorker{
-		cfg:              cfg,
+		cfg:              getCfg,
 		cluster:          cluster,
 		balanceOperators: make(map[uint64]*balanceOperator),
+		pausedUntil:      make(map[string]time.Time),
+		dryRun:           make(map[string]bool),
+		removed:          make(map[string]bool),
+		dryRunOperators:  newLRUCache(100),
+		storeLimiter:     newStoreLimiter(),
 		regionCache:      newExpireRegionCache(time.Duration(cfg.BalanceInterval)*time.Second, 4*time.Duration(cfg.BalanceInterval)*time.Second),
 		historyOperators: newLRUCache(100),
-		events:           newFifoCache(10000),
+		events:           newFifoCache(int(cfg.MaxEventCount)),
 		quit:             make(chan struct{}),
 	}
 
-	bw.balancers = append(bw.balancers, newLeaderBalancer(cfg))
-	bw.balancers = append(bw.balancers, newCapacityBalancer(cfg))
-
 	return bw
 }
 
+// builtinBalancers returns the balancers created with the worker, they
+// are created with the current config, and can be removed and added back
+// by name.
+func (bw *balancerWorker) builtinBalancers() []Balancer {
+	cfg := bw.cfg()
+	return []Balancer{
+		newLeaderBalancer(cfg),
+		newCapacityBalancer(cfg),
+		newLeaderAffinityBalancer(cfg),
+	}
+}
+
 func (bw *balancerWorker) run() {
 	bw.wg.Add(1)
 	go bw.workBalancer()
@@ -66,7 +104,7 @@ func (bw *balancerWorker) run() {
 func (bw *balancerWorker) workBalancer() {
 	defer bw.wg.Done()
 
-	timer := time.NewTimer(time.Duration(bw.cfg.BalanceInterval) * time.Second)
+	timer := time.NewTimer(time.Duration(bw.cfg().BalanceInterval) * time.Second)
 	defer timer.Stop()
 
 	for {
@@ -74,16 +112,30 @@ func (bw *balancerWorker) workBalancer() {
 		case <-bw.quit:
 			return
 		case <-timer.C:
-			err := bw.doBalance()
-			if err != nil {
-				log.Warnf("do balance failed - %v", errors.ErrorStack(err))
-			}
-
-			timer.Reset(time.Duration(bw.cfg.BalanceInterval) * time.Second)
+			bw.runPass()
+			timer.Reset(time.Duration(bw.cfg().BalanceInterval) * time.Second)
 		}
 	}
 }
 
+// runPass runs a scheduling pass, and returns the operators added. The
+// passes of the timer and the manual runs are serialized.
+func (bw *balancerWorker) runPass() []*balanceOperator {
+	bw.passLock.Lock()
+	defer bw.passLock.Unlock()
+
+	bw.removeTimeoutOperators()
+	bops, err := bw.balance()
+	if err != nil {
+		log.Warnf("do balance failed - %v", errors.ErrorStack(err))
+	}
+	if err = bw.doDryRun(); err != nil {
+		log.Warnf("do dry-run balance failed - %v", errors.ErrorStack(err))
+	}
+	bops = append(bops, bw.drainOfflineStores()...)
+	return append(bops, bw.checkOrphanPeers()...)
+}
+
 func (bw *balancerWorker) stop() {
 	close(bw.quit)
 	bw.wg.Wait()
@@ -120,24 +172,124 @@ func (bw *balancerWorker) addBalanceOperator(regionID uint64, op *balanceOperato
 	return true
 }
 
+// addManualBalanceOperator adds the operator requested by the user, it is
+// not limited by the region cache, but the region is still put into the
+// cache, so the balancers don't move it again soon.
+func (bw *balancerWorker) addManualBalanceOperator(regionID uint64, op *balanceOperator) bool {
+	bw.Lock()
+	defer bw.Unlock()
+
+	if _, ok := bw.balanceOperators[regionID]; ok {
+		return false
+	}
+
+	op.Start = time.Now()
+	bw.balanceOperators[regionID] = op
+	bw.historyOperators.add(regionID, op)
+	bw.addRegionCache(regionID)
+
+	return true
+}
+
 func (bw *balancerWorker) removeBalanceOperator(regionID uint64) {
+	if !bw.finishBalanceOperator(regionID, OperatorSuccess, "") {
+		log.Errorf("balancer operator is empty to remove - %d", regionID)
+	}
+}
+
+// finishBalanceOperator removes the operator of the region and records its
+// outcome in the history, the failed step is also posted as an event. It
+// returns false if the region has no operator.
+func (bw *balancerWorker) finishBalanceOperator(regionID uint64, status OperatorOutcome, reason string) bool {
 	bw.Lock()
 	defer bw.Unlock()
 
-	// Log balancer information.
 	op, ok := bw.balanceOperators[regionID]
-	if ok {
-		op.End = time.Now()
-		log.Infof("balancer operator finished - %s", op)
-	} else {
-		log.Errorf("balancer operator is empty to remove - %d", regionID)
+	if !ok {
+		return false
 	}
 
-	delete(bw.balanceOperators, regionID)
+	bw.innerFinishBalanceOperator(op, status, reason)
+	return true
+}
+
+// finishCurrentBalanceOperator is like finishBalanceOperator, but it only
+// removes op if op is still the operator of its region, so an operator
+// which has been replaced doesn't remove the newer one. It returns false
+// if op is not the current one.
+func (bw *balancerWorker) finishCurrentBalanceOperator(op *balanceOperator, status OperatorOutcome, reason string) bool {
+	bw.Lock()
+	defer bw.Unlock()
+
+	if bw.balanceOperators[op.getRegionID()] != op {
+		return false
+	}
+
+	bw.innerFinishBalanceOperator(op, status, reason)
+	return true
+}
+
+func (bw *balancerWorker) innerFinishBalanceOperator(op *balanceOperator, status OperatorOutcome, reason string) {
+	op.End = time.Now()
+	op.Status = status
+	op.Reason = reason
+	log.Infof("balancer operator %s - %s", status, op)
+	if status != OperatorSuccess {
+		bw.postOutcomeEvent(op)
+	}
 
+	regionID := op.getRegionID()
+	delete(bw.balanceOperators, regionID)
 	bw.historyOperators.add(regionID, op)
 }
 
+// cancelBalanceOperator removes the operator of the region and releases the
+// region, it returns false if the region has no operator.
+func (bw *balancerWorker) cancelBalanceOperator(regionID uint64) bool {
+	if !bw.finishBalanceOperator(regionID, OperatorCanceled, "canceled by user") {
+		return false
+	}
+	bw.removeRegionCache(regionID)
+	return true
+}
+
+// checkTimeoutOperator removes the operator and releases the region if the
+// operator has run for longer than the max operator wait duration, or its
+// current step has timed out more than the operator step retry times.
+func (bw *balancerWorker) checkTimeoutOperator(op *balanceOperator) bool {
+	var reason string
+	cfg := bw.cfg()
+	maxWait := cfg.MaxOperatorWaitDuration.Duration
+	stepTimeout := cfg.OperatorStepTimeout.Duration
+	if op.isTimeout(maxWait) {
+		reason = fmt.Sprintf("running for more than %s", maxWait)
+	} else if timedOut, index := op.checkStepTimeout(stepTimeout, int(cfg.OperatorStepRetry)); timedOut {
+		reason = fmt.Sprintf("step %d timed out after %d retries", index, cfg.OperatorStepRetry)
+	} else {
+		return false
+	}
+	if !bw.finishCurrentBalanceOperator(op, OperatorTimeout, reason) {
+		return false
+	}
+	bw.removeRegionCache(op.getRegionID())
+	return true
+}
+
+// removeTimeoutOperators removes the timeout operators, so their slots are
+// freed even if the regions don't report any more.
+func (bw *balancerWorker) removeTimeoutOperators() {
+	bw.RLock()
+	ops := make([]*balanceOperator, 0, len(bw.balanceOperators))
+	for _, op := range bw.balanceOperators {
+		ops = append(ops, op)
+	}
+	bw.RUnlock()
+
+	for _, op := range ops {
+		bw.checkTimeoutOperator(op)
+	}
+}
+
 func (bw *balancerWorker) addRegionCache(regionID uint64) {
 	bw.regionCache.set(regionID, nil)
 }
@@ -165,6 +317,31 @@ func (bw *balancerWorker) getBalanceOperators() map[uint64]Operator {
 	return balanceOperators
 }
 
+func (bw *balancerWorker) getOperatorStatuses() []*OperatorStatus {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	statuses := make([]*OperatorStatus, 0, len(bw.balanceOperators))
+	for _, op := range bw.balanceOperators {
+		statuses = append(statuses, op.getStatus())
+	}
+
+	return statuses
+}
+
+// getStatuses returns the status of the operators.
+func (bw *balancerWorker) getStatuses(bops []*balanceOperator) []*OperatorStatus {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	statuses := make([]*OperatorStatus, 0, len(bops))
+	for _, op := range bops {
+		statuses = append(statuses, op.getStatus())
+	}
+
+	return statuses
+}
+
 func (bw *balancerWorker) getHistoryOperators() []Operator {
 	bw.RLock()
 	defer bw.RUnlock()
@@ -178,54 +355,337 @@ func (bw *balancerWorker) getHistoryOperators() []Operator {
 	return operators
 }
 
+// getBalancers returns the balancers which are not removed and the
+// evict-leader balancers of the stores which the leaders are being moved
+// out of.
+func (bw *balancerWorker) getBalancers() []Balancer {
+	builtin := bw.builtinBalancers()
+	balancers := make([]Balancer, 0, len(builtin))
+	for _, balancer := range builtin {
+		if !bw.isBalancerRemoved(balancer.GetName()) {
+			balancers = append(balancers, balancer)
+		}
+	}
+	for _, storeID := range bw.cluster.getEvictLeaderStores() {
+		balancers = append(balancers, newEvictLeaderBalancer(storeID, bw.cfg()))
+	}
+	return balancers
+}
+
+func (bw *balancerWorker) getBalancer(name string) Balancer {
+	for _, balancer := range bw.getBalancers() {
+		if balancer.GetName() == name {
+			return balancer
+		}
+	}
+
+	return nil
+}
+
+// isBuiltinBalancer returns whether the balancer is created with the
+// worker, these balancers can be removed and added back by name.
+func (bw *balancerWorker) isBuiltinBalancer(name string) bool {
+	for _, balancer := range bw.builtinBalancers() {
+		if balancer.GetName() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setBalancerRemoved sets whether the built-in balancer is removed.
+func (bw *balancerWorker) setBalancerRemoved(name string, removed bool) {
+	bw.Lock()
+	defer bw.Unlock()
+
+	if !removed {
+		delete(bw.removed, name)
+		return
+	}
+	bw.removed[name] = true
+}
+
+// removedBalancersAfter returns the names of the removed built-in
+// balancers as if the balancer is removed or added back.
+func (bw *balancerWorker) removedBalancersAfter(name string, removed bool) []string {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	var names []string
+	for _, balancer := range bw.builtinBalancers() {
+		n := balancer.GetName()
+		if (n == name && removed) || (n != name && bw.removed[n]) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+func (bw *balancerWorker) isBalancerRemoved(name string) bool {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	return bw.removed[name]
+}
+
+// pauseBalancer pauses the balancer until the time, a zero time resumes it.
+func (bw *balancerWorker) pauseBalancer(name string, until time.Time) {
+	bw.Lock()
+	defer bw.Unlock()
+
+	if until.IsZero() {
+		delete(bw.pausedUntil, name)
+		return
+	}
+	bw.pausedUntil[name] = until
+}
+
+// getPausedUntil returns the time the balancer is paused until,
+// it returns a zero time if the balancer is not paused.
+func (bw *balancerWorker) getPausedUntil(name string) time.Time {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	until, ok := bw.pausedUntil[name]
+	if !ok || !time.Now().Before(until) {
+		return time.Time{}
+	}
+
+	return until
+}
+
+func (bw *balancerWorker) isBalancerPaused(name string) bool {
+	return !bw.getPausedUntil(name).IsZero()
+}
+
+// setMaintenance sets whether the cluster is in maintenance mode.
+func (bw *balancerWorker) setMaintenance(enabled bool) {
+	bw.Lock()
+	defer bw.Unlock()
+
+	bw.maintenance = enabled
+}
+
+func (bw *balancerWorker) inMaintenance() bool {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	return bw.maintenance
+}
+
+// setImportMode enables the import mode until the time, a zero time
+// disables it. During a bulk load the region balancers, whose operators
+// send snapshots, compete with the ingestion, so they are suppressed and
+// at most one operator is added in a loop. The replicas are still checked
+// by the region heartbeats.
+func (bw *balancerWorker) setImportMode(until time.Time) {
+	bw.Lock()
+	defer bw.Unlock()
+
+	bw.importUntil = until
+}
+
+func (bw *balancerWorker) inImportMode() bool {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	return time.Now().Before(bw.importUntil)
+}
+
+// setBalancerDryRun sets whether the balancer runs in dry-run mode.
+func (bw *balancerWorker) setBalancerDryRun(name string, dryRun bool) {
+	bw.Lock()
+	defer bw.Unlock()
+
+	if !dryRun {
+		delete(bw.dryRun, name)
+		return
+	}
+	bw.dryRun[name] = true
+}
+
+func (bw *balancerWorker) isBalancerDryRun(name string) bool {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	return bw.dryRun[name]
+}
+
+// dryRunOperator is the operator generated by a dry-run balancer.
+type dryRunOperator struct {
+	bop *balanceOperator
+	// whether the operator would be executed if it is not a dry-run.
+	wouldExecute bool
+}
+
+// DryRunOperatorStatus is the operator generated by a dry-run scheduler.
+type DryRunOperatorStatus struct {
+	*OperatorStatus
+	WouldExecute bool `json:"would_execute"`
+}
+
+func (bw *balancerWorker) getDryRunOperatorStatuses() []*DryRunOperatorStatus {
+	bw.RLock()
+	defer bw.RUnlock()
+
+	elems := bw.dryRunOperators.elems()
+	statuses := make([]*DryRunOperatorStatus, 0, len(elems))
+	for _, elem := range elems {
+		op := elem.value.(*dryRunOperator)
+		statuses = append(statuses, &DryRunOperatorStatus{
+			OperatorStatus: op.bop.getStatus(),
+			WouldExecute:   op.wouldExecute,
+		})
+	}
+
+	return statuses
+}
+
 // allowBalance indicates that whether we can add more balance operator or not.
 func (bw *balancerWorker) allowBalance() bool {
 	bw.RLock()
 	balanceCount := uint64(len(bw.balanceOperators))
+	maintenance := bw.maintenance
 	bw.RUnlock()
 
+	if maintenance {
+		return false
+	}
+
 	// TODO: We should introduce more strategies to control
 	// how many balance tasks at same time.
-	if balanceCount >= bw.cfg.MaxBalanceCount {
+	if balanceCount >= bw.cfg().MaxBalanceCount {
 		return false
 	}
 
 	return true
 }
 
+// allowBalancer indicates that whether the balancer can add more balance operator or not,
+// the leader and region balance operators are limited separately.
+func (bw *balancerWorker) allowBalancer(balancer Balancer) bool {
+	cfg := bw.cfg()
+	limit, minRegionCount := cfg.RegionScheduleLimit, cfg.MinRegionCount
+	if balancer.ScoreType() == leaderScore {
+		limit, minRegionCount = cfg.LeaderScheduleLimit, cfg.MinLeaderRegionCount
+	}
+	if uint64(bw.cluster.regions.regionCount()) < minRegionCount {
+		return false
+	}
+
+	bw.RLock()
+	defer bw.RUnlock()
+
+	count := uint64(0)
+	for _, bop := range bw.balanceOperators {
+		if bop.isTransferLeader() == (balancer.ScoreType() == leaderScore) {
+			count++
+		}
+	}
+
+	return count < limit
+}
+
+// allowNamespace checks the schedule limits of the namespace which the
+// region of the operator belongs to, the operators of the same kind in the
+// namespace are counted.
+func (bw *balancerWorker) allowNamespace(bop *balanceOperator) bool {
+	ns := bw.cluster.getNamespace(bop.Region)
+	if ns == nil {
+		return true
+	}
+	limit := ns.RegionScheduleLimit
+	if bop.isTransferLeader() {
+		limit = ns.LeaderScheduleLimit
+	}
+	if limit == 0 {
+		return true
+	}
+
+	bw.RLock()
+	defer bw.RUnlock()
+
+	count := uint64(0)
+	for _, op := range bw.balanceOperators {
+		if op.isTransferLeader() != bop.isTransferLeader() {
+			continue
+		}
+		if opNs := bw.cluster.getNamespace(op.Region); opNs != nil && opNs.Name == ns.Name {
+			count++
+		}
+	}
+
+	return count < limit
+}
+
 func (bw *balancerWorker) doBalance() error {
-	balanceCount := uint64(0)
-	for i := uint64(0); i < bw.cfg.MaxBalanceRetryPerLoop; i++ {
-		if balanceCount >= bw.cfg.MaxBalanceCountPerLoop {
-			return nil
+	_, err := bw.balance()
+	return errors.Trace(err)
+}
+
+// balance adds the balance operators until no more can be added in a loop,
+// and returns the operators added.
+func (bw *balancerWorker) balance() ([]*balanceOperator, error) {
+	var added []*balanceOperator
+	cfg := bw.cfg()
+	maxCount := cfg.MaxBalanceCountPerLoop
+	importMode := bw.inImportMode()
+	if importMode {
+		maxCount = 1
+	}
+	for i := uint64(0); i < cfg.MaxBalanceRetryPerLoop; i++ {
+		if uint64(len(added)) >= maxCount {
+			return added, nil
 		}
 
 		if !bw.allowBalance() {
-			return nil
+			return added, nil
 		}
 
 		balancerCounter.WithLabelValues("total").Inc()
 
-		scores := make([]*score, 0, len(bw.balancers))
-		bops := make([]*balanceOperator, 0, len(bw.balancers))
+		balancers := bw.getBalancers()
+		scores := make([]*score, 0, len(balancers))
+		bops := make([]*balanceOperator, 0, len(balancers))
 
 		// Find the balance operator candidates.
-		for _, balancer := range bw.balancers {
+		for _, balancer := range balancers {
+			if bw.isBalancerPaused(balancer.GetName()) || bw.isBalancerDryRun(balancer.GetName()) || !bw.allowBalancer(balancer) {
+				continue
+			}
+			if importMode && balancer.ScoreType() != leaderScore {
+				balancerCounter.WithLabelValues("import_mode").Inc()
+				continue
+			}
+
 			score, balanceOperator, err := balancer.Balance(bw.cluster)
 			if err != nil {
 				balancerCounter.WithLabelValues("failed").Inc()
-				return errors.Trace(err)
+				return added, errors.Trace(err)
 			}
 			if balanceOperator == nil {
 				continue
 			}
+			if bw.isRegionStale(balanceOperator.getRegionID()) {
+				balancerCounter.WithLabelValues("stale").Inc()
+				continue
+			}
+			if !bw.allowNamespace(balanceOperator) {
+				balancerCounter.WithLabelValues("namespace_limit").Inc()
+				continue
+			}
+			if !bw.allowStoreLimit(balanceOperator) {
+				balancerCounter.WithLabelValues("store_limit").Inc()
+				continue
+			}
 
 			scores = append(scores, score)
 			bops = append(bops, balanceOperator)
 		}
 
 		// Calculate the priority of candidates score.
-		idx, score := priorityScore(bw.cfg, scores)
+		idx, score := priorityScore(cfg, scores)
 		if score == nil {
 			balancerCounter.WithLabelValues("none").Inc()
 			continue
@@ -234,19 +694,76 @@ func (bw *balancerWorker) doBalance() error {
 		bop := bops[idx]
 		regionID := bop.getRegionID()
 		if bw.addBalanceOperator(regionID, bop) {
+			bw.takeStoreLimit(bop)
 			bw.addRegionCache(regionID)
 			balancerCounter.WithLabelValues("successed").Inc()
-			balanceCount++
+			added = append(added, bop)
 		}
 	}
 
 	log.Info("find no proper region for balance, retry later")
+	return added, nil
+}
+
+// isRegionStale checks whether the region hasn't reported heartbeats for
+// too long, the peers and leader in the cache may be outdated. The time is
+// refreshed by the heartbeat of any leader, so a region whose leader moved
+// is scheduled again after the new leader reports. The regions which
+// haven't reported since the cache is built have no leader and are never
+// chosen by the balancers.
+func (bw *balancerWorker) isRegionStale(regionID uint64) bool {
+	age, ok := bw.cluster.regions.heartbeatAge(regionID)
+	return ok && age > bw.cfg().MaxRegionHeartbeatAge.Duration
+}
+
+// doDryRun records the operators the dry-run balancers generate, the
+// operators are not executed and don't count in the balance limits.
+func (bw *balancerWorker) doDryRun() error {
+	for _, balancer := range bw.getBalancers() {
+		if !bw.isBalancerDryRun(balancer.GetName()) || bw.isBalancerPaused(balancer.GetName()) {
+			continue
+		}
+
+		candidate, bop, err := balancer.Balance(bw.cluster)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if bop == nil {
+			continue
+		}
+		// Same as doBalance, the operator is ignored if the score is low.
+		if _, candidate = priorityScore(bw.cfg(), []*score{candidate}); candidate == nil {
+			continue
+		}
+
+		regionID := bop.getRegionID()
+		bw.RLock()
+		_, hasOperator := bw.balanceOperators[regionID]
+		bw.RUnlock()
+		_, isCached := bw.regionCache.get(regionID)
+		wouldExecute := !hasOperator && !isCached && bw.allowBalance() && bw.allowBalancer(balancer)
+
+		bop.Start = time.Now()
+		log.Infof("dry-run balancer operator - %s, would execute: %v", bop, wouldExecute)
+
+		bw.Lock()
+		bw.dryRunOperators.add(regionID, &dryRunOperator{bop: bop, wouldExecute: wouldExecute})
+		bw.Unlock()
+	}
+
 	return nil
 }
 
 func (bw *balancerWorker) storeScores(store *storeInfo) []int {
-	scores := make([]int, 0, len(bw.balancers))
-	for _, balancer := range bw.balancers {
+	balancers := bw.builtinBalancers()
+	scores := make([]int, 0, len(balancers))
+	scored := make(map[scoreType]bool)
+	for _, balancer := range balancers {
+		// The balancers of the same score type give the same score.
+		if scored[balancer.ScoreType()] {
+			continue
+		}
+		scored[balancer.ScoreType()] = true
 		scorer := newScorer(balancer.ScoreType())
 		if scorer != nil {
 			scores = append(scores, scorer.Score(store))
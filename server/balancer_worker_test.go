@@ -14,8 +14,12 @@
 package server
 
 import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
 	. "github.com/pingcap/check"
 	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 )
 
 var _ = Suite(&testBalancerWorkerSuite{})
@@ -43,7 +47,7 @@ func (s *testBalancerWorkerSuite) TestBalancerWorker(c *C) {
 	c.Assert(region.GetPeers(), HasLen, 1)
 	c.Assert(leader, NotNil)
 
-	s.balancerWorker = newBalancerWorker(clusterInfo, s.ts.cfg)
+	s.balancerWorker = newBalancerWorker(clusterInfo, balanceConfigGetter(s.ts.cfg))
 
 	// The store id will be 1,2,3,4.
 	s.ts.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
@@ -135,20 +139,434 @@ func (s *testBalancerWorkerSuite) TestBalancerWorker(c *C) {
 
 	// Now the region is (1,3,4), leader is 3/4, the balance operators should be
 	// 1) add peer: 2
-	// 2) remove peer: 3/4
+	// 2) wait for the new peer to catch up
+	// 3) remove peer: 3/4
 	regionID = region.GetId()
 	bop, ok = s.balancerWorker.balanceOperators[regionID]
 	c.Assert(ok, IsTrue)
-	c.Assert(bop.Ops, HasLen, 2)
+	c.Assert(bop.Ops, HasLen, 3)
 
 	op1 := bop.Ops[0].(*changePeerOperator)
 	c.Assert(op1.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
 	c.Assert(op1.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
 
-	op2 := bop.Ops[1].(*changePeerOperator)
+	op2 := bop.Ops[2].(*changePeerOperator)
 	c.Assert(op2.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_RemoveNode)
 	c.Assert(op2.ChangePeer.GetPeer().GetStoreId(), Not(Equals), uint64(2))
 
 	c.Assert(s.balancerWorker.balanceOperators, HasLen, 1)
 	c.Assert(s.balancerWorker.regionCache.count(), Equals, 1)
 }
+
+func (s *testBalancerWorkerSuite) TestScheduleLimit(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	cfg.MaxLeaderCount = 1
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	// The store id will be 1,2,3,4.
+	s.ts.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	// Add two peers, the region is (1,3,4) and leader is 1.
+	s.ts.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.ts.addRegionPeer(c, clusterInfo, 3, region, leader)
+
+	// All the balance operators are limited, so we can't do balance.
+	cfg.LeaderScheduleLimit = 0
+	cfg.RegionScheduleLimit = 0
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 0)
+
+	// Now we can transfer leader.
+	cfg.LeaderScheduleLimit = 1
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 1)
+	bop := bw.balanceOperators[region.GetId()]
+	c.Assert(bop.isTransferLeader(), IsTrue)
+
+	// The leader balance operator count reaches the limit.
+	c.Assert(bw.allowBalancer(newLeaderBalancer(cfg)), IsFalse)
+	c.Assert(bw.allowBalancer(newCapacityBalancer(cfg)), IsFalse)
+	cfg.RegionScheduleLimit = 1
+	c.Assert(bw.allowBalancer(newCapacityBalancer(cfg)), IsTrue)
+}
+
+func (s *testBalancerWorkerSuite) TestMinRegionCount(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	cfg.MaxLeaderCount = 1
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	// The store id will be 1,2,3,4.
+	s.ts.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	// Add two peers, the region is (1,3,4) and leader is 1.
+	s.ts.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.ts.addRegionPeer(c, clusterInfo, 3, region, leader)
+
+	// The cluster has less regions than the min region counts.
+	cfg.MinRegionCount = 2
+	cfg.MinLeaderRegionCount = 2
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 0)
+	c.Assert(bw.allowBalancer(newLeaderBalancer(cfg)), IsFalse)
+	c.Assert(bw.allowBalancer(newCapacityBalancer(cfg)), IsFalse)
+
+	// The leader is transferred after the cluster has enough regions for
+	// the leader balancers.
+	cfg.MinLeaderRegionCount = 1
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 1)
+	c.Assert(bw.balanceOperators[region.GetId()].isTransferLeader(), IsTrue)
+	c.Assert(bw.allowBalancer(newCapacityBalancer(cfg)), IsFalse)
+	cfg.MinRegionCount = 1
+	c.Assert(bw.allowBalancer(newCapacityBalancer(cfg)), IsTrue)
+}
+
+func (s *testBalancerWorkerSuite) TestOperatorTimeout(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	cfg.MaxBalanceCount = 1
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	peer := &metapb.Peer{Id: proto.Uint64(100), StoreId: proto.Uint64(2)}
+	bop := newBalanceOperator(region, newAddPeerOperator(region.GetId(), peer))
+	c.Assert(bw.addBalanceOperator(region.GetId(), bop), IsTrue)
+	bw.addRegionCache(region.GetId())
+	c.Assert(bop.Status, Equals, OperatorRunning)
+	c.Assert(bw.allowBalance(), IsFalse)
+
+	// The operator is still in time.
+	bw.removeTimeoutOperators()
+	c.Assert(bw.balanceOperators, HasLen, 1)
+
+	// The operator runs for too long, it is removed and its slot is freed.
+	bop.Start = time.Now().Add(-cfg.MaxOperatorWaitDuration.Duration - time.Minute)
+	bw.removeTimeoutOperators()
+	c.Assert(bw.balanceOperators, HasLen, 0)
+	c.Assert(bw.regionCache.count(), Equals, 0)
+	c.Assert(bw.allowBalance(), IsTrue)
+
+	history := bw.getHistoryOperators()
+	c.Assert(history, HasLen, 1)
+	c.Assert(history[0].(*balanceOperator).Status, Equals, OperatorTimeout)
+	c.Assert(history[0].(*balanceOperator).Reason, Not(Equals), "")
+
+	evts := bw.latestEvents(1)
+	c.Assert(evts, HasLen, 1)
+	c.Assert(evts[0].Status, Equals, evtTimeout)
+	c.Assert(evts[0].AddReplicaEvent.Store, Equals, uint64(2))
+
+	// A timed out operator which has been replaced doesn't remove the new
+	// operator of the region.
+	stale := bop
+	bop = newBalanceOperator(region, newAddPeerOperator(region.GetId(), peer))
+	c.Assert(bw.addBalanceOperator(region.GetId(), bop), IsTrue)
+	c.Assert(bw.checkTimeoutOperator(stale), IsFalse)
+	c.Assert(bw.getBalanceOperator(region.GetId()), Equals, bop)
+}
+
+func (s *testBalancerWorkerSuite) TestOperatorStepTimeout(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	cfg.OperatorStepRetry = 1
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	// The added peer never shows up in the region, so the step never finishes.
+	peer := &metapb.Peer{Id: proto.Uint64(100), StoreId: proto.Uint64(2)}
+	bop := newBalanceOperator(region, newAddPeerOperator(region.GetId(), peer))
+	c.Assert(bw.addBalanceOperator(region.GetId(), bop), IsTrue)
+	c.Assert(bop.StepStart.IsZero(), IsTrue)
+
+	ctx := newOpContext(nil, nil)
+	finished, res, err := bop.Do(ctx, region, leader)
+	c.Assert(err, IsNil)
+	c.Assert(finished, IsFalse)
+	c.Assert(res.GetChangePeer(), NotNil)
+	c.Assert(bop.StepStart.IsZero(), IsFalse)
+
+	// The step is still in time.
+	bw.removeTimeoutOperators()
+	c.Assert(bw.balanceOperators, HasLen, 1)
+	c.Assert(bop.StepRetries, Equals, 0)
+
+	// The step times out, it is dispatched again on the next heartbeat,
+	// and the timeout of the retried step starts now.
+	bop.StepStart = time.Now().Add(-cfg.OperatorStepTimeout.Duration - time.Second)
+	bw.removeTimeoutOperators()
+	c.Assert(bw.balanceOperators, HasLen, 1)
+	c.Assert(bop.StepRetries, Equals, 1)
+	c.Assert(time.Since(bop.StepStart) < cfg.OperatorStepTimeout.Duration, IsTrue)
+	bw.removeTimeoutOperators()
+	c.Assert(bw.balanceOperators, HasLen, 1)
+
+	// The retried step times out again though the region doesn't report,
+	// the operator is abandoned.
+	bop.StepStart = bop.StepStart.Add(-cfg.OperatorStepTimeout.Duration - time.Second)
+	bw.removeTimeoutOperators()
+	c.Assert(bw.balanceOperators, HasLen, 0)
+	c.Assert(bw.regionCache.count(), Equals, 0)
+
+	history := bw.getHistoryOperators()
+	c.Assert(history, HasLen, 1)
+	c.Assert(history[0].(*balanceOperator).Status, Equals, OperatorTimeout)
+	c.Assert(history[0].(*balanceOperator).Reason, Equals, "step 0 timed out after 1 retries")
+}
+
+func (s *testBalancerWorkerSuite) TestOperatorStepRetry(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+	follower := &metapb.Peer{Id: proto.Uint64(100), StoreId: proto.Uint64(2)}
+	region.Peers = append(region.Peers, follower)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	bop := newBalanceOperator(region, newTransferLeaderOperator(region.GetId(), leader, follower, cfg))
+	c.Assert(bw.addBalanceOperator(region.GetId(), bop), IsTrue)
+
+	// The transfer leader command is sent only once.
+	ctx := newOpContext(nil, nil)
+	_, res, err := bop.Do(ctx, region, leader)
+	c.Assert(err, IsNil)
+	c.Assert(res.GetTransferLeader(), NotNil)
+	_, res, err = bop.Do(ctx, region, leader)
+	c.Assert(err, IsNil)
+	c.Assert(res, IsNil)
+
+	// The step times out, the command is sent again.
+	bop.StepStart = time.Now().Add(-cfg.OperatorStepTimeout.Duration - time.Second)
+	bw.removeTimeoutOperators()
+	c.Assert(bop.StepRetries, Equals, 1)
+	_, res, err = bop.Do(ctx, region, leader)
+	c.Assert(err, IsNil)
+	c.Assert(res.GetTransferLeader(), NotNil)
+}
+
+func (s *testBalancerWorkerSuite) TestPauseBalancer(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	cfg.MaxLeaderCount = 1
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	// The store id will be 1,2,3,4.
+	s.ts.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	// Add two peers, the region is (1,3,4) and leader is 1.
+	s.ts.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.ts.addRegionPeer(c, clusterInfo, 3, region, leader)
+
+	c.Assert(bw.getBalancer("unknown"), IsNil)
+	c.Assert(bw.getBalancer("region"), NotNil)
+
+	// Both the leader and region balancers are paused.
+	until := time.Now().Add(time.Minute)
+	bw.pauseBalancer("leader", until)
+	bw.pauseBalancer("region", until)
+	c.Assert(bw.isBalancerPaused("region"), IsTrue)
+	c.Assert(bw.getPausedUntil("region").Equal(until), IsTrue)
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 0)
+
+	// The pause expires.
+	bw.pauseBalancer("region", time.Now().Add(-time.Second))
+	c.Assert(bw.isBalancerPaused("region"), IsFalse)
+
+	// Resume the leader balancer, we can only transfer leader.
+	bw.pauseBalancer("leader", time.Time{})
+	bw.pauseBalancer("region", until)
+	c.Assert(bw.isBalancerPaused("leader"), IsFalse)
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 1)
+	c.Assert(bw.balanceOperators[region.GetId()].isTransferLeader(), IsTrue)
+}
+
+func (s *testBalancerWorkerSuite) TestDryRun(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	cfg.MaxLeaderCount = 1
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	// The store id will be 1,2,3,4.
+	s.ts.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	// Add two peers, the region is (1,3,4) and leader is 1.
+	s.ts.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.ts.addRegionPeer(c, clusterInfo, 3, region, leader)
+
+	// The leader balancer only reports the operator.
+	bw.setBalancerDryRun("leader", true)
+	bw.pauseBalancer("region", time.Now().Add(time.Minute))
+	c.Assert(bw.isBalancerDryRun("leader"), IsTrue)
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.doDryRun(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 0)
+	c.Assert(bw.regionCache.count(), Equals, 0)
+
+	statuses := bw.getDryRunOperatorStatuses()
+	c.Assert(statuses, HasLen, 1)
+	c.Assert(statuses[0].RegionID, Equals, region.GetId())
+	c.Assert(statuses[0].WouldExecute, IsTrue)
+	c.Assert(statuses[0].Operators, HasLen, 1)
+	op := statuses[0].Operators[0].(*transferLeaderOperator)
+	c.Assert(op.OldLeader.GetStoreId(), Equals, uint64(1))
+
+	// The region is not changed.
+	newRegion, newLeader := clusterInfo.regions.getRegionByID(region.GetId())
+	c.Assert(newRegion, DeepEquals, region)
+	c.Assert(newLeader, DeepEquals, leader)
+
+	// The operator would not be executed if the limit is reached.
+	cfg.LeaderScheduleLimit = 0
+	c.Assert(bw.doDryRun(), IsNil)
+	c.Assert(bw.getDryRunOperatorStatuses()[0].WouldExecute, IsFalse)
+
+	// The balancer works again after dry-run is disabled.
+	cfg.LeaderScheduleLimit = 1
+	bw.setBalancerDryRun("leader", false)
+	c.Assert(bw.doDryRun(), IsNil)
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 1)
+}
+
+func (s *testBalancerWorkerSuite) TestStaleRegion(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	cfg.MaxLeaderCount = 1
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	// The store id will be 1,2,3,4.
+	s.ts.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	// Add two peers, the region is (1,3,4) and leader is 1.
+	s.ts.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.ts.addRegionPeer(c, clusterInfo, 3, region, leader)
+
+	// The region hasn't reported for too long, it is skipped.
+	clusterInfo.regions.touchRegion(region.GetId(), time.Now().Add(-cfg.MaxRegionHeartbeatAge.Duration-time.Minute))
+	c.Assert(bw.isRegionStale(region.GetId()), IsTrue)
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 0)
+
+	// The region reports again and is scheduled.
+	clusterInfo.regions.touchRegion(region.GetId(), time.Now())
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 1)
+	bw.removeBalanceOperator(region.GetId())
+
+	// The region is stale again, then the leader moves to store 3 and the
+	// new leader reports, the region isn't stale any more.
+	clusterInfo.regions.touchRegion(region.GetId(), time.Now().Add(-cfg.MaxRegionHeartbeatAge.Duration-time.Minute))
+	c.Assert(bw.isRegionStale(region.GetId()), IsTrue)
+	_, _, err := clusterInfo.regions.heartbeat(region, leaderPeer(region, 3))
+	c.Assert(err, IsNil)
+	age, ok := clusterInfo.regions.heartbeatAge(region.GetId())
+	c.Assert(ok, IsTrue)
+	c.Assert(age, Less, time.Minute)
+	c.Assert(bw.isRegionStale(region.GetId()), IsFalse)
+}
+
+func (s *testBalancerWorkerSuite) TestImportMode(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+	c.Assert(clusterInfo, NotNil)
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	// The store id will be 1,2,3,4.
+	s.ts.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 3, 100, 30, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 4, 100, 40, 0, 0)
+
+	// Add two peers, the region is (1,3,4) and leader is 1, then store 2
+	// has the most free space. The leader balancer is paused, so only the
+	// region balancer can move the peer in store 3 to store 2.
+	s.ts.addRegionPeer(c, clusterInfo, 4, region, leader)
+	s.ts.addRegionPeer(c, clusterInfo, 3, region, leader)
+	s.ts.updateStore(c, clusterInfo, 2, 100, 90, 0, 0)
+	bw.pauseBalancer("leader", time.Now().Add(time.Minute))
+
+	// The region balancer is suppressed in import mode.
+	bw.setImportMode(time.Now().Add(time.Minute))
+	c.Assert(bw.inImportMode(), IsTrue)
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 0)
+	c.Assert(bw.regionCache.count(), Equals, 0)
+
+	// The import mode expires, the region is balanced again.
+	bw.setImportMode(time.Now().Add(-time.Second))
+	c.Assert(bw.inImportMode(), IsFalse)
+	c.Assert(bw.doBalance(), IsNil)
+	c.Assert(bw.balanceOperators, HasLen, 1)
+	bop := bw.balanceOperators[region.GetId()]
+	c.Assert(bop.isTransferLeader(), IsFalse)
+	op := bop.Ops[0].(*changePeerOperator)
+	c.Assert(op.ChangePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+	c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(2))
+}
@@ -15,7 +15,9 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -122,7 +124,7 @@ func (l *leaders) remove(regionID uint64) {
 		return
 	}
 
-	l.removeStoreRegion(storeID, regionID)
+	l.removeStoreRegion(regionID, storeID)
 	delete(l.regionStores, regionID)
 }
 
@@ -151,6 +153,50 @@ func (l *leaders) update(regionID uint64, storeID uint64) {
 	l.regionStores[regionID] = storeID
 }
 
+// regionShardIndex returns the index of the shard which owns the region,
+// the region IDs are allocated in sequence, so the regions are spread
+// evenly by the ID.
+func regionShardIndex(regionID uint64, count int) int {
+	return int(regionID % uint64(count))
+}
+
+// regionShard is the heartbeat state of the regions in a shard. The
+// heartbeats of a shard are processed by the heartbeat worker of the same
+// index, so the workers don't contend for the shard locks, only for the
+// read lock of the regions.
+type regionShard struct {
+	sync.RWMutex
+
+	// region id -> the time of the last heartbeat
+	heartbeats map[uint64]time.Time
+	// the regions whose leader is restored from the region snapshot and
+	// hasn't reported to this leader yet.
+	restored map[uint64]struct{}
+}
+
+func newRegionShard() *regionShard {
+	return &regionShard{
+		heartbeats: make(map[uint64]time.Time),
+		restored:   make(map[uint64]struct{}),
+	}
+}
+
+func (s *regionShard) touch(regionID uint64, ts time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.heartbeats[regionID] = ts
+	delete(s.restored, regionID)
+}
+
+func (s *regionShard) remove(regionID uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.heartbeats, regionID)
+	delete(s.restored, regionID)
+}
+
 // regionsInfo is regions cache info.
 type regionsInfo struct {
 	sync.RWMutex
@@ -161,9 +207,25 @@ type regionsInfo struct {
 	searchRegions *btree.BTree
 
 	leaders *leaders
+
+	// the heartbeat state of the regions sharded by the region ID, a shard
+	// is locked after the regions when both are locked.
+	shards []*regionShard
+	// the latest heartbeat time of the regions restored.
+	lastRestoredHeartbeat time.Time
+	// store id -> the count of the regions which have a peer in the store
+	storeRegionCounts map[uint64]int
+
+	// rand is the random source of the region and store selection, which
+	// is seeded by the simulator to replay the heartbeats repeatably.
+	rand *rand.Rand
 }
 
-func newRegionsInfo() *regionsInfo {
+func newRegionsInfo(shardCount int) *regionsInfo {
+	shards := make([]*regionShard, shardCount)
+	for i := range shards {
+		shards[i] = newRegionShard()
+	}
 	return &regionsInfo{
 		regions:       make(map[uint64]*metapb.Region),
 		searchRegions: btree.New(defaultBtreeDegree),
@@ -171,9 +233,16 @@ func newRegionsInfo() *regionsInfo {
 			storeRegions: make(map[uint64]map[uint64]struct{}),
 			regionStores: make(map[uint64]uint64),
 		},
+		shards:            shards,
+		storeRegionCounts: make(map[uint64]int),
+		rand:              rand.New(newLockedSource(time.Now().UnixNano())),
 	}
 }
 
+func (r *regionsInfo) shard(regionID uint64) *regionShard {
+	return r.shards[regionShardIndex(regionID, len(r.shards))]
+}
+
 // getRegion gets the region and leader peer by regionKey.
 func (r *regionsInfo) getRegion(regionKey []byte) (*metapb.Region, *metapb.Peer) {
 	r.RLock()
@@ -228,6 +297,161 @@ func (r *regionsInfo) getRegions() []*metapb.Region {
 	return regions
 }
 
+// scanRegions gets at most limit regions ordered by the start key,
+// the first offset regions are skipped.
+func (r *regionsInfo) scanRegions(offset int, limit int) []*metapb.Region {
+	r.RLock()
+	defer r.RUnlock()
+
+	if offset < 0 || limit <= 0 {
+		return nil
+	}
+
+	// The search regions are sorted with start key reversely.
+	sorted := make([]*metapb.Region, 0, r.searchRegions.Len())
+	r.searchRegions.Ascend(func(i btree.Item) bool {
+		sorted = append(sorted, i.(*searchKeyItem).region)
+		return true
+	})
+
+	regions := make([]*metapb.Region, 0, limit)
+	for i := len(sorted) - 1 - offset; i >= 0 && len(regions) < limit; i-- {
+		regions = append(regions, cloneRegion(sorted[i]))
+	}
+
+	return regions
+}
+
+// scanRegionsByKey gets at most limit regions ordered by the start key, from
+// the region which contains startKey, or the first region after it.
+func (r *regionsInfo) scanRegionsByKey(startKey []byte, limit int) []*metapb.Region {
+	regions, _ := r.scanRangeRegions(startKey, nil, limit)
+	return regions
+}
+
+// scanRangeRegions gets at most limit regions in [startKey, endKey) ordered
+// by the start key, an empty endKey means no upper bound. The region which
+// contains startKey is included. It also returns whether the range has
+// more than limit regions.
+func (r *regionsInfo) scanRangeRegions(startKey []byte, endKey []byte, limit int) ([]*metapb.Region, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	regions := make([]*metapb.Region, 0, limit)
+	more := false
+	r.innerWalkRegions(startKey, endKey, func(region *metapb.Region) bool {
+		if len(regions) >= limit {
+			more = true
+			return false
+		}
+		regions = append(regions, cloneRegion(region))
+		return true
+	})
+	return regions, more
+}
+
+// innerWalkRegions calls f with the regions in [startKey, endKey) ordered by
+// the start key until f returns false, an empty endKey means no upper
+// bound. The region which contains startKey is the first one, if no region
+// contains it, the region after it is.
+func (r *regionsInfo) innerWalkRegions(startKey []byte, endKey []byte, f func(*metapb.Region) bool) {
+	for key := startKey; ; {
+		region := r.innerGetRegion(key)
+		if region == nil || !keyInRegion(key, region) {
+			region = r.innerNextRegion(key)
+		}
+		if region == nil || (len(endKey) > 0 && bytes.Compare(region.GetStartKey(), endKey) >= 0) {
+			return
+		}
+		if !f(region) {
+			return
+		}
+
+		key = region.GetEndKey()
+		if len(key) == 0 {
+			return
+		}
+	}
+}
+
+// innerNextRegion returns the region with the least start key which is
+// greater than regionKey.
+func (r *regionsInfo) innerNextRegion(regionKey []byte) *metapb.Region {
+	startSearchItem := &searchKeyItem{
+		region: &metapb.Region{
+			StartKey: regionKey,
+		},
+	}
+
+	// The search regions are sorted with start key reversely.
+	var next *metapb.Region
+	r.searchRegions.AscendLessThan(startSearchItem, func(i btree.Item) bool {
+		next = i.(*searchKeyItem).region
+		return true
+	})
+
+	return next
+}
+
+// getAdjacentRegions returns the regions right before and after the region
+// in the key order, nil is returned at the boundary or if there is a hole
+// next to the region. It returns false if the region is not found.
+func (r *regionsInfo) getAdjacentRegions(regionID uint64) (*metapb.Region, *metapb.Region, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	region, ok := r.regions[regionID]
+	if !ok {
+		return nil, nil, false
+	}
+
+	var prev, next *metapb.Region
+	if startKey := region.GetStartKey(); len(startKey) > 0 {
+		prev = r.innerPrevRegion(startKey)
+		if prev != nil && !bytes.Equal(prev.GetEndKey(), startKey) {
+			prev = nil
+		}
+	}
+	// The next region starts at the end key, so it is the one which
+	// contains the end key.
+	if endKey := region.GetEndKey(); len(endKey) > 0 {
+		next = r.innerGetRegion(endKey)
+		if next != nil && !bytes.Equal(next.GetStartKey(), endKey) {
+			next = nil
+		}
+	}
+
+	if prev != nil {
+		prev = cloneRegion(prev)
+	}
+	if next != nil {
+		next = cloneRegion(next)
+	}
+	return prev, next, true
+}
+
+// innerPrevRegion returns the region with the greatest start key which is
+// less than regionKey.
+func (r *regionsInfo) innerPrevRegion(regionKey []byte) *metapb.Region {
+	startSearchItem := &searchKeyItem{
+		region: &metapb.Region{
+			StartKey: regionKey,
+		},
+	}
+
+	var prev *metapb.Region
+	r.searchRegions.AscendGreaterOrEqual(startSearchItem, func(i btree.Item) bool {
+		region := i.(*searchKeyItem).region
+		if bytes.Equal(region.GetStartKey(), regionKey) {
+			return true
+		}
+		prev = region
+		return false
+	})
+
+	return prev
+}
+
 func (r *regionsInfo) innerGetRegion(regionKey []byte) *metapb.Region {
 	startSearchItem := &searchKeyItem{
 		region: &metapb.Region{
@@ -248,7 +472,10 @@ func (r *regionsInfo) innerGetRegion(regionKey []byte) *metapb.Region {
 	return searchItem.region
 }
 
+// addRegion adds the region to the cache. The region is cloned, so the
+// region counts of the stores are not affected if the caller changes it.
 func (r *regionsInfo) addRegion(region *metapb.Region) {
+	region = cloneRegion(region)
 	item := &searchKeyItem{
 		region: region,
 	}
@@ -264,9 +491,12 @@ func (r *regionsInfo) addRegion(region *metapb.Region) {
 	}
 
 	r.regions[region.GetId()] = region
+	r.updateStoreRegionCounts(region, 1)
 }
 
+// updateRegion replaces the region in the cache with a clone of region.
 func (r *regionsInfo) updateRegion(region *metapb.Region) {
+	region = cloneRegion(region)
 	item := &searchKeyItem{
 		region: region,
 	}
@@ -276,7 +506,11 @@ func (r *regionsInfo) updateRegion(region *metapb.Region) {
 		log.Fatalf("updateRegion for none existed region - %v", region)
 	}
 
+	if old, ok := r.regions[region.GetId()]; ok {
+		r.updateStoreRegionCounts(old, -1)
+	}
 	r.regions[region.GetId()] = region
+	r.updateStoreRegionCounts(region, 1)
 }
 
 func (r *regionsInfo) removeRegion(region *metapb.Region) {
@@ -290,11 +524,27 @@ func (r *regionsInfo) removeRegion(region *metapb.Region) {
 		log.Fatalf("removeRegion for none existed region - %v", region)
 	}
 
-	delete(r.regions, region.GetId())
+	if old, ok := r.regions[regionID]; ok {
+		r.updateStoreRegionCounts(old, -1)
+	}
+	delete(r.regions, regionID)
+	r.shard(regionID).remove(regionID)
 
 	r.leaders.remove(regionID)
 }
 
+// updateStoreRegionCounts adds delta to the region counts of the stores
+// which have a peer of the region.
+func (r *regionsInfo) updateStoreRegionCounts(region *metapb.Region, delta int) {
+	for _, peer := range region.GetPeers() {
+		storeID := peer.GetStoreId()
+		r.storeRegionCounts[storeID] += delta
+		if r.storeRegionCounts[storeID] <= 0 {
+			delete(r.storeRegionCounts, storeID)
+		}
+	}
+}
+
 func (r *regionsInfo) heartbeatVersion(region *metapb.Region) (bool, *metapb.Region, error) {
 	// For split, we should handle heartbeat carefully.
 	// E.g, for region 1 [a, c) -> 1 [a, b) + 2 [b, c).
@@ -402,6 +652,12 @@ type heartbeatResp struct {
 
 // heartbeat handles heartbeat for the region.
 func (r *regionsInfo) heartbeat(region *metapb.Region, leaderPeer *metapb.Peer) (*heartbeatResp, *pdpb.ChangePeer, error) {
+	// Most heartbeats only update the heartbeat time, they don't need the
+	// write lock of the regions.
+	if r.heartbeatUnchanged(region, leaderPeer) {
+		return &heartbeatResp{}, nil, nil
+	}
+
 	r.Lock()
 	defer r.Unlock()
 
@@ -418,6 +674,7 @@ func (r *regionsInfo) heartbeat(region *metapb.Region, leaderPeer *metapb.Peer)
 	regionID := region.GetId()
 	storeID := leaderPeer.GetStoreId()
 	r.leaders.update(regionID, storeID)
+	r.shard(regionID).touch(regionID, time.Now())
 
 	resp := &heartbeatResp{
 		removeRegion: removeRegion,
@@ -430,6 +687,131 @@ func (r *regionsInfo) heartbeat(region *metapb.Region, leaderPeer *metapb.Peer)
 	return resp, changePeer, nil
 }
 
+// heartbeatUnchanged returns true and updates the heartbeat time if the
+// region has the same range, epoch and leader as the cached one.
+func (r *regionsInfo) heartbeatUnchanged(region *metapb.Region, leaderPeer *metapb.Peer) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	regionID := region.GetId()
+	cacheRegion, ok := r.regions[regionID]
+	if !ok {
+		return false
+	}
+	if storeID, ok := r.leaders.regionStores[regionID]; !ok || storeID != leaderPeer.GetStoreId() {
+		return false
+	}
+	epoch, cacheEpoch := region.GetRegionEpoch(), cacheRegion.GetRegionEpoch()
+	if epoch.GetVersion() != cacheEpoch.GetVersion() || epoch.GetConfVer() != cacheEpoch.GetConfVer() ||
+		!bytes.Equal(region.GetStartKey(), cacheRegion.GetStartKey()) ||
+		!bytes.Equal(region.GetEndKey(), cacheRegion.GetEndKey()) {
+		return false
+	}
+
+	// The read lock is held, so the region can't be removed meanwhile.
+	r.shard(regionID).touch(regionID, time.Now())
+	return true
+}
+
+// touchRegion sets the last heartbeat time of the region.
+func (r *regionsInfo) touchRegion(regionID uint64, ts time.Time) {
+	shard := r.shard(regionID)
+	shard.Lock()
+	defer shard.Unlock()
+
+	shard.heartbeats[regionID] = ts
+}
+
+// heartbeatAge returns the duration since the last heartbeat of the region,
+// it returns false if the region hasn't reported since the cache is built
+// and isn't restored from the region snapshot.
+func (r *regionsInfo) heartbeatAge(regionID uint64) (time.Duration, bool) {
+	shard := r.shard(regionID)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	ts, ok := shard.heartbeats[regionID]
+	if !ok {
+		return 0, false
+	}
+	return time.Since(ts), true
+}
+
+// snapshotLeaders returns the leader and the last heartbeat time of the
+// regions which have reported, or restored from the region snapshot.
+func (r *regionsInfo) snapshotLeaders() []*regionSnapshotItem {
+	r.RLock()
+	defer r.RUnlock()
+
+	items := make([]*regionSnapshotItem, 0, len(r.leaders.regionStores))
+	for _, shard := range r.shards {
+		shard.RLock()
+		for regionID, ts := range shard.heartbeats {
+			storeID, ok := r.leaders.regionStores[regionID]
+			if !ok {
+				continue
+			}
+			items = append(items, &regionSnapshotItem{
+				ID:        regionID,
+				Leader:    storeID,
+				Heartbeat: ts.Unix(),
+			})
+		}
+		shard.RUnlock()
+	}
+	return items
+}
+
+// restoreLeader sets the leader and the last heartbeat time of the region
+// from the region snapshot, it's ignored if the region has reported or the
+// store has no peer of the region now.
+func (r *regionsInfo) restoreLeader(item *regionSnapshotItem) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	region, ok := r.regions[item.ID]
+	if !ok || leaderPeer(region, item.Leader) == nil {
+		return false
+	}
+	shard := r.shard(item.ID)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, ok = shard.heartbeats[item.ID]; ok {
+		return false
+	}
+
+	ts := time.Unix(item.Heartbeat, 0)
+	r.leaders.update(item.ID, item.Leader)
+	shard.heartbeats[item.ID] = ts
+	shard.restored[item.ID] = struct{}{}
+	if ts.After(r.lastRestoredHeartbeat) {
+		r.lastRestoredHeartbeat = ts
+	}
+	return true
+}
+
+// isWarmingUp returns whether some regions whose leader is restored from
+// the region snapshot haven't reported yet. The warm-up ends anyway once
+// all the restored heartbeats are older than maxAge, the regions which
+// never report again, like the merged ones, mustn't block it forever.
+func (r *regionsInfo) isWarmingUp(maxAge time.Duration) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	if time.Since(r.lastRestoredHeartbeat) > maxAge {
+		return false
+	}
+	for _, shard := range r.shards {
+		shard.RLock()
+		restored := len(shard.restored)
+		shard.RUnlock()
+		if restored > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *regionsInfo) leaderRegionCount(storeID uint64) int {
 	r.RLock()
 	defer r.RUnlock()
@@ -444,6 +826,32 @@ func (r *regionsInfo) regionCount() int {
 	return len(r.regions)
 }
 
+// storeRegionCount returns the count of regions which have a peer in the store.
+func (r *regionsInfo) storeRegionCount(storeID uint64) int {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.storeRegionCounts[storeID]
+}
+
+// storeRegionIDs returns the IDs of at most limit regions which have a peer
+// in the store, the regions are in no particular order.
+func (r *regionsInfo) storeRegionIDs(storeID uint64, limit int) []uint64 {
+	r.RLock()
+	defer r.RUnlock()
+
+	var ids []uint64
+	for _, region := range r.regions {
+		if len(ids) >= limit {
+			break
+		}
+		if leaderPeer(region, storeID) != nil {
+			ids = append(ids, region.GetId())
+		}
+	}
+	return ids
+}
+
 // randLeaderRegion selects a leader region from region cache randomly.
 func (r *regionsInfo) randLeaderRegion(storeID uint64) *metapb.Region {
 	r.RLock()
@@ -455,15 +863,14 @@ func (r *regionsInfo) randLeaderRegion(storeID uint64) *metapb.Region {
 	}
 
 	start := time.Now()
-	idx, randIdx, randRegionID := 0, rand.Intn(len(storeRegions)), uint64(0)
+	// The region IDs are sorted, so the same random source selects the
+	// same region, e.g, when the simulator replays the heartbeats.
+	regionIDs := make([]uint64, 0, len(storeRegions))
 	for regionID := range storeRegions {
-		if idx == randIdx {
-			randRegionID = regionID
-			break
-		}
-
-		idx++
+		regionIDs = append(regionIDs, regionID)
 	}
+	sort.Sort(uint64Slice(regionIDs))
+	randRegionID := regionIDs[r.rand.Intn(len(regionIDs))]
 
 	// TODO: if costs too much time, we may refactor the rand leader region way.
 	if cost := time.Now().Sub(start); cost > maxRandRegionTime {
@@ -483,27 +890,37 @@ func (r *regionsInfo) randRegion(storeID uint64) (*metapb.Region, *metapb.Peer,
 	r.RLock()
 	defer r.RUnlock()
 
+	start := time.Now()
+	// The follower regions of the store, the region IDs are sorted like
+	// randLeaderRegion does.
+	var regionIDs []uint64
+	for regionID, rg := range r.regions {
+		leaderStoreID, ok := r.leaders.regionStores[regionID]
+		if !ok || leaderStoreID == storeID {
+			continue
+		}
+		for _, peer := range rg.GetPeers() {
+			if peer.GetStoreId() == storeID {
+				regionIDs = append(regionIDs, regionID)
+				break
+			}
+		}
+	}
+
 	var (
 		region   *metapb.Region
 		leader   *metapb.Peer
 		follower *metapb.Peer
 	)
-
-	start := time.Now()
-	for _, rg := range r.regions {
-		for _, peer := range rg.GetPeers() {
+	if len(regionIDs) > 0 {
+		sort.Sort(uint64Slice(regionIDs))
+		regionID := regionIDs[r.rand.Intn(len(regionIDs))]
+		region = cloneRegion(r.regions[regionID])
+		leader = leaderPeer(region, r.leaders.regionStores[regionID])
+		for _, peer := range region.GetPeers() {
 			if peer.GetStoreId() == storeID {
-				// Check whether it is leader region of this store.
-				regionID := rg.GetId()
-				leaderStoreID, ok := r.leaders.regionStores[regionID]
-				if ok {
-					if leaderStoreID != storeID {
-						region = cloneRegion(rg)
-						follower = peer
-						leader = leaderPeer(region, leaderStoreID)
-						break
-					}
-				}
+				follower = peer
+				break
 			}
 		}
 	}
@@ -516,27 +933,121 @@ func (r *regionsInfo) randRegion(storeID uint64) (*metapb.Region, *metapb.Peer,
 	return region, leader, follower
 }
 
+// StoreState is the store state from the view of pd.
+type StoreState byte
+
+const (
+	// StoreStateUp means the store sends heartbeats normally.
+	StoreStateUp StoreState = iota + 1
+	// StoreStateDown means the store hasn't sent heartbeats for a long time.
+	StoreStateDown
+	// StoreStateOffline means the store is being decommissioned and its
+	// regions are migrated to other stores.
+	StoreStateOffline
+	// StoreStateTombstone means the store is offline and has no region
+	// peer any more, its metadata can be purged.
+	StoreStateTombstone
+)
+
+func (st StoreState) String() string {
+	switch st {
+	case StoreStateUp:
+		return "Up"
+	case StoreStateDown:
+		return "Down"
+	case StoreStateOffline:
+		return "Offline"
+	case StoreStateTombstone:
+		return "Tombstone"
+	default:
+		return "Unknown"
+	}
+}
+
+// MarshalJSON implements json.Marshaler interface.
+func (st StoreState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(st.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface.
+func (st *StoreState) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, state := range []StoreState{StoreStateUp, StoreStateDown, StoreStateOffline, StoreStateTombstone} {
+		if state.String() == name {
+			*st = state
+			return nil
+		}
+	}
+
+	return errors.Errorf("invalid store state %s", name)
+}
+
 // StoreStatus is store status info.
 type StoreStatus struct {
 	// store capacity info.
 	Stats *pdpb.StoreStats `json:"stats"`
 
+	State StoreState `json:"state"`
+
 	LastHeartbeatTS time.Time `json:"last_heartbeat_ts"`
 
 	LeaderRegionCount int `json:"leader_region_count"`
 
 	TotalRegionCount int `json:"total_region_count"`
 
+	// UsedRatio is the used ratio of the capacity in the last heartbeat.
+	UsedRatio float64 `json:"used_ratio"`
+
 	Scores []int `json:"scores"`
 }
 
 func (s *StoreStatus) clone() *StoreStatus {
 	return &StoreStatus{
 		Stats:             proto.Clone(s.Stats).(*pdpb.StoreStats),
+		State:             s.State,
 		LastHeartbeatTS:   s.LastHeartbeatTS,
 		LeaderRegionCount: s.LeaderRegionCount,
 		TotalRegionCount:  s.TotalRegionCount,
+		UsedRatio:         s.UsedRatio,
+	}
+}
+
+// storeMeta is the store meta maintained by pd, which is not included in metapb.Store.
+type storeMeta struct {
+	// State is the state set by the administrator, it is zero
+	// unless the store has been marked as offline or tombstone.
+	State StoreState `json:"state,omitempty"`
+	// Labels describe the location of the store, e.g, zone=z1.
+	Labels map[string]string `json:"labels,omitempty"`
+	// LeaderWeight and RegionWeight are the shares of leaders and regions
+	// the store should get relative to the others, zero means 1.
+	LeaderWeight float64 `json:"leader_weight,omitempty"`
+	RegionWeight float64 `json:"region_weight,omitempty"`
+	// EvictLeader is set when the leaders of the store are being moved out
+	// by the evict-leader scheduler, so the store can be rebooted.
+	EvictLeader bool `json:"evict_leader,omitempty"`
+	// BalanceRate is the max add-peer and remove-peer operations per minute
+	// of the store, nil means the default rate and zero freezes the store.
+	BalanceRate *float64 `json:"balance_rate,omitempty"`
+}
+
+func (m storeMeta) clone() storeMeta {
+	meta := m
+	if m.Labels != nil {
+		meta.Labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			meta.Labels[k] = v
+		}
+	}
+	if m.BalanceRate != nil {
+		rate := *m.BalanceRate
+		meta.BalanceRate = &rate
 	}
+	return meta
 }
 
 // storeInfo is store cache info.
@@ -544,13 +1055,71 @@ type storeInfo struct {
 	store *metapb.Store
 
 	stats *StoreStatus
+
+	meta storeMeta
 }
 
 func (s *storeInfo) clone() *storeInfo {
 	return &storeInfo{
 		store: proto.Clone(s.store).(*metapb.Store),
 		stats: s.stats.clone(),
+		meta:  s.meta.clone(),
+	}
+}
+
+// isOffline returns true if the regions of the store should be migrated,
+// a tombstone store is offline too.
+func (s *storeInfo) isOffline() bool {
+	return s.meta.State == StoreStateOffline || s.isTombstone()
+}
+
+func (s *storeInfo) isTombstone() bool {
+	return s.meta.State == StoreStateTombstone
+}
+
+// isEvictingLeader returns true if no leader should be on the store.
+func (s *storeInfo) isEvictingLeader() bool {
+	return s.meta.EvictLeader
+}
+
+// isSameLocation returns true if the two stores have the same values of
+// all the location labels. The labels are ordered from the top level, e.g,
+// ["zone", "rack"], two stores in different zones are not in the same
+// location even if their racks have the same name. The stores without the
+// top level label have unknown locations.
+func (s *storeInfo) isSameLocation(other *storeInfo, labels []string) bool {
+	if len(labels) == 0 || len(s.meta.Labels[labels[0]]) == 0 {
+		return false
 	}
+	for _, label := range labels {
+		if s.meta.Labels[label] != other.meta.Labels[label] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *storeInfo) leaderWeight() float64 {
+	if s.meta.LeaderWeight <= 0 {
+		return 1
+	}
+	return s.meta.LeaderWeight
+}
+
+func (s *storeInfo) regionWeight() float64 {
+	if s.meta.RegionWeight <= 0 {
+		return 1
+	}
+	return s.meta.RegionWeight
+}
+
+// balanceRate returns the max add-peer and remove-peer operations per
+// minute of the store.
+func (s *storeInfo) balanceRate(defaultRate float64) float64 {
+	if s.meta.BalanceRate == nil {
+		return defaultRate
+	}
+	return *s.meta.BalanceRate
 }
 
 // leaderRatio is the leader region ratio of storage regions.
@@ -583,15 +1152,23 @@ type clusterInfo struct {
 	stores      map[uint64]*storeInfo
 	regions     *regionsInfo
 	clusterRoot string
+	// rules are the placement rules, see PlacementRule.
+	rules []*PlacementRule
+	// namespaces are the logical datasets, see Namespace.
+	namespaces []*Namespace
+	// affinities are the preferred stores of the leaders, see LeaderAffinity.
+	affinities []*LeaderAffinity
 
 	idAlloc IDAllocator
 }
 
-func newClusterInfo(clusterRoot string) *clusterInfo {
+// newClusterInfo creates the cluster info, the regions are sharded to
+// shardCount shards for the heartbeat workers.
+func newClusterInfo(clusterRoot string, shardCount int) *clusterInfo {
 	cluster := &clusterInfo{
 		clusterRoot: clusterRoot,
 		stores:      make(map[uint64]*storeInfo),
-		regions:     newRegionsInfo(),
+		regions:     newRegionsInfo(shardCount),
 	}
 
 	return cluster
@@ -607,9 +1184,28 @@ func (c *clusterInfo) addStore(store *metapb.Store) {
 	}
 
 	storeID := store.GetId()
+	// Keep the meta maintained by pd and the reported stats when the store
+	// is put again, e.g, with a changed address after it is rescheduled.
+	if old, ok := c.stores[storeID]; ok {
+		storeInfo.meta = old.meta
+		storeInfo.stats = old.stats
+	}
 	c.stores[storeID] = storeInfo
 }
 
+func (c *clusterInfo) setStoreMeta(storeID uint64, meta storeMeta) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	store, ok := c.stores[storeID]
+	if !ok {
+		return false
+	}
+
+	store.meta = meta
+	return true
+}
+
 func (c *clusterInfo) updateStoreStatus(stats *pdpb.StoreStats) bool {
 	c.Lock()
 	defer c.Unlock()
@@ -624,6 +1220,21 @@ func (c *clusterInfo) updateStoreStatus(stats *pdpb.StoreStats) bool {
 	store.stats.LastHeartbeatTS = time.Now()
 	store.stats.LeaderRegionCount = c.regions.leaderRegionCount(storeID)
 	store.stats.TotalRegionCount = c.regions.regionCount()
+	store.stats.UsedRatio = store.usedRatio()
+	return true
+}
+
+// touchStore sets the last heartbeat time of the store.
+func (c *clusterInfo) touchStore(storeID uint64, ts time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	store, ok := c.stores[storeID]
+	if !ok {
+		return false
+	}
+
+	store.stats.LastHeartbeatTS = ts
 	return true
 }
 
@@ -654,10 +1265,57 @@ func (c *clusterInfo) getStores() []*storeInfo {
 	for _, store := range c.stores {
 		stores = append(stores, store.clone())
 	}
+	// The stores are sorted, so the balancers break the ties in the scores
+	// by the store ID rather than the map order.
+	sort.Sort(storeInfosByID(stores))
 
 	return stores
 }
 
+func (c *clusterInfo) getStoreCount() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return len(c.stores)
+}
+
+// checkRemovePeerQuorum checks whether the healthy peers left can form a
+// quorum after the peer is removed from the region. The peers on the
+// unknown, tombstone or down stores are unhealthy.
+func (c *clusterInfo) checkRemovePeerQuorum(region *metapb.Region, peer *metapb.Peer, maxStoreDownDuration time.Duration) error {
+	healthy := 0
+	for _, p := range region.GetPeers() {
+		if p.GetId() == peer.GetId() {
+			continue
+		}
+		store := c.getStore(p.GetStoreId())
+		if store == nil || store.isTombstone() || float64(store.downSeconds()) >= maxStoreDownDuration.Seconds() {
+			continue
+		}
+		healthy++
+	}
+	if quorum := (len(region.GetPeers())-1)/2 + 1; healthy < quorum {
+		return errors.Annotatef(ErrQuorumLost, "region %d has %d healthy peers left after removing peer %d, needs %d", region.GetId(), healthy, peer.GetId(), quorum)
+	}
+	return nil
+}
+
+// getEvictLeaderStores returns the IDs of the stores which the leaders are
+// being moved out of, in ascending order.
+func (c *clusterInfo) getEvictLeaderStores() []uint64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	var storeIDs []uint64
+	for storeID, store := range c.stores {
+		if store.isEvictingLeader() {
+			storeIDs = append(storeIDs, storeID)
+		}
+	}
+	sort.Sort(uint64Slice(storeIDs))
+	return storeIDs
+}
+
 func (c *clusterInfo) getMetaStores() []*metapb.Store {
 	c.RLock()
 	defer c.RUnlock()
@@ -14,8 +14,11 @@
 package server
 
 import (
+	"math/rand"
 	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	. "github.com/pingcap/check"
@@ -234,10 +237,290 @@ func (alloc *mockIDAllocator) Alloc() (uint64, error) {
 }
 
 func (s *testClusterCacheSuite) TestIDAlloc(c *C) {
-	cluster := newClusterInfo("/pd")
+	cluster := newClusterInfo("/pd", 1)
 	cluster.idAlloc = newMockIDAllocator()
 
 	id, err := cluster.idAlloc.Alloc()
 	c.Assert(err, IsNil)
 	c.Assert(id, Greater, uint64(0))
 }
+
+func (s *testClusterCacheSuite) TestStoreRegionCount(c *C) {
+	regions := newRegionsInfo(1)
+	newPeer := func(storeID uint64) *metapb.Peer {
+		return &metapb.Peer{Id: proto.Uint64(storeID + 10), StoreId: proto.Uint64(storeID)}
+	}
+	region := &metapb.Region{
+		Id:       proto.Uint64(1),
+		StartKey: []byte{},
+		EndKey:   []byte("m"),
+		Peers:    []*metapb.Peer{newPeer(1), newPeer(2)},
+	}
+	regions.addRegion(region)
+	regions.addRegion(&metapb.Region{
+		Id:       proto.Uint64(2),
+		StartKey: []byte("m"),
+		EndKey:   []byte{},
+		Peers:    []*metapb.Peer{newPeer(1)},
+	})
+	c.Assert(regions.storeRegionCount(1), Equals, 2)
+	c.Assert(regions.storeRegionCount(2), Equals, 1)
+
+	// The region is cloned, so changing it doesn't affect the counts until
+	// it is updated.
+	region.Peers = []*metapb.Peer{newPeer(1), newPeer(3)}
+	c.Assert(regions.storeRegionCount(2), Equals, 1)
+	regions.updateRegion(region)
+	c.Assert(regions.storeRegionCount(1), Equals, 2)
+	c.Assert(regions.storeRegionCount(2), Equals, 0)
+	c.Assert(regions.storeRegionCount(3), Equals, 1)
+
+	regions.removeRegion(region)
+	c.Assert(regions.storeRegionCount(1), Equals, 1)
+	c.Assert(regions.storeRegionCount(3), Equals, 0)
+	c.Assert(regions.storeRegionCounts, HasLen, 1)
+}
+
+func (s *testClusterCacheSuite) TestWarmingUp(c *C) {
+	regions := newRegionsInfo(1)
+	for i := uint64(1); i <= 2; i++ {
+		regions.addRegion(&metapb.Region{
+			Id:       proto.Uint64(i),
+			StartKey: []byte{byte(i)},
+			EndKey:   []byte{byte(i + 1)},
+			Peers:    []*metapb.Peer{{Id: proto.Uint64(i + 10), StoreId: proto.Uint64(1)}},
+		})
+	}
+	c.Assert(regions.isWarmingUp(time.Minute), IsFalse)
+
+	now := time.Now()
+	c.Assert(regions.restoreLeader(&regionSnapshotItem{ID: 1, Leader: 1, Heartbeat: now.Add(-time.Hour).Unix()}), IsTrue)
+	c.Assert(regions.restoreLeader(&regionSnapshotItem{ID: 2, Leader: 1, Heartbeat: now.Unix()}), IsTrue)
+	// The store has no peer of the region.
+	c.Assert(regions.restoreLeader(&regionSnapshotItem{ID: 1, Leader: 2, Heartbeat: now.Unix()}), IsFalse)
+	c.Assert(regions.isWarmingUp(time.Minute), IsTrue)
+
+	// The warm-up ends if the restored heartbeats are too old, even if
+	// some regions haven't reported.
+	c.Assert(regions.isWarmingUp(0), IsFalse)
+
+	// The warm-up ends after the restored regions are gone.
+	for i := uint64(1); i <= 2; i++ {
+		region, _ := regions.getRegionByID(i)
+		regions.removeRegion(region)
+	}
+	c.Assert(regions.isWarmingUp(time.Minute), IsFalse)
+}
+
+func (s *testClusterCacheSuite) TestScanRangeRegions(c *C) {
+	regions := newRegionsInfo(1)
+	// The regions are [, b), [b, d), [e, ), there is a hole [d, e).
+	keys := [][]byte{{}, []byte("b"), []byte("d"), []byte("e"), {}}
+	for i, id := range []uint64{1, 2, 0, 3} {
+		if id == 0 {
+			continue
+		}
+		regions.addRegion(&metapb.Region{
+			Id:       proto.Uint64(id),
+			StartKey: keys[i],
+			EndKey:   keys[i+1],
+			Peers:    []*metapb.Peer{{Id: proto.Uint64(id + 10), StoreId: proto.Uint64(1)}},
+		})
+	}
+	regionIDs := func(startKey string, endKey string, limit int) ([]uint64, bool) {
+		scanned, more := regions.scanRangeRegions([]byte(startKey), []byte(endKey), limit)
+		ids := make([]uint64, 0, len(scanned))
+		for _, region := range scanned {
+			ids = append(ids, region.GetId())
+		}
+		return ids, more
+	}
+
+	ids, more := regionIDs("", "", 10)
+	c.Assert(ids, DeepEquals, []uint64{1, 2, 3})
+	c.Assert(more, IsFalse)
+	// The region which contains the start key is included.
+	ids, more = regionIDs("c", "e", 10)
+	c.Assert(ids, DeepEquals, []uint64{2})
+	c.Assert(more, IsFalse)
+	// The region after the hole is the first one.
+	ids, more = regionIDs("dd", "", 10)
+	c.Assert(ids, DeepEquals, []uint64{3})
+	c.Assert(more, IsFalse)
+	ids, more = regionIDs("a", "", 2)
+	c.Assert(ids, DeepEquals, []uint64{1, 2})
+	c.Assert(more, IsTrue)
+	ids, more = regionIDs("a", "d", 2)
+	c.Assert(ids, DeepEquals, []uint64{1, 2})
+	c.Assert(more, IsFalse)
+}
+
+func (s *testClusterCacheSuite) TestAdjacentRegions(c *C) {
+	regions := newRegionsInfo(1)
+	// The regions are [, b), [b, d), [e, ), there is a hole [d, e).
+	keys := [][]byte{{}, []byte("b"), []byte("d"), []byte("e"), {}}
+	for i, id := range []uint64{1, 2, 0, 3} {
+		if id == 0 {
+			continue
+		}
+		regions.addRegion(&metapb.Region{
+			Id:       proto.Uint64(id),
+			StartKey: keys[i],
+			EndKey:   keys[i+1],
+			Peers:    []*metapb.Peer{{Id: proto.Uint64(id + 10), StoreId: proto.Uint64(1)}},
+		})
+	}
+	adjacentIDs := func(regionID uint64) (uint64, uint64) {
+		prev, next, ok := regions.getAdjacentRegions(regionID)
+		c.Assert(ok, IsTrue)
+		return prev.GetId(), next.GetId()
+	}
+
+	prevID, nextID := adjacentIDs(1)
+	c.Assert(prevID, Equals, uint64(0))
+	c.Assert(nextID, Equals, uint64(2))
+	// The regions next to the hole have no sibling across it.
+	prevID, nextID = adjacentIDs(2)
+	c.Assert(prevID, Equals, uint64(1))
+	c.Assert(nextID, Equals, uint64(0))
+	prevID, nextID = adjacentIDs(3)
+	c.Assert(prevID, Equals, uint64(0))
+	c.Assert(nextID, Equals, uint64(0))
+
+	_, _, ok := regions.getAdjacentRegions(4)
+	c.Assert(ok, IsFalse)
+}
+
+// heartbeatRegions sends count heartbeats of each region from a goroutine
+// per store, like the connections of the stores do, the conf version of a
+// region is increased by every heartbeat.
+func heartbeatRegions(regions *regionsInfo, storeCount int, regionCount int, count int) int32 {
+	var (
+		wg     sync.WaitGroup
+		failed int32
+	)
+	for storeID := uint64(1); storeID <= uint64(storeCount); storeID++ {
+		wg.Add(1)
+		go func(storeID uint64) {
+			defer wg.Done()
+			for confVer := uint64(1); confVer <= uint64(count); confVer++ {
+				// The regions of a store are the ones whose ID modulo
+				// the store count is the store ID minus 1.
+				for id := storeID - 1; id < uint64(regionCount); id += uint64(storeCount) {
+					peer := &metapb.Peer{Id: proto.Uint64(id + 1000), StoreId: proto.Uint64(storeID)}
+					region := &metapb.Region{
+						Id:          proto.Uint64(id + 1),
+						StartKey:    []byte{byte(id)},
+						EndKey:      []byte{byte(id + 1)},
+						RegionEpoch: &metapb.RegionEpoch{ConfVer: proto.Uint64(confVer), Version: proto.Uint64(1)},
+						Peers:       []*metapb.Peer{peer},
+					}
+					if _, _, err := regions.heartbeat(region, peer); err != nil {
+						atomic.AddInt32(&failed, 1)
+					}
+				}
+			}
+		}(storeID)
+	}
+	wg.Wait()
+	return failed
+}
+
+func (s *testClusterCacheSuite) TestConcurrentHeartbeat(c *C) {
+	const (
+		storeCount  = 4
+		regionCount = 64
+		count       = 50
+	)
+
+	// The heartbeats of a region are processed in order by one goroutine,
+	// and the goroutines run concurrently.
+	regions := newRegionsInfo(storeCount)
+	c.Assert(heartbeatRegions(regions, storeCount, regionCount, count), Equals, int32(0))
+	c.Assert(regions.regionCount(), Equals, regionCount)
+	for id := uint64(1); id <= regionCount; id++ {
+		region, leader := regions.getRegionByID(id)
+		c.Assert(region.GetRegionEpoch().GetConfVer(), Equals, uint64(count))
+		c.Assert(leader.GetStoreId(), Equals, (id-1)%storeCount+1)
+		_, ok := regions.heartbeatAge(id)
+		c.Assert(ok, IsTrue)
+	}
+}
+
+func (s *testClusterCacheSuite) BenchmarkConcurrentHeartbeat(c *C) {
+	regions := newRegionsInfo(8)
+	heartbeatRegions(regions, 8, 128, c.N)
+}
+
+func (s *testClusterCacheSuite) TestUnchangedHeartbeat(c *C) {
+	regions := newRegionsInfo(4)
+	region := &metapb.Region{
+		Id:          proto.Uint64(1),
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: proto.Uint64(1), Version: proto.Uint64(1)},
+		Peers: []*metapb.Peer{
+			{Id: proto.Uint64(11), StoreId: proto.Uint64(1)},
+			{Id: proto.Uint64(12), StoreId: proto.Uint64(2)},
+		},
+	}
+	resp, _, err := regions.heartbeat(region, region.Peers[0])
+	c.Assert(err, IsNil)
+	c.Assert(resp.putRegion, NotNil)
+
+	// The same region only updates the heartbeat time.
+	regions.touchRegion(1, time.Now().Add(-time.Hour))
+	resp, changePeer, err := regions.heartbeat(region, region.Peers[0])
+	c.Assert(err, IsNil)
+	c.Assert(resp.putRegion, IsNil)
+	c.Assert(resp.removeRegion, IsNil)
+	c.Assert(changePeer, IsNil)
+	age, ok := regions.heartbeatAge(1)
+	c.Assert(ok, IsTrue)
+	c.Assert(age < time.Minute, IsTrue)
+
+	// The new leader is updated.
+	resp, _, err = regions.heartbeat(region, region.Peers[1])
+	c.Assert(err, IsNil)
+	c.Assert(resp.putRegion, IsNil)
+	_, leader := regions.getRegionByID(1)
+	c.Assert(leader.GetStoreId(), Equals, uint64(2))
+	c.Assert(regions.leaderRegionCount(1), Equals, 0)
+
+	// The stale region is rejected.
+	region.RegionEpoch.ConfVer = proto.Uint64(2)
+	_, _, err = regions.heartbeat(region, region.Peers[1])
+	c.Assert(err, IsNil)
+	region.RegionEpoch.ConfVer = proto.Uint64(1)
+	_, _, err = regions.heartbeat(region, region.Peers[1])
+	c.Assert(err, NotNil)
+}
+
+func (s *testClusterCacheSuite) TestRandRegionSeeded(c *C) {
+	// The regions have peers in store 1,2 and the leaders in store 1.
+	newRegions := func() *regionsInfo {
+		regions := newRegionsInfo(1)
+		regions.rand = rand.New(newLockedSource(1))
+		for i := uint64(0); i < 16; i++ {
+			region := &metapb.Region{
+				Id:       proto.Uint64(i + 1),
+				StartKey: []byte{byte(i)},
+				EndKey:   []byte{byte(i + 1)},
+				Peers: []*metapb.Peer{
+					{Id: proto.Uint64(i*10 + 1), StoreId: proto.Uint64(1)},
+					{Id: proto.Uint64(i*10 + 2), StoreId: proto.Uint64(2)},
+				},
+			}
+			regions.addRegion(region)
+			regions.leaders.update(region.GetId(), 1)
+		}
+		return regions
+	}
+
+	// The same seed selects the same regions.
+	r1, r2 := newRegions(), newRegions()
+	for i := 0; i < 20; i++ {
+		c.Assert(r2.randLeaderRegion(1).GetId(), Equals, r1.randLeaderRegion(1).GetId())
+		region1, _, _ := r1.randRegion(2)
+		region2, _, _ := r2.randRegion(2)
+		c.Assert(region2.GetId(), Equals, region1.GetId())
+	}
+}
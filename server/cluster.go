@@ -14,9 +14,11 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,10 +33,64 @@ import (
 
 var (
 	errClusterNotBootstrapped = errors.New("cluster is not bootstrapped")
+
+	// ErrStoreNotFound is returned when the store doesn't exist in the cluster.
+	ErrStoreNotFound = errors.New("store is not found")
+	// ErrInvalidStoreLabel is returned when the store label key or value is invalid.
+	ErrInvalidStoreLabel = errors.New("invalid store label")
+	// ErrInvalidStoreWeight is returned when the store weight is not positive.
+	ErrInvalidStoreWeight = errors.New("invalid store weight")
+	// ErrSchedulerNotFound is returned when the scheduler doesn't exist.
+	ErrSchedulerNotFound = errors.New("scheduler is not found")
+	// ErrInvalidSchedulerArgs is returned when the arguments to add a
+	// scheduler are missing or invalid.
+	ErrInvalidSchedulerArgs = errors.New("invalid scheduler args")
+	// ErrStoreIsLastReplica is returned when the store holds the last
+	// available replica of some region and can't be removed.
+	ErrStoreIsLastReplica = errors.New("store holds the last replica of some region")
+	// ErrRegionNotFound is returned when the region doesn't exist in the cluster.
+	ErrRegionNotFound = errors.New("region is not found")
+	// ErrRegionHasOperator is returned when the region has a pending operator.
+	ErrRegionHasOperator = errors.New("region has a pending operator")
+	// ErrOperatorNotFound is returned when the region has no operator.
+	ErrOperatorNotFound = errors.New("operator is not found")
+	// ErrInvalidOperator is returned when the operator requested by the user is illegal.
+	ErrInvalidOperator = errors.New("invalid operator")
+	// ErrNotEnoughStores is returned when there are not enough stores to
+	// place the region replicas.
+	ErrNotEnoughStores = errors.New("not enough stores")
+	// ErrDuplicateStoreAddress is returned when the store address is used by another store.
+	ErrDuplicateStoreAddress = errors.New("duplicate store address")
+	// ErrTooManyStores is returned when registering more stores than one
+	// transaction can put.
+	ErrTooManyStores = errors.New("too many stores")
+	// ErrStoreHasPeers is returned when a store which still has region peers
+	// is made tombstone.
+	ErrStoreHasPeers = errors.New("store has region peers")
+	// ErrStoreNotTombstone is returned when purging a store which is not
+	// tombstone.
+	ErrStoreNotTombstone = errors.New("store is not tombstone")
+	// ErrInvalidConfig is returned when the config is out of the valid range.
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrInvalidRegionLabel is returned when the region label key is empty or not printable.
+	ErrInvalidRegionLabel = errors.New("invalid region label")
+	// ErrPeerNotFound is returned when the region has no peer on the store.
+	ErrPeerNotFound = errors.New("peer is not found")
+	// ErrPeerExists is returned when the region has a peer on the store already.
+	ErrPeerExists = errors.New("peer exists")
+	// ErrQuorumLost is returned when the healthy peers left after removing
+	// a peer can't form a quorum of the region.
+	ErrQuorumLost = errors.New("region would lose quorum")
+	// ErrRegionChanged is returned when the region is changed by others
+	// while an operator is running on it, the operator is superseded.
+	ErrRegionChanged = errors.New("region is changed")
 )
 
 const (
 	maxBatchRegionCount = 10000
+	// A store and its meta are 2 ops, etcd allows at most 128 ops in a
+	// transaction.
+	maxRegisterStoreCount = 64
 )
 
 // RaftCluster is used for cluster config management.
@@ -43,6 +99,7 @@ const (
 // cluster 2 -> /2/raft
 // For cluster 1
 // store 1 -> /1/raft/s/1, value is metapb.Store
+// store 1 meta -> /1/raft/ss/1, value is storeMeta in JSON
 // region 1 -> /1/raft/r/1, value is metapb.Region
 type RaftCluster struct {
 	sync.RWMutex
@@ -59,6 +116,13 @@ type RaftCluster struct {
 
 	// balancer worker
 	balancerWorker *balancerWorker
+	// serializes saving the removed schedulers, so a change isn't lost by
+	// another one saved with the stale list.
+	removedSchedulersLock sync.Mutex
+
+	// the workers to process the region heartbeats, each worker owns a
+	// shard of the regions.
+	heartbeatWorkers *heartbeatWorkers
 }
 
 func (c *RaftCluster) start(meta metapb.Cluster) error {
@@ -70,11 +134,17 @@ func (c *RaftCluster) start(meta metapb.Cluster) error {
 		return nil
 	}
 
-	c.cachedCluster = newClusterInfo(c.clusterRoot)
+	c.cachedCluster = newClusterInfo(c.clusterRoot, c.s.cfg.RegionHeartbeatWorkers)
 	c.cachedCluster.idAlloc = c.s.idAlloc
 
 	c.cachedCluster.setMeta(&meta)
 
+	// The balance config and the scheduling limits may be changed by
+	// the previous leader.
+	if _, err := c.s.reloadConfig(); err != nil {
+		return errors.Trace(err)
+	}
+
 	// Cache all stores when start the cluster. We don't have
 	// many stores, so it is OK to cache them all.
 	// And we should use these cache for later ChangePeer too.
@@ -85,10 +155,42 @@ func (c *RaftCluster) start(meta metapb.Cluster) error {
 	if err := c.cacheAllRegions(); err != nil {
 		return errors.Trace(err)
 	}
+	if err := c.loadRegionSnapshot(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := c.loadPlacementRules(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := c.loadNamespaces(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := c.loadLeaderAffinities(); err != nil {
+		return errors.Trace(err)
+	}
 
-	c.balancerWorker = newBalancerWorker(c.cachedCluster, &c.s.cfg.BalanceCfg)
+	c.balancerWorker = newBalancerWorker(c.cachedCluster, c.s.getBalanceConfig)
+	// The schedulers may be removed or paused by the previous leader.
+	if err := c.loadRemovedSchedulers(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := c.loadSchedulerPauses(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := c.loadMaintenance(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := c.loadImportMode(); err != nil {
+		return errors.Trace(err)
+	}
 	c.balancerWorker.run()
 
+	c.heartbeatWorkers = newHeartbeatWorkers(c.s.cfg.RegionHeartbeatWorkers)
+	c.heartbeatWorkers.run()
+
+	c.updateClusterMetrics()
+	clusterMetrics.enable(true)
+
 	c.running = true
 
 	return nil
@@ -102,7 +204,9 @@ func (c *RaftCluster) stop() {
 		return
 	}
 
+	c.heartbeatWorkers.stop()
 	c.balancerWorker.stop()
+	clusterMetrics.enable(false)
 
 	c.running = false
 }
@@ -116,12 +220,121 @@ func (c *RaftCluster) isRunning() bool {
 
 // GetConfig gets config information.
 func (s *Server) GetConfig() *Config {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
 	return s.cfg.clone()
 }
 
-// SetBalanceConfig sets the balance config information.
-func (s *Server) SetBalanceConfig(cfg BalanceConfig) {
-	s.cfg.setBalanceConfig(cfg)
+// SetBalanceConfig sets the balance config information and saves it in etcd,
+// so the new leader can use it after the leader changes.
+func (s *Server) SetBalanceConfig(cfg BalanceConfig) error {
+	cfg.adjust()
+	if err := cfg.validate(); err != nil {
+		return errors.Trace(err)
+	}
+	value, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// The balance config contains the scheduling limits, so the saved
+	// limits are replaced too.
+	var rev int64
+	err = s.retryLeaderTxn("save balance config", func() error {
+		resp, err := s.leaderTxn().
+			Then(clientv3.OpPut(s.getBalanceConfigPath(), string(value)), clientv3.OpDelete(s.getScheduleConfigPath())).
+			Commit()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !resp.Succeeded {
+			return errors.Annotate(ErrNotLeader, "save balance config failed")
+		}
+		rev = resp.Header.Revision
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(s.updateConfig(rev, func(c *Config) error {
+		c.setBalanceConfig(cfg)
+		return nil
+	}))
+}
+
+// GetScheduleConfig gets the scheduling limits.
+func (s *Server) GetScheduleConfig() ScheduleConfig {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	return s.cfg.getScheduleConfig()
+}
+
+// SetScheduleConfig sets the scheduling limits and saves them in etcd,
+// so the new leader can use them after the leader changes.
+func (s *Server) SetScheduleConfig(cfg ScheduleConfig) error {
+	value, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var rev int64
+	err = s.retryLeaderTxn("save schedule config", func() error {
+		resp, err := s.leaderTxn().Then(clientv3.OpPut(s.getScheduleConfigPath(), string(value))).Commit()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !resp.Succeeded {
+			return errors.Annotate(ErrNotLeader, "save schedule config failed")
+		}
+		rev = resp.Header.Revision
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(s.updateConfig(rev, func(c *Config) error {
+		c.setScheduleConfig(cfg)
+		return nil
+	}))
+}
+
+// updateConfig changes the config by f if the change is saved in etcd at
+// a revision newer than the config applied last, e.g, the config watcher
+// doesn't apply the change saved by the server itself again. The balance
+// config for the scheduling is replaced by a new copy.
+func (s *Server) updateConfig(rev int64, f func(c *Config) error) error {
+	s.cfgLock.Lock()
+	defer s.cfgLock.Unlock()
+
+	if rev <= s.cfgRevision {
+		return nil
+	}
+	if err := f(s.cfg); err != nil {
+		return errors.Trace(err)
+	}
+	s.cfgRevision = rev
+	s.balanceCfg.Store(s.cfg.BalanceCfg.clone())
+	return nil
+}
+
+// getBalanceConfig returns the current balance config, the caller must
+// not change it.
+func (s *Server) getBalanceConfig() *BalanceConfig {
+	return s.balanceCfg.Load().(*BalanceConfig)
+}
+
+func (s *Server) getConfigPath() string {
+	return path.Join(s.rootPath, "config")
+}
+
+func (s *Server) getBalanceConfigPath() string {
+	return path.Join(s.getConfigPath(), "balance")
+}
+
+func (s *Server) getScheduleConfigPath() string {
+	return path.Join(s.getConfigPath(), "schedule")
 }
 
 func (s *Server) getClusterRootPath() string {
@@ -170,10 +383,50 @@ func makeRegionKey(clusterRootPath string, regionID uint64) string {
 	return strings.Join([]string{clusterRootPath, "r", fmt.Sprintf("%020d", regionID)}, "/")
 }
 
+func makeRegionLabelKey(clusterRootPath string, regionID uint64) string {
+	return strings.Join([]string{clusterRootPath, "rl", fmt.Sprintf("%020d", regionID)}, "/")
+}
+
 func makeStoreKeyPrefix(clusterRootPath string) string {
 	return strings.Join([]string{clusterRootPath, "s", ""}, "/")
 }
 
+func makeStoreMetaKey(clusterRootPath string, storeID uint64) string {
+	return strings.Join([]string{clusterRootPath, "ss", fmt.Sprintf("%020d", storeID)}, "/")
+}
+
+func makeStoreMetaKeyPrefix(clusterRootPath string) string {
+	return strings.Join([]string{clusterRootPath, "ss", ""}, "/")
+}
+
+func makePlacementRulesKey(clusterRootPath string) string {
+	return path.Join(clusterRootPath, "placement_rules")
+}
+
+func makeNamespacesKey(clusterRootPath string) string {
+	return path.Join(clusterRootPath, "namespaces")
+}
+
+func makeLeaderAffinitiesKey(clusterRootPath string) string {
+	return path.Join(clusterRootPath, "leader_affinities")
+}
+
+func makeMaintenanceKey(clusterRootPath string) string {
+	return path.Join(clusterRootPath, "maintenance")
+}
+
+func makeImportModeKey(clusterRootPath string) string {
+	return path.Join(clusterRootPath, "import_mode")
+}
+
+func makeSchedulerKey(clusterRootPath string, name string) string {
+	return strings.Join([]string{clusterRootPath, "sch", name}, "/")
+}
+
+func makeRemovedSchedulersKey(clusterRootPath string) string {
+	return path.Join(clusterRootPath, "removed_schedulers")
+}
+
 func checkBootstrapRequest(clusterID uint64, req *pdpb.BootstrapRequest) error {
 	// TODO: do more check for request fields validation.
 
@@ -290,7 +543,32 @@ func (c *RaftCluster) cacheAllStores() error {
 		}
 
 		c.cachedCluster.addStore(store)
+		// We don't know when the store sent the last heartbeat to the
+		// previous leader, so it is considered down only after it doesn't
+		// send heartbeats to us for max-store-down-duration.
+		c.cachedCluster.touchStore(store.GetId(), start)
+	}
+
+	key = makeStoreMetaKeyPrefix(c.clusterRoot)
+	metaResp, err := kvGet(c.s.client, key, clientv3.WithPrefix())
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, kv := range metaResp.Kvs {
+		storeID, err := strconv.ParseUint(path.Base(string(kv.Key)), 10, 64)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		var meta storeMeta
+		if err = json.Unmarshal(kv.Value, &meta); err != nil {
+			return errors.Trace(err)
+		}
+
+		c.cachedCluster.setStoreMeta(storeID, meta)
 	}
+
 	log.Infof("cache all %d stores cost %s", len(resp.Kvs), time.Now().Sub(start))
 	return nil
 }
@@ -335,16 +613,57 @@ func (c *RaftCluster) getRegion(regionKey []byte) (*metapb.Region, *metapb.Peer)
 	return c.cachedCluster.regions.getRegion(regionKey)
 }
 
+// GetRegion gets the region which covers the key and its leader peer from cluster.
+func (c *RaftCluster) GetRegion(regionKey []byte) (*metapb.Region, *metapb.Peer) {
+	return c.getRegion(regionKey)
+}
+
 // GetRegionByID gets region and leader peer by regionID from cluster.
 func (c *RaftCluster) GetRegionByID(regionID uint64) (*metapb.Region, *metapb.Peer) {
 	return c.cachedCluster.regions.getRegionByID(regionID)
 }
 
+// GetRegionHeartbeatAge returns the duration since the last heartbeat of
+// the region, it returns false if the region hasn't reported to this leader.
+func (c *RaftCluster) GetRegionHeartbeatAge(regionID uint64) (time.Duration, bool) {
+	return c.cachedCluster.regions.heartbeatAge(regionID)
+}
+
 // GetRegions gets regions from cluster.
 func (c *RaftCluster) GetRegions() []*metapb.Region {
 	return c.cachedCluster.regions.getRegions()
 }
 
+// ScanRegions gets at most limit regions ordered by the start key from cluster,
+// the first offset regions are skipped.
+func (c *RaftCluster) ScanRegions(offset int, limit int) []*metapb.Region {
+	return c.cachedCluster.regions.scanRegions(offset, limit)
+}
+
+// ScanRegionsByKey gets at most limit regions ordered by the start key from
+// cluster, from the region which contains startKey or the first one after it.
+func (c *RaftCluster) ScanRegionsByKey(startKey []byte, limit int) []*metapb.Region {
+	return c.cachedCluster.regions.scanRegionsByKey(startKey, limit)
+}
+
+// ScanRangeRegions gets at most limit regions in [startKey, endKey) ordered
+// by the start key from cluster, the region which contains startKey is
+// included. It also returns whether the range has more than limit regions.
+func (c *RaftCluster) ScanRangeRegions(startKey []byte, endKey []byte, limit int) ([]*metapb.Region, bool) {
+	return c.cachedCluster.regions.scanRangeRegions(startKey, endKey, limit)
+}
+
+// GetAdjacentRegions gets the regions right before and after the region in
+// the key order from cluster, it returns false if the region is not found.
+func (c *RaftCluster) GetAdjacentRegions(regionID uint64) (*metapb.Region, *metapb.Region, bool) {
+	return c.cachedCluster.regions.getAdjacentRegions(regionID)
+}
+
+// GetRegionCount gets the total region count of cluster.
+func (c *RaftCluster) GetRegionCount() int {
+	return c.cachedCluster.regions.regionCount()
+}
+
 // GetStores gets stores from cluster.
 func (c *RaftCluster) GetStores() []*metapb.Store {
 	return c.cachedCluster.getMetaStores()
@@ -361,82 +680,1324 @@ func (c *RaftCluster) GetStore(storeID uint64) (*metapb.Store, *StoreStatus, err
 		return nil, nil, errors.Errorf("invalid store ID %d, not found", storeID)
 	}
 
+	store.stats.State = c.storeState(store)
 	return store.store, store.stats, nil
 }
 
-func (c *RaftCluster) putStore(store *metapb.Store) error {
-	if store.GetId() == 0 {
-		return errors.Errorf("invalid put store %v", store)
-	}
+// ClusterStatus is the summary of the cluster status.
+type ClusterStatus struct {
+	Bootstrapped  bool   `json:"bootstrapped"`
+	ClusterID     uint64 `json:"cluster_id"`
+	UpStores      int    `json:"up_stores"`
+	DownStores    int    `json:"down_stores"`
+	OfflineStores int    `json:"offline_stores"`
+	RegionCount   int    `json:"region_count"`
+	// UnderReplicatedRegions is the count of regions whose peer count
+	// is less than the max peer count of the cluster.
+	UnderReplicatedRegions int  `json:"under_replicated_regions"`
+	UnderReplicated        bool `json:"under_replicated"`
+	// AllocIDMax is the persisted high-water mark of the ID allocator.
+	AllocIDMax uint64 `json:"alloc_id_max"`
+	// DataDirFreeBytes is the free space of the data dir of the server.
+	DataDirFreeBytes uint64 `json:"data_dir_free_bytes"`
+	// LastCompactRevision is the revision of the last compaction of the
+	// embedded etcd.
+	LastCompactRevision int64 `json:"last_compact_revision"`
+	// ImportMode is whether the scheduling is relaxed for a bulk load.
+	ImportMode bool `json:"import_mode"`
+}
 
-	storeValue, err := proto.Marshal(store)
+// GetClusterStatus returns the cluster status summary, only the cluster ID
+// and the allocated ID max are returned if the cluster is not bootstrapped.
+func (s *Server) GetClusterStatus() (*ClusterStatus, error) {
+	allocIDMax, err := s.idAlloc.getMax()
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
 
-	storePath := makeStoreKey(c.clusterRoot, store.GetId())
-	resp, err := c.s.leaderTxn().Then(clientv3.OpPut(storePath, string(storeValue))).Commit()
-	if err != nil {
-		return errors.Trace(err)
+	status := &ClusterStatus{
+		ClusterID:           s.cfg.ClusterID,
+		AllocIDMax:          allocIDMax,
+		DataDirFreeBytes:    s.getFreeSpace(),
+		LastCompactRevision: s.getCompactRevision(),
 	}
-	if !resp.Succeeded {
-		return errors.Errorf("put store %v fail", store)
+	if !s.cluster.isRunning() {
+		return status, nil
 	}
 
-	c.cachedCluster.addStore(store)
+	status.Bootstrapped = true
+	s.cluster.fillStatus(status)
+	return status, nil
+}
 
-	return nil
+func (c *RaftCluster) fillStatus(status *ClusterStatus) {
+	for _, store := range c.cachedCluster.getStores() {
+		switch c.storeState(store) {
+		case StoreStateUp:
+			status.UpStores++
+		case StoreStateDown:
+			status.DownStores++
+		case StoreStateOffline:
+			status.OfflineStores++
+		}
+	}
+
+	maxPeerCount := int(c.cachedCluster.getMeta().GetMaxPeerCount())
+	regions := c.cachedCluster.regions.getRegions()
+	for _, region := range regions {
+		if len(region.GetPeers()) < maxPeerCount {
+			status.UnderReplicatedRegions++
+		}
+	}
+	status.RegionCount = len(regions)
+	status.UnderReplicated = status.UnderReplicatedRegions > 0
+	status.ImportMode = c.balancerWorker.inImportMode()
 }
 
-// GetConfig gets config from cluster.
-func (c *RaftCluster) GetConfig() *metapb.Cluster {
-	return c.cachedCluster.getMeta()
+// UnderReplicatedRegion is the region which has less healthy replicas
+// than the max peer count.
+type UnderReplicatedRegion struct {
+	ID       uint64 `json:"id"`
+	Replicas int    `json:"replicas"`
+	Expected int    `json:"expected"`
 }
 
-func (c *RaftCluster) putConfig(meta *metapb.Cluster) error {
-	if meta.GetId() != c.clusterID {
-		return errors.Errorf("invalid cluster %v, mismatch cluster id %d", meta, c.clusterID)
+// GetUnderReplicatedRegions returns the under-replicated regions ordered
+// by the start key, at most limit regions are returned with the total count.
+// The peers on the down stores are unhealthy.
+func (c *RaftCluster) GetUnderReplicatedRegions(limit int) ([]*UnderReplicatedRegion, int) {
+	// Take snapshots of the stores and regions, so we don't hold the
+	// locks when checking a large number of regions.
+	downStores := make(map[uint64]struct{})
+	for _, store := range c.cachedCluster.getStores() {
+		if c.storeState(store) == StoreStateDown {
+			downStores[store.store.GetId()] = struct{}{}
+		}
+	}
+	regions := c.cachedCluster.regions.scanRegions(0, c.cachedCluster.regions.regionCount())
+
+	expected := int(c.cachedCluster.getMeta().GetMaxPeerCount())
+	result := make([]*UnderReplicatedRegion, 0, limit)
+	total := 0
+	for _, region := range regions {
+		replicas := 0
+		for _, peer := range region.GetPeers() {
+			if _, ok := downStores[peer.GetStoreId()]; !ok {
+				replicas++
+			}
+		}
+		if replicas >= expected {
+			continue
+		}
+
+		total++
+		if len(result) < limit {
+			result = append(result, &UnderReplicatedRegion{
+				ID:       region.GetId(),
+				Replicas: replicas,
+				Expected: expected,
+			})
+		}
 	}
+	return result, total
+}
 
-	metaValue, err := proto.Marshal(meta)
-	if err != nil {
+// GetStoreRegionCount returns the count of regions which have a peer in the store.
+func (c *RaftCluster) GetStoreRegionCount(storeID uint64) int {
+	return c.cachedCluster.regions.storeRegionCount(storeID)
+}
+
+// GetStoreRegions returns the regions which have a peer in the store ordered
+// by the start key, at most limit regions are returned with the total count.
+func (c *RaftCluster) GetStoreRegions(storeID uint64, limit int) ([]*metapb.Region, int) {
+	regions := c.cachedCluster.regions.scanRegions(0, c.cachedCluster.regions.regionCount())
+
+	result := make([]*metapb.Region, 0, limit)
+	total := 0
+	for _, region := range regions {
+		if leaderPeer(region, storeID) == nil {
+			continue
+		}
+
+		total++
+		if len(result) < limit {
+			result = append(result, region)
+		}
+	}
+	return result, total
+}
+
+// updateClusterMetrics updates the cluster-wide metrics.
+func (c *RaftCluster) updateClusterMetrics() {
+	clusterStoresGauge.Set(float64(c.cachedCluster.getStoreCount()))
+	clusterRegionsGauge.Set(float64(c.cachedCluster.regions.regionCount()))
+}
+
+// updateStoreMetrics updates the metrics of the store after its heartbeat.
+func (c *RaftCluster) updateStoreMetrics(stats *pdpb.StoreStats) {
+	storeID := stats.GetStoreId()
+	label := strconv.FormatUint(storeID, 10)
+	storeRegionsGauge.WithLabelValues(label).Set(float64(stats.GetRegionCount()))
+	storeLeadersGauge.WithLabelValues(label).Set(float64(c.cachedCluster.regions.leaderRegionCount(storeID)))
+	c.updateClusterMetrics()
+}
+
+// GetStoreLeaderCount returns the count of regions whose leader is in the store.
+func (c *RaftCluster) GetStoreLeaderCount(storeID uint64) int {
+	return c.cachedCluster.regions.leaderRegionCount(storeID)
+}
+
+// OfflineStore marks the store as offline, then the balancer will move
+// its regions to other stores. It is OK to offline an offline store again.
+func (c *RaftCluster) OfflineStore(storeID uint64) error {
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return errors.Trace(ErrStoreNotFound)
+	}
+	if store.isOffline() {
+		return nil
+	}
+
+	if c.isLastReplicaStore(storeID) {
+		return errors.Trace(ErrStoreIsLastReplica)
+	}
+
+	meta := store.meta
+	meta.State = StoreStateOffline
+	if err := c.putStoreMeta(storeID, meta); err != nil {
 		return errors.Trace(err)
 	}
 
-	resp, err := c.s.leaderTxn().Then(clientv3.OpPut(c.clusterRoot, string(metaValue))).Commit()
-	if err != nil {
+	c.balancerWorker.postStoreStateEvent(storeID, StoreStateOffline)
+	return nil
+}
+
+// GetStoreLabels returns the labels of the store.
+func (c *RaftCluster) GetStoreLabels(storeID uint64) (map[string]string, error) {
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return nil, errors.Trace(ErrStoreNotFound)
+	}
+
+	return store.meta.Labels, nil
+}
+
+// SetStoreLabels replaces the labels of the store.
+func (c *RaftCluster) SetStoreLabels(storeID uint64, labels map[string]string) error {
+	if err := validateStoreLabels(labels); err != nil {
 		return errors.Trace(err)
 	}
-	if !resp.Succeeded {
-		return errors.Errorf("put cluster meta %v error", meta)
+
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return errors.Trace(ErrStoreNotFound)
 	}
 
-	c.cachedCluster.setMeta(meta)
+	meta := store.meta
+	meta.Labels = labels
+	return errors.Trace(c.putStoreMeta(storeID, meta))
+}
+
+// validateStoreLabels checks the label keys and values are non-empty printable ASCII.
+func validateStoreLabels(labels map[string]string) error {
+	isValid := func(s string) bool {
+		if len(s) == 0 {
+			return false
+		}
+		for i := 0; i < len(s); i++ {
+			if s[i] < '!' || s[i] > '~' {
+				return false
+			}
+		}
+		return true
+	}
+
+	for k, v := range labels {
+		if !isValid(k) || !isValid(v) {
+			return errors.Annotatef(ErrInvalidStoreLabel, "%q=%q", k, v)
+		}
+	}
 
 	return nil
 }
 
-// GetBalanceOperators gets the balance operators from cluster.
-func (c *RaftCluster) GetBalanceOperators() map[uint64]Operator {
-	return c.balancerWorker.getBalanceOperators()
+// StoreWeight is the leader and region weight of the store, the balancer
+// makes the leader and region shares of the stores proportional to them.
+type StoreWeight struct {
+	Leader float64 `json:"leader"`
+	Region float64 `json:"region"`
 }
 
-// GetHistoryOperators gets the history operators from cluster.
-func (c *RaftCluster) GetHistoryOperators() []Operator {
-	return c.balancerWorker.getHistoryOperators()
+// GetStoreWeight returns the weight of the store.
+func (c *RaftCluster) GetStoreWeight(storeID uint64) (*StoreWeight, error) {
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return nil, errors.Trace(ErrStoreNotFound)
+	}
+
+	return &StoreWeight{
+		Leader: store.leaderWeight(),
+		Region: store.regionWeight(),
+	}, nil
 }
 
-// GetScores gets store scores from balancer.
-func (c *RaftCluster) GetScores(store *metapb.Store, status *StoreStatus) []int {
-	storeInfo := &storeInfo{
-		store: store,
-		stats: status,
+// SetStoreWeight sets the weight of the store.
+func (c *RaftCluster) SetStoreWeight(storeID uint64, weight StoreWeight) error {
+	if weight.Leader <= 0 || weight.Region <= 0 {
+		return errors.Annotatef(ErrInvalidStoreWeight, "leader %v, region %v", weight.Leader, weight.Region)
 	}
 
-	return c.balancerWorker.storeScores(storeInfo)
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return errors.Trace(ErrStoreNotFound)
+	}
+
+	meta := store.meta
+	meta.LeaderWeight = weight.Leader
+	meta.RegionWeight = weight.Region
+	return errors.Trace(c.putStoreMeta(storeID, meta))
 }
 
-// FetchEvents fetches the operator events.
-func (c *RaftCluster) FetchEvents(key uint64, all bool) []LogEvent {
-	return c.balancerWorker.fetchEvents(key, all)
+// StoreLimit is the max add-peer and remove-peer operations per minute of
+// the store, zero freezes the scheduling on the store.
+type StoreLimit struct {
+	Rate float64 `json:"rate"`
+}
+
+// GetStoreLimit returns the limit of the store.
+func (c *RaftCluster) GetStoreLimit(storeID uint64) (*StoreLimit, error) {
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return nil, errors.Trace(ErrStoreNotFound)
+	}
+
+	return &StoreLimit{Rate: store.balanceRate(c.s.getBalanceConfig().StoreBalanceRate)}, nil
+}
+
+// SetStoreLimit sets the limit of the store.
+func (c *RaftCluster) SetStoreLimit(storeID uint64, limit StoreLimit) error {
+	if limit.Rate < 0 {
+		return errors.Annotatef(ErrInvalidStoreLimit, "rate %v", limit.Rate)
+	}
+
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return errors.Trace(ErrStoreNotFound)
+	}
+
+	meta := store.meta
+	meta.BalanceRate = &limit.Rate
+	return errors.Trace(c.putStoreMeta(storeID, meta))
+}
+
+// EvictStoreLeader installs the evict-leader scheduler of the store, it
+// moves the leaders out of the store, and no new leader is placed on the
+// store until the eviction is canceled. The peers are kept in the store.
+func (c *RaftCluster) EvictStoreLeader(storeID uint64) error {
+	return errors.Trace(c.setStoreEvictLeader(storeID, true))
+}
+
+// CancelEvictStoreLeader removes the evict-leader scheduler of the store.
+func (c *RaftCluster) CancelEvictStoreLeader(storeID uint64) error {
+	return errors.Trace(c.setStoreEvictLeader(storeID, false))
+}
+
+func (c *RaftCluster) setStoreEvictLeader(storeID uint64, evict bool) error {
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return errors.Trace(ErrStoreNotFound)
+	}
+
+	meta := store.meta
+	meta.EvictLeader = evict
+	return errors.Trace(c.putStoreMeta(storeID, meta))
+}
+
+// isLastReplicaStore returns true if the store holds a region replica
+// which has no other replicas on the available stores.
+func (c *RaftCluster) isLastReplicaStore(storeID uint64) bool {
+	for _, region := range c.cachedCluster.regions.getRegions() {
+		if leaderPeer(region, storeID) == nil {
+			continue
+		}
+
+		hasOtherReplica := false
+		for _, peer := range region.GetPeers() {
+			if peer.GetStoreId() == storeID {
+				continue
+			}
+			store := c.cachedCluster.getStore(peer.GetStoreId())
+			if store != nil && !store.isOffline() {
+				hasOtherReplica = true
+				break
+			}
+		}
+		if !hasOtherReplica {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *RaftCluster) putStoreMeta(storeID uint64, meta storeMeta) error {
+	metaValue, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	metaPath := makeStoreMetaKey(c.clusterRoot, storeID)
+	resp, err := c.s.leaderTxn().Then(clientv3.OpPut(metaPath, string(metaValue))).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Errorf("put store %d meta %v fail", storeID, meta)
+	}
+
+	c.cachedCluster.setStoreMeta(storeID, meta)
+
+	return nil
+}
+
+// storeState returns the current state of the store.
+func (c *RaftCluster) storeState(store *storeInfo) StoreState {
+	if store.isTombstone() {
+		return StoreStateTombstone
+	}
+	if store.isOffline() {
+		return StoreStateOffline
+	}
+	if store.downSeconds() >= c.s.getBalanceConfig().MaxStoreDownDuration.Seconds() {
+		return StoreStateDown
+	}
+
+	return StoreStateUp
+}
+
+func (c *RaftCluster) putStore(store *metapb.Store) error {
+	if store.GetId() == 0 {
+		return errors.Errorf("invalid put store %v", store)
+	}
+	if err := c.checkStoreConflict(store); err != nil {
+		return errors.Trace(err)
+	}
+
+	storeValue, err := proto.Marshal(store)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	storePath := makeStoreKey(c.clusterRoot, store.GetId())
+	resp, err := c.s.leaderTxn().Then(clientv3.OpPut(storePath, string(storeValue))).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Errorf("put store %v fail", store)
+	}
+
+	if old := c.cachedCluster.getStore(store.GetId()); old != nil && old.store.GetAddress() != store.GetAddress() {
+		log.Infof("store %d address is changed from %s to %s", store.GetId(), old.store.GetAddress(), store.GetAddress())
+	}
+	c.cachedCluster.addStore(store)
+
+	return nil
+}
+
+// checkStoreConflict applies the store conflict policy if another store
+// which is not tombstone has the address of the store.
+func (c *RaftCluster) checkStoreConflict(store *metapb.Store) error {
+	for _, other := range c.cachedCluster.getStores() {
+		if other.store.GetId() == store.GetId() || other.store.GetAddress() != store.GetAddress() || other.isTombstone() {
+			continue
+		}
+
+		if c.s.cfg.StoreConflictPolicy == storeConflictReject {
+			log.Errorf("reject store %d, address %s is used by store %d", store.GetId(), store.GetAddress(), other.store.GetId())
+			return errors.Annotatef(ErrDuplicateStoreAddress, "address %s is used by store %d", store.GetAddress(), other.store.GetId())
+		}
+		if other.isOffline() {
+			continue
+		}
+
+		// The data of the old store is lost, so it is offline even if it
+		// has the last replica of some regions.
+		log.Warnf("store %d is offline, address %s is used by store %d", other.store.GetId(), store.GetAddress(), store.GetId())
+		meta := other.meta
+		meta.State = StoreStateOffline
+		if err := c.putStoreMeta(other.store.GetId(), meta); err != nil {
+			return errors.Trace(err)
+		}
+		c.balancerWorker.postStoreStateEvent(other.store.GetId(), StoreStateOffline)
+	}
+	return nil
+}
+
+// tombstoneStore marks the store as tombstone, it fails if the store still
+// has region peers.
+func (c *RaftCluster) tombstoneStore(storeID uint64) error {
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return errors.Trace(ErrStoreNotFound)
+	}
+	if store.isTombstone() {
+		return nil
+	}
+	if n := c.cachedCluster.regions.storeRegionCount(storeID); n > 0 {
+		return errors.Annotatef(ErrStoreHasPeers, "store %d has %d region peers", storeID, n)
+	}
+
+	meta := store.meta
+	meta.State = StoreStateTombstone
+	if err := c.putStoreMeta(storeID, meta); err != nil {
+		return errors.Trace(err)
+	}
+
+	log.Infof("store %d is tombstone", storeID)
+	c.balancerWorker.postStoreStateEvent(storeID, StoreStateTombstone)
+	return nil
+}
+
+// checkOfflineStores marks the offline stores which have no region peer as
+// tombstone. It runs on every store heartbeat, which is cheap because the
+// region counts of the stores are maintained by regionsInfo.
+func (c *RaftCluster) checkOfflineStores() {
+	for _, store := range c.cachedCluster.getStores() {
+		storeID := store.store.GetId()
+		if !store.isOffline() || store.isTombstone() || c.cachedCluster.regions.storeRegionCount(storeID) > 0 {
+			continue
+		}
+		if err := c.tombstoneStore(storeID); err != nil {
+			log.Errorf("tombstone store %d err %v", storeID, err)
+		}
+	}
+}
+
+// PurgeStore removes the metadata of the tombstone store.
+func (c *RaftCluster) PurgeStore(storeID uint64) error {
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return errors.Trace(ErrStoreNotFound)
+	}
+	if !store.isTombstone() {
+		return errors.Trace(ErrStoreNotTombstone)
+	}
+
+	resp, err := c.s.leaderTxn().Then(
+		clientv3.OpDelete(makeStoreKey(c.clusterRoot, storeID)),
+		clientv3.OpDelete(makeStoreMetaKey(c.clusterRoot, storeID)),
+	).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Errorf("purge store %d fail", storeID)
+	}
+
+	c.cachedCluster.removeStore(storeID)
+	log.Infof("purge tombstone store %d", storeID)
+	return nil
+}
+
+// StoreRegistration is the store to be registered by the admin API.
+type StoreRegistration struct {
+	Address string            `json:"address"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// RegisterStores registers the stores in one transaction and returns
+// the allocated store IDs in the same order. At most 64 stores can be
+// registered at a time.
+func (c *RaftCluster) RegisterStores(regs []StoreRegistration) ([]uint64, error) {
+	if len(regs) > maxRegisterStoreCount {
+		return nil, errors.Annotatef(ErrTooManyStores, "%d stores, at most %d", len(regs), maxRegisterStoreCount)
+	}
+
+	addrs := make(map[string]struct{}, len(regs))
+	for _, store := range c.cachedCluster.getMetaStores() {
+		addrs[store.GetAddress()] = struct{}{}
+	}
+	for _, reg := range regs {
+		if _, ok := addrs[reg.Address]; ok {
+			return nil, errors.Annotatef(ErrDuplicateStoreAddress, "address %s", reg.Address)
+		}
+		addrs[reg.Address] = struct{}{}
+		if err := validateStoreLabels(reg.Labels); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	stores := make([]*metapb.Store, 0, len(regs))
+	metas := make([]storeMeta, 0, len(regs))
+	ops := make([]clientv3.Op, 0, 2*len(regs))
+	for _, reg := range regs {
+		storeID, err := c.allocStoreID()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store := &metapb.Store{
+			Id:      proto.Uint64(storeID),
+			Address: proto.String(reg.Address),
+		}
+		storeValue, err := proto.Marshal(store)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		meta := storeMeta{Labels: reg.Labels}
+		metaValue, err := json.Marshal(meta)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		ops = append(ops,
+			clientv3.OpPut(makeStoreKey(c.clusterRoot, storeID), string(storeValue)),
+			clientv3.OpPut(makeStoreMetaKey(c.clusterRoot, storeID), string(metaValue)))
+		stores = append(stores, store)
+		metas = append(metas, meta)
+	}
+
+	resp, err := c.s.leaderTxn().Then(ops...).Commit()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return nil, errors.New("register stores failed, maybe we lost leader")
+	}
+
+	ids := make([]uint64, 0, len(stores))
+	for i, store := range stores {
+		c.cachedCluster.addStore(store)
+		c.cachedCluster.setStoreMeta(store.GetId(), metas[i])
+		ids = append(ids, store.GetId())
+	}
+	return ids, nil
+}
+
+// allocStoreID allocates an ID which is not used by the existing stores,
+// so registering stores never overwrites an existing one.
+func (c *RaftCluster) allocStoreID() (uint64, error) {
+	for {
+		id, err := c.s.idAlloc.Alloc()
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if c.cachedCluster.getStore(id) == nil {
+			return id, nil
+		}
+	}
+}
+
+// GetConfig gets config from cluster.
+func (c *RaftCluster) GetConfig() *metapb.Cluster {
+	return c.cachedCluster.getMeta()
+}
+
+// GetReplicateConfig gets the replica placement config of the cluster.
+func (c *RaftCluster) GetReplicateConfig() ReplicateConfig {
+	return ReplicateConfig{
+		MaxReplicas: uint64(c.cachedCluster.getMeta().GetMaxPeerCount()),
+	}
+}
+
+// SetReplicateConfig saves the replica placement config in the cluster meta,
+// the replica balancer adds or removes peers one by one to satisfy it.
+func (c *RaftCluster) SetReplicateConfig(cfg ReplicateConfig) error {
+	// The max peer count of the cluster meta is an uint32.
+	if cfg.MaxReplicas > math.MaxUint32 {
+		return errors.Annotatef(ErrInvalidConfig, "max-replicas %d is larger than %d", cfg.MaxReplicas, uint64(math.MaxUint32))
+	}
+
+	meta := c.cachedCluster.getMeta()
+	meta.MaxPeerCount = proto.Uint32(uint32(cfg.MaxReplicas))
+	return errors.Trace(c.putConfig(meta))
+}
+
+// GetPlacementRules gets the placement rules of the cluster.
+func (c *RaftCluster) GetPlacementRules() []*PlacementRule {
+	return c.cachedCluster.getPlacementRules()
+}
+
+// SetPlacementRules replaces all the placement rules of the cluster, the
+// rules are saved in etcd, so they are still respected after the leader
+// changes.
+func (c *RaftCluster) SetPlacementRules(rules []*PlacementRule) error {
+	if err := validatePlacementRules(rules); err != nil {
+		return errors.Trace(err)
+	}
+
+	key := makePlacementRulesKey(c.clusterRoot)
+	if err := c.saveRangeConfigs(key, rules, len(rules), "placement rules"); err != nil {
+		return errors.Trace(err)
+	}
+
+	c.cachedCluster.setPlacementRules(rules)
+	return nil
+}
+
+// loadPlacementRules loads the placement rules saved in etcd.
+func (c *RaftCluster) loadPlacementRules() error {
+	var rules []*PlacementRule
+	if err := c.loadRangeConfigs(makePlacementRulesKey(c.clusterRoot), &rules); err != nil {
+		return errors.Trace(err)
+	}
+	c.cachedCluster.setPlacementRules(rules)
+	return nil
+}
+
+// GetLeaderAffinities gets the leader affinities of the cluster.
+func (c *RaftCluster) GetLeaderAffinities() []*LeaderAffinity {
+	return c.cachedCluster.getLeaderAffinities()
+}
+
+// SetLeaderAffinities replaces all the leader affinities of the cluster,
+// the affinities are saved in etcd, so the new leader can use them after
+// the leader changes.
+func (c *RaftCluster) SetLeaderAffinities(affinities []*LeaderAffinity) error {
+	if err := validateLeaderAffinities(affinities); err != nil {
+		return errors.Trace(err)
+	}
+
+	key := makeLeaderAffinitiesKey(c.clusterRoot)
+	if err := c.saveRangeConfigs(key, affinities, len(affinities), "leader affinities"); err != nil {
+		return errors.Trace(err)
+	}
+
+	c.cachedCluster.setLeaderAffinities(affinities)
+	return nil
+}
+
+// loadLeaderAffinities loads the leader affinities saved in etcd.
+func (c *RaftCluster) loadLeaderAffinities() error {
+	var affinities []*LeaderAffinity
+	if err := c.loadRangeConfigs(makeLeaderAffinitiesKey(c.clusterRoot), &affinities); err != nil {
+		return errors.Trace(err)
+	}
+	c.cachedCluster.setLeaderAffinities(affinities)
+	return nil
+}
+
+// GetNamespaces gets the namespaces of the cluster.
+func (c *RaftCluster) GetNamespaces() []*Namespace {
+	return c.cachedCluster.getNamespaces()
+}
+
+// SetNamespaces replaces all the namespaces of the cluster, the namespaces
+// are saved in etcd like the placement rules.
+func (c *RaftCluster) SetNamespaces(namespaces []*Namespace) error {
+	if err := validateNamespaces(namespaces); err != nil {
+		return errors.Trace(err)
+	}
+
+	key := makeNamespacesKey(c.clusterRoot)
+	if err := c.saveRangeConfigs(key, namespaces, len(namespaces), "namespaces"); err != nil {
+		return errors.Trace(err)
+	}
+
+	c.cachedCluster.setNamespaces(namespaces)
+	return nil
+}
+
+// loadNamespaces loads the namespaces saved in etcd.
+func (c *RaftCluster) loadNamespaces() error {
+	var namespaces []*Namespace
+	if err := c.loadRangeConfigs(makeNamespacesKey(c.clusterRoot), &namespaces); err != nil {
+		return errors.Trace(err)
+	}
+	c.cachedCluster.setNamespaces(namespaces)
+	return nil
+}
+
+func (c *RaftCluster) putConfig(meta *metapb.Cluster) error {
+	if meta.GetId() != c.clusterID {
+		return errors.Errorf("invalid cluster %v, mismatch cluster id %d", meta, c.clusterID)
+	}
+
+	metaValue, err := proto.Marshal(meta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	resp, err := c.s.leaderTxn().Then(clientv3.OpPut(c.clusterRoot, string(metaValue))).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Errorf("put cluster meta %v error", meta)
+	}
+
+	c.cachedCluster.setMeta(meta)
+
+	return nil
+}
+
+// GetBalanceOperators gets the balance operators from cluster.
+func (c *RaftCluster) GetBalanceOperators() map[uint64]Operator {
+	return c.balancerWorker.getBalanceOperators()
+}
+
+// GetHistoryOperators gets the history operators from cluster.
+func (c *RaftCluster) GetHistoryOperators() []Operator {
+	return c.balancerWorker.getHistoryOperators()
+}
+
+// GetOperators gets the progress of the pending and running operators.
+func (c *RaftCluster) GetOperators() []*OperatorStatus {
+	return c.balancerWorker.getOperatorStatuses()
+}
+
+// RunSchedulePass runs a scheduling pass now instead of waiting for the
+// balance loop, and returns the operators added by the pass.
+func (c *RaftCluster) RunSchedulePass() []*OperatorStatus {
+	bops := c.balancerWorker.runPass()
+	return c.balancerWorker.getStatuses(bops)
+}
+
+// AddOperator adds the operator requested by the user, it is executed
+// before the balancers move the region. It returns the operator ID.
+func (c *RaftCluster) AddOperator(req *OperatorRequest) (uint64, error) {
+	op, err := newRequestedOperator(c.cachedCluster, req, c.s.getBalanceConfig())
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	if !c.balancerWorker.addManualBalanceOperator(req.RegionID, op) {
+		return 0, errors.Trace(ErrRegionHasOperator)
+	}
+	log.Infof("add operator %s", op)
+	return op.ID, nil
+}
+
+// AddRegionPeer adds a peer of the region on the store, the store must
+// satisfy the location labels, the placement rule and the namespace of the
+// region. If the region has enough replicas already, a follower is removed
+// after the new peer catches up, see selectReplacedPeer. It returns the
+// operator ID.
+func (c *RaftCluster) AddRegionPeer(regionID uint64, storeID uint64) (uint64, error) {
+	region, leader := c.cachedCluster.regions.getRegionByID(regionID)
+	if region == nil {
+		return 0, errors.Trace(ErrRegionNotFound)
+	}
+	store := c.cachedCluster.getStore(storeID)
+	if store == nil {
+		return 0, errors.Trace(ErrStoreNotFound)
+	}
+	if leaderPeer(region, storeID) != nil {
+		return 0, errors.Annotatef(ErrPeerExists, "region %d has a peer on store %d already", regionID, storeID)
+	}
+	if c.storeState(store) != StoreStateUp {
+		return 0, errors.Annotatef(ErrInvalidOperator, "store %d is not up", storeID)
+	}
+
+	cfg := c.s.getBalanceConfig()
+	stores := c.cachedCluster.getStores()
+	// The follower to be removed doesn't count in the location check, so
+	// the new peer can replace a follower in the same location.
+	var removedPeers []*metapb.Peer
+	if len(region.GetPeers()) >= int(c.cachedCluster.getMeta().GetMaxPeerCount()) {
+		if leader == nil {
+			return 0, errors.Annotatef(ErrInvalidOperator, "region %d has no leader now", regionID)
+		}
+		peer, err := c.selectReplacedPeer(region, leader, store, cfg)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if peer == nil {
+			return 0, errors.Annotatef(ErrInvalidOperator, "region %d has no follower to remove", regionID)
+		}
+		removedPeers = append(removedPeers, peer)
+	}
+
+	excluded := make(map[uint64]struct{})
+	excludeSameLocationStores(c.cachedCluster, stores, region, excluded, cfg.LocationLabels, removedPeers...)
+	excludeRuleStores(c.cachedCluster, stores, region, excluded)
+	excludeNamespaceStores(c.cachedCluster, stores, region, excluded)
+	if _, ok := excluded[storeID]; ok {
+		return 0, errors.Annotatef(ErrInvalidOperator, "store %d doesn't satisfy the location labels, placement rule or namespace of region %d", storeID, regionID)
+	}
+
+	peerID, err := c.cachedCluster.idAlloc.Alloc()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	newPeer := &metapb.Peer{
+		Id:      proto.Uint64(peerID),
+		StoreId: proto.Uint64(storeID),
+	}
+	ops := []Operator{newAddPeerOperator(regionID, newPeer)}
+	for _, peer := range removedPeers {
+		ops = append(ops, newCatchUpOperator(regionID, newPeer, cfg), newRemovePeerOperator(regionID, peer))
+	}
+
+	op := newBalanceOperator(region, ops...)
+	if !c.balancerWorker.addManualBalanceOperator(regionID, op) {
+		return 0, errors.Trace(ErrRegionHasOperator)
+	}
+	log.Infof("add peer for region %d - %s", regionID, op)
+	return op.ID, nil
+}
+
+// selectReplacedPeer selects the follower replaced by the new peer on the
+// store. The follower in the same location as the store is preferred, so
+// the replicas stay in different locations, otherwise the follower is
+// selected like the capacity balancer does.
+func (c *RaftCluster) selectReplacedPeer(region *metapb.Region, leader *metapb.Peer, store *storeInfo, cfg *BalanceConfig) (*metapb.Peer, error) {
+	followers := getFollowerPeers(region, leader)
+	var replaced *metapb.Peer
+	for storeID, peer := range followers {
+		followerStore := c.cachedCluster.getStore(storeID)
+		if followerStore == nil || !store.isSameLocation(followerStore, cfg.LocationLabels) {
+			continue
+		}
+		if replaced == nil || storeID < replaced.GetStoreId() {
+			replaced = peer
+		}
+	}
+	if replaced != nil {
+		return replaced, nil
+	}
+
+	peer, err := newCapacityBalancer(cfg).selectRemovePeer(c.cachedCluster, followers)
+	return peer, errors.Trace(err)
+}
+
+// RemoveRegionPeer removes the peer of the region on the store, it refuses
+// to remove the peer if the healthy peers left can't form a quorum. It
+// returns the operator ID.
+func (c *RaftCluster) RemoveRegionPeer(regionID uint64, storeID uint64) (uint64, error) {
+	region, _ := c.cachedCluster.regions.getRegionByID(regionID)
+	if region == nil {
+		return 0, errors.Trace(ErrRegionNotFound)
+	}
+	peer := leaderPeer(region, storeID)
+	if peer == nil {
+		return 0, errors.Annotatef(ErrPeerNotFound, "region %d has no peer on store %d", regionID, storeID)
+	}
+
+	if err := c.cachedCluster.checkRemovePeerQuorum(region, peer, c.s.getBalanceConfig().MaxStoreDownDuration.Duration); err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	return c.AddOperator(&OperatorRequest{
+		Name:     OperatorRemovePeer,
+		RegionID: regionID,
+		StoreID:  storeID,
+	})
+}
+
+// CancelOperator cancels the operator of the region. The steps finished
+// are not rolled back here, if a peer has been added but the old one is
+// not removed yet, the replica balancer removes the extra peer, preferring
+// the down peers, so the region is never under-replicated by canceling.
+func (c *RaftCluster) CancelOperator(regionID uint64) error {
+	if !c.balancerWorker.cancelBalanceOperator(regionID) {
+		return errors.Trace(ErrOperatorNotFound)
+	}
+	return nil
+}
+
+// ScatterRegion moves the region peers to randomly selected stores.
+func (c *RaftCluster) ScatterRegion(regionID uint64) error {
+	region, leader := c.cachedCluster.regions.getRegionByID(regionID)
+	if region == nil {
+		return errors.Trace(ErrRegionNotFound)
+	}
+
+	scatterer := newRegionScatterer(c.s.getBalanceConfig())
+	op, err := scatterer.scatter(c.cachedCluster, region, leader)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if op == nil {
+		log.Infof("region %d is already scattered", regionID)
+		return nil
+	}
+
+	if !c.balancerWorker.addManualBalanceOperator(regionID, op) {
+		return errors.Trace(ErrRegionHasOperator)
+	}
+	log.Infof("scatter region %d - %s", regionID, op)
+	return nil
+}
+
+// ScatterRegions scatters the regions, e.g, the ones ScanRangeRegions
+// returns. The regions which have pending operators are skipped, it returns
+// the IDs of the scattered regions.
+func (c *RaftCluster) ScatterRegions(regions []*metapb.Region) ([]uint64, error) {
+	var scattered []uint64
+	for _, region := range regions {
+		err := c.ScatterRegion(region.GetId())
+		switch errors.Cause(err) {
+		case nil:
+			scattered = append(scattered, region.GetId())
+		case ErrRegionHasOperator:
+		default:
+			return scattered, errors.Trace(err)
+		}
+	}
+	return scattered, nil
+}
+
+// GetScores gets store scores from balancer.
+func (c *RaftCluster) GetScores(store *metapb.Store, status *StoreStatus) []int {
+	storeInfo := &storeInfo{
+		store: store,
+		stats: status,
+	}
+
+	return c.balancerWorker.storeScores(storeInfo)
+}
+
+// FetchEvents fetches the operator events.
+func (c *RaftCluster) FetchEvents(key uint64, all bool) []LogEvent {
+	return c.balancerWorker.fetchEvents(key, all)
+}
+
+// GetStoreHistory gets the scheduling events which touch the store after
+// since, the newest event is the first.
+func (c *RaftCluster) GetStoreHistory(storeID uint64, since time.Time) ([]LogEvent, error) {
+	if store := c.cachedCluster.getStore(storeID); store == nil {
+		return nil, errors.Trace(ErrStoreNotFound)
+	}
+	return c.balancerWorker.storeEvents(storeID, since), nil
+}
+
+// GetLatestEvents gets at most n latest scheduling events.
+func (c *RaftCluster) GetLatestEvents(n int) []LogEvent {
+	return c.balancerWorker.latestEvents(n)
+}
+
+// SchedulerInfo is the scheduler name, its arguments, the time it is
+// paused until and whether it runs in dry-run mode.
+type SchedulerInfo struct {
+	Name        string            `json:"name"`
+	Args        map[string]string `json:"args,omitempty"`
+	Paused      bool              `json:"paused"`
+	PausedUntil time.Time         `json:"paused_until"`
+	DryRun      bool              `json:"dry_run"`
+}
+
+// GetSchedulers returns all the schedulers and their pause states.
+func (c *RaftCluster) GetSchedulers() []*SchedulerInfo {
+	bw := c.balancerWorker
+	balancers := bw.getBalancers()
+	schedulers := make([]*SchedulerInfo, 0, len(balancers))
+	for _, balancer := range balancers {
+		until := bw.getPausedUntil(balancer.GetName())
+		info := &SchedulerInfo{
+			Name:        balancer.GetName(),
+			Paused:      !until.IsZero(),
+			PausedUntil: until,
+			DryRun:      bw.isBalancerDryRun(balancer.GetName()),
+		}
+		if eb, ok := balancer.(*evictLeaderBalancer); ok {
+			info.Args = map[string]string{"store_id": strconv.FormatUint(eb.storeID, 10)}
+		}
+		schedulers = append(schedulers, info)
+	}
+
+	return schedulers
+}
+
+// AddScheduler adds the scheduler by name, a removed built-in scheduler
+// is added back, and the evict-leader scheduler requires the store_id
+// argument. The change is saved in etcd, so it is still respected after
+// the leader changes.
+func (c *RaftCluster) AddScheduler(name string, args map[string]string) error {
+	if name == "evict-leader" {
+		value, ok := args["store_id"]
+		if !ok {
+			return errors.Annotate(ErrInvalidSchedulerArgs, "store_id is required")
+		}
+		storeID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return errors.Annotatef(ErrInvalidSchedulerArgs, "store_id %s", value)
+		}
+		return errors.Trace(c.EvictStoreLeader(storeID))
+	}
+
+	if !c.balancerWorker.isBuiltinBalancer(name) {
+		return errors.Trace(ErrSchedulerNotFound)
+	}
+	return errors.Trace(c.setSchedulerRemoved(name, false))
+}
+
+// RemoveScheduler removes the scheduler by name, the change is saved in
+// etcd, so it is still respected after the leader changes.
+func (c *RaftCluster) RemoveScheduler(name string) error {
+	balancer := c.balancerWorker.getBalancer(name)
+	if balancer == nil {
+		return errors.Trace(ErrSchedulerNotFound)
+	}
+
+	if eb, ok := balancer.(*evictLeaderBalancer); ok {
+		return errors.Trace(c.CancelEvictStoreLeader(eb.storeID))
+	}
+	return errors.Trace(c.setSchedulerRemoved(name, true))
+}
+
+// setSchedulerRemoved saves the removed built-in schedulers, the pause
+// state of a removed scheduler is cleared.
+func (c *RaftCluster) setSchedulerRemoved(name string, removed bool) error {
+	c.removedSchedulersLock.Lock()
+	defer c.removedSchedulersLock.Unlock()
+
+	bw := c.balancerWorker
+	value, err := json.Marshal(bw.removedBalancersAfter(name, removed))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	ops := []clientv3.Op{clientv3.OpPut(makeRemovedSchedulersKey(c.clusterRoot), string(value))}
+	if removed {
+		ops = append(ops, clientv3.OpDelete(makeSchedulerKey(c.clusterRoot, name)))
+	}
+	resp, err := c.s.leaderTxn().Then(ops...).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Errorf("save scheduler %s removed state failed, maybe we lost leader", name)
+	}
+
+	if removed {
+		bw.pauseBalancer(name, time.Time{})
+	}
+	bw.setBalancerRemoved(name, removed)
+	return nil
+}
+
+// loadRemovedSchedulers loads the removed built-in schedulers saved in etcd.
+func (c *RaftCluster) loadRemovedSchedulers() error {
+	value, err := getValue(c.s.client, makeRemovedSchedulersKey(c.clusterRoot))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if value == nil {
+		return nil
+	}
+
+	var names []string
+	if err = json.Unmarshal(value, &names); err != nil {
+		return errors.Trace(err)
+	}
+	for _, name := range names {
+		c.balancerWorker.setBalancerRemoved(name, true)
+	}
+	return nil
+}
+
+// PauseScheduler pauses the scheduler for the duration. The pause state
+// is saved in etcd, so it is still respected after the leader changes.
+func (c *RaftCluster) PauseScheduler(name string, d time.Duration) error {
+	return errors.Trace(c.setSchedulerPausedUntil(name, time.Now().Add(d)))
+}
+
+// SetSchedulerDryRun sets whether the scheduler runs in dry-run mode, the
+// operators of a dry-run scheduler are only recorded but not executed.
+// The dry-run mode is not saved, it is reset after the leader changes.
+func (c *RaftCluster) SetSchedulerDryRun(name string, dryRun bool) error {
+	if c.balancerWorker.getBalancer(name) == nil {
+		return errors.Trace(ErrSchedulerNotFound)
+	}
+
+	c.balancerWorker.setBalancerDryRun(name, dryRun)
+	return nil
+}
+
+// GetDryRunOperators gets the latest operators generated by the dry-run schedulers.
+func (c *RaftCluster) GetDryRunOperators() []*DryRunOperatorStatus {
+	return c.balancerWorker.getDryRunOperatorStatuses()
+}
+
+// ResumeScheduler resumes the paused scheduler.
+func (c *RaftCluster) ResumeScheduler(name string) error {
+	return errors.Trace(c.setSchedulerPausedUntil(name, time.Time{}))
+}
+
+func (c *RaftCluster) setSchedulerPausedUntil(name string, until time.Time) error {
+	if c.balancerWorker.getBalancer(name) == nil {
+		return errors.Trace(ErrSchedulerNotFound)
+	}
+
+	key := makeSchedulerKey(c.clusterRoot, name)
+	op := clientv3.OpDelete(key)
+	if !until.IsZero() {
+		value, err := until.MarshalText()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		op = clientv3.OpPut(key, string(value))
+	}
+
+	resp, err := c.s.leaderTxn().Then(op).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Errorf("save scheduler %s pause state failed, maybe we lost leader", name)
+	}
+
+	c.balancerWorker.pauseBalancer(name, until)
+	return nil
+}
+
+// loadSchedulerPauses loads the pause states of the schedulers saved in etcd.
+func (c *RaftCluster) loadSchedulerPauses() error {
+	for _, balancer := range c.balancerWorker.getBalancers() {
+		value, err := getValue(c.s.client, makeSchedulerKey(c.clusterRoot, balancer.GetName()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if value == nil {
+			continue
+		}
+
+		var until time.Time
+		if err = until.UnmarshalText(value); err != nil {
+			return errors.Trace(err)
+		}
+		c.balancerWorker.pauseBalancer(balancer.GetName(), until)
+	}
+
+	return nil
+}
+
+// MaintenanceInfo is the maintenance mode of the cluster, no scheduling
+// operator is issued in maintenance mode.
+type MaintenanceInfo struct {
+	Enabled   bool      `json:"enabled"`
+	EnabledBy string    `json:"enabled_by,omitempty"`
+	EnabledAt time.Time `json:"enabled_at"`
+}
+
+// GetMaintenance returns the maintenance mode of the cluster.
+func (c *RaftCluster) GetMaintenance() (*MaintenanceInfo, error) {
+	info := &MaintenanceInfo{}
+	value, err := getValue(c.s.client, makeMaintenanceKey(c.clusterRoot))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if value == nil {
+		return info, nil
+	}
+
+	if err = json.Unmarshal(value, info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return info, nil
+}
+
+// SetMaintenance enables or disables the maintenance mode, by is who
+// enables it. The mode is saved in etcd, so it is still respected after
+// the leader changes.
+func (c *RaftCluster) SetMaintenance(enabled bool, by string) error {
+	key := makeMaintenanceKey(c.clusterRoot)
+	op := clientv3.OpDelete(key)
+	if enabled {
+		value, err := json.Marshal(&MaintenanceInfo{
+			Enabled:   true,
+			EnabledBy: by,
+			EnabledAt: time.Now(),
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		op = clientv3.OpPut(key, string(value))
+	}
+
+	resp, err := c.s.leaderTxn().Then(op).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.New("save maintenance mode failed, maybe we lost leader")
+	}
+
+	c.balancerWorker.setMaintenance(enabled)
+	return nil
+}
+
+// loadMaintenance loads the maintenance mode saved in etcd.
+func (c *RaftCluster) loadMaintenance() error {
+	info, err := c.GetMaintenance()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	c.balancerWorker.setMaintenance(info.Enabled)
+	return nil
+}
+
+// ImportModeInfo is the import mode of the cluster, the region balancers
+// are suppressed in import mode, see balancerWorker.setImportMode.
+type ImportModeInfo struct {
+	Enabled   bool      `json:"enabled"`
+	EnabledAt time.Time `json:"enabled_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GetImportMode returns the import mode of the cluster, the mode is
+// disabled if it has expired.
+func (c *RaftCluster) GetImportMode() (*ImportModeInfo, error) {
+	info := &ImportModeInfo{}
+	value, err := getValue(c.s.client, makeImportModeKey(c.clusterRoot))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if value == nil {
+		return info, nil
+	}
+
+	if err = json.Unmarshal(value, info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !time.Now().Before(info.ExpiresAt) {
+		return &ImportModeInfo{}, nil
+	}
+	return info, nil
+}
+
+// SetImportMode enables or disables the import mode, it expires after the
+// import mode TTL of the config. The mode is saved in etcd, so it is still
+// respected after the leader changes.
+func (c *RaftCluster) SetImportMode(enabled bool) error {
+	key := makeImportModeKey(c.clusterRoot)
+	op := clientv3.OpDelete(key)
+	info := &ImportModeInfo{}
+	if enabled {
+		now := time.Now()
+		info = &ImportModeInfo{
+			Enabled:   true,
+			EnabledAt: now,
+			ExpiresAt: now.Add(c.s.getBalanceConfig().ImportModeTTL.Duration),
+		}
+		value, err := json.Marshal(info)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		op = clientv3.OpPut(key, string(value))
+	}
+
+	resp, err := c.s.leaderTxn().Then(op).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.New("save import mode failed, maybe we lost leader")
+	}
+
+	c.balancerWorker.setImportMode(info.ExpiresAt)
+	return nil
+}
+
+// loadImportMode loads the import mode saved in etcd.
+func (c *RaftCluster) loadImportMode() error {
+	info, err := c.GetImportMode()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	c.balancerWorker.setImportMode(info.ExpiresAt)
+	return nil
 }
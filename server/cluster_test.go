@@ -16,9 +16,12 @@ package server
 import (
 	"net"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/golang/protobuf/proto"
+	"github.com/juju/errors"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
@@ -302,3 +305,287 @@ func (s *testClusterSuite) TestGetPutConfig(c *C) {
 	meta := s.getClusterConfig(c, conn, clusterID)
 	c.Assert(meta.GetMaxPeerCount(), Equals, uint32(5))
 }
+
+func (s *testClusterSuite) TestPauseScheduler(c *C) {
+	leader := mustGetLeader(c, s.client, s.svr.getLeaderPath())
+
+	conn, err := rpcConnect(leader.GetAddr())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.tryBootstrapCluster(c, conn, 0, "127.0.0.1:0")
+
+	cluster, err := s.svr.GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster, NotNil)
+
+	c.Assert(errors.Cause(cluster.PauseScheduler("unknown", time.Minute)), Equals, ErrSchedulerNotFound)
+	c.Assert(cluster.PauseScheduler("region", time.Minute), IsNil)
+
+	// The pause state is loaded from etcd after the cluster restarts,
+	// just like the new leader starts the cluster.
+	meta := cluster.cachedCluster.getMeta()
+	cluster.stop()
+	c.Assert(cluster.start(*meta), IsNil)
+	c.Assert(cluster.balancerWorker.isBalancerPaused("region"), IsTrue)
+	c.Assert(cluster.balancerWorker.isBalancerPaused("leader"), IsFalse)
+
+	c.Assert(cluster.ResumeScheduler("region"), IsNil)
+	cluster.stop()
+	c.Assert(cluster.start(*meta), IsNil)
+	c.Assert(cluster.balancerWorker.isBalancerPaused("region"), IsFalse)
+}
+
+func (s *testClusterSuite) TestRemoveSchedulersConcurrently(c *C) {
+	leader := mustGetLeader(c, s.client, s.svr.getLeaderPath())
+
+	conn, err := rpcConnect(leader.GetAddr())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.tryBootstrapCluster(c, conn, 0, "127.0.0.1:0")
+
+	cluster, err := s.svr.GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster, NotNil)
+
+	names := []string{"region", "leader", "leader-affinity"}
+	setRemoved := func(removed bool) {
+		var wg sync.WaitGroup
+		for _, name := range names {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				if removed {
+					c.Assert(cluster.RemoveScheduler(name), IsNil)
+				} else {
+					c.Assert(cluster.AddScheduler(name, nil), IsNil)
+				}
+			}(name)
+		}
+		wg.Wait()
+
+		// None of the changes is lost after the cluster restarts, just
+		// like the new leader starts the cluster.
+		meta := cluster.cachedCluster.getMeta()
+		cluster.stop()
+		c.Assert(cluster.start(*meta), IsNil)
+		for _, name := range names {
+			c.Assert(cluster.balancerWorker.isBalancerRemoved(name), Equals, removed, Commentf("scheduler %s", name))
+		}
+	}
+	for i := 0; i < 5; i++ {
+		setRemoved(true)
+		setRemoved(false)
+	}
+}
+
+func (s *testClusterSuite) TestStoreDown(c *C) {
+	leader := mustGetLeader(c, s.client, s.svr.getLeaderPath())
+
+	conn, err := rpcConnect(leader.GetAddr())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.tryBootstrapCluster(c, conn, 0, "127.0.0.1:0")
+
+	cluster, err := s.svr.GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster, NotNil)
+
+	// A too small duration may mark the healthy stores down.
+	oldCfg := s.svr.GetConfig().BalanceCfg
+	cfg := oldCfg
+	cfg.MaxStoreDownDuration.Duration = time.Second
+	c.Assert(errors.Cause(s.svr.SetBalanceConfig(cfg)), Equals, ErrInvalidConfig)
+	cfg.MaxStoreDownDuration.Duration = time.Minute
+	c.Assert(s.svr.SetBalanceConfig(cfg), IsNil)
+	defer s.svr.SetBalanceConfig(oldCfg)
+
+	storeID := s.allocID(c)
+	c.Assert(cluster.putStore(s.newStore(c, storeID, "127.0.0.1:10")), IsNil)
+	storeState := func() StoreState {
+		return cluster.storeState(cluster.cachedCluster.getStore(storeID))
+	}
+	heartbeat := func() {
+		c.Assert(cluster.cachedCluster.updateStoreStatus(&pdpb.StoreStats{StoreId: proto.Uint64(storeID)}), IsTrue)
+	}
+
+	// The store has never sent heartbeats.
+	c.Assert(storeState(), Equals, StoreStateDown)
+	heartbeat()
+	c.Assert(storeState(), Equals, StoreStateUp)
+
+	// The store is down only after it doesn't send heartbeats for the duration.
+	c.Assert(cluster.cachedCluster.touchStore(storeID, time.Now().Add(-50*time.Second)), IsTrue)
+	c.Assert(storeState(), Equals, StoreStateUp)
+	c.Assert(cluster.cachedCluster.touchStore(storeID, time.Now().Add(-61*time.Second)), IsTrue)
+	c.Assert(storeState(), Equals, StoreStateDown)
+
+	// The store is up again after the heartbeats resume.
+	heartbeat()
+	c.Assert(storeState(), Equals, StoreStateUp)
+
+	// The new leader doesn't know the heartbeats sent to the previous one,
+	// so it gives the stores the whole duration to send the heartbeats.
+	c.Assert(cluster.cachedCluster.touchStore(storeID, time.Now().Add(-61*time.Second)), IsTrue)
+	c.Assert(storeState(), Equals, StoreStateDown)
+	meta := cluster.cachedCluster.getMeta()
+	cluster.stop()
+	c.Assert(cluster.start(*meta), IsNil)
+	c.Assert(storeState(), Equals, StoreStateUp)
+}
+
+func (s *testClusterSuite) TestConfigRevision(c *C) {
+	oldCfg := s.svr.GetScheduleConfig()
+	defer s.svr.SetScheduleConfig(oldCfg)
+	old := s.svr.getBalanceConfig()
+
+	cfg := oldCfg
+	cfg.LeaderScheduleLimit = oldCfg.LeaderScheduleLimit + 1
+	c.Assert(s.svr.SetScheduleConfig(cfg), IsNil)
+
+	// A new copy of the balance config is published, the old one which may
+	// be used by the balancers is unchanged.
+	cur := s.svr.getBalanceConfig()
+	c.Assert(cur, Not(Equals), old)
+	c.Assert(cur.LeaderScheduleLimit, Equals, cfg.LeaderScheduleLimit)
+	c.Assert(old.LeaderScheduleLimit, Equals, oldCfg.LeaderScheduleLimit)
+
+	// The change saved by the server itself and the older changes are not
+	// applied again.
+	s.svr.cfgLock.RLock()
+	rev := s.svr.cfgRevision
+	s.svr.cfgLock.RUnlock()
+	for _, r := range []int64{rev, rev - 1} {
+		c.Assert(s.svr.applyConfig(s.svr.getScheduleConfigPath(), []byte(`{"leader-schedule-limit": 100}`), r), IsNil)
+		c.Assert(s.svr.getBalanceConfig(), Equals, cur)
+		c.Assert(s.svr.GetScheduleConfig().LeaderScheduleLimit, Equals, cfg.LeaderScheduleLimit)
+	}
+}
+
+func (s *testClusterSuite) TestStoreConflict(c *C) {
+	leader := mustGetLeader(c, s.client, s.svr.getLeaderPath())
+
+	conn, err := rpcConnect(leader.GetAddr())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.tryBootstrapCluster(c, conn, 0, "127.0.0.1:0")
+
+	cluster, err := s.svr.GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster, NotNil)
+	defer func() {
+		s.svr.cfg.StoreConflictPolicy = storeConflictReject
+	}()
+
+	storeID1, storeID2, storeID3 := s.allocID(c), s.allocID(c), s.allocID(c)
+	addr := "127.0.0.1:30"
+	c.Assert(cluster.putStore(s.newStore(c, storeID1, addr)), IsNil)
+	// The store can be put again with the same address.
+	c.Assert(cluster.putStore(s.newStore(c, storeID1, addr)), IsNil)
+
+	// The new store is rejected by default.
+	err = cluster.putStore(s.newStore(c, storeID2, addr))
+	c.Assert(errors.Cause(err), Equals, ErrDuplicateStoreAddress)
+	c.Assert(cluster.cachedCluster.getStore(storeID2), IsNil)
+
+	// The old store is offline after the new store is put, then it becomes
+	// tombstone because it has no region.
+	s.svr.cfg.StoreConflictPolicy = storeConflictReplace
+	c.Assert(cluster.putStore(s.newStore(c, storeID2, addr)), IsNil)
+	store1 := cluster.cachedCluster.getStore(storeID1)
+	c.Assert(cluster.storeState(store1), Equals, StoreStateOffline)
+	c.Assert(cluster.cachedCluster.getStore(storeID2).isOffline(), IsFalse)
+	cluster.checkOfflineStores()
+	c.Assert(cluster.storeState(cluster.cachedCluster.getStore(storeID1)), Equals, StoreStateTombstone)
+
+	// The tombstone store can't send heartbeats any more.
+	req := &pdpb.Request{
+		Header:  newRequestHeader(s.svr.cfg.ClusterID),
+		CmdType: pdpb.CommandType_StoreHeartbeat.Enum(),
+		StoreHeartbeat: &pdpb.StoreHeartbeatRequest{
+			Stats: &pdpb.StoreStats{StoreId: proto.Uint64(storeID1)},
+		},
+	}
+	sendRequest(c, conn, 0, req)
+	_, resp := recvResponse(c, conn)
+	c.Assert(resp.GetHeader().GetError(), NotNil)
+
+	// The tombstone store doesn't conflict with the new stores, so only
+	// store 2 becomes offline.
+	c.Assert(cluster.putStore(s.newStore(c, storeID3, addr)), IsNil)
+	c.Assert(cluster.cachedCluster.getStore(storeID2).isOffline(), IsTrue)
+	c.Assert(cluster.cachedCluster.getStore(storeID3).isOffline(), IsFalse)
+
+	// The tombstone store is purged, but the offline store can't be.
+	c.Assert(errors.Cause(cluster.PurgeStore(storeID2)), Equals, ErrStoreNotTombstone)
+	c.Assert(cluster.PurgeStore(storeID1), IsNil)
+	c.Assert(cluster.cachedCluster.getStore(storeID1), IsNil)
+	c.Assert(errors.Cause(cluster.PurgeStore(storeID1)), Equals, ErrStoreNotFound)
+}
+
+func (s *testClusterSuite) TestStoreAddressChange(c *C) {
+	leader := mustGetLeader(c, s.client, s.svr.getLeaderPath())
+
+	conn, err := rpcConnect(leader.GetAddr())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.tryBootstrapCluster(c, conn, 0, "127.0.0.1:0")
+
+	cluster, err := s.svr.GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster, NotNil)
+
+	storeID := s.allocID(c)
+	c.Assert(cluster.putStore(s.newStore(c, storeID, "127.0.0.1:40")), IsNil)
+	c.Assert(cluster.cachedCluster.updateStoreStatus(&pdpb.StoreStats{StoreId: proto.Uint64(storeID), Capacity: proto.Uint64(100)}), IsTrue)
+
+	// The store keeps its stats after it is put with a new address.
+	c.Assert(cluster.putStore(s.newStore(c, storeID, "127.0.0.1:41")), IsNil)
+	store := cluster.cachedCluster.getStore(storeID)
+	c.Assert(store.store.GetAddress(), Equals, "127.0.0.1:41")
+	c.Assert(store.stats.Stats.GetCapacity(), Equals, uint64(100))
+	c.Assert(cluster.storeState(store), Equals, StoreStateUp)
+
+	// The new leader loads the new address.
+	meta := cluster.cachedCluster.getMeta()
+	cluster.stop()
+	c.Assert(cluster.start(*meta), IsNil)
+	c.Assert(cluster.cachedCluster.getStore(storeID).store.GetAddress(), Equals, "127.0.0.1:41")
+}
+
+func (s *testClusterSuite) TestMaintenance(c *C) {
+	leader := mustGetLeader(c, s.client, s.svr.getLeaderPath())
+
+	conn, err := rpcConnect(leader.GetAddr())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.tryBootstrapCluster(c, conn, 0, "127.0.0.1:0")
+
+	cluster, err := s.svr.GetRaftCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster, NotNil)
+
+	c.Assert(cluster.SetMaintenance(true, "tester"), IsNil)
+	c.Assert(cluster.balancerWorker.allowBalance(), IsFalse)
+
+	// The maintenance mode is loaded from etcd after the cluster restarts,
+	// just like the new leader starts the cluster.
+	meta := cluster.cachedCluster.getMeta()
+	cluster.stop()
+	c.Assert(cluster.start(*meta), IsNil)
+	c.Assert(cluster.balancerWorker.inMaintenance(), IsTrue)
+	info, err := cluster.GetMaintenance()
+	c.Assert(err, IsNil)
+	c.Assert(info.EnabledBy, Equals, "tester")
+
+	c.Assert(cluster.SetMaintenance(false, ""), IsNil)
+	cluster.stop()
+	c.Assert(cluster.start(*meta), IsNil)
+	c.Assert(cluster.balancerWorker.inMaintenance(), IsFalse)
+	c.Assert(cluster.balancerWorker.allowBalance(), IsTrue)
+}
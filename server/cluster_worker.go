@@ -28,7 +28,7 @@ func (c *RaftCluster) addReplicaBalanceOperator(region *metapb.Region, leader *m
 		return nil, nil
 	}
 
-	balancer := newReplicaBalancer(region, leader, downPeers, &c.s.cfg.BalanceCfg)
+	balancer := newReplicaBalancer(region, leader, downPeers, c.s.getBalanceConfig())
 	_, balanceOperator, err := balancer.Balance(c.cachedCluster)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -52,6 +52,11 @@ func (c *RaftCluster) handleRegionHeartbeat(region *metapb.Region, leader *metap
 		return nil, errors.Errorf("invalid region, zero region peer count - %v", region)
 	}
 
+	// The pending operators are continued after the maintenance.
+	if c.balancerWorker.inMaintenance() {
+		return nil, nil
+	}
+
 	regionID := region.GetId()
 	balanceOperator := c.balancerWorker.getBalanceOperator(regionID)
 	var err error
@@ -65,17 +70,27 @@ func (c *RaftCluster) handleRegionHeartbeat(region *metapb.Region, leader *metap
 		}
 	}
 
+	if c.balancerWorker.checkTimeoutOperator(balanceOperator) {
+		return nil, nil
+	}
+
 	ctx := newOpContext(c.balancerWorker.hookStartEvent, c.balancerWorker.hookEndEvent)
+	ctx.downPeers = downPeers
 	finished, res, err := balanceOperator.Do(ctx, region, leader)
 	if err != nil {
 		// Do balance failed, remove it.
 		log.Errorf("do balance for region %d failed %s", regionID, err)
-		c.balancerWorker.removeBalanceOperator(regionID)
-		c.balancerWorker.removeRegionCache(regionID)
+		status := OperatorFailed
+		if errors.Cause(err) == ErrRegionChanged {
+			status = OperatorSuperseded
+		}
+		if c.balancerWorker.finishCurrentBalanceOperator(balanceOperator, status, err.Error()) {
+			c.balancerWorker.removeRegionCache(regionID)
+		}
 	}
 	if finished {
 		// Do finished, remove it.
-		c.balancerWorker.removeBalanceOperator(regionID)
+		c.balancerWorker.finishCurrentBalanceOperator(balanceOperator, OperatorSuccess, "")
 	}
 
 	return res, nil
@@ -149,7 +164,7 @@ func (c *RaftCluster) handleReportSplit(request *pdpb.ReportSplitRequest) (*pdpb
 	op := newSplitOperator(originRegion, left, right)
 	c.balancerWorker.historyOperators.add(originRegion.GetId(), op)
 
-	c.balancerWorker.postEvent(op, evtEnd)
+	c.balancerWorker.postEvent(op, evtEnd, "")
 
 	return &pdpb.ReportSplitResponse{}, nil
 }
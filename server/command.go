@@ -19,6 +19,7 @@ import (
 	"github.com/juju/errors"
 	"github.com/ngaut/log"
 	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
 )
 
@@ -173,12 +174,26 @@ func (c *conn) handleRegionHeartbeat(req *pdpb.Request) (*pdpb.Response, error)
 		return nil, errors.Errorf("invalid request region, %v", request)
 	}
 
-	downPeers := request.GetDownPeers()
+	// The heartbeats of a region are processed in order by the worker
+	// which owns its shard.
+	var (
+		res    *pdpb.Response
+		resErr error
+	)
+	if err = cluster.heartbeatWorkers.do(region.GetId(), func() {
+		res, resErr = c.processRegionHeartbeat(cluster, region, leader, request.GetDownPeers())
+	}); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return res, errors.Trace(resErr)
+}
 
+func (c *conn) processRegionHeartbeat(cluster *RaftCluster, region *metapb.Region, leader *metapb.Peer, downPeers []*pdpb.PeerStats) (*pdpb.Response, error) {
 	resp, changePeer, err := cluster.cachedCluster.regions.heartbeat(region, leader)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	cluster.updateClusterMetrics()
 
 	res, err := cluster.handleRegionHeartbeat(region, leader, downPeers)
 	if err != nil {
@@ -202,6 +217,8 @@ func (c *conn) handleRegionHeartbeat(req *pdpb.Request) (*pdpb.Response, error)
 		// be nil, if not, we will panic.
 		regionPath := makeRegionKey(cluster.clusterRoot, resp.removeRegion.GetId())
 		ops = append(ops, clientv3.OpDelete(regionPath))
+		// The labels of the removed region are meaningless for the new ones.
+		ops = append(ops, clientv3.OpDelete(makeRegionLabelKey(cluster.clusterRoot, resp.removeRegion.GetId())))
 	}
 
 	// TODO: we can update in etcd asynchronously later.
@@ -223,7 +240,7 @@ func (c *conn) handleRegionHeartbeat(req *pdpb.Request) (*pdpb.Response, error)
 			op = newRemovePeerOperator(region.GetId(), changePeer.GetPeer())
 		}
 
-		cluster.balancerWorker.postEvent(op, evtEnd)
+		cluster.balancerWorker.postEvent(op, evtEnd, "")
 	}
 
 	return &pdpb.Response{
@@ -243,10 +260,17 @@ func (c *conn) handleStoreHeartbeat(req *pdpb.Request) (*pdpb.Response, error) {
 		return nil, errors.Trace(err)
 	}
 
+	// The tombstone store has been decommissioned, it must not come back.
+	if store := cluster.cachedCluster.getStore(stats.GetStoreId()); store != nil && store.isTombstone() {
+		return nil, errors.Errorf("store %d is tombstone", stats.GetStoreId())
+	}
+
 	ok := cluster.cachedCluster.updateStoreStatus(stats)
 	if !ok {
 		return nil, errors.Errorf("cannot find store to update stats, stats %v", stats)
 	}
+	cluster.updateStoreMetrics(stats)
+	cluster.checkOfflineStores()
 
 	return &pdpb.Response{
 		StoreHeartbeat: &pdpb.StoreHeartbeatResponse{},
@@ -310,6 +334,7 @@ func (c *conn) handlePutStore(req *pdpb.Request) (*pdpb.Response, error) {
 	if err = cluster.putStore(store); err != nil {
 		return nil, errors.Trace(err)
 	}
+	cluster.updateClusterMetrics()
 
 	log.Infof("put store ok - %v", store)
 
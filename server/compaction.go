@@ -0,0 +1,73 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/ngaut/log"
+	"golang.org/x/net/context"
+)
+
+// getCompactRevision returns the revision of the last compaction of the
+// embedded etcd, the compaction is replicated, so every member knows it.
+func (s *Server) getCompactRevision() int64 {
+	// The first revision is -1 before any compaction.
+	if rev := s.etcd.Server.KV().FirstRev(); rev > 0 {
+		return rev
+	}
+	return 0
+}
+
+func (s *Server) defragmentLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.DefragmentInterval.Duration)
+	defer ticker.Stop()
+
+	ctx := s.client.Ctx()
+	for {
+		select {
+		case <-ticker.C:
+			s.defragment()
+		case <-ctx.Done():
+			// server closed, return
+			return
+		}
+	}
+}
+
+// defragment releases the free space of the etcd backend of the server.
+// The backend is blocked during the defragmentation, so the leader skips
+// it if any request is running, and tries again in the next interval.
+func (s *Server) defragment() {
+	if s.isLeader() && atomic.LoadInt64(&s.inflightRequests) > 0 {
+		log.Infof("skip etcd defragmentation, the leader is serving requests")
+		return
+	}
+
+	endpoint := s.GetEndpoints()[0]
+	// The defragmentation may take a long time if the backend is large.
+	ctx, cancel := context.WithTimeout(s.client.Ctx(), time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := clientv3.NewMaintenance(s.client).Defragment(ctx, endpoint); err != nil {
+		log.Errorf("defragment etcd %s err %v", endpoint, err)
+		return
+	}
+	log.Infof("defragment etcd %s, cost %s", endpoint, time.Since(start))
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/juju/errors"
+	. "github.com/pingcap/check"
+	"golang.org/x/net/context"
+)
+
+var _ = Suite(&testCompactionSuite{})
+
+type testCompactionSuite struct{}
+
+func (s *testCompactionSuite) TestInvalidRetention(c *C) {
+	for _, retention := range []time.Duration{-time.Hour, time.Nanosecond, 30 * time.Minute, 90 * time.Minute} {
+		cfg := NewTestSingleConfig()
+		cfg.AutoCompactionRetention.Duration = retention
+		c.Assert(cfg.adjust(), NotNil)
+		os.RemoveAll(cfg.DataDir)
+	}
+}
+
+func (s *testCompactionSuite) TestRetentionHours(c *C) {
+	cfg := NewTestSingleConfig()
+	defer os.RemoveAll(cfg.DataDir)
+	cfg.AutoCompactionRetention.Duration = 0
+	c.Assert(cfg.adjust(), IsNil)
+	etcdCfg, err := cfg.genEmbedEtcdConfig()
+	c.Assert(err, IsNil)
+	c.Assert(etcdCfg.AutoCompactionRetention, Equals, 1)
+
+	cfg.AutoCompactionRetention.Duration = 3 * time.Hour
+	c.Assert(cfg.adjust(), IsNil)
+	etcdCfg, err = cfg.genEmbedEtcdConfig()
+	c.Assert(err, IsNil)
+	c.Assert(etcdCfg.AutoCompactionRetention, Equals, 3)
+}
+
+func (s *testCompactionSuite) TestCompactRevision(c *C) {
+	cfg := NewTestSingleConfig()
+	cfg.DefragmentInterval.Duration = 200 * time.Millisecond
+	svr, err := NewServer(cfg)
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(cfg.DataDir)
+	defer svr.Close()
+
+	go svr.Run()
+	mustGetLeader(c, svr.client, svr.getLeaderPath())
+
+	status, err := svr.GetClusterStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.LastCompactRevision, Equals, int64(0))
+
+	key := path.Join(svr.rootPath, "test_compaction")
+	kv := clientv3.NewKV(svr.client)
+	resp, err := kv.Put(context.TODO(), key, "v1")
+	c.Assert(err, IsNil)
+	oldRev := resp.Header.Revision
+	resp, err = kv.Put(context.TODO(), key, "v2")
+	c.Assert(err, IsNil)
+
+	// The embedded etcd compacts in hours, compact it like it does.
+	_, err = kv.Compact(context.TODO(), resp.Header.Revision, clientv3.WithCompactPhysical())
+	c.Assert(err, IsNil)
+
+	// The old revision can't be read after the compaction.
+	_, err = kvGet(svr.client, key, clientv3.WithRev(oldRev))
+	c.Assert(errors.Cause(err), Equals, rpctypes.ErrCompacted)
+	value, err := getValue(svr.client, key)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "v2")
+
+	status, err = svr.GetClusterStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.LastCompactRevision, Equals, resp.Header.Revision)
+}
@@ -14,18 +14,23 @@
 package server
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/url"
 	"os"
+	"runtime"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/coreos/etcd/embed"
+	"github.com/coreos/etcd/pkg/transport"
 	"github.com/juju/errors"
+	"github.com/ngaut/log"
 )
 
 // Config is the pd server configuration.
@@ -46,12 +51,26 @@ type Config struct {
 	// Join to an existing pd cluster, a string of endpoints.
 	Join string `toml:"join" json:"join"`
 
+	// ForceNewCluster reforms a single member cluster from the data dir
+	// if the majority of the members in the initial cluster are lost,
+	// the data not replicated to this member is lost.
+	ForceNewCluster bool `toml:"force-new-cluster" json:"force-new-cluster"`
+
 	// LeaderLease time, if leader doesn't update its TTL
 	// in etcd after lease time, etcd will expire the leader key
 	// and other servers can campaign the leader again.
 	// Etcd onlys support seoncds TTL, so here is second too.
+	// A short lease makes the failover faster, but the GC pauses or
+	// the slow disk may cause unnecessary leader changes. Etcd extends
+	// the lease shorter than 5s to 5s, so it's the min lease.
 	LeaderLease int64 `toml:"lease" json:"lease"`
 
+	// LeaderPriority is the priority of the member to be the leader, the
+	// leader is transferred to the healthy member with a higher priority.
+	// It is saved when the member starts for the first time, then it can
+	// be changed through the API.
+	LeaderPriority int `toml:"leader-priority" json:"leader-priority"`
+
 	// Log level.
 	LogLevel string `toml:"log-level" json:"log-level"`
 	// Log file.
@@ -61,6 +80,9 @@ type Config struct {
 	// When the leader begins to run, it first loads the saved timestamp from etcd, e.g, T1,
 	// and the leader must guarantee that the next timestamp must be > T1 + 2 * TsoSaveInterval.
 	TsoSaveInterval int64 `toml:"tso-save-interval" json:"tso-save-interval"`
+	// TsoUpdatePhysicalInterval is the interval time (ms) to update the physical
+	// part of the timestamp, it must be less than TsoSaveInterval.
+	TsoUpdatePhysicalInterval int64 `toml:"tso-update-physical-interval" json:"tso-update-physical-interval"`
 
 	// ClusterID is the cluster ID communicating with other services.
 	ClusterID uint64 `toml:"cluster-id" json:"cluster-id"`
@@ -68,10 +90,97 @@ type Config struct {
 	// MaxPeerCount for a region. default is 3.
 	MaxPeerCount uint64 `toml:"max-peer-count" json:"max-peer-count"`
 
+	// IDAllocStep is the count of IDs reserved in etcd at a time,
+	// the reserved IDs are allocated from memory. default is 1000.
+	IDAllocStep uint64 `toml:"id-alloc-step" json:"id-alloc-step"`
+
 	BalanceCfg BalanceConfig `toml:"balance" json:"balance"`
 
+	// EnableAdminAPI enables the admin API which is only for the test
+	// and staging clusters, e.g, registering stores in bulk.
+	EnableAdminAPI bool `toml:"enable-admin-api" json:"enable-admin-api"`
+
+	// APIPrefix is the path prefix which the API is mounted under, it can
+	// be changed if PD runs behind a reverse proxy. default is "/pd".
+	APIPrefix string `toml:"api-prefix" json:"api-prefix"`
+
+	// CORSAllowedOrigins are the origins which the browsers can call the
+	// API from, "*" allows all origins. No CORS header is sent if empty.
+	CORSAllowedOrigins []string `toml:"cors-allowed-origins" json:"cors-allowed-origins"`
+
+	// APIUsername and APIPassword enable the HTTP basic auth for the API
+	// requests which change the state, the reads are not authenticated.
+	// They must be set together or not at all.
+	APIUsername string `toml:"api-username" json:"api-username"`
+	APIPassword string `toml:"api-password" json:"-"`
+
+	// APIRateLimit is the requests per second which each client IP can send
+	// to the API, with bursts of APIRateBurst requests. No limit if it is 0.
+	// The paths (without the api-prefix) in APIRateLimitExempt are never
+	// limited, default is the health check.
+	APIRateLimit       float64  `toml:"api-rate-limit" json:"api-rate-limit"`
+	APIRateBurst       int      `toml:"api-rate-burst" json:"api-rate-burst"`
+	APIRateLimitExempt []string `toml:"api-rate-limit-exempt" json:"api-rate-limit-exempt"`
+
+	// MaxScanRegions is the max region count which a range given to the API,
+	// e.g, the range to scatter, can touch, so a broad range can't make the
+	// leader scan too many regions. default is 10000.
+	MaxScanRegions int `toml:"max-scan-regions" json:"max-scan-regions"`
+
+	// MinFreeBytes is the min free space of the data dir, PD refuses to
+	// start and warns periodically if the free space is less than it.
+	MinFreeBytes uint64 `toml:"min-free-bytes" json:"min-free-bytes"`
+	// CriticalFreeBytes is the free space of the data dir below which the
+	// leader steps down, it must not be greater than MinFreeBytes.
+	CriticalFreeBytes uint64 `toml:"critical-free-bytes" json:"critical-free-bytes"`
+	// DiskCheckInterval is the interval to check the data dir free space.
+	DiskCheckInterval duration `toml:"disk-check-interval" json:"disk-check-interval"`
+
+	// AutoCompactionRetention is how long the history of the embedded etcd
+	// is kept, the older revisions are compacted by the etcd leader. The
+	// embedded etcd takes it in hours, so it must be a whole number of hours.
+	AutoCompactionRetention duration `toml:"auto-compaction-retention" json:"auto-compaction-retention"`
+	// DefragmentInterval is the interval to defragment the embedded etcd,
+	// 0 disables the defragmentation.
+	DefragmentInterval duration `toml:"defragment-interval" json:"defragment-interval"`
+
+	// RegionSnapshotInterval is the interval for the leader to save the
+	// region leaders, the next leader restores them at startup.
+	RegionSnapshotInterval duration `toml:"region-snapshot-interval" json:"region-snapshot-interval"`
+
+	// EtcdRetryCount is the max times to retry the etcd requests of the ID
+	// allocator and the config persistence if they fail transiently, the
+	// interval starts from EtcdRetryBackoff and doubles every time.
+	EtcdRetryCount   uint64   `toml:"etcd-retry-count" json:"etcd-retry-count"`
+	EtcdRetryBackoff duration `toml:"etcd-retry-backoff" json:"etcd-retry-backoff"`
+
+	// StoreConflictPolicy decides what to do if a new store is put with the
+	// address of another store, e.g, the TiKV is restarted with a new data
+	// dir. "reject" refuses the new store, "replace" marks the old one as
+	// offline, and it becomes tombstone after its regions are migrated.
+	StoreConflictPolicy string `toml:"store-conflict-policy" json:"store-conflict-policy"`
+
+	// RegionStatsPrefixLength is the length of the key prefix to group the
+	// regions by in the region stats if no key decoder is set.
+	RegionStatsPrefixLength int `toml:"region-stats-prefix-length" json:"region-stats-prefix-length"`
+	// KeyDecoder decodes the table of the region start keys for the region
+	// stats, it can only be set by the program which embeds the server.
+	KeyDecoder KeyDecoder `toml:"-" json:"-"`
+
+	// RegionHeartbeatWorkers is the count of the workers to process the
+	// region heartbeats, each worker owns the regions of a shard by the
+	// region ID. default is GOMAXPROCS.
+	RegionHeartbeatWorkers int `toml:"region-heartbeat-workers" json:"region-heartbeat-workers"`
+
+	// CertFile, KeyFile and TrustedCAFile are used for TLS of both the
+	// client and peer urls, they must be set all together or not at all.
+	CertFile      string `toml:"cert-file" json:"cert-file"`
+	KeyFile       string `toml:"key-file" json:"key-file"`
+	TrustedCAFile string `toml:"trusted-ca-file" json:"trusted-ca-file"`
+
 	// Only test can change it.
 	nextRetryDelay time.Duration
+	diskSpace      diskSpaceFunc
 
 	configFile string
 }
@@ -94,20 +203,48 @@ func NewConfig() *Config {
 	fs.StringVar(&cfg.AdvertisePeerUrls, "advertise-peer-urls", "", "advertise url for peer traffic (default '${peer-urls}')")
 	fs.StringVar(&cfg.InitialCluster, "initial-cluster", "", "initial cluster configuration for bootstrapping, e,g. pd=http://127.0.0.1:2380")
 	fs.StringVar(&cfg.Join, "join", "", "join to an existing cluster (usage: cluster's '${advertise-client-urls}'")
+	fs.BoolVar(&cfg.ForceNewCluster, "force-new-cluster", false, "force to create a new one-member cluster from the data dir if the quorum is lost")
 
-	fs.StringVar(&cfg.LogLevel, "L", "info", "log level: debug, info, warn, error, fatal")
+	fs.StringVar(&cfg.LogLevel, "L", defaultLogLevel, "log level: debug, info, warn, error, fatal")
 	fs.StringVar(&cfg.LogFile, "log-file", "", "log file path")
 
+	fs.StringVar(&cfg.CertFile, "cert-file", "", "path to the TLS certificate file")
+	fs.StringVar(&cfg.KeyFile, "key-file", "", "path to the TLS key file")
+	fs.StringVar(&cfg.TrustedCAFile, "trusted-ca-file", "", "path to the trusted CA file for TLS")
+
 	return cfg
 }
 
 const (
-	defaultLeaderLease     = int64(3)
-	defaultTsoSaveInterval = int64(2000)
-	defaultMaxPeerCount    = uint64(3)
-	defaultNextRetryDelay  = time.Second
+	defaultLeaderLease               = int64(5)
+	minLeaderLease                   = int64(5)
+	defaultTsoSaveInterval           = int64(2000)
+	defaultTsoUpdatePhysicalInterval = int64(50)
+	defaultMaxPeerCount              = uint64(3)
+	defaultIDAllocStep               = uint64(1000)
+	defaultNextRetryDelay            = time.Second
+
+	defaultMinFreeBytes      = uint64(1 << 30)
+	defaultCriticalFreeBytes = uint64(256 << 20)
+	defaultDiskCheckInterval = time.Minute
+
+	defaultAutoCompactionRetention = time.Hour
+
+	defaultRegionSnapshotInterval = time.Minute
+
+	defaultRegionStatsPrefixLength = 8
+
+	defaultEtcdRetryCount   = uint64(3)
+	defaultEtcdRetryBackoff = 100 * time.Millisecond
+
+	storeConflictReject  = "reject"
+	storeConflictReplace = "replace"
 
 	defaultName                = "pd"
+	defaultLogLevel            = "info"
+	defaultAPIPrefix           = "/pd"
+	defaultAPIRateLimitExempt  = "/api/v1/health"
+	defaultMaxScanRegions      = 10000
 	defaultClientUrls          = "http://127.0.0.1:2379"
 	defaultPeerUrls            = "http://127.0.0.1:2380"
 	defualtInitialClusterState = embed.ClusterStateFlagNew
@@ -176,15 +313,47 @@ func (c *Config) validate() error {
 	if c.Join != "" && c.InitialCluster != "" {
 		return errors.New("-initial-cluster and -join can not be provided at the same time")
 	}
+	if c.Join != "" && c.ForceNewCluster {
+		return errors.New("-force-new-cluster and -join can not be provided at the same time")
+	}
+	if c.isTLSEnabled() && (c.CertFile == "" || c.KeyFile == "" || c.TrustedCAFile == "") {
+		return errors.New("-cert-file, -key-file and -trusted-ca-file must be provided at the same time")
+	}
+	if (c.APIUsername == "") != (c.APIPassword == "") {
+		return errors.New("api-username and api-password must be provided at the same time")
+	}
 	return nil
 }
 
+func (c *Config) isTLSEnabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.TrustedCAFile != ""
+}
+
+func (c *Config) tlsInfo() transport.TLSInfo {
+	return transport.TLSInfo{
+		CertFile:      c.CertFile,
+		KeyFile:       c.KeyFile,
+		TrustedCAFile: c.TrustedCAFile,
+	}
+}
+
+// genClientTLSConfig generates the TLS config for the etcd clients,
+// returns nil if TLS is not enabled.
+func (c *Config) genClientTLSConfig() (*tls.Config, error) {
+	if !c.isTLSEnabled() {
+		return nil, nil
+	}
+	tlsCfg, err := c.tlsInfo().ClientConfig()
+	return tlsCfg, errors.Trace(err)
+}
+
 func (c *Config) adjust() error {
 	if err := c.validate(); err != nil {
 		return errors.Trace(err)
 	}
 
 	adjustString(&c.Name, defaultName)
+	adjustString(&c.LogLevel, defaultLogLevel)
 	adjustString(&c.DataDir, fmt.Sprintf("default.%s", c.Name))
 
 	adjustString(&c.ClientUrls, defaultClientUrls)
@@ -192,6 +361,15 @@ func (c *Config) adjust() error {
 	adjustString(&c.PeerUrls, defaultPeerUrls)
 	adjustString(&c.AdvertisePeerUrls, c.PeerUrls)
 
+	// The advertise urls are told to the clients and the other members, so
+	// check them early rather than fail in the member list.
+	if _, err := parseUrls(c.AdvertiseClientUrls); err != nil {
+		return errors.Annotatef(err, "invalid advertise client urls %q", c.AdvertiseClientUrls)
+	}
+	if _, err := parseUrls(c.AdvertisePeerUrls); err != nil {
+		return errors.Annotatef(err, "invalid advertise peer urls %q", c.AdvertisePeerUrls)
+	}
+
 	if c.Join != "" {
 		initialCluster, state, err := c.prepareJoinCluster()
 		if err != nil {
@@ -215,22 +393,110 @@ func (c *Config) adjust() error {
 
 	adjustString(&c.InitialClusterState, defualtInitialClusterState)
 
+	if c.ForceNewCluster {
+		if err := c.checkForceNewCluster(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	adjustUint64(&c.MaxPeerCount, defaultMaxPeerCount)
+	if c.MaxPeerCount > math.MaxUint32 {
+		return errors.Annotatef(ErrInvalidConfig, "max-peer-count %d is larger than %d", c.MaxPeerCount, uint64(math.MaxUint32))
+	}
+	adjustUint64(&c.IDAllocStep, defaultIDAllocStep)
 
 	if c.LeaderLease <= 0 {
 		c.LeaderLease = defaultLeaderLease
 	}
+	if c.LeaderLease < minLeaderLease {
+		log.Warnf("leader lease %ds is less than the etcd min lease, use %ds", c.LeaderLease, minLeaderLease)
+		c.LeaderLease = minLeaderLease
+	}
 
 	if c.TsoSaveInterval <= 0 {
 		c.TsoSaveInterval = defaultTsoSaveInterval
 	}
+	if c.TsoUpdatePhysicalInterval <= 0 {
+		c.TsoUpdatePhysicalInterval = defaultTsoUpdatePhysicalInterval
+	}
+	if c.TsoSaveInterval <= c.TsoUpdatePhysicalInterval {
+		return errors.Errorf("tso-save-interval %d must be greater than tso-update-physical-interval %d", c.TsoSaveInterval, c.TsoUpdatePhysicalInterval)
+	}
 
 	if c.nextRetryDelay == 0 {
 		c.nextRetryDelay = defaultNextRetryDelay
 	}
 
+	adjustString(&c.APIPrefix, defaultAPIPrefix)
+	c.APIPrefix = "/" + strings.Trim(c.APIPrefix, "/")
+	if c.APIPrefix == "/" || c.APIPrefix == pdRPCPrefix {
+		return errors.Errorf("invalid api-prefix %q", c.APIPrefix)
+	}
+
+	if c.APIRateLimit < 0 || c.APIRateBurst < 0 {
+		return errors.Errorf("invalid api-rate-limit %v or api-rate-burst %d", c.APIRateLimit, c.APIRateBurst)
+	}
+	if c.APIRateLimit > 0 && c.APIRateBurst == 0 {
+		c.APIRateBurst = int(math.Ceil(c.APIRateLimit))
+	}
+	if c.APIRateLimitExempt == nil {
+		c.APIRateLimitExempt = []string{defaultAPIRateLimitExempt}
+	}
+	if c.MaxScanRegions < 0 {
+		return errors.Errorf("invalid max-scan-regions %d", c.MaxScanRegions)
+	}
+	if c.MaxScanRegions == 0 {
+		c.MaxScanRegions = defaultMaxScanRegions
+	}
+
+	adjustUint64(&c.MinFreeBytes, defaultMinFreeBytes)
+	adjustUint64(&c.CriticalFreeBytes, defaultCriticalFreeBytes)
+	adjustDuration(&c.DiskCheckInterval, defaultDiskCheckInterval)
+	if c.CriticalFreeBytes > c.MinFreeBytes {
+		return errors.Errorf("critical-free-bytes %d is greater than min-free-bytes %d", c.CriticalFreeBytes, c.MinFreeBytes)
+	}
+	if c.diskSpace == nil {
+		c.diskSpace = getFreeSpace
+	}
+
+	adjustDuration(&c.AutoCompactionRetention, defaultAutoCompactionRetention)
+	if c.AutoCompactionRetention.Duration < time.Hour || c.AutoCompactionRetention.Duration%time.Hour != 0 {
+		return errors.Errorf("invalid auto-compaction-retention %v, it must be a whole number of hours",
+			c.AutoCompactionRetention.Duration)
+	}
+	if c.DefragmentInterval.Duration < 0 {
+		return errors.Errorf("invalid defragment-interval %v", c.DefragmentInterval.Duration)
+	}
+
+	adjustDuration(&c.RegionSnapshotInterval, defaultRegionSnapshotInterval)
+	if c.RegionSnapshotInterval.Duration < 0 {
+		return errors.Errorf("invalid region-snapshot-interval %v", c.RegionSnapshotInterval.Duration)
+	}
+
+	adjustUint64(&c.EtcdRetryCount, defaultEtcdRetryCount)
+	adjustDuration(&c.EtcdRetryBackoff, defaultEtcdRetryBackoff)
+
+	if c.RegionStatsPrefixLength < 0 {
+		return errors.Errorf("invalid region-stats-prefix-length %d", c.RegionStatsPrefixLength)
+	}
+	if c.RegionStatsPrefixLength == 0 {
+		c.RegionStatsPrefixLength = defaultRegionStatsPrefixLength
+	}
+
+	if c.RegionHeartbeatWorkers < 0 {
+		return errors.Errorf("invalid region-heartbeat-workers %d", c.RegionHeartbeatWorkers)
+	}
+	if c.RegionHeartbeatWorkers == 0 {
+		c.RegionHeartbeatWorkers = runtime.GOMAXPROCS(0)
+	}
+
+	adjustString(&c.StoreConflictPolicy, storeConflictReject)
+	if c.StoreConflictPolicy != storeConflictReject && c.StoreConflictPolicy != storeConflictReplace {
+		return errors.Errorf("invalid store-conflict-policy %q", c.StoreConflictPolicy)
+	}
+
 	c.BalanceCfg.adjust()
-	return nil
+	return errors.Trace(c.BalanceCfg.validate())
 }
 
 func (c *Config) clone() *Config {
@@ -246,11 +512,43 @@ func (c *Config) setBalanceConfig(cfg BalanceConfig) {
 	c.BalanceCfg = cfg
 }
 
+func (c *Config) setScheduleConfig(cfg ScheduleConfig) {
+	// Zero limits are allowed here, e.g, set leader schedule limit to 0
+	// to stop leader balance, so we don't adjust them.
+	c.BalanceCfg.MaxSendingSnapCount = cfg.MaxSnapshotCount
+	c.BalanceCfg.MaxReceivingSnapCount = cfg.MaxSnapshotCount
+	c.BalanceCfg.LeaderScheduleLimit = cfg.LeaderScheduleLimit
+	c.BalanceCfg.RegionScheduleLimit = cfg.RegionScheduleLimit
+	c.BalanceCfg.MinRegionCount = cfg.MinRegionCount
+	c.BalanceCfg.MinLeaderRegionCount = cfg.MinLeaderRegionCount
+	c.BalanceCfg.MaxDiffScoreFraction = cfg.MaxDiffScoreFraction
+	c.BalanceCfg.OperatorStepTimeout = cfg.OperatorStepTimeout
+	c.BalanceCfg.OperatorStepRetry = cfg.OperatorStepRetry
+}
+
+func (c *Config) getScheduleConfig() ScheduleConfig {
+	return ScheduleConfig{
+		MaxSnapshotCount:     c.BalanceCfg.MaxSendingSnapCount,
+		LeaderScheduleLimit:  c.BalanceCfg.LeaderScheduleLimit,
+		RegionScheduleLimit:  c.BalanceCfg.RegionScheduleLimit,
+		MinRegionCount:       c.BalanceCfg.MinRegionCount,
+		MinLeaderRegionCount: c.BalanceCfg.MinLeaderRegionCount,
+		MaxDiffScoreFraction: c.BalanceCfg.MaxDiffScoreFraction,
+		OperatorStepTimeout:  c.BalanceCfg.OperatorStepTimeout,
+		OperatorStepRetry:    c.BalanceCfg.OperatorStepRetry,
+	}
+}
+
 func (c *Config) String() string {
 	if c == nil {
 		return "<nil>"
 	}
-	return fmt.Sprintf("Config(%+v)", *c)
+	cfg := *c
+	// The password must not be logged.
+	if cfg.APIPassword != "" {
+		cfg.APIPassword = "******"
+	}
+	return fmt.Sprintf("Config(%+v)", cfg)
 }
 
 // configFromFile loads config from file.
@@ -267,6 +565,12 @@ func (d *duration) Seconds() uint64 {
 	return uint64(d.Duration.Seconds())
 }
 
+// MarshalText implements encoding.TextMarshaler, so the duration is
+// shown as a readable string like "30m0s" in JSON.
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
 func (d *duration) UnmarshalText(text []byte) error {
 	var err error
 	d.Duration, err = time.ParseDuration(string(text))
@@ -290,7 +594,7 @@ type BalanceConfig struct {
 
 	// For capacity balance.
 	// If the sending snapshot count of one storage is greater than this value,
-	// it will never be used as a from store.
+	// it will never be used as a from store or a to store.
 	MaxSendingSnapCount uint64 `toml:"max-sending-snap-count" json:"max-sending-snap-count"`
 	// If the receiving snapshot count of one storage is greater than this value,
 	// it will never be used as a to store.
@@ -306,6 +610,19 @@ type BalanceConfig struct {
 	// MaxBalanceCount is the max region count to balance at the same time.
 	MaxBalanceCount uint64 `toml:"max-balance-count" json:"max-balance-count"`
 
+	// LeaderScheduleLimit is the max leader balance operator count at the same time.
+	LeaderScheduleLimit uint64 `toml:"leader-schedule-limit" json:"leader-schedule-limit"`
+	// RegionScheduleLimit is the max region balance operator count at the same time.
+	RegionScheduleLimit uint64 `toml:"region-schedule-limit" json:"region-schedule-limit"`
+
+	// MinRegionCount is the region count of the cluster below which the
+	// region balancers stay idle, to avoid moving the few regions of a new
+	// cluster back and forth.
+	MinRegionCount uint64 `toml:"min-region-count" json:"min-region-count"`
+	// MinLeaderRegionCount is the region count of the cluster below which
+	// the leader balancers stay idle.
+	MinLeaderRegionCount uint64 `toml:"min-leader-region-count" json:"min-leader-region-count"`
+
 	// MaxBalanceRetryPerLoop is the max retry count to balance in a balance schedule.
 	MaxBalanceRetryPerLoop uint64 `toml:"max-balance-retry-per-loop" json:"max-balance-retry-per-loop"`
 
@@ -315,6 +632,25 @@ type BalanceConfig struct {
 	// MaxTransferWaitCount is the max heartbeat count to wait leader transfer to finish.
 	MaxTransferWaitCount uint64 `toml:"max-transfer-wait-count" json:"max-transfer-wait-count"`
 
+	// PeerCatchUpCount is the heartbeat count in which the leader must report a
+	// new peer healthy, before the peer it replaces is removed.
+	PeerCatchUpCount uint64 `toml:"peer-catch-up-count" json:"peer-catch-up-count"`
+
+	// MaxOperatorWaitDuration is the max duration an operator can run, after
+	// which it is marked timeout and removed, so its slot is freed.
+	MaxOperatorWaitDuration duration `toml:"max-operator-wait-duration" json:"max-operator-wait-duration"`
+
+	// OperatorStepTimeout is the max duration since a step of an operator is
+	// dispatched, after which the step is dispatched again, or the operator
+	// is marked timeout and removed if the step has been retried
+	// OperatorStepRetry times.
+	OperatorStepTimeout duration `toml:"operator-step-timeout" json:"operator-step-timeout"`
+	OperatorStepRetry   uint64   `toml:"operator-step-retry" json:"operator-step-retry"`
+
+	// StoreBalanceRate is the default max add-peer and remove-peer operations
+	// per minute of a store, it can be changed for each store.
+	StoreBalanceRate float64 `toml:"store-balance-rate" json:"store-balance-rate"`
+
 	// MaxPeerDownDuration is the max duration at which
 	// a peer will be considered to be down if its leader reports it.
 	MaxPeerDownDuration duration `toml:"max-peer-down-duration" json:"max-peer-down-duration"`
@@ -322,6 +658,61 @@ type BalanceConfig struct {
 	// MaxStoreDownDuration is the max duration at which
 	// a store will be considered to be down if it hasn't reported heartbeats.
 	MaxStoreDownDuration duration `toml:"max-store-down-duration" json:"max-store-down-duration"`
+
+	// MaxRegionHeartbeatAge is the max duration since the last heartbeat of
+	// a region, after which the balancers treat its info as stale and don't
+	// schedule it until it reports again.
+	MaxRegionHeartbeatAge duration `toml:"max-region-heartbeat-age" json:"max-region-heartbeat-age"`
+
+	// ImportModeTTL is the duration after which the import mode is disabled
+	// automatically, so it is not left on after a bulk load.
+	ImportModeTTL duration `toml:"import-mode-ttl" json:"import-mode-ttl"`
+
+	// LocationLabels are the store label keys which describe the location of a store
+	// from the top level, e.g, ["zone", "rack"]. Two replicas of a region will not be
+	// placed on the stores which have the same values of all these labels.
+	LocationLabels []string `toml:"location-labels" json:"location-labels"`
+
+	// LocationLeaderBalance is whether the leader balancer balances the
+	// leaders of the values of the first location label, e.g, the zones,
+	// before the leaders of the stores, so a zone failure loses an even
+	// share of the leaders.
+	LocationLeaderBalance bool `toml:"location-leader-balance" json:"location-leader-balance"`
+
+	// MaxEventCount is the max count of the recent scheduling events kept in memory.
+	MaxEventCount uint64 `toml:"max-event-count" json:"max-event-count"`
+
+	// RemoveOrphanPeers is whether to remove the peers on the unknown or
+	// tombstone stores, the orphan peers are only logged if it's false.
+	RemoveOrphanPeers bool `toml:"remove-orphan-peers" json:"remove-orphan-peers"`
+}
+
+// ScheduleConfig is the scheduling limits which can be changed online.
+type ScheduleConfig struct {
+	// MaxSnapshotCount is the max sending and receiving snapshot count of a store.
+	MaxSnapshotCount uint64 `json:"max-snapshot-count"`
+	// LeaderScheduleLimit is the max leader balance operator count at the same time.
+	LeaderScheduleLimit uint64 `json:"leader-schedule-limit"`
+	// RegionScheduleLimit is the max region balance operator count at the same time.
+	RegionScheduleLimit uint64 `json:"region-schedule-limit"`
+	// MinRegionCount is the region count below which the region balancers stay idle.
+	MinRegionCount uint64 `json:"min-region-count"`
+	// MinLeaderRegionCount is the region count below which the leader balancers stay idle.
+	MinLeaderRegionCount uint64 `json:"min-leader-region-count"`
+	// MaxDiffScoreFraction is the tolerance of the region and leader balancers,
+	// they do nothing unless the score of the from store exceeds the score of
+	// the to store by more than this fraction of the from store score.
+	MaxDiffScoreFraction float64 `json:"max-diff-score-fraction"`
+	// OperatorStepTimeout and OperatorStepRetry decide when an operator whose
+	// step doesn't finish is abandoned.
+	OperatorStepTimeout duration `json:"operator-step-timeout"`
+	OperatorStepRetry   uint64   `json:"operator-step-retry"`
+}
+
+// ReplicateConfig is the replica placement config which can be changed online.
+type ReplicateConfig struct {
+	// MaxReplicas is the number of replicas for each region.
+	MaxReplicas uint64 `json:"max-replicas"`
 }
 
 func newBalanceConfig() *BalanceConfig {
@@ -329,19 +720,33 @@ func newBalanceConfig() *BalanceConfig {
 }
 
 const (
-	defaultMinCapacityUsedRatio   = float64(0.3)
-	defaultMaxCapacityUsedRatio   = float64(0.9)
-	defaultMaxLeaderCount         = uint64(10)
-	defaultMaxSendingSnapCount    = uint64(3)
-	defaultMaxReceivingSnapCount  = uint64(3)
-	defaultMaxDiffScoreFraction   = float64(0.1)
-	defaultMaxBalanceCount        = uint64(16)
-	defaultBalanceInterval        = uint64(30)
-	defaultMaxBalanceRetryPerLoop = uint64(10)
-	defaultMaxBalanceCountPerLoop = uint64(3)
-	defaultMaxTransferWaitCount   = uint64(3)
-	defaultMaxPeerDownDuration    = 30 * time.Minute
-	defaultMaxStoreDownDuration   = 10 * time.Minute
+	defaultMinCapacityUsedRatio    = float64(0.3)
+	defaultMaxCapacityUsedRatio    = float64(0.9)
+	defaultMaxLeaderCount          = uint64(10)
+	defaultMaxSendingSnapCount     = uint64(3)
+	defaultMaxReceivingSnapCount   = uint64(3)
+	defaultMaxDiffScoreFraction    = float64(0.1)
+	defaultMaxBalanceCount         = uint64(16)
+	defaultLeaderScheduleLimit     = uint64(8)
+	defaultRegionScheduleLimit     = uint64(8)
+	defaultBalanceInterval         = uint64(30)
+	defaultMaxBalanceRetryPerLoop  = uint64(10)
+	defaultMaxBalanceCountPerLoop  = uint64(3)
+	defaultMaxTransferWaitCount    = uint64(3)
+	defaultPeerCatchUpCount        = uint64(3)
+	defaultMaxOperatorWaitDuration = 10 * time.Minute
+	defaultOperatorStepTimeout     = 5 * time.Minute
+	defaultOperatorStepRetry       = uint64(1)
+	defaultStoreBalanceRate        = float64(15)
+	defaultMaxPeerDownDuration     = 30 * time.Minute
+	defaultMaxStoreDownDuration    = 10 * time.Minute
+	defaultMaxRegionHeartbeatAge   = 10 * time.Minute
+	defaultImportModeTTL           = 12 * time.Hour
+	defaultMaxEventCount           = uint64(10000)
+
+	// The stores report heartbeats every 10 seconds, a store may be marked
+	// down if max-store-down-duration is close to it and a heartbeat delays.
+	minStoreDownDuration = 30 * time.Second
 )
 
 func (c *BalanceConfig) adjust() {
@@ -353,16 +758,46 @@ func (c *BalanceConfig) adjust() {
 	adjustUint64(&c.MaxReceivingSnapCount, defaultMaxReceivingSnapCount)
 
 	adjustFloat64(&c.MaxDiffScoreFraction, defaultMaxDiffScoreFraction)
+	if c.MaxDiffScoreFraction < 0 || c.MaxDiffScoreFraction >= 1 {
+		log.Warnf("max-diff-score-fraction %v is not in [0, 1), use %v", c.MaxDiffScoreFraction, defaultMaxDiffScoreFraction)
+		c.MaxDiffScoreFraction = defaultMaxDiffScoreFraction
+	}
 
 	adjustUint64(&c.BalanceInterval, defaultBalanceInterval)
 	adjustUint64(&c.MaxBalanceCount, defaultMaxBalanceCount)
+	adjustUint64(&c.LeaderScheduleLimit, defaultLeaderScheduleLimit)
+	adjustUint64(&c.RegionScheduleLimit, defaultRegionScheduleLimit)
 	adjustUint64(&c.MaxBalanceRetryPerLoop, defaultMaxBalanceRetryPerLoop)
 	adjustUint64(&c.MaxBalanceCountPerLoop, defaultMaxBalanceCountPerLoop)
 
 	adjustUint64(&c.MaxTransferWaitCount, defaultMaxTransferWaitCount)
+	adjustUint64(&c.PeerCatchUpCount, defaultPeerCatchUpCount)
+	adjustDuration(&c.MaxOperatorWaitDuration, defaultMaxOperatorWaitDuration)
+	adjustDuration(&c.OperatorStepTimeout, defaultOperatorStepTimeout)
+	adjustUint64(&c.OperatorStepRetry, defaultOperatorStepRetry)
+	adjustFloat64(&c.StoreBalanceRate, defaultStoreBalanceRate)
 
 	adjustDuration(&c.MaxPeerDownDuration, defaultMaxPeerDownDuration)
 	adjustDuration(&c.MaxStoreDownDuration, defaultMaxStoreDownDuration)
+	adjustDuration(&c.MaxRegionHeartbeatAge, defaultMaxRegionHeartbeatAge)
+	adjustDuration(&c.ImportModeTTL, defaultImportModeTTL)
+
+	adjustUint64(&c.MaxEventCount, defaultMaxEventCount)
+}
+
+func (c *BalanceConfig) validate() error {
+	if c.MaxStoreDownDuration.Duration < minStoreDownDuration {
+		return errors.Annotatef(ErrInvalidConfig, "max-store-down-duration %s is less than %s",
+			c.MaxStoreDownDuration.Duration, minStoreDownDuration)
+	}
+	return nil
+}
+
+// clone returns a copy of the config which shares nothing with it.
+func (c *BalanceConfig) clone() *BalanceConfig {
+	cfg := *c
+	cfg.LocationLabels = append([]string(nil), c.LocationLabels...)
+	return &cfg
 }
 
 func (c *BalanceConfig) String() string {
@@ -380,6 +815,9 @@ func parseUrls(s string) ([]url.URL, error) {
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, errors.Errorf("url %q has no scheme or host", item)
+		}
 
 		urls = append(urls, *u)
 	}
@@ -397,7 +835,9 @@ func (c *Config) genEmbedEtcdConfig() (*embed.Config, error) {
 	// Use unique cluster id as the etcd cluster token too.
 	cfg.InitialClusterToken = fmt.Sprintf("pd-%d", c.ClusterID)
 	cfg.ClusterState = c.InitialClusterState
+	cfg.ForceNewCluster = c.ForceNewCluster
 	cfg.EnablePprof = true
+	cfg.AutoCompactionRetention = int(c.AutoCompactionRetention.Duration / time.Hour)
 
 	var err error
 
@@ -421,6 +861,11 @@ func (c *Config) genEmbedEtcdConfig() (*embed.Config, error) {
 		return nil, errors.Trace(err)
 	}
 
+	if c.isTLSEnabled() {
+		cfg.ClientTLSInfo = c.tlsInfo()
+		cfg.PeerTLSInfo = c.tlsInfo()
+	}
+
 	return cfg, nil
 }
 
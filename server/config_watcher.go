@@ -0,0 +1,128 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// configLoop keeps the config in memory the same as the config saved in
+// etcd, so the followers serve the latest config and a new leader doesn't
+// need to wait for loading it.
+func (s *Server) configLoop() {
+	defer s.wg.Done()
+
+	ctx := s.client.Ctx()
+	for {
+		if s.isClosed() {
+			log.Infof("server is closed, return config loop")
+			return
+		}
+
+		rev, err := s.reloadConfig()
+		if err != nil {
+			log.Errorf("reload config err %v", err)
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+
+		// The watch returns if the etcd connection is lost or the revision
+		// is compacted, we reload the config and watch again.
+		s.watchConfig(rev)
+
+		select {
+		case <-ctx.Done():
+			// server closed, return
+			return
+		default:
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+}
+
+// reloadConfig loads all the saved config and returns the etcd revision
+// of the config.
+func (s *Server) reloadConfig() (int64, error) {
+	resp, err := kvGet(s.client, s.getConfigPath(), clientv3.WithPrefix())
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	// The keys are sorted, so the schedule config which overrides the
+	// scheduling limits in the balance config is applied at last.
+	for _, kv := range resp.Kvs {
+		if err = s.applyConfig(string(kv.Key), kv.Value, kv.ModRevision); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	return resp.Header.Revision, nil
+}
+
+func (s *Server) watchConfig(rev int64) {
+	watcher := clientv3.NewWatcher(s.client)
+	defer watcher.Close()
+
+	rch := watcher.Watch(s.client.Ctx(), s.getConfigPath(), clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	for wresp := range rch {
+		if err := wresp.Err(); err != nil {
+			log.Warnf("watch config err %v", err)
+			return
+		}
+
+		for _, ev := range wresp.Events {
+			// The schedule config is only deleted when the balance
+			// config is saved, which contains the scheduling limits.
+			if ev.Type != mvccpb.PUT {
+				continue
+			}
+			if err := s.applyConfig(string(ev.Kv.Key), ev.Kv.Value, ev.Kv.ModRevision); err != nil {
+				log.Errorf("apply config %s err %v", ev.Kv.Key, err)
+			}
+		}
+	}
+}
+
+// applyConfig applies the config saved in etcd at the revision, it is
+// ignored if a newer config has been applied.
+func (s *Server) applyConfig(key string, value []byte, rev int64) error {
+	switch key {
+	case s.getBalanceConfigPath():
+		var cfg BalanceConfig
+		if err := json.Unmarshal(value, &cfg); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(s.updateConfig(rev, func(c *Config) error {
+			c.setBalanceConfig(cfg)
+			return nil
+		}))
+	case s.getScheduleConfigPath():
+		return errors.Trace(s.updateConfig(rev, func(c *Config) error {
+			// The config saved by an older version may miss some fields,
+			// which are kept unchanged.
+			cfg := c.getScheduleConfig()
+			if err := json.Unmarshal(value, &cfg); err != nil {
+				return errors.Trace(err)
+			}
+			c.setScheduleConfig(cfg)
+			return nil
+		}))
+	}
+	return nil
+}
@@ -89,7 +89,9 @@ func (c *conn) run() {
 			label = convertName(requestCmdName)
 		}
 
+		c.s.beginRequest()
 		response, err := c.handleRequest(request)
+		c.s.endRequest()
 		if err != nil {
 			log.Errorf("handle request %s err %v", request, errors.ErrorStack(err))
 			response = newError(err)
@@ -0,0 +1,176 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/juju/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+// The reasons why the region is not balanced.
+const (
+	diagnoseNoLeader         = "no_leader"
+	diagnoseHasOperator      = "operator_running"
+	diagnoseStaleHeartbeat   = "stale_heartbeat"
+	diagnoseReplicaCount     = "replica_count_mismatch"
+	diagnoseHasPeer          = "has_peer"
+	diagnoseNamespace        = "namespace"
+	diagnosePlacementRule    = "placement_rule"
+	diagnoseSameLocation     = "same_location"
+	diagnoseStoreUnavailable = "store_unavailable"
+	diagnoseStoreFull        = "store_full"
+	diagnoseStoreNotFull     = "store_not_full"
+	diagnoseSnapshotLimit    = "snapshot_limit"
+	diagnoseScoreDiff        = "score_diff_too_small"
+)
+
+// StoreDiagnosis tells why the store can't be the target store of the
+// region, the reason is empty if the peer can be moved to the store.
+type StoreDiagnosis struct {
+	StoreID uint64 `json:"store_id"`
+	Reason  string `json:"reason"`
+}
+
+// RegionDiagnosis tells why no operator is generated for the region. The
+// capacity balancer moves the peer on the most used store of the region,
+// which is the source store. The region reasons prevent the region from
+// being scheduled at all.
+type RegionDiagnosis struct {
+	RegionID      uint64            `json:"region_id"`
+	Reasons       []string          `json:"reasons"`
+	SourceStoreID uint64            `json:"source_store_id"`
+	SourceReason  string            `json:"source_reason"`
+	Stores        []*StoreDiagnosis `json:"stores"`
+}
+
+// DiagnoseRegion runs the filters of the capacity balancer against the
+// region, it doesn't change the state of the balancers.
+func (c *RaftCluster) DiagnoseRegion(regionID uint64) (*RegionDiagnosis, error) {
+	region, leader := c.cachedCluster.regions.getRegionByID(regionID)
+	if region == nil {
+		return nil, errors.Trace(ErrRegionNotFound)
+	}
+	return diagnoseRegion(c.cachedCluster, c.balancerWorker, region, leader), nil
+}
+
+// filterReason returns the reason of the store filtered by the filter.
+func filterReason(filter Filter) string {
+	switch filter.(type) {
+	case *stateFilter:
+		return diagnoseStoreUnavailable
+	case *capacityFilter:
+		return diagnoseStoreFull
+	case *snapCountFilter:
+		return diagnoseSnapshotLimit
+	}
+	return "filtered"
+}
+
+func diagnoseRegion(cluster *clusterInfo, bw *balancerWorker, region *metapb.Region, leader *metapb.Peer) *RegionDiagnosis {
+	cfg := bw.cfg()
+	cb := newCapacityBalancer(cfg)
+	diagnosis := &RegionDiagnosis{RegionID: region.GetId()}
+
+	if leader == nil {
+		diagnosis.Reasons = append(diagnosis.Reasons, diagnoseNoLeader)
+	}
+	if bw.getBalanceOperator(region.GetId()) != nil {
+		diagnosis.Reasons = append(diagnosis.Reasons, diagnoseHasOperator)
+	}
+	if bw.isRegionStale(region.GetId()) {
+		diagnosis.Reasons = append(diagnosis.Reasons, diagnoseStaleHeartbeat)
+	}
+	if len(region.GetPeers()) != int(cluster.getMeta().GetMaxPeerCount()) {
+		diagnosis.Reasons = append(diagnosis.Reasons, diagnoseReplicaCount)
+	}
+
+	// The source store is the most used store of the region.
+	peerStores := make([]*storeInfo, 0, len(region.GetPeers()))
+	for _, peer := range region.GetPeers() {
+		if store := cluster.getStore(peer.GetStoreId()); store != nil {
+			peerStores = append(peerStores, store)
+		}
+	}
+	var sourcePeer *metapb.Peer
+	if source := selectFromStore(peerStores, nil, nil, cb.st); source != nil {
+		diagnosis.SourceStoreID = source.store.GetId()
+		sourcePeer = leaderPeer(region, diagnosis.SourceStoreID)
+		for _, filter := range cb.filters {
+			if filter.FilterFromStore(source) {
+				diagnosis.SourceReason = filterReason(filter)
+				if _, ok := filter.(*capacityFilter); ok {
+					diagnosis.SourceReason = diagnoseStoreNotFull
+				}
+				break
+			}
+		}
+	}
+
+	stores := cluster.getStores()
+	excludedChecks := []struct {
+		reason   string
+		excluded map[uint64]struct{}
+	}{
+		{reason: diagnoseHasPeer, excluded: getExcludedStores(region)},
+		{reason: diagnoseNamespace, excluded: make(map[uint64]struct{})},
+		{reason: diagnosePlacementRule, excluded: make(map[uint64]struct{})},
+		{reason: diagnoseSameLocation, excluded: make(map[uint64]struct{})},
+	}
+	excludeNamespaceStores(cluster, stores, region, excludedChecks[1].excluded)
+	excludeRuleStores(cluster, stores, region, excludedChecks[2].excluded)
+	if sourcePeer != nil {
+		excludeSameLocationStores(cluster, stores, region, excludedChecks[3].excluded, cfg.LocationLabels, sourcePeer)
+	}
+
+	storeIDs := make([]uint64, 0, len(stores))
+	storesByID := make(map[uint64]*storeInfo, len(stores))
+	for _, store := range stores {
+		storeIDs = append(storeIDs, store.store.GetId())
+		storesByID[store.store.GetId()] = store
+	}
+	sort.Sort(uint64Slice(storeIDs))
+
+	for _, storeID := range storeIDs {
+		store := storesByID[storeID]
+		storeDiagnosis := &StoreDiagnosis{StoreID: storeID}
+		diagnosis.Stores = append(diagnosis.Stores, storeDiagnosis)
+
+		for _, check := range excludedChecks {
+			if _, ok := check.excluded[storeID]; ok {
+				storeDiagnosis.Reason = check.reason
+				break
+			}
+		}
+		if storeDiagnosis.Reason != "" {
+			continue
+		}
+		for _, filter := range cb.filters {
+			if filter.FilterToStore(store) {
+				storeDiagnosis.Reason = filterReason(filter)
+				break
+			}
+		}
+		if storeDiagnosis.Reason != "" || sourcePeer == nil {
+			continue
+		}
+		newPeer := &metapb.Peer{StoreId: proto.Uint64(storeID)}
+		if _, ok := checkAndGetDiffScore(cluster, sourcePeer, newPeer, cb.st, cfg); !ok {
+			storeDiagnosis.Reason = diagnoseScoreDiff
+		}
+	}
+	return diagnosis
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	. "github.com/pingcap/check"
+)
+
+func (s *testBalancerSuite) TestDiagnoseRegion(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	clusterInfo.addStore(s.newStore(c, 5, "127.0.0.1:5"))
+	clusterInfo.addStore(s.newStore(c, 6, "127.0.0.1:6"))
+
+	// The region is on store 1, 2 and 3, store 1 is the most used store.
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	region.Peers = append(region.Peers, s.newPeer(c, 2, 100), s.newPeer(c, 3, 101))
+	clusterInfo.regions.updateRegion(region)
+	s.updateStore(c, clusterInfo, 1, 100, 5, 0, 0)
+	s.updateStore(c, clusterInfo, 2, 100, 50, 0, 0)
+	s.updateStore(c, clusterInfo, 3, 100, 50, 0, 0)
+	// Store 4 receives too many snapshots, store 5 is full and store 6 never
+	// reports its stats.
+	s.updateStore(c, clusterInfo, 4, 100, 50, 0, uint32(s.cfg.MaxReceivingSnapCount+1))
+	s.updateStore(c, clusterInfo, 5, 100, 5, 0, 0)
+
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(s.cfg))
+	reasons := func() map[uint64]string {
+		diagnosis := diagnoseRegion(clusterInfo, bw, region, leader)
+		c.Assert(diagnosis.RegionID, Equals, region.GetId())
+		c.Assert(diagnosis.SourceStoreID, Equals, uint64(1))
+		c.Assert(diagnosis.SourceReason, Equals, "")
+		reasons := make(map[uint64]string, len(diagnosis.Stores))
+		for _, store := range diagnosis.Stores {
+			reasons[store.StoreID] = store.Reason
+		}
+		return reasons
+	}
+
+	c.Assert(reasons(), DeepEquals, map[uint64]string{
+		1: diagnoseHasPeer,
+		2: diagnoseHasPeer,
+		3: diagnoseHasPeer,
+		4: diagnoseSnapshotLimit,
+		5: diagnoseStoreFull,
+		6: diagnoseStoreUnavailable,
+	})
+
+	// Store 4 is eligible after the snapshots are received, but it is
+	// outside the namespace of the region.
+	s.updateStore(c, clusterInfo, 4, 100, 50, 0, 0)
+	c.Assert(reasons()[4], Equals, "")
+	clusterInfo.setNamespaces([]*Namespace{{Name: "a", Ranges: []*KeyRange{{}}, StoreIDs: []uint64{1, 2, 3, 5, 6}}})
+	c.Assert(reasons()[4], Equals, diagnoseNamespace)
+
+	// The diagnosis doesn't generate any operator.
+	c.Assert(bw.getBalanceOperators(), HasLen, 0)
+	c.Assert(diagnoseRegion(clusterInfo, bw, region, leader).Reasons, IsNil)
+	c.Assert(bw.addBalanceOperator(region.GetId(), newBalanceOperator(region)), IsTrue)
+	c.Assert(diagnoseRegion(clusterInfo, bw, region, leader).Reasons, DeepEquals, []string{diagnoseHasOperator})
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// ErrDiskSpaceLow is returned when the free space of the data dir is less
+// than the min free bytes.
+var ErrDiskSpaceLow = errors.New("data dir free space is low")
+
+// diskSpaceFunc returns the available bytes of the file system which the
+// dir is on.
+type diskSpaceFunc func(dir string) (uint64, error)
+
+// getFreeSpace is the default diskSpaceFunc, the data dir is created by
+// etcd later, so the nearest existing parent dir is checked if it doesn't
+// exist yet.
+func getFreeSpace(dir string) (uint64, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	for {
+		if _, err = os.Stat(dir); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(dir, &stat); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// updateFreeSpace checks the free space of the data dir and saves it for
+// the cluster status.
+func (s *Server) updateFreeSpace() (uint64, error) {
+	free, err := s.cfg.diskSpace(s.cfg.DataDir)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	atomic.StoreUint64(&s.freeSpace, free)
+	return free, nil
+}
+
+// getFreeSpace returns the free space of the data dir in the last check.
+func (s *Server) getFreeSpace() uint64 {
+	return atomic.LoadUint64(&s.freeSpace)
+}
+
+func (s *Server) diskCheckLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.DiskCheckInterval.Duration)
+	defer ticker.Stop()
+
+	ctx := s.client.Ctx()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkDiskSpace()
+		case <-ctx.Done():
+			// server closed, return
+			return
+		}
+	}
+}
+
+// checkDiskSpace warns if the free space is low, the leader steps down if
+// the free space is critical, because etcd may be corrupted if the disk is
+// full, and the member is hardly able to serve as the leader.
+func (s *Server) checkDiskSpace() {
+	free, err := s.updateFreeSpace()
+	if err != nil {
+		log.Errorf("check data dir %s free space err %v", s.cfg.DataDir, err)
+		return
+	}
+	if free >= s.cfg.MinFreeBytes {
+		return
+	}
+
+	log.Warnf("data dir %s free space %d bytes is less than %d bytes", s.cfg.DataDir, free, s.cfg.MinFreeBytes)
+	if free >= s.cfg.CriticalFreeBytes || !s.isLeader() {
+		return
+	}
+
+	log.Errorf("data dir %s free space %d bytes is critical, resign leader", s.cfg.DataDir, free)
+	if err = s.ResignLeader(); err != nil {
+		log.Errorf("resign leader for low disk space err %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/juju/errors"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testDiskSuite{})
+
+type testDiskSuite struct{}
+
+func (s *testDiskSuite) TestGetFreeSpace(c *C) {
+	dir, err := ioutil.TempDir("/tmp", "test_pd_disk")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	free, err := getFreeSpace(dir)
+	c.Assert(err, IsNil)
+	c.Assert(free, Greater, uint64(0))
+
+	// The data dir is not created yet, its parent is checked.
+	free, err = getFreeSpace(filepath.Join(dir, "a", "b"))
+	c.Assert(err, IsNil)
+	c.Assert(free, Greater, uint64(0))
+}
+
+func (s *testDiskSuite) TestLowSpaceStart(c *C) {
+	cfg := NewTestSingleConfig()
+	defer os.RemoveAll(cfg.DataDir)
+	cfg.diskSpace = func(string) (uint64, error) {
+		return 1 << 20, nil
+	}
+
+	_, err := CreateServer(cfg)
+	c.Assert(errors.Cause(err), Equals, ErrDiskSpaceLow)
+
+	cfg.MinFreeBytes = 1 << 10
+	cfg.CriticalFreeBytes = 1 << 10
+	svr, err := CreateServer(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(svr.getFreeSpace(), Equals, uint64(1<<20))
+
+	// The critical threshold can't be greater than the min free bytes.
+	cfg.CriticalFreeBytes = 1 << 11
+	_, err = CreateServer(cfg)
+	c.Assert(err, NotNil)
+}
+
+func (s *testDiskSuite) TestCriticalSpaceResign(c *C) {
+	// The data dir of the server in lowDir has little space after start.
+	var lowDir atomic.Value
+	lowDir.Store("")
+	diskSpace := func(dir string) (uint64, error) {
+		if dir == lowDir.Load().(string) {
+			return 1 << 20, nil
+		}
+		return 1 << 40, nil
+	}
+
+	cfgs := NewTestMultiConfig(3)
+	ch := make(chan *Server, 3)
+	for _, cfg := range cfgs {
+		cfg.diskSpace = diskSpace
+		cfg.DiskCheckInterval.Duration = 100 * time.Millisecond
+		go func(cfg *Config) {
+			svr, err := NewServer(cfg)
+			c.Assert(err, IsNil)
+			ch <- svr
+		}(cfg)
+	}
+
+	var leaderPath string
+	svrs := make(map[string]*Server, 3)
+	endpoints := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		svr := <-ch
+		svrs[svr.GetAddr()] = svr
+		leaderPath = svr.getLeaderPath()
+		endpoints = append(endpoints, svr.GetEndpoints()...)
+		defer os.RemoveAll(svr.cfg.DataDir)
+		defer svr.Close()
+		go svr.Run()
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 3 * time.Second,
+	})
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	leader1 := mustGetLeader(c, client, leaderPath)
+	svr := svrs[leader1.GetAddr()]
+	lowDir.Store(svr.cfg.DataDir)
+
+	for i := 0; i < 50; i++ {
+		leader, _ := getLeader(client, leaderPath)
+		if leader != nil && leader.GetAddr() != leader1.GetAddr() {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	leader2 := mustGetLeader(c, client, leaderPath)
+	c.Assert(leader2.GetAddr(), Not(Equals), leader1.GetAddr())
+	c.Assert(svr.getFreeSpace(), Equals, uint64(1<<20))
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sort"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// drainingStore is an offline store with the count of the regions left.
+type drainingStore struct {
+	id          uint64
+	regionCount int
+}
+
+// getDrainingStores returns the offline stores which still have regions,
+// the store nearest to empty comes first.
+func getDrainingStores(cluster *clusterInfo) []*drainingStore {
+	var stores []*drainingStore
+	for _, store := range cluster.getStores() {
+		if !store.isOffline() || store.isTombstone() {
+			continue
+		}
+		count := cluster.regions.storeRegionCount(store.store.GetId())
+		if count == 0 {
+			continue
+		}
+		stores = append(stores, &drainingStore{id: store.store.GetId(), regionCount: count})
+	}
+	sort.Sort(drainingStores(stores))
+	return stores
+}
+
+type drainingStores []*drainingStore
+
+func (s drainingStores) Len() int {
+	return len(s)
+}
+
+func (s drainingStores) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s drainingStores) Less(i, j int) bool {
+	if s[i].regionCount != s[j].regionCount {
+		return s[i].regionCount < s[j].regionCount
+	}
+	return s[i].id < s[j].id
+}
+
+// drainOfflineStores moves the regions out of the offline stores. All the
+// offline stores are drained at the same time, but the regions are picked
+// from the store nearest to empty first, so it's emptied as soon as
+// possible. The region counts are computed once a pass. It returns the
+// operators added.
+func (bw *balancerWorker) drainOfflineStores() []*balanceOperator {
+	if bw.inImportMode() {
+		return nil
+	}
+
+	cfg := bw.cfg()
+	var added []*balanceOperator
+	for _, store := range getDrainingStores(bw.cluster) {
+		for _, regionID := range bw.cluster.regions.storeRegionIDs(store.id, int(cfg.MaxBalanceRetryPerLoop)) {
+			if uint64(len(added)) >= cfg.MaxBalanceCountPerLoop || !bw.allowBalance() {
+				return added
+			}
+			bop, err := bw.drainRegion(regionID)
+			if err != nil {
+				log.Warnf("drain region %d from store %d failed - %v", regionID, store.id, errors.ErrorStack(err))
+				continue
+			}
+			if bop != nil {
+				added = append(added, bop)
+			}
+		}
+	}
+	return added
+}
+
+// drainRegion adds the replica balance operator of the region, which moves
+// its peers out of the offline stores.
+func (bw *balancerWorker) drainRegion(regionID uint64) (*balanceOperator, error) {
+	if bw.getBalanceOperator(regionID) != nil || bw.isRegionStale(regionID) {
+		return nil, nil
+	}
+	region, leader := bw.cluster.regions.getRegionByID(regionID)
+	if region == nil || leader == nil {
+		return nil, nil
+	}
+
+	_, bop, err := newReplicaBalancer(region, leader, nil, bw.cfg()).Balance(bw.cluster)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if bop == nil || !bw.allowStoreLimit(bop) || !bw.addBalanceOperator(regionID, bop) {
+		return nil, nil
+	}
+	bw.takeStoreLimit(bop)
+	bw.addRegionCache(regionID)
+	log.Infof("drain region %d - %s", regionID, bop)
+	return bop, nil
+}
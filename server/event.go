@@ -15,6 +15,7 @@ package server
 
 import (
 	"sync/atomic"
+	"time"
 
 	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
 )
@@ -24,8 +25,21 @@ type statusType byte
 const (
 	evtStart statusType = iota + 1
 	evtEnd
+	evtFailed
+	evtCanceled
+	evtTimeout
+	evtSuperseded
 )
 
+// outcomeEventStatus is the event status of the operators which don't
+// finish successfully.
+var outcomeEventStatus = map[OperatorOutcome]statusType{
+	OperatorFailed:     evtFailed,
+	OperatorCanceled:   evtCanceled,
+	OperatorTimeout:    evtTimeout,
+	OperatorSuperseded: evtSuperseded,
+}
+
 type msgType byte
 
 const (
@@ -33,13 +47,16 @@ const (
 	msgTransferLeader
 	msgAddReplica
 	msgRemoveReplica
+	msgStoreState
 )
 
 // LogEvent is operator log event.
 type LogEvent struct {
 	ID     uint64     `json:"id"`
+	Time   time.Time  `json:"time"`
 	Code   msgType    `json:"code"`
 	Status statusType `json:"status"`
+	Reason string     `json:"reason,omitempty"`
 
 	SplitEvent struct {
 		Region uint64 `json:"region"`
@@ -62,17 +79,24 @@ type LogEvent struct {
 		StoreFrom uint64 `json:"store_from"`
 		StoreTo   uint64 `json:"store_to"`
 	} `json:"transfer_leader_event,omitempty"`
+
+	StoreStateEvent struct {
+		Store uint64 `json:"store"`
+		State string `json:"state"`
+	} `json:"store_state_event,omitempty"`
 }
 
 func (bw *balancerWorker) innerPostEvent(evt LogEvent) {
 	key := atomic.AddUint64(&baseID, 1)
 	evt.ID = key
+	evt.Time = time.Now()
 	bw.events.add(key, evt)
 }
 
-func (bw *balancerWorker) postEvent(op Operator, status statusType) {
+func (bw *balancerWorker) postEvent(op Operator, status statusType, reason string) {
 	var evt LogEvent
 	evt.Status = status
+	evt.Reason = reason
 
 	switch e := op.(type) {
 	case *splitOperator:
@@ -118,10 +142,72 @@ func (bw *balancerWorker) fetchEvents(key uint64, all bool) []LogEvent {
 	return evts
 }
 
+// latestEvents returns at most n latest events.
+func (bw *balancerWorker) latestEvents(n int) []LogEvent {
+	elems := bw.events.latestElems(n)
+	evts := make([]LogEvent, 0, len(elems))
+	for _, ele := range elems {
+		evts = append(evts, ele.value.(LogEvent))
+	}
+
+	return evts
+}
+
+// hasStore checks whether the event touches the store.
+func (evt *LogEvent) hasStore(storeID uint64) bool {
+	switch evt.Code {
+	case msgAddReplica:
+		return evt.AddReplicaEvent.Store == storeID
+	case msgRemoveReplica:
+		return evt.RemoveReplicaEvent.Store == storeID
+	case msgTransferLeader:
+		return evt.TransferLeaderEvent.StoreFrom == storeID || evt.TransferLeaderEvent.StoreTo == storeID
+	case msgStoreState:
+		return evt.StoreStateEvent.Store == storeID
+	}
+	return false
+}
+
+// storeEvents returns the events which touch the store and happen after
+// since, the newest event is the first.
+func (bw *balancerWorker) storeEvents(storeID uint64, since time.Time) []LogEvent {
+	elems := bw.events.elems()
+	evts := make([]LogEvent, 0)
+	for i := len(elems) - 1; i >= 0; i-- {
+		evt := elems[i].value.(LogEvent)
+		if evt.Time.Before(since) {
+			break
+		}
+		if evt.hasStore(storeID) {
+			evts = append(evts, evt)
+		}
+	}
+
+	return evts
+}
+
+func (bw *balancerWorker) postStoreStateEvent(storeID uint64, state StoreState) {
+	var evt LogEvent
+	evt.Code = msgStoreState
+	evt.Status = evtEnd
+	evt.StoreStateEvent.Store = storeID
+	evt.StoreStateEvent.State = state.String()
+	bw.innerPostEvent(evt)
+}
+
+// postOutcomeEvent posts the event of the running step with the outcome
+// and the reason of the operator which doesn't finish successfully.
+func (bw *balancerWorker) postOutcomeEvent(bop *balanceOperator) {
+	status, ok := outcomeEventStatus[bop.Status]
+	if ok && bop.Index < len(bop.Ops) {
+		bw.postEvent(bop.Ops[bop.Index], status, bop.Reason)
+	}
+}
+
 func (bw *balancerWorker) hookStartEvent(op Operator) {
-	bw.postEvent(op, evtStart)
+	bw.postEvent(op, evtStart, "")
 }
 
 func (bw *balancerWorker) hookEndEvent(op Operator) {
-	bw.postEvent(op, evtEnd)
+	bw.postEvent(op, evtEnd, "")
 }
@@ -69,7 +69,8 @@ func (sf *stateFilter) FilterFromStore(store *storeInfo, args ...interface{}) bo
 }
 
 func (sf *stateFilter) FilterToStore(store *storeInfo, args ...interface{}) bool {
-	return sf.filterBadStore(store)
+	// We should not move any region to the offline store.
+	return sf.filterBadStore(store) || store.isOffline()
 }
 
 type capacityFilter struct {
@@ -100,8 +101,11 @@ func (sf *snapCountFilter) FilterFromStore(store *storeInfo, args ...interface{}
 	return uint64(store.stats.Stats.GetSendingSnapCount()) > sf.cfg.MaxSendingSnapCount
 }
 
+// FilterToStore filters the store which is sending too many snapshots too,
+// because the snapshots sent and received share the disk I/O of the store.
 func (sf *snapCountFilter) FilterToStore(store *storeInfo, args ...interface{}) bool {
-	return uint64(store.stats.Stats.GetReceivingSnapCount()) > sf.cfg.MaxReceivingSnapCount
+	return uint64(store.stats.Stats.GetReceivingSnapCount()) > sf.cfg.MaxReceivingSnapCount ||
+		sf.FilterFromStore(store, args...)
 }
 
 type leaderCountFilter struct {
@@ -0,0 +1,197 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// ErrSafePointRollback is returned when the new GC safe point is less than
+// the saved one.
+var ErrSafePointRollback = errors.New("gc safe point can't be rolled back")
+
+// ErrInvalidServiceID is returned when the service ID of the service safe
+// point is empty or not a valid path element.
+var ErrInvalidServiceID = errors.New("invalid service id")
+
+func (s *Server) getGCSafePointPath() string {
+	return path.Join(s.rootPath, "gc", "safe_point")
+}
+
+// GetGCSafePoint returns the GC safe point, it is 0 if no safe point is
+// saved.
+func (s *Server) GetGCSafePoint() (uint64, error) {
+	safePoint, _, err := s.loadGCSafePoint()
+	return safePoint, errors.Trace(err)
+}
+
+// loadGCSafePoint returns the GC safe point and the mod revision of its key,
+// the revision is 0 if no safe point is saved.
+func (s *Server) loadGCSafePoint() (uint64, int64, error) {
+	resp, err := kvGet(s.client, s.getGCSafePointPath())
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, 0, nil
+	}
+
+	safePoint, err := bytesToUint64(resp.Kvs[0].Value)
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	return safePoint, resp.Kvs[0].ModRevision, nil
+}
+
+// UpdateGCSafePoint saves the GC safe point, which never decreases, so GC
+// can't advance and then go back to the data still needed by the readers.
+// The safe point is capped by the service safe points which are not
+// expired. It returns the safe point saved after the update, if the new
+// safe point is less than it, ErrSafePointRollback is returned.
+func (s *Server) UpdateGCSafePoint(safePoint uint64) (uint64, error) {
+	key := s.getGCSafePointPath()
+	for {
+		current, rev, err := s.loadGCSafePoint()
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if safePoint < current {
+			return current, errors.Annotatef(ErrSafePointRollback, "safe point %d is less than %d", safePoint, current)
+		}
+
+		serviceSafePoint, found, err := s.loadServiceGCSafePoint()
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if found && serviceSafePoint < safePoint {
+			log.Infof("gc safe point %d is capped by the service safe point %d", safePoint, serviceSafePoint)
+			safePoint = serviceSafePoint
+		}
+		if safePoint <= current && rev != 0 {
+			return current, nil
+		}
+
+		// The safe point is changed only if no one changes it after we load it.
+		resp, err := s.txn().
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+			Then(clientv3.OpPut(key, string(uint64ToBytes(safePoint)))).
+			Commit()
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if resp.Succeeded {
+			log.Infof("gc safe point is updated from %d to %d", current, safePoint)
+			return safePoint, nil
+		}
+	}
+}
+
+// ServiceSafePoint is the GC safe point registered by a service, like backup
+// or CDC, which needs the data after the safe point until it expires.
+type ServiceSafePoint struct {
+	ServiceID string    `json:"service_id"`
+	SafePoint uint64    `json:"safe_point"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+func (s *Server) getServiceSafePointPath() string {
+	return path.Join(s.rootPath, "gc", "service_safe_point")
+}
+
+// UpdateServiceGCSafePoint registers the safe point of the service for ttl,
+// the registration of the same service is replaced. It returns the minimal
+// safe point of the services which are not expired.
+func (s *Server) UpdateServiceGCSafePoint(serviceID string, ttl time.Duration, safePoint uint64) (uint64, error) {
+	// The service ID is a part of the key, it mustn't escape the directory.
+	if serviceID == "" || strings.Contains(serviceID, "/") || strings.Contains(serviceID, "..") {
+		return 0, errors.Annotatef(ErrInvalidServiceID, "service id %q", serviceID)
+	}
+
+	ssp := &ServiceSafePoint{
+		ServiceID: serviceID,
+		SafePoint: safePoint,
+		ExpiredAt: time.Now().Add(ttl),
+	}
+	value, err := json.Marshal(ssp)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	key := path.Join(s.getServiceSafePointPath(), serviceID)
+	if _, err = s.txn().Then(clientv3.OpPut(key, string(value))).Commit(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	log.Infof("service %s gc safe point is updated to %d, ttl %v", serviceID, safePoint, ttl)
+
+	return s.GetServiceGCSafePoint()
+}
+
+// GetServiceGCSafePoint returns the minimal safe point of the services which
+// are not expired, it is 0 if there is no such service. The expired
+// registrations are removed here.
+func (s *Server) GetServiceGCSafePoint() (uint64, error) {
+	safePoint, _, err := s.loadServiceGCSafePoint()
+	return safePoint, errors.Trace(err)
+}
+
+// loadServiceGCSafePoint is like GetServiceGCSafePoint, it also returns
+// whether there is a service which is not expired.
+func (s *Server) loadServiceGCSafePoint() (uint64, bool, error) {
+	resp, err := kvGet(s.client, s.getServiceSafePointPath()+"/", clientv3.WithPrefix())
+	if err != nil {
+		return 0, false, errors.Trace(err)
+	}
+
+	var (
+		minSafePoint uint64
+		found        bool
+		now          = time.Now()
+	)
+	for _, kv := range resp.Kvs {
+		ssp := &ServiceSafePoint{}
+		if err = json.Unmarshal(kv.Value, ssp); err != nil {
+			return 0, false, errors.Trace(err)
+		}
+		if !ssp.ExpiredAt.After(now) {
+			s.removeServiceSafePoint(string(kv.Key), kv.ModRevision)
+			continue
+		}
+		if !found || ssp.SafePoint < minSafePoint {
+			minSafePoint, found = ssp.SafePoint, true
+		}
+	}
+	return minSafePoint, found, nil
+}
+
+// removeServiceSafePoint removes the expired registration, unless the service
+// registers again after it is loaded.
+func (s *Server) removeServiceSafePoint(key string, rev int64) {
+	resp, err := s.txn().
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		log.Errorf("remove expired service safe point %s err %v", key, err)
+		return
+	}
+	if resp.Succeeded {
+		log.Infof("expired service safe point %s is removed", key)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+const heartbeatQueueSize = 1024
+
+var errHeartbeatWorkersStopped = errors.New("region heartbeat workers are stopped")
+
+// heartbeatTask is a region heartbeat waiting to be processed.
+type heartbeatTask struct {
+	f    func()
+	done chan struct{}
+}
+
+// heartbeatWorkers processes the region heartbeats in the workers sharded
+// by the region ID like the regions cache, so the worker of a shard is the
+// only one which processes the heartbeats of its regions. The heartbeats of
+// different shards are processed concurrently, and the heartbeats of a
+// region are processed in order by the same worker.
+type heartbeatWorkers struct {
+	wg     sync.WaitGroup
+	queues []chan *heartbeatTask
+	quit   chan struct{}
+}
+
+func newHeartbeatWorkers(count int) *heartbeatWorkers {
+	w := &heartbeatWorkers{
+		queues: make([]chan *heartbeatTask, count),
+		quit:   make(chan struct{}),
+	}
+	for i := range w.queues {
+		w.queues[i] = make(chan *heartbeatTask, heartbeatQueueSize)
+	}
+	return w
+}
+
+func (w *heartbeatWorkers) run() {
+	for _, queue := range w.queues {
+		w.wg.Add(1)
+		go w.work(queue)
+	}
+}
+
+func (w *heartbeatWorkers) work(queue chan *heartbeatTask) {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case task := <-queue:
+			task.f()
+			close(task.done)
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+func (w *heartbeatWorkers) stop() {
+	close(w.quit)
+	w.wg.Wait()
+}
+
+// do runs f in the worker of the region and waits for it, the calls of
+// the same region run in the order they are made.
+func (w *heartbeatWorkers) do(regionID uint64, f func()) error {
+	task := &heartbeatTask{f: f, done: make(chan struct{})}
+	select {
+	case w.queues[regionShardIndex(regionID, len(w.queues))] <- task:
+	case <-w.quit:
+		return errors.Trace(errHeartbeatWorkersStopped)
+	}
+
+	select {
+	case <-task.done:
+		return nil
+	case <-w.quit:
+		// The worker may have taken the task before it quits.
+		select {
+		case <-task.done:
+			return nil
+		default:
+			return errors.Trace(errHeartbeatWorkersStopped)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/juju/errors"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+var _ = Suite(&testHeartbeatWorkersSuite{})
+
+type testHeartbeatWorkersSuite struct{}
+
+// sendHeartbeats sends the heartbeats of the regions concurrently, every
+// sender sends count heartbeats of each region in order.
+func sendHeartbeats(c *C, w *heartbeatWorkers, regionCount int, senders int, count int, f func(regionID uint64, sender int, seq int)) {
+	var (
+		wg     sync.WaitGroup
+		failed int32
+	)
+	for regionID := 0; regionID < regionCount; regionID++ {
+		for sender := 0; sender < senders; sender++ {
+			wg.Add(1)
+			go func(regionID uint64, sender int) {
+				defer wg.Done()
+				for seq := 0; seq < count; seq++ {
+					if err := w.do(regionID, func() { f(regionID, sender, seq) }); err != nil {
+						atomic.AddInt32(&failed, 1)
+					}
+				}
+			}(uint64(regionID), sender)
+		}
+	}
+	wg.Wait()
+	c.Assert(failed, Equals, int32(0))
+}
+
+func (s *testHeartbeatWorkersSuite) TestOrder(c *C) {
+	const (
+		regionCount = 64
+		senders     = 4
+		count       = 50
+	)
+
+	w := newHeartbeatWorkers(8)
+	w.run()
+	defer w.stop()
+
+	// The state of a region is only touched by its worker, so it needs no lock.
+	running := make([]int32, regionCount)
+	lastSeq := make([][]int, regionCount)
+	total := make([]int, regionCount)
+	for i := range lastSeq {
+		lastSeq[i] = make([]int, senders)
+		for j := range lastSeq[i] {
+			lastSeq[i][j] = -1
+		}
+	}
+
+	var violations int32
+	sendHeartbeats(c, w, regionCount, senders, count, func(regionID uint64, sender int, seq int) {
+		if atomic.AddInt32(&running[regionID], 1) != 1 || seq != lastSeq[regionID][sender]+1 {
+			atomic.AddInt32(&violations, 1)
+		}
+		lastSeq[regionID][sender] = seq
+		total[regionID]++
+		atomic.AddInt32(&running[regionID], -1)
+	})
+
+	c.Assert(violations, Equals, int32(0))
+	for regionID := range total {
+		c.Assert(total[regionID], Equals, senders*count)
+	}
+}
+
+func (s *testHeartbeatWorkersSuite) TestThroughput(c *C) {
+	const (
+		regionCount = 64
+		count       = 5
+	)
+
+	// The regions are sharded like the workers, every heartbeat changes the
+	// region, which is saved in etcd for 1ms.
+	elapsed := func(workers int) time.Duration {
+		w := newHeartbeatWorkers(workers)
+		w.run()
+		defer w.stop()
+
+		regions := newRegionsInfo(workers)
+		start := time.Now()
+		var failed int32
+		sendHeartbeats(c, w, regionCount, 1, count, func(regionID uint64, _ int, seq int) {
+			peer := &metapb.Peer{Id: proto.Uint64(regionID + 1000), StoreId: proto.Uint64(1)}
+			region := &metapb.Region{
+				Id:          proto.Uint64(regionID + 1),
+				StartKey:    []byte{byte(regionID)},
+				EndKey:      []byte{byte(regionID + 1)},
+				RegionEpoch: &metapb.RegionEpoch{ConfVer: proto.Uint64(uint64(seq + 1)), Version: proto.Uint64(1)},
+				Peers:       []*metapb.Peer{peer},
+			}
+			resp, _, err := regions.heartbeat(region, peer)
+			if err != nil || resp.putRegion == nil {
+				atomic.AddInt32(&failed, 1)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		})
+		c.Assert(failed, Equals, int32(0))
+		for id := uint64(1); id <= regionCount; id++ {
+			region, _ := regions.getRegionByID(id)
+			c.Assert(region.GetRegionEpoch().GetConfVer(), Equals, uint64(count))
+		}
+		return time.Since(start)
+	}
+
+	single, sharded := elapsed(1), elapsed(8)
+	c.Assert(sharded < single/2, IsTrue, Commentf("1 worker %v, 8 workers %v", single, sharded))
+}
+
+func (s *testHeartbeatWorkersSuite) TestStop(c *C) {
+	w := newHeartbeatWorkers(2)
+	w.run()
+
+	called := false
+	c.Assert(w.do(1, func() { called = true }), IsNil)
+	c.Assert(called, IsTrue)
+
+	w.stop()
+	err := w.do(1, func() {})
+	c.Assert(errors.Cause(err), Equals, errHeartbeatWorkersStopped)
+}
+
+func (s *testHeartbeatWorkersSuite) BenchmarkHeartbeat(c *C) {
+	w := newHeartbeatWorkers(8)
+	w.run()
+	defer w.stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(regionID uint64) {
+			defer wg.Done()
+			for j := 0; j < c.N; j++ {
+				w.do(regionID, func() {})
+			}
+		}(uint64(i))
+	}
+	wg.Wait()
+}
@@ -21,10 +21,6 @@ import (
 	"github.com/juju/errors"
 )
 
-const (
-	allocStep = uint64(1000)
-)
-
 // IDAllocator is the allocator to generate unique ID.
 type IDAllocator interface {
 	Alloc() (uint64, error)
@@ -49,7 +45,7 @@ func (alloc *idAllocator) Alloc() (uint64, error) {
 		}
 
 		alloc.end = end
-		alloc.base = alloc.end - allocStep
+		alloc.base = alloc.end - alloc.s.cfg.IDAllocStep
 	}
 
 	alloc.base++
@@ -57,7 +53,42 @@ func (alloc *idAllocator) Alloc() (uint64, error) {
 	return alloc.base, nil
 }
 
+// reset drops the IDs left in memory, so the next allocation starts from
+// the persisted high-water mark. It must be called when we become leader,
+// because other leaders may have allocated IDs after us.
+func (alloc *idAllocator) reset() {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	alloc.base = 0
+	alloc.end = 0
+}
+
+// getMax returns the persisted high-water mark, all IDs allocated are not
+// greater than it.
+func (alloc *idAllocator) getMax() (uint64, error) {
+	value, err := getValue(alloc.s.client, alloc.s.getAllocIDPath())
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if value == nil {
+		return 0, nil
+	}
+	max, err := bytesToUint64(value)
+	return max, errors.Trace(err)
+}
+
 func (alloc *idAllocator) generate() (uint64, error) {
+	var end uint64
+	err := alloc.s.retryLeaderTxn("generate id", func() error {
+		var err error
+		end, err = alloc.tryGenerate()
+		return errors.Trace(err)
+	})
+	return end, errors.Trace(err)
+}
+
+func (alloc *idAllocator) tryGenerate() (uint64, error) {
 	key := alloc.s.getAllocIDPath()
 	value, err := getValue(alloc.s.client, key)
 	if err != nil {
@@ -82,14 +113,14 @@ func (alloc *idAllocator) generate() (uint64, error) {
 		cmp = clientv3.Compare(clientv3.Value(key), "=", string(value))
 	}
 
-	end += allocStep
+	end += alloc.s.cfg.IDAllocStep
 	value = uint64ToBytes(end)
 	resp, err := alloc.s.leaderTxn(cmp).Then(clientv3.OpPut(key, string(value))).Commit()
 	if err != nil {
 		return 0, errors.Trace(err)
 	}
 	if !resp.Succeeded {
-		return 0, errors.New("generate id failed, we may not leader")
+		return 0, errors.Annotate(ErrNotLeader, "generate id failed")
 	}
 
 	return end, nil
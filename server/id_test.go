@@ -49,7 +49,7 @@ func (s *testAllocIDSuite) TestID(c *C) {
 	mustGetLeader(c, s.client, s.svr.getLeaderPath())
 
 	var last uint64
-	for i := uint64(0); i < allocStep; i++ {
+	for i := uint64(0); i < s.svr.cfg.IDAllocStep; i++ {
 		id, err := s.alloc.Alloc()
 		c.Assert(err, IsNil)
 		c.Assert(id, Greater, last)
@@ -81,6 +81,65 @@ func (s *testAllocIDSuite) TestID(c *C) {
 	wg.Wait()
 }
 
+func (s *testAllocIDSuite) TestConcurrentAlloc(c *C) {
+	mustGetLeader(c, s.client, s.svr.getLeaderPath())
+
+	var (
+		wg  sync.WaitGroup
+		m   sync.Mutex
+		ids = make(map[uint64]struct{})
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// IDs allocated by one goroutine must be increasing.
+			var last uint64
+			for i := 0; i < 500; i++ {
+				id, err := s.alloc.Alloc()
+				c.Assert(err, IsNil)
+				c.Assert(id, Greater, last)
+				last = id
+				m.Lock()
+				_, ok := ids[id]
+				ids[id] = struct{}{}
+				m.Unlock()
+				c.Assert(ok, IsFalse)
+			}
+		}()
+	}
+
+	wg.Wait()
+	c.Assert(ids, HasLen, 5000)
+
+	max, err := s.alloc.getMax()
+	c.Assert(err, IsNil)
+	for id := range ids {
+		c.Assert(id <= max, IsTrue)
+	}
+}
+
+func (s *testAllocIDSuite) TestReset(c *C) {
+	mustGetLeader(c, s.client, s.svr.getLeaderPath())
+
+	_, err := s.alloc.Alloc()
+	c.Assert(err, IsNil)
+	max, err := s.alloc.getMax()
+	c.Assert(err, IsNil)
+
+	// After reset, we must allocate from the persisted high-water mark.
+	s.alloc.reset()
+	id, err := s.alloc.Alloc()
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, max+1)
+
+	newMax, err := s.alloc.getMax()
+	c.Assert(err, IsNil)
+	c.Assert(newMax, Equals, max+s.svr.cfg.IDAllocStep)
+}
+
 func (s *testAllocIDSuite) TestCommand(c *C) {
 	leader := mustGetLeader(c, s.client, s.svr.getLeaderPath())
 
@@ -96,7 +155,7 @@ func (s *testAllocIDSuite) TestCommand(c *C) {
 	}
 
 	var last uint64
-	for i := uint64(0); i < 2*allocStep; i++ {
+	for i := uint64(0); i < 2*s.svr.cfg.IDAllocStep; i++ {
 		rawMsgID := uint64(rand.Int63())
 		sendRequest(c, conn, rawMsgID, req)
 		msgID, resp := recvResponse(c, conn)
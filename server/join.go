@@ -30,12 +30,17 @@ import (
 const defaultDialTimeout = 30 * time.Second
 
 // TODO: support HTTPS
-func genClientV3Config(cfg *Config) clientv3.Config {
+func genClientV3Config(cfg *Config) (clientv3.Config, error) {
 	endpoints := strings.Split(cfg.Join, ",")
+	tlsCfg, err := cfg.genClientTLSConfig()
+	if err != nil {
+		return clientv3.Config{}, errors.Trace(err)
+	}
 	return clientv3.Config{
 		Endpoints:   endpoints,
 		DialTimeout: defaultDialTimeout,
-	}
+		TLS:         tlsCfg,
+	}, nil
 }
 
 func memberAdd(client *clientv3.Client, urls []string) (*clientv3.MemberAddResponse, error) {
@@ -118,7 +123,11 @@ func (cfg *Config) prepareJoinCluster() (string, string, error) {
 		return initialCluster, embed.ClusterStateFlagNew, nil
 	}
 
-	client, err := clientv3.New(genClientV3Config(cfg))
+	clientCfg, err := genClientV3Config(cfg)
+	if err != nil {
+		return "", "", errors.Trace(err)
+	}
+	client, err := clientv3.New(clientCfg)
 	if err != nil {
 		return "", "", errors.Trace(err)
 	}
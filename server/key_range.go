@@ -0,0 +1,114 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/juju/errors"
+)
+
+// KeyRange is the key range [StartKey, EndKey), an empty EndKey means no
+// upper bound. It is embedded in the configs which apply to a key range,
+// like the placement rules, and a region belongs to the range if its start
+// key is in the range.
+type KeyRange struct {
+	StartKey []byte `json:"start_key"`
+	EndKey   []byte `json:"end_key"`
+}
+
+func (r *KeyRange) String() string {
+	return fmt.Sprintf("[%x, %x)", r.StartKey, r.EndKey)
+}
+
+func (r *KeyRange) containsKey(key []byte) bool {
+	return bytes.Compare(key, r.StartKey) >= 0 && (len(r.EndKey) == 0 || bytes.Compare(key, r.EndKey) < 0)
+}
+
+// validate checks the start key is less than the end key.
+func (r *KeyRange) validate() error {
+	if len(r.EndKey) > 0 && bytes.Compare(r.StartKey, r.EndKey) >= 0 {
+		return errors.Errorf("start key %x is not less than end key %x", r.StartKey, r.EndKey)
+	}
+	return nil
+}
+
+// findOverlappingRanges returns two of the ranges which overlap, or nil if
+// no range overlaps with another.
+func findOverlappingRanges(ranges []*KeyRange) (*KeyRange, *KeyRange) {
+	sorted := make([]*KeyRange, 0, len(ranges))
+	sorted = append(sorted, ranges...)
+	sort.Sort(keyRangesByKey(sorted))
+	for i := 1; i < len(sorted); i++ {
+		prev, r := sorted[i-1], sorted[i]
+		if len(prev.EndKey) == 0 || bytes.Compare(prev.EndKey, r.StartKey) > 0 {
+			return prev, r
+		}
+	}
+	return nil, nil
+}
+
+type keyRangesByKey []*KeyRange
+
+func (s keyRangesByKey) Len() int {
+	return len(s)
+}
+
+func (s keyRangesByKey) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s keyRangesByKey) Less(i, j int) bool {
+	return bytes.Compare(s[i].StartKey, s[j].StartKey) < 0
+}
+
+// saveRangeConfigs saves the configs of the key ranges, which is a slice
+// of n items like the placement rules, in JSON to key. The key is deleted
+// if there is no item. name is the name of the configs in the error.
+func (c *RaftCluster) saveRangeConfigs(key string, configs interface{}, n int, name string) error {
+	op := clientv3.OpDelete(key)
+	if n > 0 {
+		value, err := json.Marshal(configs)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		op = clientv3.OpPut(key, string(value))
+	}
+
+	resp, err := c.s.leaderTxn().Then(op).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Errorf("save %s failed, maybe we lost leader", name)
+	}
+	return nil
+}
+
+// loadRangeConfigs loads the configs saved by saveRangeConfigs into
+// configs, which is unchanged if the key doesn't exist.
+func (c *RaftCluster) loadRangeConfigs(key string, configs interface{}) error {
+	value, err := getValue(c.s.client, key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if value == nil {
+		return nil
+	}
+	return errors.Trace(json.Unmarshal(value, configs))
+}
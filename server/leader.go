@@ -20,7 +20,9 @@ import (
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/golang/protobuf/proto"
 	"github.com/juju/errors"
 	"github.com/ngaut/log"
@@ -28,13 +30,54 @@ import (
 	"golang.org/x/net/context"
 )
 
-const checkEtcdLeaderInterval = time.Second
+const (
+	checkEtcdLeaderInterval = time.Second
+	// nextLeaderTTL is the TTL (seconds) of the next leader key, if the next
+	// leader can't become leader in time, other servers can campaign again.
+	nextLeaderTTL = int64(10)
+)
+
+var (
+	// ErrNotLeader is returned when the server is not the pd leader.
+	ErrNotLeader = errors.New("server is not leader")
+	// ErrNoHealthyMember is returned when no healthy member can become the new leader.
+	ErrNoHealthyMember = errors.New("no healthy member to be the new leader")
+	// ErrMemberNotFound is returned when the member is not in the cluster.
+	ErrMemberNotFound = errors.New("member not found")
+	// ErrMemberIsLeader is returned when transferring the leader to the leader itself.
+	ErrMemberIsLeader = errors.New("member is already the leader")
+	// ErrMemberUnhealthy is returned when the member to be the new leader is unhealthy.
+	ErrMemberUnhealthy = errors.New("member is unhealthy")
+)
 
 // isLeader returns whether server is leader or not.
 func (s *Server) isLeader() bool {
 	return atomic.LoadInt64(&s.isLeaderValue) == 1
 }
 
+// IsLeader returns whether the server is the pd leader.
+func (s *Server) IsLeader() bool {
+	return s.isLeader()
+}
+
+// ConfirmLeader confirms the server is still the leader by a txn comparing
+// the leader key, etcd serves it after its read index is applied, so the
+// state written by any previous leader is seen by the reads after it.
+func (s *Server) ConfirmLeader() error {
+	if !s.isLeader() {
+		return errors.Trace(ErrNotLeader)
+	}
+
+	resp, err := s.leaderTxn().Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Trace(ErrNotLeader)
+	}
+	return nil
+}
+
 func (s *Server) enableLeader(b bool) {
 	value := int64(0)
 	if b {
@@ -57,6 +100,21 @@ func (s *Server) getLeaderPath() string {
 	return path.Join(s.rootPath, "leader")
 }
 
+// getNextLeaderPath returns the key which saves the name of the member
+// which should be the next leader after the leader resigns.
+func (s *Server) getNextLeaderPath() string {
+	return path.Join(s.rootPath, "next_leader")
+}
+
+func (s *Server) getNextLeader() (string, error) {
+	value, err := getValue(s.client, s.getNextLeaderPath())
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	return string(value), nil
+}
+
 func (s *Server) leaderLoop() {
 	defer s.wg.Done()
 
@@ -89,6 +147,24 @@ func (s *Server) leaderLoop() {
 			}
 		}
 
+		nextLeader, err := s.getNextLeader()
+		if err != nil {
+			log.Errorf("get next leader err %v", err)
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+		if len(nextLeader) > 0 && nextLeader != s.Name() {
+			// the previous leader resigned and chose another server, wait for it.
+			log.Infof("next leader is %s, wait for it", nextLeader)
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+		if nextLeader == s.Name() && !s.isEtcdLeader() {
+			if err = s.campaignEtcdLeader(); err != nil {
+				log.Errorf("campaign etcd leader err %v", err)
+			}
+		}
+
 		if !s.isEtcdLeader() {
 			// we should put pd leader and etcd leader together
 			log.Infof("pd's etcd %s is not leader, leader is %s", s.etcd.Server.ID(), s.etcd.Server.Leader())
@@ -177,8 +253,22 @@ func (s *Server) campaignLeader() error {
 	}
 
 	log.Debugf("campaign leader ok %s", s.Name())
+	// Other leaders may allocate IDs after our last term.
+	s.idAlloc.reset()
 	s.enableLeader(true)
 	defer s.enableLeader(false)
+	leaderChangesCounter.Inc()
+
+	// Drop the resign request for the previous term.
+	select {
+	case <-s.resignCh:
+	default:
+	}
+
+	// We are the leader now, the next leader key is useless.
+	if _, err = s.leaderTxn().Then(clientv3.OpDelete(s.getNextLeaderPath())).Commit(); err != nil {
+		return errors.Trace(err)
+	}
 
 	// Try to create raft cluster.
 	err = s.createRaftCluster()
@@ -197,7 +287,7 @@ func (s *Server) campaignLeader() error {
 		return errors.Trace(err)
 	}
 
-	tsTicker := time.NewTicker(time.Duration(updateTimestampStep) * time.Millisecond)
+	tsTicker := time.NewTicker(time.Duration(s.cfg.TsoUpdatePhysicalInterval) * time.Millisecond)
 	defer tsTicker.Stop()
 
 	leaderTicker := time.NewTicker(checkEtcdLeaderInterval)
@@ -218,6 +308,9 @@ func (s *Server) campaignLeader() error {
 			if !s.isEtcdLeader() {
 				return errors.New("current etcd member is not leader")
 			}
+		case <-s.resignCh:
+			log.Infof("%s resigns leader", s.Name())
+			return errors.Trace(s.resignLeader())
 		case <-s.client.Ctx().Done():
 			return errors.New("server closed")
 		}
@@ -267,6 +360,148 @@ func (s *Server) resignLeader() error {
 	return nil
 }
 
+// ResignLeader makes the leader step down and lets the healthy member with
+// the highest leader priority become the new leader. If no other member is
+// healthy, the leader is kept.
+func (s *Server) ResignLeader() error {
+	if !s.isLeader() {
+		return errors.Trace(ErrNotLeader)
+	}
+
+	candidates, err := s.leaderCandidates()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, c := range candidates {
+		if !s.isMemberHealthy(c.member) {
+			continue
+		}
+		return errors.Trace(s.transferLeader(c.member.Name))
+	}
+
+	return errors.Trace(ErrNoHealthyMember)
+}
+
+// TransferLeader makes the member with the name become the new leader.
+func (s *Server) TransferLeader(name string) error {
+	if !s.isLeader() {
+		return errors.Trace(ErrNotLeader)
+	}
+
+	ctx, cancel := context.WithTimeout(s.client.Ctx(), requestTimeout)
+	defer cancel()
+	listResp, err := s.client.MemberList(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, m := range listResp.Members {
+		if m.Name != name {
+			continue
+		}
+		if name == s.Name() {
+			return errors.Trace(ErrMemberIsLeader)
+		}
+		if !s.isMemberHealthy(m) {
+			return errors.Trace(ErrMemberUnhealthy)
+		}
+		return errors.Trace(s.transferLeader(name))
+	}
+
+	return errors.Trace(ErrMemberNotFound)
+}
+
+// transferLeader saves the next leader name and lets the leader resign,
+// then the next leader can campaign the etcd leader and pd leader.
+func (s *Server) transferLeader(name string) error {
+	lessor := clientv3.NewLease(s.client)
+	defer lessor.Close()
+
+	ctx, cancel := context.WithTimeout(s.client.Ctx(), requestTimeout)
+	leaseResp, err := lessor.Grant(ctx, nextLeaderTTL)
+	cancel()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	resp, err := s.leaderTxn().
+		Then(clientv3.OpPut(s.getNextLeaderPath(), name, clientv3.WithLease(clientv3.LeaseID(leaseResp.ID)))).
+		Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.Trace(ErrNotLeader)
+	}
+
+	log.Infof("%s transfers leader to %s", s.Name(), name)
+	select {
+	case s.resignCh <- struct{}{}:
+	default:
+		// A resign request is in progress.
+	}
+
+	return nil
+}
+
+// isMemberHealthy checks whether the member is reachable.
+func (s *Server) isMemberHealthy(m *etcdserverpb.Member) bool {
+	for _, url := range m.ClientURLs {
+		if err := s.checkEndpointHealth(url); err != nil {
+			log.Warnf("member %s %s is unhealthy: %v", m.Name, url, err)
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+func (s *Server) checkEndpointHealth(endpoint string) error {
+	tlsCfg, err := s.cfg.genClientTLSConfig()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// The client can only dial the endpoints in its config,
+	// so we use a new client here.
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: etcdTimeout,
+		TLS:         tlsCfg,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(client.Ctx(), etcdTimeout)
+	defer cancel()
+	_, err = client.Status(ctx, endpoint)
+	return errors.Trace(err)
+}
+
+// campaignEtcdLeader lets the etcd leader transfer leadership to us, using
+// the same message the etcd leader sends when it transfers leadership.
+func (s *Server) campaignEtcdLeader() error {
+	leaderID := uint64(s.etcd.Server.Leader())
+	if leaderID == 0 {
+		return errors.New("etcd has no leader now")
+	}
+
+	msg := raftpb.Message{
+		Type: raftpb.MsgTimeoutNow,
+		From: leaderID,
+		To:   uint64(s.etcd.Server.ID()),
+		Term: s.etcd.Server.Term(),
+	}
+
+	ctx, cancel := context.WithTimeout(s.client.Ctx(), requestTimeout)
+	defer cancel()
+	return errors.Trace(s.etcd.Server.Process(ctx, msg))
+}
+
 func (s *Server) leaderCmp() clientv3.Cmp {
 	return clientv3.Compare(clientv3.Value(s.getLeaderPath()), "=", s.leaderValue)
 }
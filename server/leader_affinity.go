@@ -0,0 +1,226 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+var (
+	// ErrInvalidLeaderAffinity is returned when the leader affinity has an
+	// invalid range or no label.
+	ErrInvalidLeaderAffinity = errors.New("invalid leader affinity")
+	// ErrOverlappingLeaderAffinities is returned when the ranges of the
+	// leader affinities overlap.
+	ErrOverlappingLeaderAffinities = errors.New("leader affinities overlap")
+)
+
+// maxAffinityScanRegions is the max count of the regions in the range of
+// a leader affinity which are checked in a balance.
+const maxAffinityScanRegions = 1000
+
+// LeaderAffinity prefers the leaders of the regions in the key range to be
+// on the stores with all the labels, e.g, the stores close to the clients
+// in Location.
+type LeaderAffinity struct {
+	Location string `json:"location"`
+	KeyRange
+	Labels map[string]string `json:"labels"`
+}
+
+// matchStore checks whether the store has all the labels of the affinity.
+func (a *LeaderAffinity) matchStore(store *storeInfo) bool {
+	return matchStoreLabels(store, a.Labels)
+}
+
+// validateLeaderAffinities checks the affinities, the ranges of the
+// affinities must not overlap, otherwise a region may prefer two locations.
+func validateLeaderAffinities(affinities []*LeaderAffinity) error {
+	ranges := make([]*KeyRange, 0, len(affinities))
+	for _, a := range affinities {
+		if len(a.Labels) == 0 {
+			return errors.Annotatef(ErrInvalidLeaderAffinity, "affinity %s has no label", &a.KeyRange)
+		}
+		if err := validateStoreLabels(a.Labels); err != nil {
+			return errors.Annotatef(ErrInvalidLeaderAffinity, "affinity %s has invalid label: %v", &a.KeyRange, err)
+		}
+		if err := a.validate(); err != nil {
+			return errors.Annotatef(ErrInvalidLeaderAffinity, "affinity %v", err)
+		}
+		ranges = append(ranges, &a.KeyRange)
+	}
+
+	if prev, r := findOverlappingRanges(ranges); prev != nil {
+		return errors.Annotatef(ErrOverlappingLeaderAffinities, "affinity %s overlaps with affinity %s", prev, r)
+	}
+	return nil
+}
+
+// getRegionLeaderAffinity returns the affinity whose range contains the
+// region, or nil if there is none.
+func (c *clusterInfo) getRegionLeaderAffinity(region *metapb.Region) *LeaderAffinity {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, a := range c.affinities {
+		if a.containsKey(region.GetStartKey()) {
+			return a
+		}
+	}
+	return nil
+}
+
+// removeAffinityFollowers removes the followers not on the preferred stores
+// if the leader is on them, so the leader balancer doesn't move the leaders
+// back from where the leader affinity moved them.
+func removeAffinityFollowers(cluster *clusterInfo, region *metapb.Region, leaderStore *storeInfo, followers map[uint64]*metapb.Peer) {
+	affinity := cluster.getRegionLeaderAffinity(region)
+	if affinity == nil || !affinity.matchStore(leaderStore) {
+		return
+	}
+	for id := range followers {
+		if store := cluster.getStore(id); store == nil || !affinity.matchStore(store) {
+			delete(followers, id)
+		}
+	}
+}
+
+func (c *clusterInfo) setLeaderAffinities(affinities []*LeaderAffinity) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.affinities = affinities
+}
+
+func (c *clusterInfo) getLeaderAffinities() []*LeaderAffinity {
+	c.RLock()
+	defer c.RUnlock()
+
+	affinities := make([]*LeaderAffinity, 0, len(c.affinities))
+	return append(affinities, c.affinities...)
+}
+
+// leaderAffinityBalancer moves the leaders of the regions toward the stores
+// preferred by the leader affinities. It is a soft preference, the leader
+// is only moved if the leaders of the stores stay balanced.
+type leaderAffinityBalancer struct {
+	filters []Filter
+
+	cfg *BalanceConfig
+}
+
+func newLeaderAffinityBalancer(cfg *BalanceConfig) *leaderAffinityBalancer {
+	ab := &leaderAffinityBalancer{cfg: cfg}
+	ab.filters = append(ab.filters, newStateFilter(cfg))
+	return ab
+}
+
+func (ab *leaderAffinityBalancer) ScoreType() scoreType {
+	return leaderScore
+}
+
+func (ab *leaderAffinityBalancer) GetName() string {
+	return "leader-affinity"
+}
+
+// Balance transfers the leader of a region in the range of a random
+// affinity to a follower on the preferred stores.
+func (ab *leaderAffinityBalancer) Balance(cluster *clusterInfo) (*score, *balanceOperator, error) {
+	affinities := cluster.getLeaderAffinities()
+	if len(affinities) == 0 {
+		return nil, nil, nil
+	}
+
+	affinity := affinities[cluster.regions.rand.Intn(len(affinities))]
+	regions, _ := cluster.regions.scanRangeRegions(affinity.StartKey, affinity.EndKey, maxAffinityScanRegions)
+	for _, region := range regions {
+		// The first region may contain the start key, it isn't in the
+		// range since its start key is before the range.
+		if !affinity.containsKey(region.GetStartKey()) {
+			continue
+		}
+
+		region, leader := cluster.regions.getRegionByID(region.GetId())
+		if region == nil || leader == nil {
+			continue
+		}
+		score, newLeader := ab.selectNewLeader(cluster, affinity, region, leader)
+		if newLeader == nil {
+			continue
+		}
+
+		transferLeaderOperator := newTransferLeaderOperator(region.GetId(), leader, newLeader, ab.cfg)
+		return score, newBalanceOperator(region, transferLeaderOperator), nil
+	}
+
+	log.Debugf("no region of affinity %s can move the leader to the preferred stores", affinity.Location)
+	return nil, nil, nil
+}
+
+// selectNewLeader selects the follower on the preferred stores with the
+// lowest leader score, it returns nil if the leader is on the preferred
+// stores already or the move unbalances the leaders.
+func (ab *leaderAffinityBalancer) selectNewLeader(cluster *clusterInfo, affinity *LeaderAffinity, region *metapb.Region, leader *metapb.Peer) (*score, *metapb.Peer) {
+	leaderStore := cluster.getStore(leader.GetStoreId())
+	if leaderStore == nil || affinity.matchStore(leaderStore) {
+		return nil, nil
+	}
+
+	followers := getFollowerPeers(region, leader)
+	stores := make([]*storeInfo, 0, len(followers))
+	for storeID := range followers {
+		if store := cluster.getStore(storeID); store != nil && affinity.matchStore(store) {
+			stores = append(stores, store)
+		}
+	}
+	store := selectToStore(stores, getEvictingLeaderStores(stores), ab.filters, leaderScore)
+	if store == nil {
+		return nil, nil
+	}
+
+	// The move must not unbalance the leaders, i.e, the new store mustn't
+	// have more leaders than the old one beyond the tolerance after it,
+	// which is checked like checkAndGetDiffScore.
+	from, to := leaderScoreAfter(leaderStore, -1), leaderScoreAfter(store, 1)
+	if to-from > int(float64(to)*ab.cfg.MaxDiffScoreFraction) {
+		return nil, nil
+	}
+
+	// The affinity is a soft preference, it has the min priority if the
+	// new store has more leaders.
+	diff := from - to
+	if diff < 1 {
+		diff = 1
+	}
+	score := &score{
+		from:      from,
+		to:        to,
+		diff:      diff,
+		threshold: noThreshold,
+		st:        leaderScore,
+	}
+	return score, followers[store.store.GetId()]
+}
+
+// leaderScoreAfter returns the leader score of the store after its leader
+// count changes by delta.
+func leaderScoreAfter(store *storeInfo, delta int) int {
+	if store.stats.TotalRegionCount == 0 {
+		return 0
+	}
+	ratio := float64(store.stats.LeaderRegionCount+delta) / float64(store.stats.TotalRegionCount)
+	return int(ratio / store.leaderWeight() * 100)
+}
@@ -0,0 +1,243 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"golang.org/x/net/context"
+)
+
+// checkLeaderPriorityInterval is the interval for the leader to check
+// whether a healthy member has a higher leader priority.
+const checkLeaderPriorityInterval = 3 * time.Second
+
+func (s *Server) getLeaderPriorityPath(name string) string {
+	return path.Join(s.rootPath, "member", name, "leader_priority")
+}
+
+// GetMemberLeaderPriority returns the leader priority of the member, it is
+// 0 if the member has no priority saved.
+func (s *Server) GetMemberLeaderPriority(name string) (int, error) {
+	if err := s.checkMember(name); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return s.getLeaderPriority(name)
+}
+
+// SetMemberLeaderPriority changes the leader priority of the member, the
+// priority is kept after the member restarts.
+func (s *Server) SetMemberLeaderPriority(name string, priority int) error {
+	if err := s.checkMember(name); err != nil {
+		return errors.Trace(err)
+	}
+
+	op := clientv3.OpPut(s.getLeaderPriorityPath(name), strconv.Itoa(priority))
+	if _, err := s.txn().Then(op).Commit(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Infof("leader priority of %s is changed to %d", name, priority)
+	return nil
+}
+
+// DeleteMemberLeaderPriority deletes the leader priority of the member, it
+// is called after the member is removed, so a new member with the same
+// name doesn't inherit the priority.
+func (s *Server) DeleteMemberLeaderPriority(name string) error {
+	op := clientv3.OpDelete(s.getLeaderPriorityPath(name))
+	if _, err := s.txn().Then(op).Commit(); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (s *Server) getLeaderPriority(name string) (int, error) {
+	value, err := getValue(s.client, s.getLeaderPriorityPath(name))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if value == nil {
+		return 0, nil
+	}
+
+	priority, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return priority, nil
+}
+
+// checkMember returns ErrMemberNotFound if the member is not in the cluster.
+func (s *Server) checkMember(name string) error {
+	members, err := s.listMembers()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, m := range members {
+		if m.Name == name {
+			return nil
+		}
+	}
+	return errors.Trace(ErrMemberNotFound)
+}
+
+// initLeaderPriority saves the leader priority in the config if the member
+// has no priority saved, the priority changed by the API is not overridden.
+func (s *Server) initLeaderPriority() error {
+	key := s.getLeaderPriorityPath(s.Name())
+	_, err := s.txn().
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, strconv.Itoa(s.cfg.LeaderPriority))).
+		Commit()
+	return errors.Trace(err)
+}
+
+// leaderPriorityLoop transfers the leader to the healthy member which has
+// a higher leader priority than the leader.
+func (s *Server) leaderPriorityLoop() {
+	defer s.wg.Done()
+
+	ctx := s.client.Ctx()
+	for {
+		if err := s.initLeaderPriority(); err != nil {
+			log.Errorf("init leader priority err %v", err)
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
+			// server closed, return
+			return
+		}
+	}
+
+	ticker := time.NewTicker(checkLeaderPriorityInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !s.isLeader() {
+				continue
+			}
+			if err := s.checkLeaderPriority(); err != nil {
+				log.Errorf("check leader priority err %v", errors.ErrorStack(err))
+			}
+		case <-ctx.Done():
+			// server closed, return
+			return
+		}
+	}
+}
+
+// checkLeaderPriority transfers the leader to the first healthy member in
+// the priority order which is preferred to the leader, see preferredTo.
+func (s *Server) checkLeaderPriority() error {
+	candidates, err := s.leaderCandidates()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	priority, err := s.getLeaderPriority(s.Name())
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, c := range candidates {
+		if !c.preferredTo(s.Name(), priority) {
+			return nil
+		}
+		if !s.isMemberHealthy(c.member) {
+			continue
+		}
+		log.Infof("%s with leader priority %d is preferred to %s %d", c.member.Name, c.priority, s.Name(), priority)
+		return errors.Trace(s.transferLeader(c.member.Name))
+	}
+	return nil
+}
+
+type leaderCandidate struct {
+	member   *etcdserverpb.Member
+	priority int
+}
+
+// preferredTo returns whether the candidate is preferred to be the leader
+// to the member with the name and priority. The one with the higher
+// priority is preferred, and the ties break by the name. The priority 0
+// means no preference, the members with it don't take the leader from each
+// other.
+func (c *leaderCandidate) preferredTo(name string, priority int) bool {
+	if c.priority != priority {
+		return c.priority > priority
+	}
+	return priority != 0 && c.member.Name < name
+}
+
+// leaderCandidates returns the other members ordered by the leader priority
+// descendingly, the members with the same priority are ordered by the name.
+func (s *Server) leaderCandidates() ([]*leaderCandidate, error) {
+	members, err := s.listMembers()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	candidates := make([]*leaderCandidate, 0, len(members))
+	for _, m := range members {
+		if m.Name == s.Name() {
+			continue
+		}
+		priority, err := s.getLeaderPriority(m.Name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		candidates = append(candidates, &leaderCandidate{member: m, priority: priority})
+	}
+	sort.Sort(leaderCandidates(candidates))
+	return candidates, nil
+}
+
+func (s *Server) listMembers() ([]*etcdserverpb.Member, error) {
+	ctx, cancel := context.WithTimeout(s.client.Ctx(), requestTimeout)
+	defer cancel()
+	listResp, err := s.client.MemberList(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return listResp.Members, nil
+}
+
+type leaderCandidates []*leaderCandidate
+
+func (s leaderCandidates) Len() int {
+	return len(s)
+}
+
+func (s leaderCandidates) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s leaderCandidates) Less(i, j int) bool {
+	if s[i].priority != s[j].priority {
+		return s[i].priority > s[j].priority
+	}
+	return s[i].member.Name < s[j].member.Name
+}
@@ -14,6 +14,8 @@
 package server
 
 import (
+	"sync/atomic"
+
 	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -88,8 +90,96 @@ var (
 			Help:      "Bucketed histogram of processing time (s) of handled txns.",
 			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 13),
 		}, []string{"result"})
+
+	leaderChangesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "pd",
+			Name:      "leader_changes_total",
+			Help:      "Counter of the times this server becomes leader.",
+		})
+
+	clusterStoresGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "pd",
+			Subsystem: "cluster",
+			Name:      "stores_total",
+			Help:      "Total number of stores in the cluster.",
+		})
+
+	clusterRegionsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "pd",
+			Subsystem: "cluster",
+			Name:      "regions_total",
+			Help:      "Total number of regions in the cluster.",
+		})
+
+	storeRegionsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd",
+			Subsystem: "cluster",
+			Name:      "store_regions",
+			Help:      "Number of regions in the store.",
+		}, []string{"store"})
+
+	storeLeadersGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd",
+			Subsystem: "cluster",
+			Name:      "store_leaders",
+			Help:      "Number of region leaders in the store.",
+		}, []string{"store"})
+
+	clusterMetrics = &clusterCollector{
+		collectors: []prometheus.Collector{
+			clusterStoresGauge,
+			clusterRegionsGauge,
+			storeRegionsGauge,
+			storeLeadersGauge,
+		},
+	}
 )
 
+// clusterCollector only reports the cluster metrics when it is enabled by
+// the leader, so the followers don't report the stale cluster metrics.
+// The metrics are updated on the heartbeats and collecting them doesn't
+// need the cluster lock.
+type clusterCollector struct {
+	enabled    int32
+	collectors []prometheus.Collector
+}
+
+func (c *clusterCollector) enable(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&c.enabled, 1)
+		return
+	}
+
+	atomic.StoreInt32(&c.enabled, 0)
+	clusterStoresGauge.Set(0)
+	clusterRegionsGauge.Set(0)
+	storeRegionsGauge.Reset()
+	storeLeadersGauge.Reset()
+}
+
+// Describe implements prometheus.Collector interface.
+func (c *clusterCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector interface.
+func (c *clusterCollector) Collect(ch chan<- prometheus.Metric) {
+	if atomic.LoadInt32(&c.enabled) == 0 {
+		return
+	}
+
+	for _, collector := range c.collectors {
+		collector.Collect(ch)
+	}
+}
+
 func init() {
 	prometheus.MustRegister(cmdCounter)
 	prometheus.MustRegister(cmdFailedCounter)
@@ -98,4 +188,6 @@ func init() {
 	prometheus.MustRegister(balancerCounter)
 	prometheus.MustRegister(txnCounter)
 	prometheus.MustRegister(txnDuration)
+	prometheus.MustRegister(leaderChangesCounter)
+	prometheus.MustRegister(clusterMetrics)
 }
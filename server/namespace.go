@@ -0,0 +1,139 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/juju/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+var (
+	// ErrInvalidNamespace is returned when the namespace has no name, no
+	// range, no store or an invalid range.
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	// ErrOverlappingNamespaces is returned when the ranges of the namespaces
+	// overlap.
+	ErrOverlappingNamespaces = errors.New("namespace ranges overlap")
+)
+
+// Namespace is a logical dataset in the cluster, the peers of the regions
+// in its ranges are only placed on its stores. A region belongs to the
+// namespace if its start key is in one of the ranges, the regions which
+// belong to no namespace can be placed on any store.
+//
+// The schedule limits bound the balance operators of the namespace, zero
+// means only the limits of the cluster are applied.
+type Namespace struct {
+	Name                string      `json:"name"`
+	Ranges              []*KeyRange `json:"ranges"`
+	StoreIDs            []uint64    `json:"store_ids"`
+	LeaderScheduleLimit uint64      `json:"leader_schedule_limit"`
+	RegionScheduleLimit uint64      `json:"region_schedule_limit"`
+}
+
+func (ns *Namespace) containsKey(key []byte) bool {
+	for _, r := range ns.Ranges {
+		if r.containsKey(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ns *Namespace) hasStore(storeID uint64) bool {
+	for _, id := range ns.StoreIDs {
+		if id == storeID {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNamespaces checks the namespaces, the names must be unique and
+// the ranges of all the namespaces must not overlap, otherwise a region
+// may belong to more than one namespace.
+func validateNamespaces(namespaces []*Namespace) error {
+	names := make(map[string]struct{}, len(namespaces))
+	var ranges []*KeyRange
+	for _, ns := range namespaces {
+		if len(ns.Name) == 0 {
+			return errors.Annotate(ErrInvalidNamespace, "namespace has no name")
+		}
+		if _, ok := names[ns.Name]; ok {
+			return errors.Annotatef(ErrInvalidNamespace, "duplicate namespace %s", ns.Name)
+		}
+		names[ns.Name] = struct{}{}
+		if len(ns.Ranges) == 0 {
+			return errors.Annotatef(ErrInvalidNamespace, "namespace %s has no range", ns.Name)
+		}
+		if len(ns.StoreIDs) == 0 {
+			return errors.Annotatef(ErrInvalidNamespace, "namespace %s has no store", ns.Name)
+		}
+		for _, r := range ns.Ranges {
+			if err := r.validate(); err != nil {
+				return errors.Annotatef(ErrInvalidNamespace, "namespace %s %v", ns.Name, err)
+			}
+			ranges = append(ranges, r)
+		}
+	}
+
+	if prev, r := findOverlappingRanges(ranges); prev != nil {
+		return errors.Annotatef(ErrOverlappingNamespaces, "range %s overlaps with range %s", prev, r)
+	}
+	return nil
+}
+
+func (c *clusterInfo) setNamespaces(namespaces []*Namespace) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.namespaces = namespaces
+}
+
+func (c *clusterInfo) getNamespaces() []*Namespace {
+	c.RLock()
+	defer c.RUnlock()
+
+	namespaces := make([]*Namespace, 0, len(c.namespaces))
+	return append(namespaces, c.namespaces...)
+}
+
+// getNamespace returns the namespace which the region belongs to, or nil
+// if the region belongs to no namespace.
+func (c *clusterInfo) getNamespace(region *metapb.Region) *Namespace {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, ns := range c.namespaces {
+		if ns.containsKey(region.GetStartKey()) {
+			return ns
+		}
+	}
+	return nil
+}
+
+// excludeNamespaceStores adds the stores which are not in the namespace of
+// the region to excluded.
+func excludeNamespaceStores(cluster *clusterInfo, stores []*storeInfo, region *metapb.Region, excluded map[uint64]struct{}) {
+	ns := cluster.getNamespace(region)
+	if ns == nil {
+		return
+	}
+
+	for _, store := range stores {
+		if !ns.hasStore(store.store.GetId()) {
+			excluded[store.store.GetId()] = struct{}{}
+		}
+	}
+}
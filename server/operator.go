@@ -15,9 +15,11 @@ package server
 
 import (
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/juju/errors"
 	"github.com/ngaut/log"
 	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
@@ -32,6 +34,9 @@ type callback func(op Operator)
 type opContext struct {
 	start callback
 	end   callback
+
+	// downPeers are the peers reported down in the region heartbeat.
+	downPeers []*pdpb.PeerStats
 }
 
 func newOpContext(start callback, end callback) *opContext {
@@ -53,28 +58,62 @@ type Operator interface {
 	Do(ctx *opContext, region *metapb.Region, leader *metapb.Peer) (bool, *pdpb.RegionHeartbeatResponse, error)
 }
 
+// retryableOperator is the operator which sends its command only once, it
+// sends the command again on the next heartbeat after retry. The other
+// operators send their commands on every heartbeat until they finish.
+type retryableOperator interface {
+	retry()
+}
+
+// OperatorOutcome is the outcome of an operator, an operator is running
+// until it is removed with one of the other outcomes.
+type OperatorOutcome string
+
+// The outcomes of the operators.
+const (
+	OperatorRunning    OperatorOutcome = "running"
+	OperatorSuccess    OperatorOutcome = "success"
+	OperatorFailed     OperatorOutcome = "failed"
+	OperatorCanceled   OperatorOutcome = "canceled"
+	OperatorTimeout    OperatorOutcome = "timeout"
+	OperatorSuperseded OperatorOutcome = "superseded"
+)
+
 // balanceOperator is used to do region balance.
 type balanceOperator struct {
-	ID       uint64         `json:"id"`
-	Index    int            `json:"index"`
-	Start    time.Time      `json:"start"`
-	End      time.Time      `json:"end"`
-	Finished bool           `json:"finished"`
-	Ops      []Operator     `json:"operators"`
-	Region   *metapb.Region `json:"region"`
+	ID       uint64          `json:"id"`
+	Index    int             `json:"index"`
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	Finished bool            `json:"finished"`
+	Status   OperatorOutcome `json:"status"`
+	Reason   string          `json:"reason,omitempty"`
+	Ops      []Operator      `json:"operators"`
+	Region   *metapb.Region  `json:"region"`
+	// StepStart is when the current step is dispatched, it is zero until
+	// the region reports a heartbeat, and it restarts when the step is
+	// retried. StepRetries is the count of the times the current step
+	// timed out and is dispatched again.
+	StepStart   time.Time `json:"step_start"`
+	StepRetries int       `json:"step_retries"`
+
+	// stepLock guards the steps, which are done by the region heartbeats
+	// and retried by the balancer worker.
+	stepLock sync.Mutex
 }
 
 func newBalanceOperator(region *metapb.Region, ops ...Operator) *balanceOperator {
 	return &balanceOperator{
 		ID:     atomic.AddUint64(&baseID, 1),
+		Status: OperatorRunning,
 		Ops:    ops,
 		Region: region,
 	}
 }
 
 func (bo *balanceOperator) String() string {
-	ret := fmt.Sprintf("[balanceOperator]id: %d, index: %d, start: %s, end: %s, finished: %v, region: %v, ops:",
-		bo.ID, bo.Index, bo.Start, bo.End, bo.Finished, bo.Region)
+	ret := fmt.Sprintf("[balanceOperator]id: %d, index: %d, start: %s, end: %s, finished: %v, status: %s, reason: %s, region: %v, ops:",
+		bo.ID, bo.Index, bo.Start, bo.End, bo.Finished, bo.Status, bo.Reason, bo.Region)
 
 	for i := range bo.Ops {
 		ret += fmt.Sprintf(" [%d - %v] ", i, bo.Ops[i])
@@ -92,7 +131,7 @@ func (bo *balanceOperator) check(region *metapb.Region, leader *metapb.Peer) (bo
 
 	err := checkStaleRegion(bo.Region, region)
 	if err != nil {
-		return false, errors.Trace(err)
+		return false, errors.Annotate(ErrRegionChanged, err.Error())
 	}
 
 	bo.Region = cloneRegion(region)
@@ -102,6 +141,9 @@ func (bo *balanceOperator) check(region *metapb.Region, leader *metapb.Peer) (bo
 
 // Do implements Operator.Do interface.
 func (bo *balanceOperator) Do(ctx *opContext, region *metapb.Region, leader *metapb.Peer) (bool, *pdpb.RegionHeartbeatResponse, error) {
+	bo.stepLock.Lock()
+	defer bo.stepLock.Unlock()
+
 	ok, err := bo.check(region, leader)
 	if err != nil {
 		return false, nil, errors.Trace(err)
@@ -110,6 +152,9 @@ func (bo *balanceOperator) Do(ctx *opContext, region *metapb.Region, leader *met
 		return true, nil, nil
 	}
 
+	if bo.StepStart.IsZero() {
+		bo.StepStart = time.Now()
+	}
 	finished, res, err := bo.Ops[bo.Index].Do(ctx, region, leader)
 	if err != nil {
 		return false, nil, errors.Trace(err)
@@ -119,6 +164,8 @@ func (bo *balanceOperator) Do(ctx *opContext, region *metapb.Region, leader *met
 	}
 
 	bo.Index++
+	bo.StepStart = time.Time{}
+	bo.StepRetries = 0
 
 	bo.Finished = bo.Index >= len(bo.Ops)
 	return bo.Finished, res, nil
@@ -129,6 +176,47 @@ func (bo *balanceOperator) getRegionID() uint64 {
 	return bo.Region.GetId()
 }
 
+// isTimeout returns whether the operator has run for longer than maxWait.
+func (bo *balanceOperator) isTimeout(maxWait time.Duration) bool {
+	return time.Since(bo.Start) > maxWait
+}
+
+// checkStepTimeout checks whether the current step has been dispatched for
+// longer than timeout, a zero timeout disables it. The step which times out
+// is dispatched again on the next heartbeat if it has been retried less
+// than maxRetries times, otherwise it returns true and the step index.
+func (bo *balanceOperator) checkStepTimeout(timeout time.Duration, maxRetries int) (bool, int) {
+	bo.stepLock.Lock()
+	defer bo.stepLock.Unlock()
+
+	if timeout == 0 || bo.StepStart.IsZero() || time.Since(bo.StepStart) <= timeout {
+		return false, bo.Index
+	}
+	if bo.StepRetries >= maxRetries {
+		return true, bo.Index
+	}
+
+	log.Warnf("step %d of balancer operator %d timed out after %s, retry it", bo.Index, bo.ID, timeout)
+	if op, ok := bo.Ops[bo.Index].(retryableOperator); ok {
+		op.retry()
+	}
+	// The retried step times out again even if the region doesn't report.
+	bo.StepStart = time.Now()
+	bo.StepRetries++
+	return false, bo.Index
+}
+
+// isTransferLeader returns true if the operator only transfers region leader.
+func (bo *balanceOperator) isTransferLeader() bool {
+	for _, op := range bo.Ops {
+		if _, ok := op.(*transferLeaderOperator); !ok {
+			return false
+		}
+	}
+
+	return len(bo.Ops) > 0
+}
+
 // onceOperator is the operator wrapping another operator
 // and can be called only once. It will return finished every time.
 type onceOperator struct {
@@ -239,6 +327,73 @@ func (co *changePeerOperator) Do(ctx *opContext, region *metapb.Region, leader *
 	return false, res, nil
 }
 
+// catchUpOperator waits for the added peer to catch up with the leader, it
+// is placed between adding a peer and removing the peer it replaces, so the
+// region never loses a replica which is not replaced yet. The heartbeats
+// don't report the progress of the peers, the peer is considered caught up
+// after the leader reports it healthy in PeerCatchUpCount heartbeats. If the
+// leader reports it down, the add is rolled back by removing the peer, and
+// the operator fails, so the rest steps are not done.
+//
+// The peer can't be added as a learner and promoted after it catches up,
+// because the peers have no role and the conf changes have no add-learner
+// type in the protocol, so the new peer votes as soon as it is added.
+type catchUpOperator struct {
+	Peer     *metapb.Peer `json:"peer"`
+	RegionID uint64       `json:"regionid"`
+	Name     string       `json:"name"`
+	Count    int          `json:"count"`
+	Rollback bool         `json:"rollback"`
+
+	cfg *BalanceConfig
+}
+
+func newCatchUpOperator(regionID uint64, peer *metapb.Peer, cfg *BalanceConfig) *catchUpOperator {
+	return &catchUpOperator{
+		Peer:     peer,
+		RegionID: regionID,
+		Name:     "catch_up",
+		cfg:      cfg,
+	}
+}
+
+func (co *catchUpOperator) String() string {
+	return fmt.Sprintf("[catchUpOperator]regionID: %d, peer: %v, count: %d, rollback: %v", co.RegionID, co.Peer, co.Count, co.Rollback)
+}
+
+func (co *catchUpOperator) rollback() *pdpb.RegionHeartbeatResponse {
+	return &pdpb.RegionHeartbeatResponse{
+		ChangePeer: &pdpb.ChangePeer{
+			ChangeType: raftpb.ConfChangeType_RemoveNode.Enum(),
+			Peer:       co.Peer,
+		},
+	}
+}
+
+// Do implements Operator.Do interface.
+func (co *catchUpOperator) Do(ctx *opContext, region *metapb.Region, leader *metapb.Peer) (bool, *pdpb.RegionHeartbeatResponse, error) {
+	if !containPeer(region, co.Peer) {
+		if co.Rollback {
+			return false, nil, errors.Errorf("peer %v is down, the add peer is rolled back", co.Peer)
+		}
+		return false, nil, errors.Errorf("peer %v is removed before it catches up", co.Peer)
+	}
+	if co.Rollback {
+		return false, co.rollback(), nil
+	}
+
+	for _, stats := range ctx.downPeers {
+		if stats.GetPeer().GetId() == co.Peer.GetId() {
+			log.Warnf("balance [%s], peer %s is down before it catches up, roll back", region, co.Peer)
+			co.Rollback = true
+			return false, co.rollback(), nil
+		}
+	}
+
+	co.Count++
+	return co.Count >= int(co.cfg.PeerCatchUpCount), nil, nil
+}
+
 // transferLeaderOperator is used to do leader transfer.
 type transferLeaderOperator struct {
 	Count int `json:"count"`
@@ -322,6 +477,12 @@ func (tlo *transferLeaderOperator) Do(ctx *opContext, region *metapb.Region, lea
 	return false, res, nil
 }
 
+// retry sends the transfer leader command again, and waits for
+// MaxTransferWaitCount heartbeats again.
+func (tlo *transferLeaderOperator) retry() {
+	tlo.Count = 0
+}
+
 // splitOperator is used to do region split, only for history operator mark.
 type splitOperator struct {
 	Origin *metapb.Region `json:"origin"`
@@ -344,3 +505,101 @@ func newSplitOperator(origin *metapb.Region, left *metapb.Region, right *metapb.
 func (so *splitOperator) Do(ctx *opContext, region *metapb.Region, leader *metapb.Peer) (bool, *pdpb.RegionHeartbeatResponse, error) {
 	return true, nil, nil
 }
+
+// Names of the operators which can be requested by the user.
+const (
+	OperatorTransferLeader = "transfer-leader"
+	OperatorAddPeer        = "add-peer"
+	OperatorRemovePeer     = "remove-peer"
+)
+
+// OperatorRequest describes an operator requested by the user,
+// e.g, {"name": "transfer-leader", "region_id": 42, "store_id": 7}.
+type OperatorRequest struct {
+	Name     string `json:"name"`
+	RegionID uint64 `json:"region_id"`
+	StoreID  uint64 `json:"store_id"`
+}
+
+// OperatorStatus is the progress and the outcome of an operator.
+type OperatorStatus struct {
+	ID        uint64          `json:"id"`
+	RegionID  uint64          `json:"region_id"`
+	Start     time.Time       `json:"start"`
+	Step      int             `json:"step"`
+	Total     int             `json:"total"`
+	Status    OperatorOutcome `json:"status"`
+	Reason    string          `json:"reason,omitempty"`
+	Operators []Operator      `json:"operators"`
+}
+
+func (bo *balanceOperator) getStatus() *OperatorStatus {
+	return &OperatorStatus{
+		ID:        bo.ID,
+		RegionID:  bo.getRegionID(),
+		Start:     bo.Start,
+		Step:      bo.Index,
+		Total:     len(bo.Ops),
+		Status:    bo.Status,
+		Reason:    bo.Reason,
+		Operators: bo.Ops,
+	}
+}
+
+// newRequestedOperator checks the operator requested by the user is legal
+// and creates it.
+func newRequestedOperator(cluster *clusterInfo, req *OperatorRequest, cfg *BalanceConfig) (*balanceOperator, error) {
+	region, leader := cluster.regions.getRegionByID(req.RegionID)
+	if region == nil {
+		return nil, errors.Trace(ErrRegionNotFound)
+	}
+	store := cluster.getStore(req.StoreID)
+	if store == nil {
+		return nil, errors.Trace(ErrStoreNotFound)
+	}
+
+	regionID := region.GetId()
+	peer := leaderPeer(region, req.StoreID)
+	switch req.Name {
+	case OperatorTransferLeader:
+		if peer == nil {
+			return nil, errors.Annotatef(ErrInvalidOperator, "region %d has no peer on store %d", regionID, req.StoreID)
+		}
+		if leader == nil {
+			return nil, errors.Annotatef(ErrInvalidOperator, "region %d has no leader now", regionID)
+		}
+		if leader.GetId() == peer.GetId() {
+			return nil, errors.Annotatef(ErrInvalidOperator, "region %d leader is on store %d already", regionID, req.StoreID)
+		}
+		return newBalanceOperator(region, newTransferLeaderOperator(regionID, leader, peer, cfg)), nil
+	case OperatorAddPeer:
+		if peer != nil {
+			return nil, errors.Annotatef(ErrInvalidOperator, "region %d has a peer on store %d already", regionID, req.StoreID)
+		}
+		if store.isOffline() {
+			return nil, errors.Annotatef(ErrInvalidOperator, "store %d is offline", req.StoreID)
+		}
+		peerID, err := cluster.idAlloc.Alloc()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		newPeer := &metapb.Peer{
+			Id:      proto.Uint64(peerID),
+			StoreId: proto.Uint64(req.StoreID),
+		}
+		return newBalanceOperator(region, newAddPeerOperator(regionID, newPeer)), nil
+	case OperatorRemovePeer:
+		if peer == nil {
+			return nil, errors.Annotatef(ErrInvalidOperator, "region %d has no peer on store %d", regionID, req.StoreID)
+		}
+		if leader.GetId() == peer.GetId() {
+			return nil, errors.Annotatef(ErrInvalidOperator, "region %d leader on store %d can't be removed", regionID, req.StoreID)
+		}
+		if len(region.GetPeers()) <= 1 {
+			return nil, errors.Annotatef(ErrInvalidOperator, "region %d has only one peer", regionID)
+		}
+		return newBalanceOperator(region, newRemovePeerOperator(regionID, peer)), nil
+	}
+
+	return nil, errors.Annotatef(ErrInvalidOperator, "unknown operator %s", req.Name)
+}
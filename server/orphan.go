@@ -0,0 +1,128 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/ngaut/log"
+)
+
+// The reasons why a peer is orphaned.
+const (
+	orphanStoreNotFound  = "store_not_found"
+	orphanStoreTombstone = "store_tombstone"
+)
+
+// OrphanPeer is the peer on a store which doesn't exist or is tombstone,
+// the peer can never be healthy again.
+type OrphanPeer struct {
+	RegionID uint64 `json:"region_id"`
+	PeerID   uint64 `json:"peer_id"`
+	StoreID  uint64 `json:"store_id"`
+	Reason   string `json:"reason"`
+}
+
+// findOrphanPeers returns the orphan peers of the regions ordered by the
+// start key of the regions.
+func findOrphanPeers(cluster *clusterInfo) []*OrphanPeer {
+	orphans := make([]*OrphanPeer, 0)
+	for _, region := range cluster.regions.scanRegions(0, cluster.regions.regionCount()) {
+		for _, peer := range region.GetPeers() {
+			reason := orphanReason(cluster.getStore(peer.GetStoreId()))
+			if reason == "" {
+				continue
+			}
+			orphans = append(orphans, &OrphanPeer{
+				RegionID: region.GetId(),
+				PeerID:   peer.GetId(),
+				StoreID:  peer.GetStoreId(),
+				Reason:   reason,
+			})
+		}
+	}
+	return orphans
+}
+
+func orphanReason(store *storeInfo) string {
+	if store == nil {
+		return orphanStoreNotFound
+	}
+	if store.isTombstone() {
+		return orphanStoreTombstone
+	}
+	return ""
+}
+
+// GetOrphanPeers returns the peers on the unknown or tombstone stores.
+func (c *RaftCluster) GetOrphanPeers() []*OrphanPeer {
+	return findOrphanPeers(c.cachedCluster)
+}
+
+// checkOrphanPeers logs the new orphan peers, and removes them if the
+// remove orphan peers config is set. At most one peer of a region is
+// removed at a time, and only if the healthy peers left can form a quorum.
+// It returns the operators added.
+func (bw *balancerWorker) checkOrphanPeers() []*balanceOperator {
+	orphans := findOrphanPeers(bw.cluster)
+	bw.logOrphanPeers(orphans)
+	if !bw.cfg().RemoveOrphanPeers {
+		return nil
+	}
+
+	var added []*balanceOperator
+	for _, orphan := range orphans {
+		if !bw.allowBalance() {
+			return added
+		}
+
+		region, leader := bw.cluster.regions.getRegionByID(orphan.RegionID)
+		if region == nil || leader == nil || leader.GetId() == orphan.PeerID {
+			continue
+		}
+		peer := leaderPeer(region, orphan.StoreID)
+		if peer == nil {
+			continue
+		}
+		if err := bw.cluster.checkRemovePeerQuorum(region, peer, bw.cfg().MaxStoreDownDuration.Duration); err != nil {
+			log.Debugf("can't remove orphan peer %d of region %d - %v", peer.GetId(), region.GetId(), err)
+			continue
+		}
+
+		bop := newBalanceOperator(region, newOnceOperator(newRemovePeerOperator(region.GetId(), peer)))
+		if bw.addBalanceOperator(region.GetId(), bop) {
+			bw.addRegionCache(region.GetId())
+			log.Infof("remove orphan peer %d of region %d - %s", peer.GetId(), region.GetId(), bop)
+			added = append(added, bop)
+		}
+	}
+	return added
+}
+
+// logOrphanPeers logs the orphan peers which are not found in the last
+// check, so an orphan peer is only logged once when it's found, and once
+// when it's gone.
+func (bw *balancerWorker) logOrphanPeers(orphans []*OrphanPeer) {
+	found := make(map[uint64]struct{}, len(orphans))
+	for _, orphan := range orphans {
+		found[orphan.PeerID] = struct{}{}
+		if _, ok := bw.orphanPeers[orphan.PeerID]; !ok {
+			log.Warnf("region %d has orphan peer %d on store %d, reason %s", orphan.RegionID, orphan.PeerID, orphan.StoreID, orphan.Reason)
+		}
+	}
+	for peerID := range bw.orphanPeers {
+		if _, ok := found[peerID]; !ok {
+			log.Infof("orphan peer %d is gone", peerID)
+		}
+	}
+	bw.orphanPeers = found
+}
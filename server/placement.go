@@ -0,0 +1,117 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/juju/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+var (
+	// ErrInvalidPlacementRule is returned when the placement rule has an
+	// invalid range or no label.
+	ErrInvalidPlacementRule = errors.New("invalid placement rule")
+	// ErrOverlappingPlacementRules is returned when the ranges of the
+	// placement rules overlap.
+	ErrOverlappingPlacementRules = errors.New("placement rules overlap")
+)
+
+// PlacementRule requires the peers of the regions in the key range to be
+// placed on the stores with all the labels.
+type PlacementRule struct {
+	KeyRange
+	Labels map[string]string `json:"labels"`
+}
+
+// matchStore checks whether the store has all the labels of the rule.
+func (r *PlacementRule) matchStore(store *storeInfo) bool {
+	return matchStoreLabels(store, r.Labels)
+}
+
+// matchStoreLabels checks whether the store has all the labels.
+func matchStoreLabels(store *storeInfo, labels map[string]string) bool {
+	for k, v := range labels {
+		if store.meta.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// validatePlacementRules checks the rules, the ranges of the rules must
+// not overlap, otherwise a region may belong to more than one rule.
+func validatePlacementRules(rules []*PlacementRule) error {
+	ranges := make([]*KeyRange, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule.Labels) == 0 {
+			return errors.Annotatef(ErrInvalidPlacementRule, "rule %s has no label", &rule.KeyRange)
+		}
+		if err := validateStoreLabels(rule.Labels); err != nil {
+			return errors.Annotatef(ErrInvalidPlacementRule, "rule %s has invalid label: %v", &rule.KeyRange, err)
+		}
+		if err := rule.validate(); err != nil {
+			return errors.Annotatef(ErrInvalidPlacementRule, "rule %v", err)
+		}
+		ranges = append(ranges, &rule.KeyRange)
+	}
+
+	if prev, r := findOverlappingRanges(ranges); prev != nil {
+		return errors.Annotatef(ErrOverlappingPlacementRules, "rule %s overlaps with rule %s", prev, r)
+	}
+	return nil
+}
+
+func (c *clusterInfo) setPlacementRules(rules []*PlacementRule) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.rules = rules
+}
+
+func (c *clusterInfo) getPlacementRules() []*PlacementRule {
+	c.RLock()
+	defer c.RUnlock()
+
+	rules := make([]*PlacementRule, 0, len(c.rules))
+	return append(rules, c.rules...)
+}
+
+// getPlacementRule returns the rule which the region belongs to, or nil
+// if the region belongs to no rule.
+func (c *clusterInfo) getPlacementRule(region *metapb.Region) *PlacementRule {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, rule := range c.rules {
+		if rule.containsKey(region.GetStartKey()) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// excludeRuleStores adds the stores which don't satisfy the placement rule
+// of the region to excluded.
+func excludeRuleStores(cluster *clusterInfo, stores []*storeInfo, region *metapb.Region, excluded map[uint64]struct{}) {
+	rule := cluster.getPlacementRule(region)
+	if rule == nil {
+		return
+	}
+
+	for _, store := range stores {
+		if !rule.matchStore(store) {
+			excluded[store.store.GetId()] = struct{}{}
+		}
+	}
+}
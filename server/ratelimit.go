@@ -0,0 +1,49 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"math"
+	"time"
+)
+
+// TokenBucket holds up to capacity tokens and gains rate tokens per second,
+// the rate and the capacity are given in each refill, so they can change.
+// It is used by the rate limiters of the API and the stores, and it is not
+// safe for concurrent use.
+type TokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a bucket full of capacity tokens.
+func NewTokenBucket(capacity float64, now time.Time) *TokenBucket {
+	return &TokenBucket{tokens: capacity, last: now}
+}
+
+// Refill adds the tokens gained from the last refill to now, up to the
+// capacity, and returns the tokens in the bucket.
+func (b *TokenBucket) Refill(rate float64, capacity float64, now time.Time) float64 {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		b.last = now
+	}
+	b.tokens = math.Min(b.tokens, capacity)
+	return b.tokens
+}
+
+// Take takes n tokens from the bucket.
+func (b *TokenBucket) Take(n float64) {
+	b.tokens -= n
+}
@@ -0,0 +1,129 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/coreos/etcd/etcdserver/membership"
+	"github.com/coreos/etcd/wal"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// the maximum amount of time to check whether a peer is alive before
+// forcing a new cluster.
+const peerCheckTimeout = 3 * time.Second
+
+// ErrQuorumReachable is returned when the force new cluster is required
+// but the quorum of the cluster is still reachable.
+var ErrQuorumReachable = errors.New("quorum of the cluster is reachable")
+
+// checkForceNewCluster checks whether it's safe to force a new cluster.
+// The existing data dir is required, because etcd reforms the cluster from
+// it. The other members recorded in the data dir are dialed, if a quorum is
+// reachable the cluster can elect a leader by itself, and forcing a new
+// cluster splits the brain.
+func (c *Config) checkForceNewCluster() error {
+	if !wal.Exist(filepath.Join(c.DataDir, "member", "wal")) {
+		return errors.Errorf("force new cluster requires the existing data in %s", c.DataDir)
+	}
+
+	members, err := loadMembers(c.DataDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	reachable := 0
+	for _, m := range members {
+		if m.Name == c.Name {
+			reachable++
+			continue
+		}
+		for _, u := range m.PeerURLs {
+			peerURL, err := url.Parse(u)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if isPeerReachable(*peerURL) {
+				log.Warnf("peer %s %s is reachable", m.Name, u)
+				reachable++
+				break
+			}
+		}
+	}
+	// A single member cluster has nothing to lose.
+	if len(members) > 1 && reachable > len(members)/2 {
+		return errors.Annotatef(ErrQuorumReachable, "%d of %d members are reachable", reachable, len(members))
+	}
+
+	log.Warnf("force new cluster from %s, %d of %d members are reachable, the data not replicated to this member is lost",
+		c.DataDir, reachable, len(members))
+	return nil
+}
+
+// loadMembers loads the members of the etcd cluster from the backend in the
+// data dir, which is the membership applied by this member, the initial
+// cluster in the config may be outdated after the members are changed.
+func loadMembers(dataDir string) ([]*membership.Member, error) {
+	db, err := bolt.Open(filepath.Join(dataDir, "member", "snap", "db"), 0600, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  peerCheckTimeout,
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer db.Close()
+
+	var members []*membership.Member
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("members"))
+		if bucket == nil {
+			return errors.Errorf("no members in %s", dataDir)
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			m := &membership.Member{}
+			if err := json.Unmarshal(v, m); err != nil {
+				return errors.Trace(err)
+			}
+			members = append(members, m)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return members, nil
+}
+
+func isPeerReachable(u url.URL) bool {
+	network := "tcp"
+	switch u.Scheme {
+	// used in tests
+	case "unix", "unixs":
+		network = "unix"
+	}
+
+	conn, err := net.DialTimeout(network, u.Host, peerCheckTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/juju/errors"
+	. "github.com/pingcap/check"
+	"golang.org/x/net/context"
+)
+
+var _ = Suite(&testForceNewClusterSuite{})
+
+type testForceNewClusterSuite struct{}
+
+func (s *testForceNewClusterSuite) TestForceNewCluster(c *C) {
+	cfgs := NewTestMultiConfig(3)
+	ch := make(chan *Server, 3)
+	for _, cfg := range cfgs {
+		defer os.RemoveAll(cfg.DataDir)
+		go func(cfg *Config) {
+			svr, err := NewServer(cfg)
+			c.Assert(err, IsNil)
+			ch <- svr
+		}(cfg)
+	}
+
+	svrs := make(map[string]*Server, 3)
+	for i := 0; i < 3; i++ {
+		svr := <-ch
+		svrs[svr.Name()] = svr
+		go svr.Run()
+	}
+
+	svr := svrs[cfgs[2].Name]
+	mustGetLeader(c, svr.client, svr.getLeaderPath())
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	_, err := svr.client.Put(ctx, "force_new_cluster", "test")
+	cancel()
+	c.Assert(err, IsNil)
+	// Wait for the key replicated to all the members.
+	time.Sleep(time.Second)
+	svr.Close()
+
+	// The other members are alive, so the cluster isn't lost.
+	cfg := *cfgs[2]
+	cfg.ForceNewCluster = true
+	_, err = CreateServer(&cfg)
+	c.Assert(errors.Cause(err), Equals, ErrQuorumReachable)
+
+	// The members are loaded from the data dir, not the initial cluster.
+	cfg = *cfgs[2]
+	cfg.ForceNewCluster = true
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, cfg.AdvertisePeerUrls)
+	_, err = CreateServer(&cfg)
+	c.Assert(errors.Cause(err), Equals, ErrQuorumReachable)
+
+	// Only pd3 survives.
+	svrs[cfgs[0].Name].Close()
+	svrs[cfgs[1].Name].Close()
+
+	cfg = *cfgs[2]
+	cfg.ForceNewCluster = true
+	svr, err = NewServer(&cfg)
+	c.Assert(err, IsNil)
+	defer svr.Close()
+	go svr.Run()
+
+	// The lease of the old leader expires, then pd3 becomes the leader.
+	for i := 0; i < 50 && !svr.IsLeader(); i++ {
+		time.Sleep(200 * time.Millisecond)
+	}
+	leader := mustGetLeader(c, svr.client, svr.getLeaderPath())
+	c.Assert(leader.GetAddr(), Equals, svr.GetAddr())
+
+	resp, err := kvGet(svr.client, "force_new_cluster")
+	c.Assert(err, IsNil)
+	c.Assert(resp.Kvs, HasLen, 1)
+	c.Assert(string(resp.Kvs[0].Value), Equals, "test")
+
+	members, err := memberList(svr.client)
+	c.Assert(err, IsNil)
+	c.Assert(members.Members, HasLen, 1)
+	c.Assert(members.Members[0].Name, Equals, cfgs[2].Name)
+}
+
+func (s *testForceNewClusterSuite) TestForceSingleCluster(c *C) {
+	cfg := NewTestSingleConfig()
+	defer os.RemoveAll(cfg.DataDir)
+
+	svr, err := NewServer(cfg)
+	c.Assert(err, IsNil)
+	go svr.Run()
+	mustGetLeader(c, svr.client, svr.getLeaderPath())
+	svr.Close()
+
+	forceCfg := *cfg
+	forceCfg.ForceNewCluster = true
+	svr, err = NewServer(&forceCfg)
+	c.Assert(err, IsNil)
+	defer svr.Close()
+	go svr.Run()
+	mustGetLeader(c, svr.client, svr.getLeaderPath())
+}
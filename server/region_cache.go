@@ -272,6 +272,24 @@ func (c *fifoCache) fromElems(key uint64) []*cacheItem {
 	return elems
 }
 
+// latestElems returns at most n latest items, from the oldest to the latest.
+func (c *fifoCache) latestElems(n int) []*cacheItem {
+	c.RLock()
+	defer c.RUnlock()
+
+	if n > c.ll.Len() {
+		n = c.ll.Len()
+	}
+	elems := make([]*cacheItem, n)
+	ele := c.ll.Front()
+	for i := n - 1; i >= 0; i-- {
+		elems[i] = ele.Value.(*cacheItem)
+		ele = ele.Next()
+	}
+
+	return elems
+}
+
 func (c *fifoCache) len() int {
 	c.RLock()
 	defer c.RUnlock()
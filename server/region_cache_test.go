@@ -164,6 +164,12 @@ func (s *testRegionCacheSuite) TestFifoCache(c *C) {
 	c.Assert(elems, HasLen, 1)
 	c.Assert(elems[0].value, DeepEquals, "4")
 
+	elems = cache.latestElems(2)
+	c.Assert(elems, HasLen, 2)
+	c.Assert(elems[0].value, DeepEquals, "3")
+	c.Assert(elems[1].value, DeepEquals, "4")
+	c.Assert(cache.latestElems(5), HasLen, 3)
+
 	cache.remove()
 	cache.remove()
 	cache.remove()
@@ -0,0 +1,93 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/juju/errors"
+)
+
+// GetRegionLabels returns the labels annotated to the region by the
+// operators, the labels are free-form and not used by the scheduler.
+func (c *RaftCluster) GetRegionLabels(regionID uint64) (map[string]string, error) {
+	if region, _ := c.cachedCluster.regions.getRegionByID(regionID); region == nil {
+		return nil, errors.Trace(ErrRegionNotFound)
+	}
+
+	value, err := getValue(c.s.client, makeRegionLabelKey(c.clusterRoot, regionID))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	labels := make(map[string]string)
+	if value == nil {
+		return labels, nil
+	}
+	if err = json.Unmarshal(value, &labels); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return labels, nil
+}
+
+// SetRegionLabels replaces the labels of the region. The labels are saved
+// in etcd and removed when the region is removed by a split or merge.
+func (c *RaftCluster) SetRegionLabels(regionID uint64, labels map[string]string) error {
+	if err := validateRegionLabels(labels); err != nil {
+		return errors.Trace(err)
+	}
+	if region, _ := c.cachedCluster.regions.getRegionByID(regionID); region == nil {
+		return errors.Trace(ErrRegionNotFound)
+	}
+
+	key := makeRegionLabelKey(c.clusterRoot, regionID)
+	op := clientv3.OpDelete(key)
+	if len(labels) > 0 {
+		value, err := json.Marshal(labels)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		op = clientv3.OpPut(key, string(value))
+	}
+
+	resp, err := c.s.leaderTxn().Then(op).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.New("save region labels failed, maybe we lost leader")
+	}
+	return nil
+}
+
+// DeleteRegionLabels removes all the labels of the region.
+func (c *RaftCluster) DeleteRegionLabels(regionID uint64) error {
+	return errors.Trace(c.SetRegionLabels(regionID, nil))
+}
+
+// validateRegionLabels checks the label keys are non-empty printable ASCII,
+// the values are free-form text.
+func validateRegionLabels(labels map[string]string) error {
+	for k := range labels {
+		if len(k) == 0 {
+			return errors.Annotate(ErrInvalidRegionLabel, "empty key")
+		}
+		for i := 0; i < len(k); i++ {
+			if k[i] < '!' || k[i] > '~' {
+				return errors.Annotatef(ErrInvalidRegionLabel, "key %q", k)
+			}
+		}
+	}
+	return nil
+}
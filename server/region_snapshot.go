@@ -0,0 +1,148 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// regionSnapshotChunkSize is the max region count saved in an etcd key of
+// the region snapshot, so the value doesn't exceed the etcd request limit.
+const regionSnapshotChunkSize = 10000
+
+// regionSnapshotItem is the leader store and the last heartbeat time in
+// unix seconds of a region. The regions themselves are saved in etcd
+// already, but the leaders are only known after the regions report.
+type regionSnapshotItem struct {
+	ID        uint64 `json:"id"`
+	Leader    uint64 `json:"leader"`
+	Heartbeat int64  `json:"heartbeat"`
+}
+
+func makeRegionSnapshotKey(clusterRootPath string, chunk uint64) string {
+	return strings.Join([]string{clusterRootPath, "rs", fmt.Sprintf("%020d", chunk)}, "/")
+}
+
+// regionSnapshotLoop saves the region snapshot periodically if the server
+// is the leader, the next leader loads it to serve the region leaders before
+// the regions report to it.
+func (s *Server) regionSnapshotLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.RegionSnapshotInterval.Duration)
+	defer ticker.Stop()
+
+	ctx := s.client.Ctx()
+	for {
+		select {
+		case <-ticker.C:
+			cluster, err := s.GetRaftCluster()
+			if err != nil || cluster == nil {
+				continue
+			}
+			if err = cluster.saveRegionSnapshot(); err != nil {
+				log.Errorf("save region snapshot err %v", errors.ErrorStack(err))
+			}
+		case <-ctx.Done():
+			// server closed, return
+			return
+		}
+	}
+}
+
+// saveRegionSnapshot saves the region leaders in chunks, and deletes the
+// chunks left by the previous larger snapshot.
+func (c *RaftCluster) saveRegionSnapshot() error {
+	items := c.cachedCluster.regions.snapshotLeaders()
+
+	chunk := uint64(0)
+	for start := 0; start < len(items); start += regionSnapshotChunkSize {
+		end := start + regionSnapshotChunkSize
+		if end > len(items) {
+			end = len(items)
+		}
+		value, err := json.Marshal(items[start:end])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		op := clientv3.OpPut(makeRegionSnapshotKey(c.clusterRoot, chunk), string(value))
+		if err = c.commitRegionSnapshot(op); err != nil {
+			return errors.Trace(err)
+		}
+		chunk++
+	}
+
+	endKey := makeRegionSnapshotKey(c.clusterRoot, math.MaxUint64)
+	op := clientv3.OpDelete(makeRegionSnapshotKey(c.clusterRoot, chunk), clientv3.WithRange(endKey))
+	return errors.Trace(c.commitRegionSnapshot(op))
+}
+
+func (c *RaftCluster) commitRegionSnapshot(op clientv3.Op) error {
+	resp, err := c.s.leaderTxn().Then(op).Commit()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return errors.New("save region snapshot failed, maybe we lost leader")
+	}
+	return nil
+}
+
+// loadRegionSnapshot restores the region leaders from the region snapshot,
+// the reads are possibly stale until the restored regions report. A broken
+// chunk is skipped, the regions in it wait for the heartbeats.
+func (c *RaftCluster) loadRegionSnapshot() error {
+	start := time.Now()
+
+	restored := 0
+	for chunk := uint64(0); ; chunk++ {
+		value, err := getValue(c.s.client, makeRegionSnapshotKey(c.clusterRoot, chunk))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if value == nil {
+			break
+		}
+
+		var items []*regionSnapshotItem
+		if err = json.Unmarshal(value, &items); err != nil {
+			log.Errorf("unmarshal region snapshot chunk %d err %v", chunk, err)
+			continue
+		}
+		for _, item := range items {
+			if c.cachedCluster.regions.restoreLeader(item) {
+				restored++
+			}
+		}
+	}
+
+	log.Infof("restore %d region leaders from snapshot cost %s", restored, time.Since(start))
+	return nil
+}
+
+// IsWarmingUp returns whether some region leaders are restored from the
+// region snapshot and haven't reported to this leader, the region info of
+// the cluster is possibly stale. It lasts at most max-region-heartbeat-age
+// since the latest restored heartbeat.
+func (c *RaftCluster) IsWarmingUp() bool {
+	return c.cachedCluster.regions.isWarmingUp(c.s.getBalanceConfig().MaxRegionHeartbeatAge.Duration)
+}
@@ -0,0 +1,170 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// KeyDecoder returns the table which the key belongs to.
+type KeyDecoder func(key []byte) (string, error)
+
+// unknownTable is the bucket of the regions whose start key can't be
+// decoded by the key decoder.
+const unknownTable = "unknown"
+
+const (
+	tableKeyPrefix        = 't'
+	tableKeyLength        = 9
+	signMask       uint64 = 0x8000000000000000
+
+	encGroupSize = 8
+	encMarker    = byte(0xFF)
+	encPad       = byte(0x0)
+)
+
+// DecodeTableKey is the key decoder for the keys of TiDB. The region keys
+// are the memcomparable encoded bytes of the TiDB keys, which start with
+// 't' and the sign flipped big endian table ID.
+func DecodeTableKey(key []byte) (string, error) {
+	decoded, err := decodeBytesPrefix(key, tableKeyLength)
+	if err != nil {
+		return "", errors.Annotatef(err, "invalid table key %x", key)
+	}
+	if len(decoded) < tableKeyLength || decoded[0] != tableKeyPrefix {
+		return "", errors.Errorf("invalid table key %x", key)
+	}
+	tableID := int64(binary.BigEndian.Uint64(decoded[1:tableKeyLength]) ^ signMask)
+	return fmt.Sprintf("table_%d", tableID), nil
+}
+
+// decodeBytesPrefix decodes at least n bytes of the memcomparable encoded
+// key, unless the key ends before. The key is encoded in the groups of 8
+// bytes, each followed by a marker byte, which is 0xFF minus the count of
+// the zero bytes padded to the last group.
+func decodeBytesPrefix(key []byte, n int) ([]byte, error) {
+	var decoded []byte
+	for len(decoded) < n {
+		if len(key) < encGroupSize+1 {
+			return nil, errors.New("insufficient bytes to decode")
+		}
+		group, marker := key[:encGroupSize], key[encGroupSize]
+		padCount := int(encMarker - marker)
+		if padCount > encGroupSize {
+			return nil, errors.Errorf("invalid marker byte %x", marker)
+		}
+
+		realGroupSize := encGroupSize - padCount
+		decoded = append(decoded, group[:realGroupSize]...)
+		key = key[encGroupSize+1:]
+		if padCount == 0 {
+			continue
+		}
+		for _, b := range group[realGroupSize:] {
+			if b != encPad {
+				return nil, errors.Errorf("invalid padding byte %x", b)
+			}
+		}
+		break
+	}
+	return decoded, nil
+}
+
+// RegionStatsBucket is the count of the regions in a group.
+type RegionStatsBucket struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// RegionStats is the region stats grouped by the table or the raw key
+// prefix of the region start keys.
+type RegionStats struct {
+	GroupBy string               `json:"group_by"`
+	Buckets []*RegionStatsBucket `json:"buckets"`
+}
+
+// The fields to group the regions by.
+const (
+	RegionStatsByTable  = "table"
+	RegionStatsByPrefix = "prefix"
+)
+
+// GetRegionStats groups the regions by the decoded table of the start keys
+// if a key decoder is set, otherwise by the hex encoded prefix of the start
+// keys. The buckets are sorted by the key.
+func (c *RaftCluster) GetRegionStats(groupBy string) *RegionStats {
+	decoder := c.s.cfg.KeyDecoder
+	if decoder == nil {
+		groupBy = RegionStatsByPrefix
+	}
+	prefixLength := c.s.cfg.RegionStatsPrefixLength
+
+	counts := make(map[string]int)
+	for _, region := range c.cachedCluster.regions.getRegions() {
+		key := region.GetStartKey()
+		if groupBy == RegionStatsByTable {
+			counts[decodeTable(decoder, key)]++
+			continue
+		}
+		if len(key) > prefixLength {
+			key = key[:prefixLength]
+		}
+		counts[hex.EncodeToString(key)]++
+	}
+
+	stats := &RegionStats{
+		GroupBy: groupBy,
+		Buckets: make([]*RegionStatsBucket, 0, len(counts)),
+	}
+	for key, count := range counts {
+		stats.Buckets = append(stats.Buckets, &RegionStatsBucket{Key: key, Count: count})
+	}
+	sort.Sort(regionStatsBuckets(stats.Buckets))
+	return stats
+}
+
+// decodeTable returns the table of the key, the key is in the unknown
+// table if the decoder fails or panics.
+func decodeTable(decoder KeyDecoder, key []byte) (table string) {
+	defer func() {
+		if r := recover(); r != nil {
+			table = unknownTable
+		}
+	}()
+
+	table, err := decoder(key)
+	if err != nil {
+		return unknownTable
+	}
+	return table
+}
+
+type regionStatsBuckets []*RegionStatsBucket
+
+func (s regionStatsBuckets) Len() int {
+	return len(s)
+}
+
+func (s regionStatsBuckets) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s regionStatsBuckets) Less(i, j int) bool {
+	return s[i].Key < s[j].Key
+}
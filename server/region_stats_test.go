@@ -0,0 +1,69 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testRegionStatsSuite{})
+
+type testRegionStatsSuite struct{}
+
+// encodeBytes encodes the key in the memcomparable format like TiDB.
+func encodeBytes(key []byte) []byte {
+	var encoded []byte
+	for i := 0; i <= len(key); i += encGroupSize {
+		group := make([]byte, encGroupSize)
+		n := copy(group, key[i:])
+		encoded = append(encoded, group...)
+		encoded = append(encoded, encMarker-byte(encGroupSize-n))
+	}
+	return encoded
+}
+
+func (s *testRegionStatsSuite) TestDecodeTableKey(c *C) {
+	table, err := DecodeTableKey(encodeBytes([]byte("t\x80\x00\x00\x00\x00\x00\x00\x05_r1")))
+	c.Assert(err, IsNil)
+	c.Assert(table, Equals, "table_5")
+	table, err = DecodeTableKey(encodeBytes([]byte("t\x7f\xff\xff\xff\xff\xff\xff\xff")))
+	c.Assert(err, IsNil)
+	c.Assert(table, Equals, "table_-1")
+	// The table prefix of a long key is decoded.
+	table, err = DecodeTableKey(encodeBytes([]byte("t\x80\x00\x00\x00\x00\x00\x01\x00_r\x80\x00\x00\x00\x00\x00\x00\x01")))
+	c.Assert(err, IsNil)
+	c.Assert(table, Equals, "table_256")
+
+	for _, key := range []string{
+		"",
+		"t",
+		// The key is not encoded.
+		"t\x80\x00\x00\x00\x00\x00\x00\x05",
+		string(encodeBytes([]byte("t\x80\x00"))),
+		string(encodeBytes([]byte("u\x80\x00\x00\x00\x00\x00\x00\x05"))),
+		// The marker byte or the padding is invalid.
+		"t\x80\x00\x00\x00\x00\x00\x00\xf0\x05\x00\x00\x00\x00\x00\x00\x00\xf7",
+		"t\x80\x00\x00\x00\x00\x00\x00\xff\x05\x01\x00\x00\x00\x00\x00\x00\xf7",
+	} {
+		_, err = DecodeTableKey([]byte(key))
+		c.Assert(err, NotNil)
+		c.Assert(decodeTable(DecodeTableKey, []byte(key)), Equals, unknownTable)
+	}
+
+	// The panic of the decoder is recovered.
+	panicDecoder := func(key []byte) (string, error) {
+		return string(key[100:]), nil
+	}
+	c.Assert(decodeTable(panicDecoder, []byte("t")), Equals, unknownTable)
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// retryLeaderTxn runs f and retries it with exponential backoff if it fails,
+// the etcd requests may fail transiently when the etcd leader changes. The
+// retry stops once the server is not the leader, otherwise the old leader
+// may overwrite the data written by the new leader. f should return an
+// ErrNotLeader cause if the leader comparison of the txn fails.
+func (s *Server) retryLeaderTxn(name string, f func() error) error {
+	backoff := s.cfg.EtcdRetryBackoff.Duration
+	for i := uint64(0); ; i++ {
+		if !s.isLeader() {
+			return errors.Annotate(ErrNotLeader, name)
+		}
+
+		err := f()
+		if err == nil || errors.Cause(err) == ErrNotLeader || i >= s.cfg.EtcdRetryCount {
+			return errors.Trace(err)
+		}
+
+		log.Warnf("%s err %v, retry after %s", name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-s.client.Ctx().Done():
+			return errors.Trace(err)
+		}
+		backoff *= 2
+	}
+}
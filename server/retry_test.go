@@ -0,0 +1,98 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testRetrySuite{})
+
+type testRetrySuite struct {
+	svr *Server
+}
+
+func (s *testRetrySuite) SetUpSuite(c *C) {
+	s.svr = newTestServer(c)
+	s.svr.cfg.EtcdRetryBackoff.Duration = 10 * time.Millisecond
+
+	go s.svr.Run()
+	mustGetLeader(c, s.svr.client, s.svr.getLeaderPath())
+}
+
+func (s *testRetrySuite) TearDownSuite(c *C) {
+	s.svr.Close()
+
+	os.RemoveAll(s.svr.cfg.DataDir)
+}
+
+func (s *testRetrySuite) TestRetry(c *C) {
+	errFlaky := errors.New("flaky etcd")
+
+	// The txn succeeds after failing twice.
+	calls := 0
+	err := s.svr.retryLeaderTxn("test", func() error {
+		calls++
+		if calls <= 2 {
+			return errFlaky
+		}
+		resp, err := s.svr.leaderTxn().Then().Commit()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !resp.Succeeded {
+			return errors.Annotate(ErrNotLeader, "test failed")
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 3)
+
+	// The retry stops after the max times.
+	calls = 0
+	err = s.svr.retryLeaderTxn("test", func() error {
+		calls++
+		return errFlaky
+	})
+	c.Assert(errors.Cause(err), Equals, errFlaky)
+	c.Assert(calls, Equals, int(s.svr.cfg.EtcdRetryCount)+1)
+
+	// The leader comparison failure is not retried.
+	calls = 0
+	err = s.svr.retryLeaderTxn("test", func() error {
+		calls++
+		return errors.Annotate(ErrNotLeader, "test failed")
+	})
+	c.Assert(errors.Cause(err), Equals, ErrNotLeader)
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *testRetrySuite) TestRetryLostLeader(c *C) {
+	defer atomic.StoreInt64(&s.svr.isLeaderValue, 1)
+
+	// The leadership is lost after the first failure, so the retry aborts.
+	calls := 0
+	err := s.svr.retryLeaderTxn("test", func() error {
+		calls++
+		atomic.StoreInt64(&s.svr.isLeaderValue, 0)
+		return errors.New("flaky etcd")
+	})
+	c.Assert(errors.Cause(err), Equals, ErrNotLeader)
+	c.Assert(calls, Equals, 1)
+}
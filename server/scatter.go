@@ -0,0 +1,160 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/juju/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+// regionScatterer moves the region peers to randomly selected stores,
+// it is used to spread the regions piled up on a few stores after bulk split.
+type regionScatterer struct {
+	filters []Filter
+
+	cfg *BalanceConfig
+}
+
+func newRegionScatterer(cfg *BalanceConfig) *regionScatterer {
+	rs := &regionScatterer{cfg: cfg}
+	rs.filters = append(rs.filters, newStateFilter(cfg))
+	rs.filters = append(rs.filters, newCapacityFilter(cfg))
+	rs.filters = append(rs.filters, newSnapCountFilter(cfg))
+	return rs
+}
+
+// selectStores selects count stores randomly for the region, the stores
+// satisfy the placement rule and the namespace, and are not in the same
+// location if possible.
+func (rs *regionScatterer) selectStores(cluster *clusterInfo, region *metapb.Region, count int) []*storeInfo {
+	stores := cluster.getStores()
+	excluded := make(map[uint64]struct{})
+	excludeRuleStores(cluster, stores, region, excluded)
+	excludeNamespaceStores(cluster, stores, region, excluded)
+	candidates := make([]*storeInfo, 0, len(stores))
+	for _, i := range cluster.regions.rand.Perm(len(stores)) {
+		if _, ok := excluded[stores[i].store.GetId()]; ok {
+			continue
+		}
+		if !filterToStore(stores[i], rs.filters) {
+			candidates = append(candidates, stores[i])
+		}
+	}
+
+	selected := make([]*storeInfo, 0, count)
+	isSelected := make(map[uint64]struct{}, count)
+	sameLocation := func(store *storeInfo) bool {
+		for _, s := range selected {
+			if store.isSameLocation(s, rs.cfg.LocationLabels) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The replica count is more important than the location, so we
+	// select the stores in the same location if we can't avoid it.
+	for _, checkLocation := range []bool{true, false} {
+		for _, store := range candidates {
+			if len(selected) >= count {
+				return selected
+			}
+			if _, ok := isSelected[store.store.GetId()]; ok {
+				continue
+			}
+			if checkLocation && sameLocation(store) {
+				continue
+			}
+			selected = append(selected, store)
+			isSelected[store.store.GetId()] = struct{}{}
+		}
+	}
+
+	return selected
+}
+
+// scatter generates the operator to move the region peers to the randomly
+// selected stores, it returns nil if no peer needs to be moved.
+func (rs *regionScatterer) scatter(cluster *clusterInfo, region *metapb.Region, leader *metapb.Peer) (*balanceOperator, error) {
+	peers := region.GetPeers()
+	targets := rs.selectStores(cluster, region, len(peers))
+	if len(targets) < len(peers) {
+		return nil, errors.Annotatef(ErrNotEnoughStores, "region %d needs %d stores, but only %d available",
+			region.GetId(), len(peers), len(targets))
+	}
+
+	// Keep at most one peer on every target store, the others are moved
+	// to the target stores which have no peer of the region.
+	kept := make(map[uint64]struct{}, len(peers))
+	for _, store := range targets {
+		storeID := store.store.GetId()
+		for _, peer := range peers {
+			if peer.GetStoreId() == storeID {
+				kept[peer.GetId()] = struct{}{}
+				break
+			}
+		}
+	}
+	hasPeer := getExcludedStores(region)
+	newStores := make([]uint64, 0, len(peers))
+	for _, store := range targets {
+		if _, ok := hasPeer[store.store.GetId()]; !ok {
+			newStores = append(newStores, store.store.GetId())
+		}
+	}
+
+	var (
+		ops        []Operator
+		leaderOps  []Operator
+		regionID   = region.GetId()
+		newStoreID uint64
+	)
+	for _, peer := range peers {
+		if _, ok := kept[peer.GetId()]; ok {
+			continue
+		}
+
+		newStoreID, newStores = newStores[0], newStores[1:]
+		peerID, err := cluster.idAlloc.Alloc()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		newPeer := &metapb.Peer{
+			Id:      proto.Uint64(peerID),
+			StoreId: proto.Uint64(newStoreID),
+		}
+
+		// The leader can't be removed, so we transfer the leader to the new
+		// peer before removing it, and move the leader at the end.
+		if leader != nil && peer.GetId() == leader.GetId() {
+			leaderOps = append(leaderOps,
+				newAddPeerOperator(regionID, newPeer),
+				newCatchUpOperator(regionID, newPeer, rs.cfg),
+				newTransferLeaderOperator(regionID, peer, newPeer, rs.cfg),
+				newRemovePeerOperator(regionID, peer))
+			continue
+		}
+		ops = append(ops,
+			newAddPeerOperator(regionID, newPeer),
+			newCatchUpOperator(regionID, newPeer, rs.cfg),
+			newRemovePeerOperator(regionID, peer))
+	}
+
+	ops = append(ops, leaderOps...)
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	return newBalanceOperator(region, ops...), nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/juju/errors"
+	. "github.com/pingcap/check"
+	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+// newClusteredRegion returns the region with peers on store 1, 1 and 2,
+// the leader is on store 1.
+func (s *testBalancerSuite) newClusteredRegion(c *C, clusterInfo *clusterInfo) (*metapb.Region, *metapb.Peer) {
+	for storeID := uint64(1); storeID <= 4; storeID++ {
+		s.updateStore(c, clusterInfo, storeID, 100, 50, 0, 0)
+	}
+
+	region, leader := clusterInfo.regions.getRegion([]byte("a"))
+	c.Assert(leader, NotNil)
+	region.Peers = append(region.Peers, s.newPeer(c, 1, 100), s.newPeer(c, 2, 101))
+	region.RegionEpoch = &metapb.RegionEpoch{
+		ConfVer: proto.Uint64(3),
+		Version: proto.Uint64(1),
+	}
+	clusterInfo.regions.updateRegion(region)
+	return region, leader
+}
+
+// applyScatter applies the scatter operator to the region,
+// it returns the stores of the region peers at last.
+func (s *testBalancerSuite) applyScatter(c *C, bop *balanceOperator, region *metapb.Region, leader *metapb.Peer) map[uint64]struct{} {
+	region = cloneRegion(region)
+	for _, op := range bop.Ops {
+		switch op := op.(type) {
+		case *changePeerOperator:
+			peer := op.ChangePeer.GetPeer()
+			if op.ChangePeer.GetChangeType() == raftpb.ConfChangeType_AddNode {
+				// The store must not have a peer of the region.
+				addRegionPeer(c, region, peer)
+			} else {
+				c.Assert(peer.GetId(), Not(Equals), leader.GetId())
+				removeRegionPeer(c, region, peer)
+			}
+		case *catchUpOperator:
+			// The new peer is added before waiting for it.
+			c.Assert(containPeer(region, op.Peer), IsTrue)
+		case *transferLeaderOperator:
+			c.Assert(op.OldLeader.GetId(), Equals, leader.GetId())
+			c.Assert(containPeer(region, op.NewLeader), IsTrue)
+			leader = op.NewLeader
+		default:
+			c.Fatalf("unexpected operator %v", op)
+		}
+	}
+
+	c.Assert(containPeer(region, leader), IsTrue)
+	stores := getExcludedStores(region)
+	c.Assert(region.GetPeers(), HasLen, len(stores))
+	return stores
+}
+
+func (s *testBalancerSuite) TestScatter(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, leader := s.newClusteredRegion(c, clusterInfo)
+
+	scatterer := newRegionScatterer(s.cfg)
+	for i := 0; i < 20; i++ {
+		bop, err := scatterer.scatter(clusterInfo, region, leader)
+		c.Assert(err, IsNil)
+		// The two peers on store 1 can't stay together.
+		c.Assert(bop, NotNil)
+		stores := s.applyScatter(c, bop, region, leader)
+		c.Assert(stores, HasLen, 3)
+	}
+
+	// The region is not moved, but there are not enough stores for 5 replicas.
+	region.Peers = append(region.Peers, s.newPeer(c, 3, 102), s.newPeer(c, 4, 103))
+	_, err := scatterer.scatter(clusterInfo, region, leader)
+	c.Assert(errors.Cause(err), Equals, ErrNotEnoughStores)
+}
+
+func (s *testBalancerSuite) TestScatterLocationLabels(c *C) {
+	clusterInfo := s.newClusterInfo(c)
+	region, leader := s.newClusteredRegion(c, clusterInfo)
+
+	cfg := *s.cfg
+	cfg.LocationLabels = []string{"zone"}
+	// Store 1 and store 2 are in the same zone.
+	for storeID, zone := range map[uint64]string{1: "z1", 2: "z1", 3: "z2", 4: "z3"} {
+		c.Assert(clusterInfo.setStoreMeta(storeID, storeMeta{Labels: map[string]string{"zone": zone}}), IsTrue)
+	}
+
+	scatterer := newRegionScatterer(&cfg)
+	for i := 0; i < 20; i++ {
+		bop, err := scatterer.scatter(clusterInfo, region, leader)
+		c.Assert(err, IsNil)
+		stores := s.applyScatter(c, bop, region, leader)
+		c.Assert(stores, HasLen, 3)
+		_, ok1 := stores[1]
+		_, ok2 := stores[2]
+		c.Assert(ok1 != ok2, IsTrue)
+	}
+}
@@ -94,7 +94,9 @@ func newLeaderScorer() *leaderScorer {
 }
 
 func (ls *leaderScorer) Score(store *storeInfo) int {
-	return int(store.leaderRatio() * 100)
+	// The store with a larger weight gets a lower score, so it is
+	// balanced to have more leaders.
+	return int(store.leaderRatio() / store.leaderWeight() * 100)
 }
 
 type capacityScorer struct {
@@ -105,7 +107,7 @@ func newCapacityScorer() *capacityScorer {
 }
 
 func (cs *capacityScorer) Score(store *storeInfo) int {
-	return int(store.usedRatio() * 100)
+	return int(store.usedRatio() / store.regionWeight() * 100)
 }
 
 func newScorer(st scoreType) Scorer {
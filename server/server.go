@@ -31,8 +31,9 @@ const (
 	etcdTimeout = time.Second * 3
 	// pdRootPath for all pd servers.
 	pdRootPath  = "/pd"
-	pdAPIPrefix = "/pd/"
 	pdRPCPrefix = "/pd/rpc"
+	// defaultCloseTimeout is the max time Close waits for the in-flight requests.
+	defaultCloseTimeout = time.Second * 5
 )
 
 // Server is the pd server.
@@ -57,9 +58,13 @@ type Server struct {
 
 	closed int64
 
+	// the number of running HTTP and RPC requests, Close waits for them.
+	inflightRequests int64
+
 	// for tso
-	ts            atomic.Value
-	lastSavedTime time.Time
+	ts atomic.Value
+	// the unix nano time of the last saved timestamp, accessed atomically.
+	lastSavedTime int64
 
 	// for id allocator, we can use one allocator for
 	// store, region and peer, because we just need
@@ -73,6 +78,23 @@ type Server struct {
 	msgID uint64
 
 	id uint64
+
+	// for leader resign
+	resignCh chan struct{}
+
+	// cfgLock protects the balance config, it is changed by the API and
+	// by the config watcher.
+	cfgLock sync.RWMutex
+	// cfgRevision is the etcd revision of the config applied last, the
+	// older changes are ignored. It is protected by cfgLock.
+	cfgRevision int64
+	// balanceCfg is a copy of the balance config for the scheduling, a new
+	// copy is stored after the config changes, so the readers get a
+	// consistent config without the lock. The copy must not be changed.
+	balanceCfg atomic.Value
+
+	// the free space of the data dir in the last check.
+	freeSpace uint64
 }
 
 // NewServer creates the pd server with given configuration.
@@ -98,8 +120,10 @@ func CreateServer(cfg *Config) (*Server, error) {
 		conns:         make(map[*conn]struct{}),
 		closed:        0,
 		rootPath:      path.Join(pdRootPath, strconv.FormatUint(cfg.ClusterID, 10)),
+		resignCh:      make(chan struct{}, 1),
 	}
 
+	s.balanceCfg.Store(cfg.BalanceCfg.clone())
 	s.idAlloc = &idAllocator{s: s}
 	s.cluster = &RaftCluster{
 		s:           s,
@@ -108,6 +132,15 @@ func CreateServer(cfg *Config) (*Server, error) {
 		clusterRoot: s.getClusterRootPath(),
 	}
 
+	free, err := s.updateFreeSpace()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if free < cfg.MinFreeBytes {
+		return nil, errors.Annotatef(ErrDiskSpaceLow, "data dir %s has %d bytes free, min free bytes is %d",
+			cfg.DataDir, free, cfg.MinFreeBytes)
+	}
+
 	return s, nil
 }
 
@@ -121,7 +154,7 @@ func (s *Server) StartEtcd(apiHandler http.Handler) error {
 		pdRPCPrefix: s,
 	}
 	if apiHandler != nil {
-		etcdCfg.UserHandlers[pdAPIPrefix] = apiHandler
+		etcdCfg.UserHandlers[s.cfg.APIPrefix+"/"] = s.trackRequests(apiHandler)
 	}
 
 	log.Info("start embed etcd")
@@ -133,10 +166,16 @@ func (s *Server) StartEtcd(apiHandler http.Handler) error {
 
 	endpoints := []string{etcdCfg.LCUrls[0].String()}
 
+	tlsCfg, err := s.cfg.genClientTLSConfig()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	log.Infof("create etcd v3 client with endpoints %v", endpoints)
 	client, err := clientv3.New(clientv3.Config{
 		Endpoints:   endpoints,
 		DialTimeout: etcdTimeout,
+		TLS:         tlsCfg,
 	})
 	if err != nil {
 		return errors.Trace(err)
@@ -158,8 +197,16 @@ func (s *Server) StartEtcd(apiHandler http.Handler) error {
 	return nil
 }
 
-// Close closes the server.
+// Close closes the server, it waits at most defaultCloseTimeout for the
+// in-flight requests.
 func (s *Server) Close() {
+	s.CloseWithTimeout(defaultCloseTimeout)
+}
+
+// CloseWithTimeout closes the server gracefully. If the server is leader,
+// it resigns first so that clients can find the new leader soon, then it
+// waits at most timeout for the in-flight requests to finish.
+func (s *Server) CloseWithTimeout(timeout time.Duration) {
 	if !atomic.CompareAndSwapInt64(&s.closed, 0, 1) {
 		// server is already closed
 		return
@@ -167,6 +214,16 @@ func (s *Server) Close() {
 
 	log.Info("closing server")
 
+	if s.isLeader() {
+		if err := s.ResignLeader(); err != nil {
+			log.Warnf("resign leader before closing failed - %v", err)
+		}
+	}
+
+	if !s.waitRequests(timeout) {
+		log.Warnf("close server with %d requests in flight", atomic.LoadInt64(&s.inflightRequests))
+	}
+
 	s.enableLeader(false)
 
 	if s.client != nil {
@@ -193,7 +250,15 @@ func (s *Server) Run() {
 	// address before run, so we set leader value here.
 	s.leaderValue = s.marshalLeader()
 
-	s.wg.Add(1)
+	s.wg.Add(5)
+	go s.configLoop()
+	go s.diskCheckLoop()
+	go s.regionSnapshotLoop()
+	go s.leaderPriorityLoop()
+	if s.cfg.DefragmentInterval.Duration > 0 {
+		s.wg.Add(1)
+		go s.defragmentLoop()
+	}
 	s.leaderLoop()
 }
 
@@ -272,6 +337,40 @@ func (s *Server) closeAllConnections() {
 	s.conns = make(map[*conn]struct{})
 }
 
+func (s *Server) beginRequest() {
+	atomic.AddInt64(&s.inflightRequests, 1)
+}
+
+func (s *Server) endRequest() {
+	atomic.AddInt64(&s.inflightRequests, -1)
+}
+
+// waitRequests waits for the in-flight requests to finish, it returns
+// false if there are still running requests after timeout.
+func (s *Server) waitRequests(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&s.inflightRequests) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// trackRequests counts the requests served by h as in-flight requests.
+// The upgraded connections, like websocket, are long-lived, so they are
+// not counted.
+func (s *Server) trackRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			s.beginRequest()
+			defer s.endRequest()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 // txn returns an etcd client transaction wrapper.
 // The wrapper will set a request timeout to the context and log slow transactions.
 func (s *Server) txn() clientv3.Txn {
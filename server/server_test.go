@@ -14,6 +14,11 @@
 package server
 
 import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -113,3 +118,145 @@ func (s *testLeaderServerSuite) TestLeader(c *C) {
 	leader2 := mustGetLeader(c, s.client, s.leaderPath)
 	c.Assert(leader1.GetAddr(), Not(Equals), leader2.GetAddr())
 }
+
+var _ = Suite(&testServerCloseSuite{})
+
+type testServerCloseSuite struct{}
+
+// mustStartSlowServer starts a server whose API requests are blocked
+// until release is closed, started receives a value for every request.
+func mustStartSlowServer(c *C, started chan<- struct{}, release <-chan struct{}) (*Server, string) {
+	cfg := NewTestSingleConfig()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+	})
+
+	svr, err := CreateServer(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(svr.StartEtcd(handler), IsNil)
+	go svr.Run()
+
+	u, err := url.Parse(cfg.ClientUrls)
+	c.Assert(err, IsNil)
+	u.Scheme = "http"
+	u.Path = cfg.APIPrefix + "/slow"
+	return svr, u.String()
+}
+
+// sendSlowRequest sends the request in background, the response status
+// or an error is sent to the returned channel.
+func sendSlowRequest(addr string) <-chan interface{} {
+	ch := make(chan interface{}, 1)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, addr string) (net.Conn, error) {
+				return net.Dial("unix", addr)
+			},
+		},
+	}
+	go func() {
+		resp, err := client.Get(addr)
+		if err != nil {
+			ch <- err
+			return
+		}
+		resp.Body.Close()
+		ch <- resp.StatusCode
+	}()
+	return ch
+}
+
+func (s *testServerCloseSuite) TestCloseWaitsForRequests(c *C) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	svr, addr := mustStartSlowServer(c, started, release)
+	defer os.RemoveAll(svr.cfg.DataDir)
+
+	respCh := sendSlowRequest(addr)
+	<-started
+	delay := 500 * time.Millisecond
+	time.AfterFunc(delay, func() { close(release) })
+
+	start := time.Now()
+	svr.CloseWithTimeout(5 * time.Second)
+	c.Assert(time.Since(start) >= delay, IsTrue)
+	c.Assert(<-respCh, Equals, http.StatusOK)
+
+	// Close again is safe.
+	svr.Close()
+}
+
+func (s *testServerCloseSuite) TestCloseTimeout(c *C) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	svr, addr := mustStartSlowServer(c, started, release)
+	defer os.RemoveAll(svr.cfg.DataDir)
+
+	sendSlowRequest(addr)
+	<-started
+
+	start := time.Now()
+	svr.CloseWithTimeout(200 * time.Millisecond)
+	c.Assert(time.Since(start) < 3*time.Second, IsTrue)
+	close(release)
+}
+
+var _ = Suite(&testLeaderLeaseSuite{})
+
+type testLeaderLeaseSuite struct{}
+
+func (s *testLeaderLeaseSuite) TestLeaderFailover(c *C) {
+	cfgs := NewTestMultiConfig(3)
+	ch := make(chan *Server, 3)
+	for _, cfg := range cfgs {
+		defer os.RemoveAll(cfg.DataDir)
+		go func(cfg *Config) {
+			svr, err := NewServer(cfg)
+			c.Assert(err, IsNil)
+			ch <- svr
+		}(cfg)
+	}
+
+	svrs := make(map[string]*Server, 3)
+	for i := 0; i < 3; i++ {
+		svr := <-ch
+		defer svr.Close()
+		svrs[svr.GetAddr()] = svr
+		go svr.Run()
+	}
+
+	var svr *Server
+	for _, svr = range svrs {
+		break
+	}
+	lease := svr.GetConfig().LeaderLease
+	c.Assert(lease, Equals, minLeaderLease)
+
+	leader1 := mustGetLeader(c, svr.client, svr.getLeaderPath())
+	// Kill the leader without resigning, the leader key is kept until the
+	// lease expires.
+	killed := svrs[leader1.GetAddr()]
+	atomic.StoreInt64(&killed.closed, 1)
+	killed.client.Close()
+	killed.etcd.Close()
+	start := time.Now()
+
+	for _, svr = range svrs {
+		if svr != killed {
+			break
+		}
+	}
+	timeout := 3 * time.Duration(lease) * time.Second
+	for time.Since(start) < timeout {
+		leader, _ := getLeader(svr.client, svr.getLeaderPath())
+		if leader != nil && leader.GetAddr() != leader1.GetAddr() {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	leader2 := mustGetLeader(c, svr.client, svr.getLeaderPath())
+	c.Assert(leader2.GetAddr(), Not(Equals), leader1.GetAddr())
+	c.Assert(time.Since(start) < timeout, IsTrue)
+}
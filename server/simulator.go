@@ -0,0 +1,163 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/juju/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+)
+
+// SimulatorConfig is the config to replay the recorded heartbeats.
+type SimulatorConfig struct {
+	// Seed is the seed of the random region selection of the balancers.
+	Seed int64 `toml:"seed" json:"seed"`
+	// MaxPeerCount is the max peer count of a region.
+	MaxPeerCount uint32 `toml:"max-peer-count" json:"max-peer-count"`
+
+	BalanceCfg BalanceConfig `toml:"balance" json:"balance"`
+}
+
+// SimulatorEvent is a recorded event, only one of the fields is set. The
+// recorded store is added to the cluster, the balancers run once for a
+// balance event, so the replay doesn't depend on the balance interval.
+type SimulatorEvent struct {
+	Store           *metapb.Store                `json:"store,omitempty"`
+	StoreHeartbeat  *pdpb.StoreStats             `json:"store_heartbeat,omitempty"`
+	RegionHeartbeat *pdpb.RegionHeartbeatRequest `json:"region_heartbeat,omitempty"`
+	Balance         bool                         `json:"balance,omitempty"`
+}
+
+// SimulatedOperator is the operator sent to the region in the heartbeat
+// response, event is the index of the region heartbeat in the events.
+type SimulatedOperator struct {
+	Event    int                           `json:"event"`
+	RegionID uint64                        `json:"region_id"`
+	Response *pdpb.RegionHeartbeatResponse `json:"response"`
+}
+
+// simIDAllocator allocates the IDs greater than all the IDs in the events
+// replayed, so the new peers don't conflict with the recorded ones.
+type simIDAllocator struct {
+	maxID uint64
+}
+
+func (alloc *simIDAllocator) Alloc() (uint64, error) {
+	alloc.maxID++
+	return alloc.maxID, nil
+}
+
+func (alloc *simIDAllocator) observe(ids ...uint64) {
+	for _, id := range ids {
+		if id > alloc.maxID {
+			alloc.maxID = id
+		}
+	}
+}
+
+// Simulate replays the recorded events in r against the scheduler without
+// TiKV, and returns the operators the scheduler emits. The events are JSON
+// encoded SimulatorEvents. The random selection uses a source seeded with
+// cfg.Seed, the stores tied in the scores are selected by the store ID and
+// the regions of a store are selected in the order of the region ID, so the
+// replay is repeatable.
+func Simulate(cfg *SimulatorConfig, r io.Reader) ([]*SimulatedOperator, error) {
+	svrCfg := &Config{BalanceCfg: cfg.BalanceCfg}
+	svrCfg.BalanceCfg.adjust()
+	if err := svrCfg.BalanceCfg.validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	maxPeerCount := cfg.MaxPeerCount
+	if maxPeerCount == 0 {
+		maxPeerCount = uint32(defaultMaxPeerCount)
+	}
+
+	svr := &Server{cfg: svrCfg}
+	svr.balanceCfg.Store(svrCfg.BalanceCfg.clone())
+	idAlloc := &simIDAllocator{}
+	cluster := &RaftCluster{
+		s:           svr,
+		running:     true,
+		clusterRoot: "simulator",
+	}
+	// The heartbeats are replayed one by one, they need only one shard.
+	cluster.cachedCluster = newClusterInfo(cluster.clusterRoot, 1)
+	cluster.cachedCluster.regions.rand = rand.New(newLockedSource(cfg.Seed))
+	cluster.cachedCluster.idAlloc = idAlloc
+	cluster.cachedCluster.setMeta(&metapb.Cluster{
+		Id:           proto.Uint64(0),
+		MaxPeerCount: proto.Uint32(maxPeerCount),
+	})
+	cluster.balancerWorker = newBalancerWorker(cluster.cachedCluster, svr.getBalanceConfig)
+
+	var ops []*SimulatedOperator
+	dec := json.NewDecoder(r)
+	for i := 0; ; i++ {
+		var event SimulatorEvent
+		if err := dec.Decode(&event); err == io.EOF {
+			return ops, nil
+		} else if err != nil {
+			return nil, errors.Annotatef(err, "decode event %d", i)
+		}
+
+		res, err := cluster.simulateEvent(&event, idAlloc)
+		if err != nil {
+			return nil, errors.Annotatef(err, "replay event %d", i)
+		}
+		if res != nil {
+			ops = append(ops, &SimulatedOperator{
+				Event:    i,
+				RegionID: event.RegionHeartbeat.GetRegion().GetId(),
+				Response: res,
+			})
+		}
+	}
+}
+
+func (c *RaftCluster) simulateEvent(event *SimulatorEvent, idAlloc *simIDAllocator) (*pdpb.RegionHeartbeatResponse, error) {
+	switch {
+	case event.Store != nil:
+		idAlloc.observe(event.Store.GetId())
+		c.cachedCluster.addStore(event.Store)
+	case event.StoreHeartbeat != nil:
+		if !c.cachedCluster.updateStoreStatus(event.StoreHeartbeat) {
+			return nil, errors.Errorf("cannot find store to update stats, stats %v", event.StoreHeartbeat)
+		}
+	case event.RegionHeartbeat != nil:
+		region, leader := event.RegionHeartbeat.GetRegion(), event.RegionHeartbeat.GetLeader()
+		if region.GetId() == 0 || leader == nil {
+			return nil, errors.Errorf("invalid region heartbeat %v", event.RegionHeartbeat)
+		}
+		idAlloc.observe(region.GetId())
+		for _, peer := range region.GetPeers() {
+			idAlloc.observe(peer.GetId())
+		}
+
+		if _, _, err := c.cachedCluster.regions.heartbeat(region, leader); err != nil {
+			return nil, errors.Trace(err)
+		}
+		res, err := c.handleRegionHeartbeat(region, leader, event.RegionHeartbeat.GetDownPeers())
+		return res, errors.Trace(err)
+	case event.Balance:
+		return nil, errors.Trace(c.balancerWorker.doBalance())
+	default:
+		return nil, errors.New("empty event")
+	}
+	return nil, nil
+}
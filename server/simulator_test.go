@@ -0,0 +1,161 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	. "github.com/pingcap/check"
+	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
+)
+
+var _ = Suite(&testSimulatorSuite{})
+
+type testSimulatorSuite struct{}
+
+// The recorded scenario, region 5 has only one replica on store 1, the
+// replica balancer adds the peers to the stores with the most space. Then
+// store 4 fills up, the capacity balancer moves its peer to store 2.
+const simulatorEvents = `
+{"store": {"id": 1, "address": "127.0.0.1:1"}}
+{"store": {"id": 2, "address": "127.0.0.1:2"}}
+{"store": {"id": 3, "address": "127.0.0.1:3"}}
+{"store": {"id": 4, "address": "127.0.0.1:4"}}
+{"store_heartbeat": {"store_id": 1, "capacity": 100, "available": 60}}
+{"store_heartbeat": {"store_id": 2, "capacity": 100, "available": 70}}
+{"store_heartbeat": {"store_id": 3, "capacity": 100, "available": 80}}
+{"store_heartbeat": {"store_id": 4, "capacity": 100, "available": 90}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 1, "version": 1}, "peers": [{"id": 6, "store_id": 1}]}, "leader": {"id": 6, "store_id": 1}}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 2, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 7, "store_id": 4}]}, "leader": {"id": 6, "store_id": 1}}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 3, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 7, "store_id": 4}, {"id": 8, "store_id": 3}]}, "leader": {"id": 6, "store_id": 1}}}
+{"store_heartbeat": {"store_id": 4, "capacity": 100, "available": 5}}
+{"balance": true}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 3, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 7, "store_id": 4}, {"id": 8, "store_id": 3}]}, "leader": {"id": 6, "store_id": 1}}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 4, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 7, "store_id": 4}, {"id": 8, "store_id": 3}, {"id": 9, "store_id": 2}]}, "leader": {"id": 6, "store_id": 1}}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 4, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 7, "store_id": 4}, {"id": 8, "store_id": 3}, {"id": 9, "store_id": 2}]}, "leader": {"id": 6, "store_id": 1}}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 4, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 7, "store_id": 4}, {"id": 8, "store_id": 3}, {"id": 9, "store_id": 2}]}, "leader": {"id": 6, "store_id": 1}}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 4, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 7, "store_id": 4}, {"id": 8, "store_id": 3}, {"id": 9, "store_id": 2}]}, "leader": {"id": 6, "store_id": 1}}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 4, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 7, "store_id": 4}, {"id": 8, "store_id": 3}, {"id": 9, "store_id": 2}]}, "leader": {"id": 6, "store_id": 1}}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 5, "version": 1}, "peers": [{"id": 6, "store_id": 1}, {"id": 8, "store_id": 3}, {"id": 9, "store_id": 2}]}, "leader": {"id": 6, "store_id": 1}}}
+`
+
+func (s *testSimulatorSuite) TestSimulate(c *C) {
+	cfg := &SimulatorConfig{Seed: 1}
+	ops, err := Simulate(cfg, strings.NewReader(simulatorEvents))
+	c.Assert(err, IsNil)
+
+	// The add peer step of the operator finishes silently when the peer is
+	// seen, the remove peer step is sent after the new peer is reported
+	// healthy in 3 heartbeats.
+	expected := []struct {
+		event      int
+		changeType raftpb.ConfChangeType
+		peerID     uint64
+		storeID    uint64
+	}{
+		{8, raftpb.ConfChangeType_AddNode, 7, 4},
+		{9, raftpb.ConfChangeType_AddNode, 8, 3},
+		{13, raftpb.ConfChangeType_AddNode, 9, 2},
+		{18, raftpb.ConfChangeType_RemoveNode, 7, 4},
+	}
+	c.Assert(ops, HasLen, len(expected))
+	for i, e := range expected {
+		c.Assert(ops[i].Event, Equals, e.event)
+		c.Assert(ops[i].RegionID, Equals, uint64(5))
+		changePeer := ops[i].Response.GetChangePeer()
+		c.Assert(changePeer.GetChangeType(), Equals, e.changeType)
+		c.Assert(changePeer.GetPeer().GetId(), Equals, e.peerID)
+		c.Assert(changePeer.GetPeer().GetStoreId(), Equals, e.storeID)
+	}
+
+	// The same events and seed produce the same operators.
+	again, err := Simulate(cfg, strings.NewReader(simulatorEvents))
+	c.Assert(err, IsNil)
+	c.Assert(again, DeepEquals, ops)
+}
+
+func (s *testSimulatorSuite) TestInvalidEvent(c *C) {
+	cfg := &SimulatorConfig{Seed: 1}
+	_, err := Simulate(cfg, strings.NewReader(`{"store": {"id": 1}} {"balance": `))
+	c.Assert(err, NotNil)
+}
+
+func (s *testSimulatorSuite) TestSimulateTie(c *C) {
+	// The stores 2, 3 and 4 tie in the scores, the store with the smallest
+	// ID is selected.
+	events := `
+{"store": {"id": 1, "address": "127.0.0.1:1"}}
+{"store": {"id": 4, "address": "127.0.0.1:4"}}
+{"store": {"id": 3, "address": "127.0.0.1:3"}}
+{"store": {"id": 2, "address": "127.0.0.1:2"}}
+{"store_heartbeat": {"store_id": 1, "capacity": 100, "available": 50}}
+{"store_heartbeat": {"store_id": 2, "capacity": 100, "available": 50}}
+{"store_heartbeat": {"store_id": 3, "capacity": 100, "available": 50}}
+{"store_heartbeat": {"store_id": 4, "capacity": 100, "available": 50}}
+{"region_heartbeat": {"region": {"id": 5, "region_epoch": {"conf_ver": 1, "version": 1}, "peers": [{"id": 6, "store_id": 1}]}, "leader": {"id": 6, "store_id": 1}}}
+`
+	cfg := &SimulatorConfig{Seed: 1, MaxPeerCount: 2}
+	for i := 0; i < 10; i++ {
+		ops, err := Simulate(cfg, strings.NewReader(events))
+		c.Assert(err, IsNil)
+		c.Assert(ops, HasLen, 1)
+		changePeer := ops[0].Response.GetChangePeer()
+		c.Assert(changePeer.GetChangeType(), Equals, raftpb.ConfChangeType_AddNode)
+		c.Assert(changePeer.GetPeer().GetStoreId(), Equals, uint64(2))
+	}
+}
+
+func (s *testSimulatorSuite) TestSimulateRegions(c *C) {
+	// The 8 regions have peers in store 1,2,3 and the leaders in store 1,
+	// store 3 has the least space and store 4 has the most. The balancers
+	// select the leader and follower regions of a store randomly, the same
+	// seed selects the same ones.
+	var events []string
+	for id := 1; id <= 4; id++ {
+		events = append(events, fmt.Sprintf(`{"store": {"id": %d, "address": "127.0.0.1:%d"}}`, id, id))
+	}
+	var regions []string
+	for i := 0; i < 8; i++ {
+		id := 10 + i*10
+		var keys string
+		if i > 0 {
+			keys += fmt.Sprintf(`, "start_key": "%s"`, base64.StdEncoding.EncodeToString([]byte{byte(i)}))
+		}
+		if i < 7 {
+			keys += fmt.Sprintf(`, "end_key": "%s"`, base64.StdEncoding.EncodeToString([]byte{byte(i + 1)}))
+		}
+		regions = append(regions, fmt.Sprintf(`{"region_heartbeat": {"region": {"id": %d%s, "region_epoch": {"conf_ver": 3, "version": 1}, `+
+			`"peers": [{"id": %d, "store_id": 1}, {"id": %d, "store_id": 2}, {"id": %d, "store_id": 3}]}, "leader": {"id": %d, "store_id": 1}}}`,
+			id, keys, id+1, id+2, id+3, id+1))
+	}
+	events = append(events, regions...)
+	for id, available := range []int{50, 50, 30, 90} {
+		events = append(events, fmt.Sprintf(`{"store_heartbeat": {"store_id": %d, "capacity": 100, "available": %d}}`, id+1, available))
+	}
+	events = append(events, `{"balance": true}`)
+	events = append(events, regions...)
+
+	cfg := &SimulatorConfig{Seed: 1}
+	cfg.BalanceCfg.MaxLeaderCount = 1
+	ops, err := Simulate(cfg, strings.NewReader(strings.Join(events, "\n")))
+	c.Assert(err, IsNil)
+	c.Assert(len(ops) > 1, IsTrue)
+	for i := 0; i < 10; i++ {
+		again, err := Simulate(cfg, strings.NewReader(strings.Join(events, "\n")))
+		c.Assert(err, IsNil)
+		c.Assert(again, DeepEquals, ops)
+	}
+}
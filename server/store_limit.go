@@ -0,0 +1,131 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
+)
+
+// ErrInvalidStoreLimit is returned when the store balance rate is negative.
+var ErrInvalidStoreLimit = errors.New("invalid store limit")
+
+// storeLimiter limits the add-peer and remove-peer operations of the
+// stores, so an empty store is not flooded by the moved regions. Each
+// store has a token bucket which is refilled at its rate per minute and
+// holds one minute of operations, or one operation if the rate is lower
+// than one per minute, so a slow store still gets an operation now and
+// then. The zero rate freezes the store.
+type storeLimiter struct {
+	sync.Mutex
+
+	buckets map[uint64]*TokenBucket
+}
+
+func newStoreLimiter() *storeLimiter {
+	return &storeLimiter{
+		buckets: make(map[uint64]*TokenBucket),
+	}
+}
+
+// storeBucketCapacity returns the capacity of the bucket of a store with
+// the rate.
+func storeBucketCapacity(rate float64) float64 {
+	if rate <= 0 {
+		return 0
+	}
+	return math.Max(1, rate)
+}
+
+// refill returns the bucket of the store with the tokens refilled up to
+// now, a new bucket is full.
+func (l *storeLimiter) refill(storeID uint64, rate float64, now time.Time) *TokenBucket {
+	capacity := storeBucketCapacity(rate)
+	bucket, ok := l.buckets[storeID]
+	if !ok {
+		bucket = NewTokenBucket(capacity, now)
+		l.buckets[storeID] = bucket
+	}
+	bucket.Refill(rate/60, capacity, now)
+	return bucket
+}
+
+// allow checks whether all the stores have enough tokens for the counts of
+// operations.
+func (l *storeLimiter) allow(counts map[uint64]int, rate func(storeID uint64) float64, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	for storeID, count := range counts {
+		if l.refill(storeID, rate(storeID), now).tokens < float64(count) {
+			return false
+		}
+	}
+	return true
+}
+
+// take consumes the tokens of the stores for the counts of operations.
+func (l *storeLimiter) take(counts map[uint64]int, rate func(storeID uint64) float64, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	for storeID, count := range counts {
+		l.refill(storeID, rate(storeID), now).Take(float64(count))
+	}
+}
+
+// storeOperationCounts returns the add-peer and remove-peer count of each
+// store in the operator.
+func storeOperationCounts(bop *balanceOperator) map[uint64]int {
+	counts := make(map[uint64]int)
+	for _, op := range bop.Ops {
+		if once, ok := op.(*onceOperator); ok {
+			op = once.Op
+		}
+		changePeer, ok := op.(*changePeerOperator)
+		if !ok {
+			continue
+		}
+		switch changePeer.ChangePeer.GetChangeType() {
+		case raftpb.ConfChangeType_AddNode, raftpb.ConfChangeType_RemoveNode:
+			counts[changePeer.ChangePeer.GetPeer().GetStoreId()]++
+		}
+	}
+	return counts
+}
+
+// storeBalanceRate returns the balance rate of the store, the default rate
+// is used if the store is not found.
+func (bw *balancerWorker) storeBalanceRate(storeID uint64) float64 {
+	store := bw.cluster.getStore(storeID)
+	if store == nil {
+		return bw.cfg().StoreBalanceRate
+	}
+	return store.balanceRate(bw.cfg().StoreBalanceRate)
+}
+
+// allowStoreLimit checks whether the stores of the operator have enough
+// tokens.
+func (bw *balancerWorker) allowStoreLimit(bop *balanceOperator) bool {
+	return bw.storeLimiter.allow(storeOperationCounts(bop), bw.storeBalanceRate, time.Now())
+}
+
+// takeStoreLimit consumes the tokens of the stores of the operator.
+func (bw *balancerWorker) takeStoreLimit(bop *balanceOperator) {
+	bw.storeLimiter.take(storeOperationCounts(bop), bw.storeBalanceRate, time.Now())
+}
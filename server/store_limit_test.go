@@ -0,0 +1,130 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	raftpb "github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+func (s *testBalancerWorkerSuite) TestStoreLimiter(c *C) {
+	l := newStoreLimiter()
+	rate := func(storeID uint64) float64 {
+		return float64(storeID)
+	}
+	now := time.Now()
+
+	// Store 2 can do 2 operations per minute.
+	c.Assert(l.allow(map[uint64]int{2: 3}, rate, now), IsFalse)
+	c.Assert(l.allow(map[uint64]int{1: 1, 2: 2}, rate, now), IsTrue)
+	l.take(map[uint64]int{1: 1, 2: 2}, rate, now)
+	c.Assert(l.allow(map[uint64]int{2: 1}, rate, now), IsFalse)
+	c.Assert(l.allow(map[uint64]int{1: 1}, rate, now), IsFalse)
+
+	// The tokens are refilled over time, up to one minute of operations.
+	c.Assert(l.allow(map[uint64]int{2: 1}, rate, now.Add(30*time.Second)), IsTrue)
+	c.Assert(l.allow(map[uint64]int{2: 2}, rate, now.Add(30*time.Second)), IsFalse)
+	c.Assert(l.allow(map[uint64]int{2: 2}, rate, now.Add(time.Hour)), IsTrue)
+	c.Assert(l.allow(map[uint64]int{2: 3}, rate, now.Add(time.Hour)), IsFalse)
+
+	// Zero rate freezes the store.
+	c.Assert(l.allow(map[uint64]int{0: 1}, rate, now.Add(2*time.Hour)), IsFalse)
+	c.Assert(l.allow(map[uint64]int{}, rate, now), IsTrue)
+
+	// The store with a rate lower than one per minute still gets one
+	// operation at the rate.
+	slow := func(storeID uint64) float64 {
+		return 0.5
+	}
+	c.Assert(l.allow(map[uint64]int{10: 1}, slow, now), IsTrue)
+	l.take(map[uint64]int{10: 1}, slow, now)
+	c.Assert(l.allow(map[uint64]int{10: 1}, slow, now.Add(time.Minute)), IsFalse)
+	c.Assert(l.allow(map[uint64]int{10: 1}, slow, now.Add(2*time.Minute)), IsTrue)
+	c.Assert(l.allow(map[uint64]int{10: 2}, slow, now.Add(time.Hour)), IsFalse)
+}
+
+func (s *testBalancerWorkerSuite) TestStoreLimit(c *C) {
+	clusterInfo := s.ts.newClusterInfo(c)
+
+	// Replace the region with 10 regions on store 1, 2 and 3, the leaders
+	// are on store 1.
+	region, _ := clusterInfo.regions.getRegion([]byte("a"))
+	clusterInfo.regions.removeRegion(region)
+	for i := 0; i < 10; i++ {
+		id := uint64(100 + i*10)
+		startKey, endKey := []byte{byte('a' + i)}, []byte{byte('b' + i)}
+		if i == 0 {
+			startKey = []byte{}
+		}
+		if i == 9 {
+			endKey = []byte{}
+		}
+		peers := []*metapb.Peer{s.ts.newPeer(c, 1, id+1), s.ts.newPeer(c, 2, id+2), s.ts.newPeer(c, 3, id+3)}
+		clusterInfo.regions.addRegion(s.ts.newRegion(c, id, startKey, endKey, peers, nil))
+		clusterInfo.regions.leaders.update(id, 1)
+	}
+
+	// Store 4 is empty, the regions on store 2 are moved to it.
+	s.ts.updateStore(c, clusterInfo, 1, 100, 50, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 2, 100, 20, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 3, 100, 50, 0, 0)
+	s.ts.updateStore(c, clusterInfo, 4, 100, 90, 0, 0)
+
+	cfg := newBalanceConfig()
+	cfg.adjust()
+	cfg.RegionScheduleLimit = 100
+	// Don't transfer the leaders, they would occupy the regions.
+	cfg.LeaderScheduleLimit = 0
+	cfg.StoreBalanceRate = 100
+	c.Assert(clusterInfo.setStoreMeta(4, storeMeta{BalanceRate: proto.Float64(2)}), IsTrue)
+	bw := newBalancerWorker(clusterInfo, balanceConfigGetter(cfg))
+
+	addedToStore4 := func() int {
+		count := 0
+		for _, bop := range bw.getBalanceOperators() {
+			for _, op := range bop.(*balanceOperator).Ops {
+				if op, ok := op.(*changePeerOperator); ok && op.ChangePeer.GetChangeType() == raftpb.ConfChangeType_AddNode {
+					c.Assert(op.ChangePeer.GetPeer().GetStoreId(), Equals, uint64(4))
+					count++
+				}
+			}
+		}
+		return count
+	}
+	balance := func() {
+		for i := 0; i < 20; i++ {
+			c.Assert(bw.doBalance(), IsNil)
+		}
+	}
+
+	// Only 2 peers can be added to store 4 in a minute.
+	balance()
+	c.Assert(addedToStore4(), Equals, 2)
+
+	// The tokens are refilled a minute later.
+	bucket := bw.storeLimiter.buckets[4]
+	bucket.last = bucket.last.Add(-time.Minute)
+	balance()
+	c.Assert(addedToStore4(), Equals, 4)
+
+	// The scheduling on store 4 is frozen.
+	c.Assert(clusterInfo.setStoreMeta(4, storeMeta{BalanceRate: proto.Float64(0)}), IsTrue)
+	bucket.last = bucket.last.Add(-time.Hour)
+	balance()
+	c.Assert(addedToStore4(), Equals, 4)
+}
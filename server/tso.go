@@ -25,11 +25,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/pdpb"
 )
 
-const (
-	// update timestamp every updateTimestampStep milliseconds.
-	updateTimestampStep = int64(50)
-	maxLogical          = int64(1 << 18)
-)
+const maxLogical = int64(1 << 18)
 
 type atomicObject struct {
 	physical time.Time
@@ -71,7 +67,7 @@ func (s *Server) saveTimestamp(now time.Time) error {
 		return errors.New("save timestamp failed, maybe we lost leader")
 	}
 
-	s.lastSavedTime = now
+	atomic.StoreInt64(&s.lastSavedTime, now.UnixNano())
 
 	return nil
 }
@@ -121,7 +117,7 @@ func (s *Server) updateTimestamp() error {
 	now := time.Now()
 
 	since := now.Sub(prev.physical).Nanoseconds() / 1e6
-	if since > 3*updateTimestampStep {
+	if since > 3*s.cfg.TsoUpdatePhysicalInterval {
 		log.Warnf("clock offset: %v, prev: %v, now %v", since, prev.physical, now)
 	}
 	// Avoid the same physical time stamp
@@ -130,7 +126,7 @@ func (s *Server) updateTimestamp() error {
 		return nil
 	}
 
-	if now.Sub(s.lastSavedTime).Nanoseconds()/1e6 > s.cfg.TsoSaveInterval {
+	if (now.UnixNano()-atomic.LoadInt64(&s.lastSavedTime))/1e6 > s.cfg.TsoSaveInterval {
 		if err := s.saveTimestamp(now); err != nil {
 			return errors.Trace(err)
 		}
@@ -144,6 +140,31 @@ func (s *Server) updateTimestamp() error {
 	return nil
 }
 
+// TSOStatus is the status of the timestamp oracle.
+type TSOStatus struct {
+	Physical  time.Time
+	Logical   int64
+	LastSaved time.Time
+}
+
+// GetTSOStatus returns the current timestamp and the time of the last
+// saved timestamp, only the leader allocates timestamps.
+func (s *Server) GetTSOStatus() (*TSOStatus, error) {
+	if !s.isLeader() {
+		return nil, errors.Trace(ErrNotLeader)
+	}
+
+	current, ok := s.ts.Load().(*atomicObject)
+	if !ok {
+		return nil, errors.New("timestamp is not synced")
+	}
+	return &TSOStatus{
+		Physical:  current.physical,
+		Logical:   atomic.LoadInt64(&current.logical),
+		LastSaved: time.Unix(0, atomic.LoadInt64(&s.lastSavedTime)),
+	}, nil
+}
+
 const maxRetryCount = 100
 
 func (s *Server) getRespTS(count uint32) *pdpb.Timestamp {
@@ -119,3 +119,90 @@ func (s *testTsoSuite) TestTso(c *C) {
 
 	wg.Wait()
 }
+
+func mustGetTimestamp(c *C, conn net.Conn) *pdpb.Timestamp {
+	req := &pdpb.Request{
+		CmdType: pdpb.CommandType_Tso.Enum(),
+		Tso: &pdpb.TsoRequest{
+			Count: proto.Uint32(1),
+		},
+	}
+
+	rawMsgID := uint64(rand.Int63())
+	sendRequest(c, conn, rawMsgID, req)
+	msgID, resp := recvResponse(c, conn)
+	c.Assert(rawMsgID, Equals, msgID)
+	c.Assert(resp.Tso, NotNil)
+	return resp.Tso.Timestamp
+}
+
+// tsLess checks whether the timestamp t1 is allocated before t2.
+func tsLess(t1, t2 *pdpb.Timestamp) bool {
+	if t1.GetPhysical() != t2.GetPhysical() {
+		return t1.GetPhysical() < t2.GetPhysical()
+	}
+	return t1.GetLogical() < t2.GetLogical()
+}
+
+var _ = Suite(&testTsoIntervalSuite{})
+
+type testTsoIntervalSuite struct{}
+
+func (s *testTsoIntervalSuite) TestTsoInterval(c *C) {
+	cfg := NewTestSingleConfig()
+	cfg.TsoSaveInterval = 100
+	cfg.TsoUpdatePhysicalInterval = 10
+	svr, err := NewServer(cfg)
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(cfg.DataDir)
+	defer svr.Close()
+	go svr.Run()
+
+	leader := mustGetLeader(c, svr.client, svr.getLeaderPath())
+	conn, err := rpcConnect(leader.GetAddr())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	last := mustGetTimestamp(c, conn)
+	for i := 0; i < 20; i++ {
+		ts := mustGetTimestamp(c, conn)
+		c.Assert(tsLess(last, ts), IsTrue)
+		last = ts
+		time.Sleep(5 * time.Millisecond)
+	}
+	// The physical time is updated in the interval.
+	first := last
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(mustGetTimestamp(c, conn).GetPhysical(), Greater, first.GetPhysical())
+
+	// Resign and campaign again, the connections are closed when the
+	// leader steps down.
+	resp, err := kvGet(svr.client, svr.getLeaderPath())
+	c.Assert(err, IsNil)
+	rev := resp.Kvs[0].CreateRevision
+	last = mustGetTimestamp(c, conn)
+	svr.resignCh <- struct{}{}
+	for i := 0; i < 50; i++ {
+		resp, err = kvGet(svr.client, svr.getLeaderPath())
+		c.Assert(err, IsNil)
+		if len(resp.Kvs) > 0 && resp.Kvs[0].CreateRevision != rev {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(resp.Kvs, HasLen, 1)
+	c.Assert(resp.Kvs[0].CreateRevision, Not(Equals), rev)
+
+	conn2, err := rpcConnect(leader.GetAddr())
+	c.Assert(err, IsNil)
+	defer conn2.Close()
+	ts := mustGetTimestamp(c, conn2)
+	c.Assert(tsLess(last, ts), IsTrue)
+
+	// The save interval must be greater than the update interval.
+	cfg = NewTestSingleConfig()
+	defer os.RemoveAll(cfg.DataDir)
+	cfg.TsoSaveInterval = 10
+	cfg.TsoUpdatePhysicalInterval = 10
+	c.Assert(cfg.adjust(), NotNil)
+}
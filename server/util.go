@@ -16,9 +16,11 @@ package server
 import (
 	"encoding/binary"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -314,3 +316,89 @@ func InitLogger(cfg *Config) error {
 
 	return nil
 }
+
+// ErrInvalidLogLevel is returned when the log level is not supported.
+var ErrInvalidLogLevel = errors.New("invalid log level")
+
+var logLevels = map[string]struct{}{
+	"debug": {},
+	"info":  {},
+	"warn":  {},
+	"error": {},
+	"fatal": {},
+}
+
+// GetLogLevel returns the current log level of the process.
+func (s *Server) GetLogLevel() string {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	return s.cfg.LogLevel
+}
+
+// SetLogLevel changes the log level of the process, it takes effect
+// immediately but is not persisted, the server uses the log level in
+// the config after restart.
+func (s *Server) SetLogLevel(level string) error {
+	if _, ok := logLevels[level]; !ok {
+		return errors.Annotatef(ErrInvalidLogLevel, "log level %s", level)
+	}
+
+	s.cfgLock.Lock()
+	defer s.cfgLock.Unlock()
+
+	log.SetLevelByString(level)
+	log.Infof("log level is changed from %s to %s", s.cfg.LogLevel, level)
+	s.cfg.LogLevel = level
+	return nil
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int {
+	return len(s)
+}
+
+func (s uint64Slice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s uint64Slice) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+type storeInfosByID []*storeInfo
+
+func (s storeInfosByID) Len() int {
+	return len(s)
+}
+
+func (s storeInfosByID) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s storeInfosByID) Less(i, j int) bool {
+	return s[i].store.GetId() < s[j].store.GetId()
+}
+
+// lockedSource is a random source safe for the concurrent use, like the
+// source of the global functions in math/rand.
+type lockedSource struct {
+	sync.Mutex
+	src rand.Source
+}
+
+func newLockedSource(seed int64) *lockedSource {
+	return &lockedSource{src: rand.NewSource(seed)}
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.src.Seed(seed)
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	etcdversion "github.com/coreos/etcd/version"
+)
+
+// The version and build info, the git hash and build time are set by the
+// ldflags in the Makefile.
+var (
+	PDReleaseVersion = "1.0.0"
+	PDGitHash        = "None"
+	PDBuildTS        = "None"
+)
+
+// VersionInfo is the version and build info of the running PD binary.
+type VersionInfo struct {
+	Version     string `json:"version"`
+	GitHash     string `json:"git_hash"`
+	BuildTS     string `json:"build_ts"`
+	EtcdVersion string `json:"etcd_version"`
+}
+
+// GetVersionInfo returns the version and build info of the PD binary,
+// including the version of the embedded etcd.
+func GetVersionInfo() *VersionInfo {
+	return &VersionInfo{
+		Version:     PDReleaseVersion,
+		GitHash:     PDGitHash,
+		BuildTS:     PDBuildTS,
+		EtcdVersion: etcdversion.Version,
+	}
+}